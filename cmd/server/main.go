@@ -13,6 +13,7 @@ import (
 	"mikromon/internal/api"
 	"mikromon/internal/auth"
 	"mikromon/internal/db"
+	"mikromon/internal/notify"
 	"mikromon/internal/worker"
 	"mikromon/web"
 
@@ -36,6 +37,12 @@ func main() {
 		log.Printf("Could not connect to MongoDB: %v. Running in partial mode.", err)
 	}
 
+	// Make sure there is at least one login available (DB or JSON store)
+	auth.EnsureDefaultAdmin()
+
+	// Encrypt any device password still stored in clear
+	api.SealStoredCredentials()
+
 	// Router Setup
 	r := mux.NewRouter()
 
@@ -51,55 +58,118 @@ func main() {
 	// Protected V1
 	v1 := apiRouter.PathPrefix("/v1").Subrouter()
 	v1.Use(auth.JwtMiddleware) // Enforce Auth
+	// Every route below is wrapped with auth.Require so the role in the token
+	// decides access (admin: all, tech: read+execute, monitor: read only).
 
 	// User
-	v1.HandleFunc("/me", auth.MeHandler).Methods("GET")
-	v1.HandleFunc("/me/change-password", auth.ChangePasswordHandler).Methods("POST")
-	v1.HandleFunc("/users", api.GetUsersHandler).Methods("GET")
-	v1.HandleFunc("/users", api.CreateUserHandler).Methods("POST")
+	v1.Handle("/me", auth.Require(auth.PermRead, auth.MeHandler)).Methods("GET")
+	v1.Handle("/me/change-password", auth.Require(auth.PermRead, auth.ChangePasswordHandler)).Methods("POST")
+	v1.Handle("/users", auth.Require(auth.PermManageUsers, api.GetUsersHandler)).Methods("GET")
+	v1.Handle("/users", auth.Require(auth.PermManageUsers, api.CreateUserHandler)).Methods("POST")
+	v1.Handle("/users", auth.Require(auth.PermManageUsers, api.UpdateUserHandler)).Methods("PUT")
+	v1.Handle("/users", auth.Require(auth.PermManageUsers, api.DeleteUserHandler)).Methods("DELETE")
+	v1.Handle("/users/reset-password", auth.Require(auth.PermManageUsers, api.ResetUserPasswordHandler)).Methods("POST")
 
 	// Devices & Commands
-	v1.HandleFunc("/devices", api.GetDevicesHandler).Methods("GET")
-	v1.HandleFunc("/devices", api.AddDeviceHandler).Methods("POST")
-	v1.HandleFunc("/devices", api.DeleteDeviceHandler).Methods("DELETE")
-	v1.HandleFunc("/devices/command", api.RunCommandHandler).Methods("POST") // Ad-hoc
+	v1.Handle("/devices", auth.Require(auth.PermRead, api.GetDevicesHandler)).Methods("GET")
+	v1.Handle("/devices", auth.Require(auth.PermManageDevices, api.AddDeviceHandler)).Methods("POST")
+	v1.Handle("/devices", auth.Require(auth.PermManageDevices, api.DeleteDeviceHandler)).Methods("DELETE")
+	v1.Handle("/devices/hostkeys", auth.Require(auth.PermManageDevices, api.GetHostKeysHandler)).Methods("GET")
+	v1.Handle("/devices/hostkeys", auth.Require(auth.PermManageDevices, api.ResetHostKeyHandler)).Methods("DELETE")
+	v1.Handle("/devices/hostkeys/accept", auth.Require(auth.PermManageDevices, api.AcceptHostKeyHandler)).Methods("POST")
+	v1.Handle("/devices/{id}/interfaces", auth.Require(auth.PermRead, api.GetDeviceInterfacesHandler)).Methods("GET")
+	v1.Handle("/devices/{id}/optical-power", auth.Require(auth.PermRead, api.GetOpticalPowerHandler)).Methods("GET")
+	v1.Handle("/devices/{id}/export", auth.Require(auth.PermExecute, api.ExportDeviceConfigHandler)).Methods("GET")
+	v1.Handle("/devices/{id}/configs", auth.Require(auth.PermRead, api.GetConfigVersionsHandler)).Methods("GET")
+	v1.Handle("/devices/{id}/configs/diff", auth.Require(auth.PermExecute, api.DiffConfigVersionsHandler)).Methods("GET")
+	v1.Handle("/devices/{id}/configs/{version:[0-9]+}", auth.Require(auth.PermExecute, api.GetConfigVersionHandler)).Methods("GET")
+	v1.Handle("/devices/{id}/configs/{version:[0-9]+}/baseline", auth.Require(auth.PermManageBackups, api.SetConfigBaselineHandler)).Methods("PUT")
+	v1.Handle("/devices/{id}/configs/{version:[0-9]+}/restore", auth.Require(auth.PermManageBackups, api.RestoreConfigVersionHandler)).Methods("POST")
+	v1.Handle("/devices/{id}/configs/baseline", auth.Require(auth.PermManageBackups, api.ClearConfigBaselineHandler)).Methods("DELETE")
+	v1.Handle("/devices/{id}/configs/drift", auth.Require(auth.PermExecute, api.CheckDeviceDriftHandler)).Methods("POST")
+	v1.Handle("/config-drift", auth.Require(auth.PermRead, api.GetConfigDriftHandler)).Methods("GET")
+	v1.Handle("/devices/{id}/reboot", auth.Require(auth.PermExecute, api.RebootDeviceHandler)).Methods("POST")
+	v1.Handle("/devices/command", auth.Require(auth.PermExecute, api.RunCommandHandler)).Methods("POST") // Ad-hoc
+
+	// Credentials
+	v1.Handle("/admin/secrets/rotate", auth.Require(auth.PermManageSecrets, api.RotateMasterKeyHandler)).Methods("POST")
+
+	// Session Recordings (audit)
+	v1.Handle("/recordings", auth.Require(auth.PermAudit, api.GetRecordingsHandler)).Methods("GET")
+	v1.Handle("/recordings/{id}/download", auth.Require(auth.PermAudit, api.DownloadRecordingHandler)).Methods("GET")
+	v1.Handle("/recordings/{id}/replay", auth.Require(auth.PermAudit, api.ReplayRecordingHandler)).Methods("GET")
 
 	// Custom Commands
-	v1.HandleFunc("/commands", api.GetCustomCommandsHandler).Methods("GET")
-	v1.HandleFunc("/commands", api.CreateCustomCommandHandler).Methods("POST")
-	v1.HandleFunc("/commands/execute", api.RunCustomCommandHandler).Methods("POST") // Execute Saved
+	v1.Handle("/commands", auth.Require(auth.PermRead, api.GetCustomCommandsHandler)).Methods("GET")
+	v1.Handle("/commands", auth.Require(auth.PermExecute, api.CreateCustomCommandHandler)).Methods("POST")
+	v1.Handle("/commands/execute", auth.Require(auth.PermExecute, api.RunCustomCommandHandler)).Methods("POST") // Execute Saved
 
 	// Network & OLT
-	v1.HandleFunc("/network/critical-signals", api.GetTopCriticalSignalsHandler).Methods("GET")
-	v1.HandleFunc("/olt/stats", api.GetOltStatsHandler).Methods("GET")
-	v1.HandleFunc("/pon/{id}/status", api.GetPonStatusHandler).Methods("GET")
-	v1.HandleFunc("/onus/unregistered", api.GetUnregisteredOnusHandler).Methods("GET")
-	v1.HandleFunc("/onus/install", api.InstallOnuHandler).Methods("POST")
+	v1.Handle("/network/critical-signals", auth.Require(auth.PermRead, api.GetTopCriticalSignalsHandler)).Methods("GET")
+	v1.Handle("/signals/history", auth.Require(auth.PermRead, api.GetSignalHistoryHandler)).Methods("GET")
+	v1.Handle("/signals/degradation", auth.Require(auth.PermRead, api.GetSignalDegradationHandler)).Methods("GET")
+	v1.Handle("/olt/stats", auth.Require(auth.PermRead, api.GetOltStatsHandler)).Methods("GET")
+	v1.Handle("/pon/{id}/status", auth.Require(auth.PermRead, api.GetPonStatusHandler)).Methods("GET")
+	v1.Handle("/onus/unregistered", auth.Require(auth.PermRead, api.GetUnregisteredOnusHandler)).Methods("GET")
+	v1.Handle("/onus/install", auth.Require(auth.PermExecute, api.InstallOnuHandler)).Methods("POST")
+	v1.Handle("/onus/assignments", auth.Require(auth.PermRead, api.GetOnuAssignmentsHandler)).Methods("GET")
+	v1.Handle("/onus/assignments/{id}", auth.Require(auth.PermExecute, api.DeprovisionOnuHandler)).Methods("DELETE")
+	v1.Handle("/onus/assignments/{id}/reboot", auth.Require(auth.PermExecute, api.RebootOnuHandler)).Methods("POST")
+	v1.Handle("/onus/assignments/{id}/move", auth.Require(auth.PermExecute, api.MoveOnuHandler)).Methods("POST")
+	v1.Handle("/onus/assignments/{id}/replace", auth.Require(auth.PermExecute, api.ReplaceOnuSerialHandler)).Methods("POST")
+	v1.Handle("/alarms", auth.Require(auth.PermRead, api.GetAlarmsHandler)).Methods("GET")
+	v1.Handle("/alarms/outages", auth.Require(auth.PermRead, api.GetOutagesHandler)).Methods("GET")
 
 	// Backups
-	v1.HandleFunc("/backups/config", api.GetBackupConfigHandler).Methods("GET")
-	v1.HandleFunc("/backups/config", api.UpdateBackupConfigHandler).Methods("POST")
-	v1.HandleFunc("/backups", api.GetBackupsHandler).Methods("GET")
-	v1.HandleFunc("/backups", api.DeleteBackupHandler).Methods("DELETE")
-	v1.HandleFunc("/backups/manual", api.ManualBackupHandler).Methods("POST")
-	v1.HandleFunc("/backups/test", api.TestBackupHandler).Methods("POST")
+	v1.Handle("/backups/config", auth.Require(auth.PermRead, api.GetBackupConfigHandler)).Methods("GET")
+	v1.Handle("/backups/config", auth.Require(auth.PermManageBackups, api.UpdateBackupConfigHandler)).Methods("POST")
+	v1.Handle("/backups/retention/preview", auth.Require(auth.PermManageBackups, api.PreviewRetentionHandler)).Methods("POST")
+	v1.Handle("/backups", auth.Require(auth.PermRead, api.GetBackupsHandler)).Methods("GET")
+	v1.Handle("/backups", auth.Require(auth.PermManageBackups, api.DeleteBackupHandler)).Methods("DELETE")
+	v1.Handle("/backups/manual", auth.Require(auth.PermExecute, api.ManualBackupHandler)).Methods("POST")
+	v1.Handle("/backups/test", auth.Require(auth.PermExecute, api.TestBackupHandler)).Methods("POST")
+	v1.Handle("/backups/{id}/download", auth.Require(auth.PermExecute, api.DownloadBackupHandler)).Methods("GET")
+	v1.Handle("/backups/{id}/restore", auth.Require(auth.PermManageBackups, api.RestoreBackupHandler)).Methods("POST")
 
 	// Schedules
-	v1.HandleFunc("/schedules", api.GetSchedulesHandler).Methods("GET")
-	v1.HandleFunc("/schedules", api.CreateScheduleHandler).Methods("POST")
-	v1.HandleFunc("/schedules", api.DeleteScheduleHandler).Methods("DELETE")
+	v1.Handle("/schedules", auth.Require(auth.PermRead, api.GetSchedulesHandler)).Methods("GET")
+	v1.Handle("/schedules", auth.Require(auth.PermExecute, api.CreateScheduleHandler)).Methods("POST")
+	v1.Handle("/schedules", auth.Require(auth.PermExecute, api.DeleteScheduleHandler)).Methods("DELETE")
 
 	// Syslog
-	v1.HandleFunc("/logs", api.GetLogsHandler).Methods("GET")
+	v1.Handle("/logs", auth.Require(auth.PermRead, api.GetLogsHandler)).Methods("GET")
+	v1.Handle("/logs/tail", auth.Require(auth.PermRead, api.LogTailWebSocketHandler))
+
+	// Log Alerts
+	v1.Handle("/alerts", auth.Require(auth.PermRead, api.GetAlertsHandler)).Methods("GET")
+	v1.Handle("/alerts/rules", auth.Require(auth.PermRead, api.GetAlertRulesHandler)).Methods("GET")
+	v1.Handle("/alerts/rules", auth.Require(auth.PermManageAlerts, api.CreateAlertRuleHandler)).Methods("POST")
+	v1.Handle("/alerts/rules/{id}", auth.Require(auth.PermManageAlerts, api.UpdateAlertRuleHandler)).Methods("PUT")
+	v1.Handle("/alerts/rules/{id}", auth.Require(auth.PermManageAlerts, api.DeleteAlertRuleHandler)).Methods("DELETE")
+
+	// Notifications
+	v1.Handle("/notifications/channels", auth.Require(auth.PermRead, api.GetNotificationChannelsHandler)).Methods("GET")
+	v1.Handle("/notifications/channels", auth.Require(auth.PermManageNotifications, api.CreateNotificationChannelHandler)).Methods("POST")
+	v1.Handle("/notifications/channels/{id}", auth.Require(auth.PermManageNotifications, api.UpdateNotificationChannelHandler)).Methods("PUT")
+	v1.Handle("/notifications/channels/{id}", auth.Require(auth.PermManageNotifications, api.DeleteNotificationChannelHandler)).Methods("DELETE")
+	v1.Handle("/notifications/channels/{id}/test", auth.Require(auth.PermManageNotifications, api.TestNotificationChannelHandler)).Methods("POST")
+	v1.Handle("/notifications/subscriptions", auth.Require(auth.PermRead, api.GetNotificationSubscriptionsHandler)).Methods("GET")
+	v1.Handle("/notifications/subscriptions", auth.Require(auth.PermRead, api.CreateNotificationSubscriptionHandler)).Methods("POST")
+	v1.Handle("/notifications/subscriptions/{id}", auth.Require(auth.PermRead, api.UpdateNotificationSubscriptionHandler)).Methods("PUT")
+	v1.Handle("/notifications/subscriptions/{id}", auth.Require(auth.PermRead, api.DeleteNotificationSubscriptionHandler)).Methods("DELETE")
+	v1.Handle("/notifications/templates", auth.Require(auth.PermManageNotifications, api.GetNotificationTemplatesHandler)).Methods("GET")
+	v1.Handle("/notifications/templates/{event}", auth.Require(auth.PermManageNotifications, api.UpdateNotificationTemplateHandler)).Methods("PUT")
+	v1.Handle("/notifications/templates/{event}", auth.Require(auth.PermManageNotifications, api.ResetNotificationTemplateHandler)).Methods("DELETE")
+	v1.Handle("/notifications/deliveries", auth.Require(auth.PermRead, api.GetNotificationDeliveriesHandler)).Methods("GET")
 
 	// Provisioning
-	v1.HandleFunc("/provision", api.GetProvisionScriptHandler).Methods("GET")
-	v1.HandleFunc("/public-key", api.GetPublicKeyHandler).Methods("GET")
+	v1.Handle("/provision", auth.Require(auth.PermManageDevices, api.GetProvisionScriptHandler)).Methods("GET")
+	v1.Handle("/public-key", auth.Require(auth.PermRead, api.GetPublicKeyHandler)).Methods("GET")
 
 	// WebSocket (For Terminal / Realtime)
 	// Note: WS usually bypasses JSON middleware, but needs Auth.
 	// Handled inside handler or query param token. For prototype, assuming session/public or check origin.
-	v1.HandleFunc("/ws", api.SSHWebSocketHandler)
+	v1.Handle("/ws", auth.Require(auth.PermExecute, api.SSHWebSocketHandler))
 
 	// Serve Static Files (Embedded)
 	// web.Assets is embed.FS (root is "index.html" etc)
@@ -126,11 +196,16 @@ func main() {
 	}
 
 	// Start Syslog Server
+	go api.StartLogWriter()
+	go api.StartAlertEngine()
+	go notify.Start()
 	go api.StartSyslogServer()
 
 	// Start Scheduler
 	go worker.StartScheduler()
 	go worker.StartBackupWorker()
+	go worker.StartOltCollector()
+	go worker.StartSignalCollector()
 
 	// Run our server in a goroutine so that it doesn't block.
 	go func() {
@@ -0,0 +1,358 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"mikromon/internal/db"
+	"mikromon/internal/driver"
+	"mikromon/internal/persistence"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ONU alarms are parsed out of OLT syslog messages (processLog). One row per
+// fault: a repeated raise bumps Count, a clear closes the row.
+
+// OnuAlarm is an active or cleared ONU fault
+type OnuAlarm struct {
+	ID         primitive.ObjectID `json:"id" bson:"_id"`
+	DeviceID   string             `json:"device_id" bson:"device_id"`
+	DeviceName string             `json:"device_name" bson:"device_name"`
+	Port       string             `json:"port" bson:"port"`
+	OnuID      int                `json:"onu_id" bson:"onu_id"` // -1 when unknown
+	OnuSerial  string             `json:"onu_serial" bson:"onu_serial"`
+	UserRef    string             `json:"user_ref,omitempty" bson:"user_ref,omitempty"`
+	Type       string             `json:"type" bson:"type"` // los, dying_gasp, low_power
+	Active     bool               `json:"active" bson:"active"`
+	Count      int                `json:"count" bson:"count"`
+	Message    string             `json:"message" bson:"message"` // Last one received
+	RaisedAt   time.Time          `json:"raised_at" bson:"raised_at"`
+	LastSeen   time.Time          `json:"last_seen" bson:"last_seen"`
+	ClearedAt  *time.Time         `json:"cleared_at,omitempty" bson:"cleared_at,omitempty"`
+}
+
+// PonOutage groups the active outage alarms of one PON port
+type PonOutage struct {
+	DeviceID   string     `json:"device_id"`
+	DeviceName string     `json:"device_name"`
+	Port       string     `json:"port"`
+	Affected   int        `json:"affected"`
+	Connected  int        `json:"connected,omitempty"` // ONUs on the port at the last OLT poll
+	LOS        int        `json:"los"`
+	DyingGasp  int        `json:"dying_gasp"`
+	Cause      string     `json:"cause"`   // "fiber" (mostly LOS) or "power" (mostly dying gasp)
+	Massive    bool       `json:"massive"` // Many ONUs at once, likely one fault upstream
+	Since      time.Time  `json:"since"`
+	Alarms     []OnuAlarm `json:"alarms"`
+}
+
+const (
+	// Cleared alarms kept without MongoDB
+	maxClearedAlarms = 1000
+	// A port with this many ONUs down is reported as one incident
+	massOutageOnus = 4
+)
+
+var (
+	alarmMu       sync.Mutex
+	mockAlarms    []OnuAlarm
+	mockAlarmOnce sync.Once
+)
+
+// loadMockAlarms reads the JSON file once. Caller holds alarmMu.
+func loadMockAlarms() {
+	mockAlarmOnce.Do(func() {
+		if err := persistence.GetStore().Load(persistence.AlarmsFile, &mockAlarms); err != nil {
+			mockAlarms = []OnuAlarm{}
+		}
+	})
+}
+
+// saveMockAlarms drops the oldest cleared alarms beyond the cap and writes
+// the JSON file. Caller holds alarmMu.
+func saveMockAlarms() {
+	cleared := 0
+	for i := len(mockAlarms) - 1; i >= 0; i-- {
+		if mockAlarms[i].Active {
+			continue
+		}
+		if cleared++; cleared > maxClearedAlarms {
+			mockAlarms = append(mockAlarms[:i], mockAlarms[i+1:]...)
+		}
+	}
+	if err := persistence.GetStore().Save(persistence.AlarmsFile, mockAlarms); err != nil {
+		log.Printf("Error saving alarms: %v", err)
+	}
+}
+
+// ingestAlarm records the alarm in a syslog message, if there is one
+func ingestAlarm(ip, message string) {
+	// Cheap check first, most messages are not alarms
+	if _, ok := driver.ParseAlarm("", message); !ok {
+		return
+	}
+	device, ok := deviceByIP(ip)
+	if !ok || !isOLT(device) {
+		return
+	}
+	a, ok := driver.ParseAlarm(device.Vendor, message)
+	if !ok {
+		return
+	}
+	if err := recordAlarm(device, a, strings.TrimSpace(message), time.Now()); err != nil {
+		log.Printf("Error recording alarm from %s: %v", device.Name, err)
+	}
+}
+
+// resolveOnu fills in what the alarm left out from the provisioned ONUs
+func resolveOnu(device Device, a *driver.Alarm) (userRef string) {
+	list, err := listAssignments([]string{device.ID.Hex()}, "", a.Serial)
+	if err != nil {
+		return ""
+	}
+	for _, as := range list {
+		if a.Serial != "" || (as.PonPort == a.Port && as.OnuID == a.OnuID) {
+			if a.Port == "" || a.OnuID < 0 {
+				a.Port, a.OnuID = as.PonPort, as.OnuID
+			}
+			if a.Serial == "" {
+				a.Serial = as.SerialNumber
+			}
+			return as.UserRef
+		}
+	}
+	return ""
+}
+
+// recordAlarm opens, refreshes or clears the alarm row of an ONU
+func recordAlarm(device Device, a driver.Alarm, message string, at time.Time) error {
+	userRef := resolveOnu(device, &a)
+	key := bson.M{"device_id": device.ID.Hex(), "port": a.Port, "onu_id": a.OnuID, "type": a.Type, "active": true}
+	if a.OnuID < 0 {
+		key["onu_serial"] = a.Serial
+	}
+
+	collection := db.GetCollection("onu_alarms")
+	if collection == nil {
+		alarmMu.Lock()
+		defer alarmMu.Unlock()
+		loadMockAlarms()
+
+		idx := -1
+		for i, m := range mockAlarms {
+			if m.Active && m.DeviceID == device.ID.Hex() && m.Port == a.Port && m.OnuID == a.OnuID &&
+				m.Type == a.Type && (a.OnuID >= 0 || m.OnuSerial == a.Serial) {
+				idx = i
+				break
+			}
+		}
+		switch {
+		case a.Cleared && idx < 0:
+			return nil
+		case a.Cleared:
+			mockAlarms[idx].Active = false
+			mockAlarms[idx].ClearedAt = &at
+			mockAlarms[idx].Message = message
+		case idx >= 0:
+			mockAlarms[idx].Count++
+			mockAlarms[idx].LastSeen = at
+			mockAlarms[idx].Message = message
+		default:
+			mockAlarms = append(mockAlarms, OnuAlarm{
+				ID:         primitive.NewObjectID(),
+				DeviceID:   device.ID.Hex(),
+				DeviceName: device.Name,
+				Port:       a.Port,
+				OnuID:      a.OnuID,
+				OnuSerial:  a.Serial,
+				UserRef:    userRef,
+				Type:       a.Type,
+				Active:     true,
+				Count:      1,
+				Message:    message,
+				RaisedAt:   at,
+				LastSeen:   at,
+			})
+		}
+		saveMockAlarms()
+		return nil
+	}
+
+	if a.Cleared {
+		_, err := collection.UpdateMany(context.TODO(), key, bson.M{"$set": bson.M{
+			"active":     false,
+			"cleared_at": at,
+			"message":    message,
+		}})
+		return err
+	}
+
+	onInsert := bson.M{
+		"_id":         primitive.NewObjectID(),
+		"device_name": device.Name,
+		"user_ref":    userRef,
+		"raised_at":   at,
+	}
+	if a.OnuID >= 0 {
+		onInsert["onu_serial"] = a.Serial
+	}
+	_, err := collection.UpdateOne(context.TODO(), key, bson.M{
+		"$set":         bson.M{"last_seen": at, "message": message},
+		"$inc":         bson.M{"count": 1},
+		"$setOnInsert": onInsert,
+	}, options.Update().SetUpsert(true))
+	return err
+}
+
+// listAlarms returns alarms of the given devices, newest first. active nil means both states.
+func listAlarms(deviceIDs []string, active *bool, port, alarmType string, limit int) ([]OnuAlarm, error) {
+	list := []OnuAlarm{}
+	collection := db.GetCollection("onu_alarms")
+	if collection == nil {
+		alarmMu.Lock()
+		defer alarmMu.Unlock()
+		loadMockAlarms()
+		allowed := map[string]bool{}
+		for _, id := range deviceIDs {
+			allowed[id] = true
+		}
+		for i := len(mockAlarms) - 1; i >= 0 && len(list) < limit; i-- {
+			m := mockAlarms[i]
+			if !allowed[m.DeviceID] || (active != nil && m.Active != *active) ||
+				(port != "" && m.Port != port) || (alarmType != "" && m.Type != alarmType) {
+				continue
+			}
+			list = append(list, m)
+		}
+		return list, nil
+	}
+
+	filter := bson.M{"device_id": bson.M{"$in": deviceIDs}}
+	if active != nil {
+		filter["active"] = *active
+	}
+	if port != "" {
+		filter["port"] = port
+	}
+	if alarmType != "" {
+		filter["type"] = alarmType
+	}
+	opts := options.Find().SetSort(bson.M{"raised_at": -1}).SetLimit(int64(limit))
+	cursor, err := collection.Find(context.TODO(), filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(context.TODO())
+	err = cursor.All(context.TODO(), &list)
+	return list, err
+}
+
+// GetAlarmsHandler lists ONU alarms
+// GET /alarms?state=active|cleared|all&device_id=&port=&type=&limit=
+func GetAlarmsHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	var active *bool
+	switch q.Get("state") {
+	case "", "active":
+		v := true
+		active = &v
+	case "cleared":
+		v := false
+		active = &v
+	case "all":
+	default:
+		http.Error(w, "state must be active, cleared or all", http.StatusBadRequest)
+		return
+	}
+	limit := 500
+	if v, err := strconv.Atoi(q.Get("limit")); err == nil && v > 0 && v < limit {
+		limit = v
+	}
+
+	list, err := listAlarms(visibleDeviceIDs(r, q.Get("device_id")), active, q.Get("port"), q.Get("type"), limit)
+	if err != nil {
+		http.Error(w, "Error fetching alarms", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
+
+// GetOutagesHandler groups the ONUs currently down (LOS or dying gasp) by PON
+// port, so a fiber cut shows up as one incident. Biggest first.
+// GET /alarms/outages?device_id=
+func GetOutagesHandler(w http.ResponseWriter, r *http.Request) {
+	active := true
+	list, err := listAlarms(visibleDeviceIDs(r, r.URL.Query().Get("device_id")), &active, "", "", 100000)
+	if err != nil {
+		http.Error(w, "Error fetching alarms", http.StatusInternalServerError)
+		return
+	}
+
+	index := map[string]int{}
+	outages := []PonOutage{}
+	for _, a := range list {
+		if a.Type != driver.AlarmLOS && a.Type != driver.AlarmDyingGasp {
+			continue
+		}
+		key := a.DeviceID + "|" + a.Port
+		i, ok := index[key]
+		if !ok {
+			i = len(outages)
+			index[key] = i
+			outages = append(outages, PonOutage{DeviceID: a.DeviceID, DeviceName: a.DeviceName, Port: a.Port, Since: a.RaisedAt})
+		}
+		o := &outages[i]
+		if a.Type == driver.AlarmLOS {
+			o.LOS++
+		} else {
+			o.DyingGasp++
+		}
+		if a.RaisedAt.Before(o.Since) {
+			o.Since = a.RaisedAt
+		}
+		o.Alarms = append(o.Alarms, a)
+	}
+
+	for i := range outages {
+		o := &outages[i]
+		// An ONU often sends a dying gasp and then LOS; count it once
+		onus := map[string]bool{}
+		for _, a := range o.Alarms {
+			if a.OnuID >= 0 {
+				onus[strconv.Itoa(a.OnuID)] = true
+			} else {
+				onus[a.OnuSerial] = true
+			}
+		}
+		o.Affected = len(onus)
+		o.Massive = o.Affected >= massOutageOnus
+		o.Cause = "fiber"
+		if o.DyingGasp > o.LOS {
+			o.Cause = "power"
+		}
+		if stats, ok := getOltStats(o.DeviceID); ok {
+			for _, p := range stats.PortDensity {
+				if p.Port == o.Port {
+					o.Connected = p.Connected
+				}
+			}
+		}
+	}
+	sort.SliceStable(outages, func(i, j int) bool {
+		return outages[i].Affected > outages[j].Affected
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(outages)
+}
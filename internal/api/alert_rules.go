@@ -0,0 +1,350 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"mikromon/internal/audit"
+	"mikromon/internal/db"
+	"mikromon/internal/persistence"
+	"mikromon/internal/syslog"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// AlertRule is evaluated against every syslog entry (see alerts.go)
+type AlertRule struct {
+	ID      primitive.ObjectID `json:"id" bson:"_id"`
+	Name    string             `json:"name" bson:"name"`
+	Enabled bool               `json:"enabled" bson:"enabled"`
+
+	// Which entries count. Empty fields match everything.
+	Pattern     string   `json:"pattern" bson:"pattern"`           // Regex on the message
+	MinSeverity string   `json:"min_severity" bson:"min_severity"` // Entries this severe or worse (e.g. "warning")
+	AppName     string   `json:"app_name,omitempty" bson:"app_name,omitempty"`
+	DeviceIDs   []string `json:"device_ids,omitempty" bson:"device_ids,omitempty"`
+	DeviceType  string   `json:"device_type,omitempty" bson:"device_type,omitempty"`   // OLT, ROUTER...
+	DeviceGroup string   `json:"device_group,omitempty" bson:"device_group,omitempty"` // Device.Group
+
+	// Fires when Threshold matches from one device happen within Window.
+	// Threshold 0 or 1 fires on every match.
+	Threshold int    `json:"threshold" bson:"threshold"`
+	Window    string `json:"window" bson:"window"` // Go duration, e.g. "5m"
+	// Resolved after this long without matches (default: Window, at least 5m)
+	ResolveAfter string `json:"resolve_after,omitempty" bson:"resolve_after,omitempty"`
+	Severity     string `json:"severity" bson:"severity"` // Of the alert: critical, warning, info
+
+	CreatedBy string    `json:"created_by" bson:"created_by"`
+	CreatedAt time.Time `json:"created_at" bson:"created_at"`
+	UpdatedAt time.Time `json:"updated_at" bson:"updated_at"`
+}
+
+const (
+	minResolveAfter = 5 * time.Minute
+	maxRuleWindow   = 24 * time.Hour
+	maxRulePattern  = 1024
+)
+
+// compiledRule is a rule ready for evaluation
+type compiledRule struct {
+	AlertRule
+	regex        *regexp.Regexp
+	maxSeverity  int
+	window       time.Duration
+	resolveAfter time.Duration
+}
+
+// compile validates the rule; the error is meant for the client
+func (rule *AlertRule) compile() (*compiledRule, error) {
+	c := &compiledRule{AlertRule: *rule, maxSeverity: 7}
+	if strings.TrimSpace(rule.Name) == "" {
+		return nil, fmt.Errorf("name is required")
+	}
+	if rule.Pattern != "" {
+		if len(rule.Pattern) > maxRulePattern {
+			return nil, fmt.Errorf("pattern longer than %d characters", maxRulePattern)
+		}
+		re, err := regexp.Compile(rule.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern: %v", err)
+		}
+		c.regex = re
+	}
+	if rule.MinSeverity != "" {
+		sev, ok := syslog.ParseSeverity(rule.MinSeverity)
+		if !ok {
+			return nil, fmt.Errorf("unknown min_severity %q", rule.MinSeverity)
+		}
+		c.maxSeverity = sev
+	}
+	if rule.Threshold < 0 {
+		return nil, fmt.Errorf("threshold must not be negative")
+	}
+	if rule.Threshold > 1 {
+		d, err := time.ParseDuration(rule.Window)
+		if err != nil || d <= 0 || d > maxRuleWindow {
+			return nil, fmt.Errorf("window must be a duration up to 24h when threshold > 1")
+		}
+		c.window = d
+	}
+	c.resolveAfter = max(c.window, minResolveAfter)
+	if rule.ResolveAfter != "" {
+		d, err := time.ParseDuration(rule.ResolveAfter)
+		if err != nil || d < time.Minute {
+			return nil, fmt.Errorf("resolve_after must be a duration of at least 1m")
+		}
+		c.resolveAfter = d
+	}
+	switch rule.Severity {
+	case "":
+		c.Severity = "warning"
+	case "critical", "warning", "info":
+	default:
+		return nil, fmt.Errorf("severity must be critical, warning or info")
+	}
+	return c, nil
+}
+
+// match tells whether an entry from device counts for the rule
+func (c *compiledRule) match(e LogEntry, device *Device) bool {
+	if !c.Enabled || e.Severity > c.maxSeverity {
+		return false
+	}
+	if c.AppName != "" && !strings.EqualFold(c.AppName, e.AppName) {
+		return false
+	}
+	if len(c.DeviceIDs) > 0 || c.DeviceType != "" || c.DeviceGroup != "" {
+		if device == nil {
+			return false
+		}
+		if len(c.DeviceIDs) > 0 && !containsString(c.DeviceIDs, device.ID.Hex()) {
+			return false
+		}
+		if c.DeviceType != "" && !strings.EqualFold(c.DeviceType, device.Type) {
+			return false
+		}
+		if c.DeviceGroup != "" && !strings.EqualFold(c.DeviceGroup, device.Group) {
+			return false
+		}
+	}
+	return c.regex == nil || c.regex.MatchString(e.Message)
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+var (
+	ruleMu        sync.Mutex
+	mockRules     []AlertRule
+	mockRulesOnce sync.Once
+)
+
+// loadMockRules reads the JSON file once. Caller holds ruleMu.
+func loadMockRules() {
+	mockRulesOnce.Do(func() {
+		if err := persistence.GetStore().Load(persistence.AlertRulesFile, &mockRules); err != nil {
+			mockRules = []AlertRule{}
+		}
+	})
+}
+
+func listAlertRules() ([]AlertRule, error) {
+	collection := db.GetCollection("alert_rules")
+	if collection == nil {
+		ruleMu.Lock()
+		defer ruleMu.Unlock()
+		loadMockRules()
+		return append([]AlertRule{}, mockRules...), nil
+	}
+
+	rules := []AlertRule{}
+	cursor, err := collection.Find(context.TODO(), bson.M{})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(context.TODO())
+	err = cursor.All(context.TODO(), &rules)
+	return rules, err
+}
+
+// saveAlertRule inserts or replaces by ID
+func saveAlertRule(rule AlertRule) error {
+	defer reloadAlertRules()
+	collection := db.GetCollection("alert_rules")
+	if collection == nil {
+		ruleMu.Lock()
+		defer ruleMu.Unlock()
+		loadMockRules()
+		replaced := false
+		for i := range mockRules {
+			if mockRules[i].ID == rule.ID {
+				mockRules[i] = rule
+				replaced = true
+			}
+		}
+		if !replaced {
+			mockRules = append(mockRules, rule)
+		}
+		return persistence.GetStore().Save(persistence.AlertRulesFile, mockRules)
+	}
+
+	_, err := collection.ReplaceOne(context.TODO(), bson.M{"_id": rule.ID}, rule, options.Replace().SetUpsert(true))
+	return err
+}
+
+// deleteAlertRule reports whether the rule existed
+func deleteAlertRule(id primitive.ObjectID) (bool, error) {
+	defer reloadAlertRules()
+	collection := db.GetCollection("alert_rules")
+	if collection == nil {
+		ruleMu.Lock()
+		defer ruleMu.Unlock()
+		loadMockRules()
+		for i := range mockRules {
+			if mockRules[i].ID == id {
+				mockRules = append(mockRules[:i], mockRules[i+1:]...)
+				return true, persistence.GetStore().Save(persistence.AlertRulesFile, mockRules)
+			}
+		}
+		return false, nil
+	}
+
+	res, err := collection.DeleteOne(context.TODO(), bson.M{"_id": id})
+	if err != nil {
+		return false, err
+	}
+	return res.DeletedCount > 0, nil
+}
+
+func findAlertRule(id primitive.ObjectID) (AlertRule, bool) {
+	rules, err := listAlertRules()
+	if err != nil {
+		return AlertRule{}, false
+	}
+	for _, r := range rules {
+		if r.ID == id {
+			return r, true
+		}
+	}
+	return AlertRule{}, false
+}
+
+// GetAlertRulesHandler lists the rules (GET /alerts/rules)
+func GetAlertRulesHandler(w http.ResponseWriter, r *http.Request) {
+	rules, err := listAlertRules()
+	if err != nil {
+		http.Error(w, "Error fetching rules", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(rules)
+}
+
+// decodeAlertRule reads and validates a rule from the body
+func decodeAlertRule(w http.ResponseWriter, r *http.Request) (AlertRule, bool) {
+	var rule AlertRule
+	if err := json.NewDecoder(r.Body).Decode(&rule); err != nil {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return AlertRule{}, false
+	}
+	c, err := rule.compile()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return AlertRule{}, false
+	}
+	return c.AlertRule, true
+}
+
+// CreateAlertRuleHandler adds a rule (POST /alerts/rules)
+func CreateAlertRuleHandler(w http.ResponseWriter, r *http.Request) {
+	rule, ok := decodeAlertRule(w, r)
+	if !ok {
+		return
+	}
+	username, _ := r.Context().Value("username").(string)
+	rule.ID = primitive.NewObjectID()
+	rule.CreatedBy = username
+	rule.CreatedAt = time.Now()
+	rule.UpdatedAt = rule.CreatedAt
+
+	if err := saveAlertRule(rule); err != nil {
+		log.Printf("Error saving alert rule: %v", err)
+		http.Error(w, "Error saving rule", http.StatusInternalServerError)
+		return
+	}
+	audit.LogAction(username, "alert_rule_create", rule.Name, rule.Pattern)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(rule)
+}
+
+// UpdateAlertRuleHandler replaces a rule (PUT /alerts/rules/{id})
+func UpdateAlertRuleHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := primitive.ObjectIDFromHex(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Rule not found", http.StatusNotFound)
+		return
+	}
+	old, ok := findAlertRule(id)
+	if !ok {
+		http.Error(w, "Rule not found", http.StatusNotFound)
+		return
+	}
+	rule, ok := decodeAlertRule(w, r)
+	if !ok {
+		return
+	}
+	rule.ID, rule.CreatedBy, rule.CreatedAt = old.ID, old.CreatedBy, old.CreatedAt
+	rule.UpdatedAt = time.Now()
+
+	if err := saveAlertRule(rule); err != nil {
+		log.Printf("Error saving alert rule: %v", err)
+		http.Error(w, "Error saving rule", http.StatusInternalServerError)
+		return
+	}
+	username, _ := r.Context().Value("username").(string)
+	audit.LogAction(username, "alert_rule_update", rule.Name, rule.Pattern)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(rule)
+}
+
+// DeleteAlertRuleHandler removes a rule (DELETE /alerts/rules/{id}). Its
+// firing alerts are resolved by the engine on the next tick.
+func DeleteAlertRuleHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := primitive.ObjectIDFromHex(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Rule not found", http.StatusNotFound)
+		return
+	}
+	rule, _ := findAlertRule(id)
+	found, err := deleteAlertRule(id)
+	if err != nil {
+		http.Error(w, "Error deleting rule", http.StatusInternalServerError)
+		return
+	}
+	if !found {
+		http.Error(w, "Rule not found", http.StatusNotFound)
+		return
+	}
+	username, _ := r.Context().Value("username").(string)
+	audit.LogAction(username, "alert_rule_delete", rule.Name, "")
+	w.WriteHeader(http.StatusOK)
+}
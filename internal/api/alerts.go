@@ -0,0 +1,411 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"mikromon/internal/auth"
+	"mikromon/internal/db"
+	"mikromon/internal/notify"
+	"mikromon/internal/persistence"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Alert rules are evaluated by one goroutine (StartAlertEngine) fed by
+// processLog, so ingestion never waits on it. An alert is identified by rule
+// and device: while it is firing, new matches only bump its count, and it is
+// resolved after the rule's quiet period.
+
+const (
+	AlertFiring   = "firing"
+	AlertResolved = "resolved"
+)
+
+// Alert is one firing or resolved occurrence of a rule on a device
+type Alert struct {
+	ID          primitive.ObjectID `json:"id" bson:"_id"`
+	Fingerprint string             `json:"fingerprint" bson:"fingerprint"` // rule ID | device
+	RuleID      string             `json:"rule_id" bson:"rule_id"`
+	RuleName    string             `json:"rule_name" bson:"rule_name"`
+	Severity    string             `json:"severity" bson:"severity"`
+	DeviceID    string             `json:"device_id,omitempty" bson:"device_id,omitempty"`
+	DeviceName  string             `json:"device_name,omitempty" bson:"device_name,omitempty"`
+	DeviceIP    string             `json:"device_ip" bson:"device_ip"`
+	State       string             `json:"state" bson:"state"`
+	Count       int                `json:"count" bson:"count"` // Matches since it fired
+	LastMessage string             `json:"last_message" bson:"last_message"`
+	StartsAt    time.Time          `json:"starts_at" bson:"starts_at"`
+	LastSeen    time.Time          `json:"last_seen" bson:"last_seen"`
+	ResolvedAt  *time.Time         `json:"resolved_at,omitempty" bson:"resolved_at,omitempty"`
+}
+
+const (
+	alertQueueSize     = 10000
+	alertTickInterval  = 5 * time.Second
+	maxResolvedAlerts  = 1000
+	alertMessageMaxLen = 1024
+)
+
+type alertInput struct {
+	entry  LogEntry
+	device *Device
+}
+
+var (
+	alertQueue   = make(chan alertInput, alertQueueSize)
+	alertDropped atomic.Int64
+	rulesChanged atomic.Bool
+
+	alertMu         sync.Mutex
+	mockAlerts      []Alert
+	mockAlertsOnce  sync.Once
+	mockAlertsDirty bool
+)
+
+// evaluateLog queues an entry for the alert engine without blocking
+func evaluateLog(e LogEntry, device *Device) {
+	select {
+	case alertQueue <- alertInput{entry: e, device: device}:
+	default:
+		alertDropped.Add(1)
+	}
+}
+
+// reloadAlertRules makes the engine pick up rule changes
+func reloadAlertRules() {
+	rulesChanged.Store(true)
+}
+
+// alertEngine is owned by the StartAlertEngine goroutine
+type alertEngine struct {
+	rules  []*compiledRule
+	hits   map[string][]time.Time // Recent matches of fingerprints not firing
+	firing map[string]*Alert
+	dirty  map[string]bool // Firing alerts with unsaved counts
+}
+
+// StartAlertEngine evaluates rules against queued log entries
+func StartAlertEngine() {
+	e := &alertEngine{hits: map[string][]time.Time{}, firing: map[string]*Alert{}, dirty: map[string]bool{}}
+	e.loadRules()
+	firing, err := listAlerts(nil, AlertFiring, "", 0)
+	if err != nil {
+		log.Printf("Alerts: error loading firing alerts: %v", err)
+	}
+	for i := range firing {
+		e.firing[firing[i].Fingerprint] = &firing[i]
+	}
+
+	ticker := time.NewTicker(alertTickInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case in := <-alertQueue:
+			if rulesChanged.Swap(false) {
+				e.loadRules()
+			}
+			e.evaluate(in.entry, in.device)
+		case now := <-ticker.C:
+			if rulesChanged.Swap(false) {
+				e.loadRules()
+			}
+			e.tick(now)
+			if n := alertDropped.Swap(0); n > 0 {
+				log.Printf("Alerts: queue full, %d log entries not evaluated", n)
+			}
+		}
+	}
+}
+
+func (e *alertEngine) loadRules() {
+	rules, err := listAlertRules()
+	if err != nil {
+		log.Printf("Alerts: error loading rules: %v", err)
+		return
+	}
+	e.rules = e.rules[:0]
+	for i := range rules {
+		c, err := rules[i].compile()
+		if err != nil {
+			log.Printf("Alerts: skipping rule %q: %v", rules[i].Name, err)
+			continue
+		}
+		e.rules = append(e.rules, c)
+	}
+}
+
+func (e *alertEngine) rule(id string) *compiledRule {
+	for _, r := range e.rules {
+		if r.ID.Hex() == id {
+			return r
+		}
+	}
+	return nil
+}
+
+func (e *alertEngine) evaluate(entry LogEntry, device *Device) {
+	msg := entry.Message
+	if len(msg) > alertMessageMaxLen {
+		msg = msg[:alertMessageMaxLen]
+	}
+	for _, rule := range e.rules {
+		if !rule.match(entry, device) {
+			continue
+		}
+		source := entry.DeviceIP
+		if device != nil {
+			source = device.ID.Hex()
+		}
+		fp := rule.ID.Hex() + "|" + source
+
+		if a, ok := e.firing[fp]; ok {
+			a.Count++
+			a.LastSeen = entry.ReceivedAt
+			a.LastMessage = msg
+			e.dirty[fp] = true
+			continue
+		}
+
+		// Sliding window of matches; below the threshold nothing fires yet
+		hits := append(e.hits[fp], entry.ReceivedAt)
+		if rule.window > 0 {
+			cutoff := entry.ReceivedAt.Add(-rule.window)
+			for len(hits) > 0 && hits[0].Before(cutoff) {
+				hits = hits[1:]
+			}
+		}
+		if len(hits) < max(rule.Threshold, 1) {
+			e.hits[fp] = hits
+			continue
+		}
+		delete(e.hits, fp)
+
+		a := &Alert{
+			ID:          primitive.NewObjectID(),
+			Fingerprint: fp,
+			RuleID:      rule.ID.Hex(),
+			RuleName:    rule.Name,
+			Severity:    rule.Severity,
+			DeviceIP:    entry.DeviceIP,
+			State:       AlertFiring,
+			Count:       len(hits),
+			LastMessage: msg,
+			StartsAt:    hits[0],
+			LastSeen:    entry.ReceivedAt,
+		}
+		if device != nil {
+			a.DeviceID, a.DeviceName = device.ID.Hex(), device.Name
+		}
+		e.firing[fp] = a
+		if err := saveAlert(*a); err != nil {
+			log.Printf("Alerts: error saving alert %q: %v", a.RuleName, err)
+		}
+		log.Printf("Alert firing: %s on %s (%d matches)", a.RuleName, alertSource(*a), a.Count)
+		publishAlert(*a)
+	}
+}
+
+// tick saves counts and resolves alerts that went quiet
+func (e *alertEngine) tick(now time.Time) {
+	for fp, a := range e.firing {
+		rule := e.rule(a.RuleID)
+		if rule == nil || now.Sub(a.LastSeen) >= rule.resolveAfter {
+			a.State = AlertResolved
+			a.ResolvedAt = &now
+			delete(e.firing, fp)
+			delete(e.dirty, fp)
+			if err := saveAlert(*a); err != nil {
+				log.Printf("Alerts: error saving alert %q: %v", a.RuleName, err)
+			}
+			log.Printf("Alert resolved: %s on %s", a.RuleName, alertSource(*a))
+			publishAlert(*a)
+			continue
+		}
+		if e.dirty[fp] {
+			delete(e.dirty, fp)
+			if err := saveAlert(*a); err != nil {
+				log.Printf("Alerts: error saving alert %q: %v", a.RuleName, err)
+			}
+		}
+	}
+
+	// Forget windows that can no longer reach their threshold
+	for fp, hits := range e.hits {
+		if len(hits) == 0 || now.Sub(hits[len(hits)-1]) > maxRuleWindow {
+			delete(e.hits, fp)
+		}
+	}
+	flushMockAlerts()
+}
+
+func alertSource(a Alert) string {
+	if a.DeviceName != "" {
+		return a.DeviceName + " (" + a.DeviceIP + ")"
+	}
+	return a.DeviceIP
+}
+
+// publishAlert notifies the subscribers of a fired or resolved alert
+func publishAlert(a Alert) {
+	e := notify.Event{
+		Type:       notify.EventAlertFiring,
+		Severity:   a.Severity,
+		Title:      fmt.Sprintf("Alert firing: %s on %s", a.RuleName, alertSource(a)),
+		Message:    a.LastMessage,
+		DeviceID:   a.DeviceID,
+		DeviceName: a.DeviceName,
+		Fields:     map[string]string{"rule": a.RuleName, "source": a.DeviceIP, "matches": strconv.Itoa(a.Count)},
+	}
+	if a.State == AlertResolved {
+		e.Type = notify.EventAlertResolved
+		e.Title = fmt.Sprintf("Alert resolved: %s on %s", a.RuleName, alertSource(a))
+	}
+	if d, ok := findDevice(a.DeviceID); ok {
+		e.Owner = d.Owner
+	}
+	notify.Publish(e)
+}
+
+// loadMockAlerts reads the JSON file once. Caller holds alertMu.
+func loadMockAlerts() {
+	mockAlertsOnce.Do(func() {
+		if err := persistence.GetStore().Load(persistence.AlertsFile, &mockAlerts); err != nil {
+			mockAlerts = []Alert{}
+		}
+	})
+}
+
+// flushMockAlerts writes the JSON file when something changed, dropping the
+// oldest resolved alerts beyond the cap
+func flushMockAlerts() {
+	alertMu.Lock()
+	defer alertMu.Unlock()
+	if !mockAlertsDirty {
+		return
+	}
+	mockAlertsDirty = false
+	resolved := 0
+	for i := len(mockAlerts) - 1; i >= 0; i-- {
+		if mockAlerts[i].State != AlertResolved {
+			continue
+		}
+		if resolved++; resolved > maxResolvedAlerts {
+			mockAlerts = append(mockAlerts[:i], mockAlerts[i+1:]...)
+		}
+	}
+	if err := persistence.GetStore().Save(persistence.AlertsFile, mockAlerts); err != nil {
+		log.Printf("Error saving alerts: %v", err)
+	}
+}
+
+// saveAlert inserts or replaces by ID. Without MongoDB the file is written
+// on the next engine tick.
+func saveAlert(a Alert) error {
+	collection := db.GetCollection("alerts")
+	if collection == nil {
+		alertMu.Lock()
+		defer alertMu.Unlock()
+		loadMockAlerts()
+		mockAlertsDirty = true
+		for i := range mockAlerts {
+			if mockAlerts[i].ID == a.ID {
+				mockAlerts[i] = a
+				return nil
+			}
+		}
+		mockAlerts = append(mockAlerts, a)
+		return nil
+	}
+
+	_, err := collection.ReplaceOne(context.TODO(), bson.M{"_id": a.ID}, a, options.Replace().SetUpsert(true))
+	return err
+}
+
+// listAlerts returns alerts newest first. deviceIDs nil means every device
+// (including unknown senders); state and ruleID empty match all; limit 0 is no limit.
+func listAlerts(deviceIDs []string, state, ruleID string, limit int) ([]Alert, error) {
+	list := []Alert{}
+	collection := db.GetCollection("alerts")
+	if collection == nil {
+		alertMu.Lock()
+		defer alertMu.Unlock()
+		loadMockAlerts()
+		for i := len(mockAlerts) - 1; i >= 0 && (limit == 0 || len(list) < limit); i-- {
+			a := mockAlerts[i]
+			if (deviceIDs != nil && !containsString(deviceIDs, a.DeviceID)) ||
+				(state != "" && a.State != state) || (ruleID != "" && a.RuleID != ruleID) {
+				continue
+			}
+			list = append(list, a)
+		}
+		return list, nil
+	}
+
+	filter := bson.M{}
+	if deviceIDs != nil {
+		filter["device_id"] = bson.M{"$in": deviceIDs}
+	}
+	if state != "" {
+		filter["state"] = state
+	}
+	if ruleID != "" {
+		filter["rule_id"] = ruleID
+	}
+	opts := options.Find().SetSort(bson.M{"starts_at": -1})
+	if limit > 0 {
+		opts.SetLimit(int64(limit))
+	}
+	cursor, err := collection.Find(context.TODO(), filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(context.TODO())
+	err = cursor.All(context.TODO(), &list)
+	return list, err
+}
+
+// GetAlertsHandler lists alerts, newest first
+// GET /alerts?state=firing|resolved|all&device_id=&rule_id=&limit=
+func GetAlertsHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	state := q.Get("state")
+	switch state {
+	case "":
+		state = AlertFiring
+	case AlertFiring, AlertResolved:
+	case "all":
+		state = ""
+	default:
+		http.Error(w, "state must be firing, resolved or all", http.StatusBadRequest)
+		return
+	}
+	limit := 500
+	if v, err := strconv.Atoi(q.Get("limit")); err == nil && v > 0 && v < limit {
+		limit = v
+	}
+
+	// Alerts of unregistered senders are only shown to admins
+	var deviceIDs []string
+	role, _ := r.Context().Value("role").(string)
+	if role != auth.RoleAdmin || q.Get("device_id") != "" {
+		deviceIDs = visibleDeviceIDs(r, q.Get("device_id"))
+	}
+
+	list, err := listAlerts(deviceIDs, state, q.Get("rule_id"), limit)
+	if err != nil {
+		http.Error(w, "Error fetching alerts", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
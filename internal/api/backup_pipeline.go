@@ -0,0 +1,204 @@
+package api
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"mikromon/internal/db"
+	"mikromon/internal/driver"
+	"mikromon/internal/ssher"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Every backup, automatic or manual, goes through RunBackup: the driver
+// makes it, RouterOS files are fetched over SFTP and removed from the
+// device, and the result is kept in data/backups/<device ID>/ with its size
+// and SHA-256. Failures are recorded as backups with status "failed". The
+// text configuration is captured in the same session as a config version.
+
+const backupDir = "data/backups"
+
+// What started a backup
+const (
+	BackupAuto   = "auto"
+	BackupManual = "manual"
+)
+
+// Backup status
+const (
+	BackupOK     = "ok"
+	BackupFailed = "failed"
+)
+
+// backupMu guards MockBackups against the worker and handlers appending at once
+var backupMu sync.Mutex
+
+// backupPath is where the file of a backup is kept
+func backupPath(b Backup) string {
+	return filepath.Join(backupDir, b.DeviceID, filepath.Base(b.Filename))
+}
+
+// formatSize renders a byte count the way the backup list shows it
+func formatSize(n int64) string {
+	switch {
+	case n < 1024:
+		return fmt.Sprintf("%d B", n)
+	case n < 1024*1024:
+		return fmt.Sprintf("%.0f KB", float64(n)/1024)
+	default:
+		return fmt.Sprintf("%.1f MB", float64(n)/(1024*1024))
+	}
+}
+
+// RunBackup backs up device and records the outcome. The returned Backup is
+// the record, with status failed and the error when it did not work.
+func RunBackup(device Device, trigger string) (Backup, error) {
+	now := time.Now()
+	b := Backup{
+		ID:          primitive.NewObjectID().Hex(),
+		DeviceID:    device.ID.Hex(),
+		DeviceName:  device.Name,
+		DeviceModel: device.Model,
+		CreatedAt:   now.Format("2006-01-02 15:04"),
+		Trigger:     trigger,
+		Status:      BackupOK,
+	}
+	name := fmt.Sprintf("mikromon_%s_%s", trigger, now.Format("20060102_150405"))
+
+	config, err := fetchBackup(device, name, &b)
+	if err != nil {
+		b.Status = BackupFailed
+		b.Error = err.Error()
+		b.Size = "-"
+		if b.Filename == "" {
+			b.Filename = name
+		}
+	}
+	if rerr := recordBackup(b); rerr != nil {
+		log.Printf("Backup: error recording backup of %s: %v", device.Name, rerr)
+		if err == nil {
+			err = rerr
+		}
+	}
+	if err == nil && config != "" {
+		if _, _, cerr := saveConfigVersion(device, config, ExportSensitive(), trigger, b.ID); cerr != nil {
+			log.Printf("Backup: error saving configuration version of %s: %v", device.Name, cerr)
+		}
+	}
+	return b, err
+}
+
+// fetchBackup makes the backup and stores it locally, filling the file
+// fields of b. It also returns the text configuration, empty when the
+// export failed.
+func fetchBackup(device Device, name string, b *Backup) (string, error) {
+	if device.Port == 0 {
+		device.Port = driver.DefaultPort(device.Transport)
+	}
+	dir := filepath.Join(backupDir, device.ID.Hex())
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return "", err
+	}
+
+	if db.GetCollection("devices") == nil {
+		// Mock mode: a stand-in file so size and checksum are real
+		b.Filename = name + ".backup"
+		content := fmt.Sprintf("# MikroMon mock backup of %s (%s)\n# %s\n", device.Name, device.IP, time.Now().Format(time.RFC3339))
+		return mockExport(device), writeBackupFile(filepath.Join(dir, b.Filename), []byte(content), b)
+	}
+
+	drv, sess, err := openDriver(device)
+	if err != nil {
+		return "", err
+	}
+	result, err := drv.Backup(sess, name)
+	if err != nil {
+		sess.Close()
+		return "", err
+	}
+
+	if result.RemoteFile == "" {
+		// OLTs hand us the configuration text, which is also the export
+		sess.Close()
+		b.Filename = name + result.Extension
+		return string(result.Content), writeBackupFile(filepath.Join(dir, b.Filename), result.Content, b)
+	}
+
+	config, err := drv.ExportConfig(sess, ExportSensitive())
+	sess.Close()
+	if err != nil {
+		log.Printf("Backup: %s: configuration export failed: %v", device.Name, err)
+		config = ""
+	}
+
+	b.Filename = filepath.Base(result.RemoteFile)
+	local := filepath.Join(dir, b.Filename)
+	pool := ssher.GetPool()
+	if err := pool.DownloadFile(device.Username, device.Password, device.IP, device.Port, device.UseSSHKey, result.RemoteFile, local+".part"); err != nil {
+		os.Remove(local + ".part")
+		return "", fmt.Errorf("download failed: %v", err)
+	}
+	if err := finishBackupFile(local+".part", local, b); err != nil {
+		return "", err
+	}
+	// The copy is safe here; a file left behind only costs flash space
+	if err := pool.RemoveFile(device.Username, device.Password, device.IP, device.Port, device.UseSSHKey, result.RemoteFile); err != nil {
+		log.Printf("Backup: %s: could not remove %s from the device: %v", device.Name, result.RemoteFile, err)
+	}
+	return config, nil
+}
+
+func writeBackupFile(path string, content []byte, b *Backup) error {
+	if err := os.WriteFile(path+".part", content, 0640); err != nil {
+		os.Remove(path + ".part")
+		return err
+	}
+	return finishBackupFile(path+".part", path, b)
+}
+
+// finishBackupFile checksums a complete download and moves it in place
+func finishBackupFile(part, path string, b *Backup) error {
+	f, err := os.Open(part)
+	if err != nil {
+		return err
+	}
+	h := sha256.New()
+	n, err := io.Copy(h, f)
+	f.Close()
+	if err == nil && n == 0 {
+		err = errors.New("backup file is empty")
+	}
+	if err == nil {
+		err = os.Rename(part, path)
+	}
+	if err != nil {
+		os.Remove(part)
+		return err
+	}
+	b.SizeBytes = n
+	b.Size = formatSize(n)
+	b.SHA256 = hex.EncodeToString(h.Sum(nil))
+	return nil
+}
+
+func recordBackup(b Backup) error {
+	collection := db.GetCollection("backups")
+	if collection == nil {
+		backupMu.Lock()
+		MockBackups = append([]Backup{b}, MockBackups...)
+		backupMu.Unlock()
+		return nil
+	}
+	_, err := collection.InsertOne(context.TODO(), b)
+	return err
+}
@@ -0,0 +1,226 @@
+package api
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"mikromon/internal/audit"
+	"mikromon/internal/auth"
+	"mikromon/internal/db"
+	"mikromon/internal/driver"
+	"mikromon/internal/ssher"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// findBackup loads a backup record by its ID
+func findBackup(id string) (Backup, bool) {
+	collection := db.GetCollection("backups")
+	if collection == nil {
+		backupMu.Lock()
+		defer backupMu.Unlock()
+		for _, b := range MockBackups {
+			if b.ID == id {
+				return b, true
+			}
+		}
+		return Backup{}, false
+	}
+
+	var b Backup
+	if err := collection.FindOne(context.TODO(), bson.M{"id": id}).Decode(&b); err != nil {
+		return Backup{}, false
+	}
+	return b, true
+}
+
+// requestBackup loads the {id} backup of the route and its device, checking
+// that the caller may use it. Backups of deleted devices are admin only.
+func requestBackup(w http.ResponseWriter, r *http.Request) (Backup, Device, bool) {
+	b, ok := findBackup(mux.Vars(r)["id"])
+	if ok {
+		device, found := findDevice(b.DeviceID)
+		if found && canUseDevice(r, device) {
+			return b, device, true
+		}
+		role, _ := r.Context().Value("role").(string)
+		if !found && role == auth.RoleAdmin {
+			return b, Device{Name: b.DeviceName, Model: b.DeviceModel}, true
+		}
+	}
+	http.Error(w, "Backup not found", http.StatusNotFound)
+	return Backup{}, Device{}, false
+}
+
+// openBackupFile opens the stored file of a backup
+func openBackupFile(b Backup) (*os.File, error) {
+	if b.Status == BackupFailed {
+		return nil, fmt.Errorf("backup failed, there is no file")
+	}
+	return os.Open(backupPath(b))
+}
+
+// verifyBackupFile checks the stored file against the recorded checksum.
+// Records from before checksums were kept pass.
+func verifyBackupFile(b Backup) error {
+	f, err := openBackupFile(b)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if b.SHA256 == "" {
+		return nil
+	}
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return err
+	}
+	if hex.EncodeToString(h.Sum(nil)) != b.SHA256 {
+		return fmt.Errorf("checksum mismatch, the stored file is corrupted")
+	}
+	return nil
+}
+
+// DownloadBackupHandler streams the stored file of a backup (GET /backups/{id}/download)
+func DownloadBackupHandler(w http.ResponseWriter, r *http.Request) {
+	b, device, ok := requestBackup(w, r)
+	if !ok {
+		return
+	}
+
+	f, err := openBackupFile(b)
+	if err != nil {
+		http.Error(w, "Backup file not available", http.StatusNotFound)
+		return
+	}
+	defer f.Close()
+	st, err := f.Stat()
+	if err != nil {
+		http.Error(w, "Backup file not available", http.StatusNotFound)
+		return
+	}
+
+	username, _ := r.Context().Value("username").(string)
+	audit.LogAction(username, "backup_download", device.Name, b.Filename)
+
+	name := filepath.Base(b.Filename)
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+name+`"`)
+	if b.SHA256 != "" {
+		w.Header().Set("X-Checksum-SHA256", b.SHA256)
+	}
+	http.ServeContent(w, r, name, st.ModTime(), f)
+}
+
+// RestoreBackupHandler uploads a .backup file to a device and loads it with
+// /system backup load (the router reboots). The target defaults to the device
+// the backup came from and must be the same model; another device needs the
+// model recorded on both. "confirm" must repeat the target device name.
+// Text exports are restored from the configuration history instead, see
+// RestoreConfigVersionHandler.
+// POST /backups/{id}/restore {"device_id": "", "confirm": "<device name>"}
+func RestoreBackupHandler(w http.ResponseWriter, r *http.Request) {
+	b, source, ok := requestBackup(w, r)
+	if !ok {
+		return
+	}
+	var input struct {
+		DeviceID string `json:"device_id"`
+		Confirm  string `json:"confirm"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+
+	target := source
+	if input.DeviceID != "" && input.DeviceID != b.DeviceID {
+		device, found := findDevice(input.DeviceID)
+		if !found || !canUseDevice(r, device) {
+			http.Error(w, "Device not found", http.StatusNotFound)
+			return
+		}
+		target = device
+	}
+	if target.ID.IsZero() {
+		http.Error(w, "The device of this backup no longer exists, choose a device_id", http.StatusBadRequest)
+		return
+	}
+	if input.Confirm != target.Name {
+		http.Error(w, fmt.Sprintf("Restoring overwrites the configuration of %s: set confirm to the device name to proceed", target.Name), http.StatusPreconditionRequired)
+		return
+	}
+
+	// A device replaced by another model keeps its ID, so the model is
+	// checked even then; across devices it must be known on both sides
+	sameDevice := b.DeviceID == target.ID.Hex()
+	known := b.DeviceModel != "" && target.Model != ""
+	if (known && !strings.EqualFold(b.DeviceModel, target.Model)) || (!sameDevice && !known) {
+		http.Error(w, fmt.Sprintf("Backup is from model %q, %s is %q: restoring across models is refused", b.DeviceModel, target.Name, target.Model), http.StatusConflict)
+		return
+	}
+
+	if !strings.EqualFold(filepath.Ext(b.Filename), ".backup") {
+		http.Error(w, "Only .backup files can be restored", http.StatusBadRequest)
+		return
+	}
+	if err := verifyBackupFile(b); err != nil {
+		http.Error(w, "Backup file not usable: "+err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	username, _ := r.Context().Value("username").(string)
+	details := b.Filename
+	if source.Name != target.Name {
+		details += " from " + source.Name
+	}
+
+	if db.GetCollection("devices") != nil {
+		if err := restoreFile(target, backupPath(b), filepath.Base(b.Filename)); err != nil {
+			audit.LogAction(username, "backup_restore_failed", target.Name+" ("+target.IP+")", details+": "+err.Error())
+			http.Error(w, "Error restoring backup: "+err.Error(), driverErrorStatus(err))
+			return
+		}
+	}
+	audit.LogAction(username, "backup_restore", target.Name+" ("+target.IP+")", details)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "message": "Backup loaded, the device is rebooting"})
+}
+
+// restoreFile uploads local to device as remote and loads it (.backup) or
+// imports it (.rsc)
+func restoreFile(device Device, local, remote string) error {
+	if device.Port == 0 {
+		device.Port = driver.DefaultPort(device.Transport)
+	}
+	drv, sess, err := openDriver(device)
+	if err != nil {
+		return err
+	}
+	defer sess.Close()
+
+	pool := ssher.GetPool()
+	if err := pool.UploadFile(device.Username, device.Password, device.IP, device.Port, device.UseSSHKey, local, remote); err != nil {
+		return fmt.Errorf("upload failed: %v", err)
+	}
+	err = drv.Restore(sess, remote)
+
+	// A loaded backup stays until the reboot; an imported script can go
+	if err == nil && strings.HasSuffix(remote, ".rsc") {
+		if rerr := pool.RemoveFile(device.Username, device.Password, device.IP, device.Port, device.UseSSHKey, remote); rerr != nil {
+			log.Printf("Restore: %s: could not remove %s from the device: %v", device.Name, remote, rerr)
+		}
+	}
+	return err
+}
@@ -0,0 +1,237 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"time"
+
+	"mikromon/internal/db"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Retention follows the stored BackupConfig. Every backup of the last week
+// is kept; older ones are thinned per device to the newest N of each
+// period: AfterWeek per day past a week, AfterMonth per day past a month,
+// AfterYear per week past a year and AfterThree per month past three years.
+// A limit of 0 drops everything in its tier. The newest successful backup
+// of a device is always kept, failed records go after a week and test
+// backups are left to the test endpoint.
+
+const (
+	retentionWeek  = 7 * 24 * time.Hour
+	retentionMonth = 30 * 24 * time.Hour
+	retentionYear  = 365 * 24 * time.Hour
+	retentionThree = 3 * retentionYear
+
+	maxBackupsPerDay = 288 // The worker checks every 5 minutes
+)
+
+// LoadBackupConfig returns the stored backup configuration, or the default
+func LoadBackupConfig() BackupConfig {
+	collection := db.GetCollection("backup_config")
+	if collection == nil {
+		return MockBackupConfig
+	}
+	var config BackupConfig
+	if err := collection.FindOne(context.TODO(), bson.M{}).Decode(&config); err != nil {
+		return MockBackupConfig
+	}
+	return config
+}
+
+// Validate rejects limits that make no sense
+func (p BackupRetention) Validate() error {
+	if p.BackupsPerDay < 1 || p.BackupsPerDay > maxBackupsPerDay {
+		return fmt.Errorf("backups_per_day must be between 1 and %d", maxBackupsPerDay)
+	}
+	if p.AfterWeek < 0 || p.AfterMonth < 0 || p.AfterYear < 0 || p.AfterThree < 0 {
+		return fmt.Errorf("retention limits cannot be negative")
+	}
+	return nil
+}
+
+// BackupInterval is the time between automatic backups of a device
+func (p BackupRetention) BackupInterval() time.Duration {
+	if p.BackupsPerDay < 1 {
+		return 24 * time.Hour
+	}
+	return 24 * time.Hour / time.Duration(p.BackupsPerDay)
+}
+
+// BackupTime parses the creation time of a backup
+func BackupTime(b Backup) (time.Time, bool) {
+	t, err := time.ParseInLocation("2006-01-02 15:04", b.CreatedAt, time.Local)
+	return t, err == nil
+}
+
+// retentionBucket returns the period a backup of this age competes in and
+// how many of that period are kept. ok is false within the first week.
+func retentionBucket(p BackupRetention, created time.Time, age time.Duration) (string, int, bool) {
+	switch {
+	case age <= retentionWeek:
+		return "", 0, false
+	case age <= retentionMonth:
+		return "w" + created.Format("2006-01-02"), p.AfterWeek, true
+	case age <= retentionYear:
+		return "m" + created.Format("2006-01-02"), p.AfterMonth, true
+	case age <= retentionThree:
+		year, week := created.ISOWeek()
+		return fmt.Sprintf("y%d-%02d", year, week), p.AfterYear, true
+	}
+	return "t" + created.Format("2006-01"), p.AfterThree, true
+}
+
+// RetentionPlan returns the backups policy would delete at now
+func RetentionPlan(backups []Backup, p BackupRetention, now time.Time) []Backup {
+	type dated struct {
+		Backup
+		created time.Time
+	}
+	byDevice := map[string][]dated{}
+	for _, b := range backups {
+		if b.IsTest {
+			continue
+		}
+		created, ok := BackupTime(b)
+		if !ok {
+			continue
+		}
+		byDevice[b.DeviceID] = append(byDevice[b.DeviceID], dated{b, created})
+	}
+
+	prune := []Backup{}
+	for _, list := range byDevice {
+		sort.SliceStable(list, func(i, j int) bool { return list[i].created.After(list[j].created) })
+		kept := map[string]int{}
+		newestSeen := false
+		for _, b := range list {
+			age := now.Sub(b.created)
+			if b.Status == BackupFailed {
+				if age > retentionWeek {
+					prune = append(prune, b.Backup)
+				}
+				continue
+			}
+			if !newestSeen {
+				newestSeen = true
+				continue
+			}
+			bucket, limit, ok := retentionBucket(p, b.created, age)
+			if !ok {
+				continue
+			}
+			if kept[bucket] < limit {
+				kept[bucket]++
+				continue
+			}
+			prune = append(prune, b.Backup)
+		}
+	}
+	sort.SliceStable(prune, func(i, j int) bool { return prune[i].CreatedAt < prune[j].CreatedAt })
+	return prune
+}
+
+// allBackups returns every backup record
+func allBackups() ([]Backup, error) {
+	collection := db.GetCollection("backups")
+	if collection == nil {
+		backupMu.Lock()
+		defer backupMu.Unlock()
+		return append([]Backup{}, MockBackups...), nil
+	}
+	var backups []Backup
+	cursor, err := collection.Find(context.TODO(), bson.M{})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(context.TODO())
+	err = cursor.All(context.TODO(), &backups)
+	return backups, err
+}
+
+// DeleteBackup removes a backup record and its stored file
+func DeleteBackup(b Backup) error {
+	collection := db.GetCollection("backups")
+	if collection == nil {
+		backupMu.Lock()
+		for i, m := range MockBackups {
+			if m.ID == b.ID {
+				MockBackups = append(MockBackups[:i], MockBackups[i+1:]...)
+				break
+			}
+		}
+		backupMu.Unlock()
+	} else if _, err := collection.DeleteOne(context.TODO(), bson.M{"id": b.ID}); err != nil {
+		return err
+	}
+
+	if b.Status == BackupFailed || b.Filename == "" {
+		return nil
+	}
+	if err := os.Remove(backupPath(b)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// ApplyRetention deletes the backups the stored policy no longer keeps
+func ApplyRetention() (int, error) {
+	backups, err := allBackups()
+	if err != nil {
+		return 0, err
+	}
+	deleted := 0
+	for _, b := range RetentionPlan(backups, LoadBackupConfig().Retention, time.Now()) {
+		if err := DeleteBackup(b); err != nil {
+			log.Printf("Backup: retention could not delete %s of %s: %v", b.Filename, b.DeviceName, err)
+			continue
+		}
+		deleted++
+	}
+	return deleted, nil
+}
+
+// PreviewRetentionHandler lists what a retention policy would delete now,
+// without deleting anything. The body is the proposed BackupRetention;
+// fields left out keep their stored value.
+// POST /backups/retention/preview
+func PreviewRetentionHandler(w http.ResponseWriter, r *http.Request) {
+	policy := LoadBackupConfig().Retention
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&policy); err != nil {
+			http.Error(w, "Invalid input", http.StatusBadRequest)
+			return
+		}
+	}
+	if err := policy.Validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	backups, err := allBackups()
+	if err != nil {
+		http.Error(w, "Error fetching backups", http.StatusInternalServerError)
+		return
+	}
+	prune := RetentionPlan(backups, policy, time.Now())
+	var bytes int64
+	for _, b := range prune {
+		bytes += b.SizeBytes
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"policy":      policy,
+		"total":       len(backups),
+		"prune":       prune,
+		"prune_count": len(prune),
+		"prune_bytes": bytes,
+		"interval":    policy.BackupInterval().String(),
+	})
+}
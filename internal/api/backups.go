@@ -4,14 +4,19 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"log"
+	"mikromon/internal/audit"
 	"mikromon/internal/db"
+	"mikromon/internal/driver"
 	"mikromon/internal/ssher"
 	"net/http"
+	"os"
 	"path/filepath"
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 type BackupRetention struct {
@@ -23,13 +28,20 @@ type BackupRetention struct {
 }
 
 type Backup struct {
-	ID         string `json:"id" bson:"id"`
-	DeviceID   string `json:"device_id" bson:"device_id"`
-	DeviceName string `json:"device_name" bson:"device_name"`
-	Filename   string `json:"filename" bson:"filename"`
-	Size       string `json:"size" bson:"size"`
-	CreatedAt  string `json:"created_at" bson:"created_at"`
-	IsTest     bool   `json:"is_test" bson:"is_test"` // Flag for test backups
+	ID          string `json:"id" bson:"id"`
+	DeviceID    string `json:"device_id" bson:"device_id"`
+	DeviceName  string `json:"device_name" bson:"device_name"`
+	DeviceModel string `json:"device_model,omitempty" bson:"device_model,omitempty"` // Restores only go to the same model
+	Filename    string `json:"filename" bson:"filename"`
+	Size        string `json:"size" bson:"size"`
+	CreatedAt   string `json:"created_at" bson:"created_at"`
+	IsTest      bool   `json:"is_test" bson:"is_test"` // Flag for test backups
+
+	SizeBytes int64  `json:"size_bytes,omitempty" bson:"size_bytes,omitempty"`
+	SHA256    string `json:"sha256,omitempty" bson:"sha256,omitempty"`
+	Trigger   string `json:"trigger,omitempty" bson:"trigger,omitempty"` // auto, manual
+	Status    string `json:"status,omitempty" bson:"status,omitempty"`   // ok, failed (empty on older records)
+	Error     string `json:"error,omitempty" bson:"error,omitempty"`
 }
 
 type BackupConfig struct {
@@ -42,6 +54,13 @@ var MockBackups = []Backup{
 	{ID: "2", DeviceID: "d2", DeviceName: "Borda-Mikrotik", Filename: "Borda-Mikrotik_20260130_1145.backup", Size: "128 KB", CreatedAt: "2026-01-30 11:45"},
 }
 
+// MockBackupList returns a copy of MockBackups that is safe to range over
+func MockBackupList() []Backup {
+	backupMu.Lock()
+	defer backupMu.Unlock()
+	return append([]Backup{}, MockBackups...)
+}
+
 var MockBackupConfig = BackupConfig{
 	Enabled: true,
 	Retention: BackupRetention{
@@ -54,24 +73,8 @@ var MockBackupConfig = BackupConfig{
 }
 
 func GetBackupConfigHandler(w http.ResponseWriter, r *http.Request) {
-	collection := db.GetCollection("backup_config")
-	if collection == nil {
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(MockBackupConfig)
-		return
-	}
-
-	var config BackupConfig
-	err := collection.FindOne(context.TODO(), bson.M{}).Decode(&config)
-	if err != nil {
-		// Return default if not found
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(MockBackupConfig)
-		return
-	}
-
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(config)
+	json.NewEncoder(w).Encode(LoadBackupConfig())
 }
 
 func UpdateBackupConfigHandler(w http.ResponseWriter, r *http.Request) {
@@ -80,19 +83,28 @@ func UpdateBackupConfigHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Invalid input", http.StatusBadRequest)
 		return
 	}
+	if err := config.Retention.Validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
 	collection := db.GetCollection("backup_config")
 	if collection == nil {
 		MockBackupConfig = config
 	} else {
-		// Simple upsert logic
-		_, err := collection.UpdateOne(context.TODO(), bson.M{}, bson.M{"$set": config}, nil)
+		// A single document, created on the first save
+		_, err := collection.UpdateOne(context.TODO(), bson.M{}, bson.M{"$set": config}, options.Update().SetUpsert(true))
 		if err != nil {
 			http.Error(w, "Error saving config", http.StatusInternalServerError)
 			return
 		}
 	}
 
+	username, _ := r.Context().Value("username").(string)
+	p := config.Retention
+	audit.LogAction(username, "backup_config_update", "backups", fmt.Sprintf("enabled=%v per_day=%d week=%d month=%d year=%d three=%d",
+		config.Enabled, p.BackupsPerDay, p.AfterWeek, p.AfterMonth, p.AfterYear, p.AfterThree))
+
 	w.WriteHeader(http.StatusOK)
 }
 
@@ -101,7 +113,7 @@ func GetBackupsHandler(w http.ResponseWriter, r *http.Request) {
 	var backups []Backup
 
 	if collection == nil {
-		backups = MockBackups
+		backups = MockBackupList()
 	} else {
 		cursor, err := collection.Find(context.TODO(), bson.M{})
 		if err == nil {
@@ -126,29 +138,22 @@ func ManualBackupHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Mock backup creation
-	newBackup := Backup{
-		ID:         primitive.NewObjectID().Hex(),
-		DeviceID:   input.DeviceID,
-		DeviceName: "Equipamento Manual", // In real app, fetch name from device ID
-		Filename:   "Manual_Backup_" + primitive.NewObjectID().Hex()[:8] + ".backup",
-		Size:       "256 KB",
-		CreatedAt:  "Recentemente",
+	device, ok := findDevice(input.DeviceID)
+	if !ok || !canUseDevice(r, device) {
+		http.Error(w, "Device not found", http.StatusNotFound)
+		return
 	}
 
-	collection := db.GetCollection("backups")
-	if collection == nil {
-		MockBackups = append([]Backup{newBackup}, MockBackups...)
-	} else {
-		_, err := collection.InsertOne(context.TODO(), newBackup)
-		if err != nil {
-			http.Error(w, "Error saving backup", http.StatusInternalServerError)
-			return
-		}
-	}
+	backup, err := RunBackup(device, BackupManual)
+	username, _ := r.Context().Value("username").(string)
+	audit.LogAction(username, "backup_manual", device.Name, backup.Status)
 
+	// The failed record is returned too, with its error
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(newBackup)
+	if err != nil {
+		w.WriteHeader(http.StatusBadGateway)
+	}
+	json.NewEncoder(w).Encode(backup)
 }
 
 func TestBackupHandler(w http.ResponseWriter, r *http.Request) {
@@ -167,7 +172,7 @@ func TestBackupHandler(w http.ResponseWriter, r *http.Request) {
 		Command string `json:"command,omitempty"`
 	}
 
-	response.Command = "/system backup save name=test_connection"
+	response.Command = "backup test_connection"
 
 	if input.DeviceID == "" {
 		response.Success = false
@@ -181,6 +186,7 @@ func TestBackupHandler(w http.ResponseWriter, r *http.Request) {
 	if collection != nil {
 		collection.DeleteMany(context.TODO(), bson.M{"device_id": input.DeviceID, "is_test": true})
 	} else {
+		backupMu.Lock()
 		newMock := []Backup{}
 		for _, b := range MockBackups {
 			if b.DeviceID == input.DeviceID && b.IsTest {
@@ -189,6 +195,7 @@ func TestBackupHandler(w http.ResponseWriter, r *http.Request) {
 			newMock = append(newMock, b)
 		}
 		MockBackups = newMock
+		backupMu.Unlock()
 	}
 
 	// 2. Perform Real or Mock Backup
@@ -210,24 +217,45 @@ func TestBackupHandler(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	filename := "test_connection.backup"
+	size := "-"
 	if err == nil {
 		if device.Port == 0 {
-			device.Port = 22
+			device.Port = driver.DefaultPort(device.Transport)
 		}
 
-		pool := ssher.GetPool()
-		// A. Execute Backup Command
-		output, err = pool.RunCommand(device.Username, device.Password, device.IP, device.Port, device.UseSSHKey, response.Command)
+		// A. Execute Backup through the vendor driver
+		var drv driver.Driver
+		var sess driver.Session
+		var result driver.BackupResult
+		drv, sess, err = openDriver(device)
+		if err == nil {
+			response.Command = drv.Name() + ": backup test_connection"
+			result, err = drv.Backup(sess, "test_connection")
+			sess.Close()
+		}
 
 		if err == nil {
-			// B. Download the file to local server
-			localPath := filepath.Join("data", "backups", input.DeviceID, "test_connection.backup")
-			// MikroTik saves to root usually or specific path if provided.
-			err = pool.DownloadFile(device.Username, device.Password, device.IP, device.Port, device.UseSSHKey, "test_connection.backup", localPath)
-			if err != nil {
-				output += "\n[Warning] command success but file download failed: " + err.Error()
+			localDir := filepath.Join("data", "backups", input.DeviceID)
+			if result.RemoteFile != "" {
+				// B. Download the file to local server
+				filename = result.RemoteFile
+				pool := ssher.GetPool()
+				err = pool.DownloadFile(device.Username, device.Password, device.IP, device.Port, device.UseSSHKey, result.RemoteFile, filepath.Join(localDir, filename))
+				if err != nil {
+					output += "\n[Warning] command success but file download failed: " + err.Error()
+				} else {
+					output += "\n[Success] File downloaded to server successfully."
+				}
 			} else {
-				output += "\n[Success] File downloaded to server successfully."
+				// B. The OLT printed the configuration, store it
+				filename = "test_connection" + result.Extension
+				if err = os.MkdirAll(localDir, 0750); err == nil {
+					err = os.WriteFile(filepath.Join(localDir, filename), result.Content, 0640)
+				}
+			}
+			if info, serr := os.Stat(filepath.Join(localDir, filename)); serr == nil {
+				size = formatSize(info.Size())
 			}
 		}
 	}
@@ -239,15 +267,15 @@ func TestBackupHandler(w http.ResponseWriter, r *http.Request) {
 	} else {
 		response.Success = true
 		response.Message = "Sucesso: Diagnóstico concluído e arquivo baixado."
-		response.Details = "Conexão SSH/SFTP OK.\nO arquivo 'test_connection.backup' foi baixado para o armazenamento local do servidor."
+		response.Details = "Conexão SSH/SFTP OK.\nO arquivo '" + filename + "' foi baixado para o armazenamento local do servidor."
 
 		// 3. Register the new Test Backup
 		newTestBackup := Backup{
 			ID:         primitive.NewObjectID().Hex(),
 			DeviceID:   input.DeviceID,
 			DeviceName: device.Name,
-			Filename:   "test_connection.backup",
-			Size:       "Calculando...",
+			Filename:   filename,
+			Size:       size,
 			CreatedAt:  time.Now().Format("2006-01-02 15:04"),
 			IsTest:     true,
 		}
@@ -255,7 +283,9 @@ func TestBackupHandler(w http.ResponseWriter, r *http.Request) {
 		if collection != nil {
 			collection.InsertOne(context.TODO(), newTestBackup)
 		} else {
+			backupMu.Lock()
 			MockBackups = append([]Backup{newTestBackup}, MockBackups...)
+			backupMu.Unlock()
 		}
 	}
 
@@ -270,23 +300,19 @@ func DeleteBackupHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	collection := db.GetCollection("backups")
-	if collection == nil {
-		// Mock Delete
-		for i, b := range MockBackups {
-			if b.ID == id {
-				MockBackups = append(MockBackups[:i], MockBackups[i+1:]...)
-				break
-			}
-		}
-	} else {
-		// MongoDB Delete
-		_, err := collection.DeleteOne(context.TODO(), bson.M{"id": id})
-		if err != nil {
-			http.Error(w, "Error deleting backup", http.StatusInternalServerError)
-			return
-		}
+	b, ok := findBackup(id)
+	if !ok {
+		http.Error(w, "Backup not found", http.StatusNotFound)
+		return
 	}
+	if err := DeleteBackup(b); err != nil {
+		log.Printf("Backup: error deleting %s of %s: %v", b.Filename, b.DeviceName, err)
+		http.Error(w, "Error deleting backup", http.StatusInternalServerError)
+		return
+	}
+
+	username, _ := r.Context().Value("username").(string)
+	audit.LogAction(username, "backup_delete", b.DeviceName, b.Filename)
 
 	w.WriteHeader(http.StatusOK)
 }
@@ -148,7 +148,7 @@ func RunCustomCommandHandler(w http.ResponseWriter, r *http.Request) {
 	output, err := pool.RunCommand(req.User, req.Password, req.Host, req.Port, req.UseSSHKey, finalCmd)
 
 	if err != nil {
-		http.Error(w, "Command execution failed: "+err.Error(), http.StatusInternalServerError)
+		http.Error(w, "Command execution failed: "+err.Error(), sshErrorStatus(err))
 		return
 	}
 
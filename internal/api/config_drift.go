@@ -0,0 +1,523 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"mikromon/internal/audit"
+	"mikromon/internal/db"
+	"mikromon/internal/notify"
+	"mikromon/internal/persistence"
+	"mikromon/internal/textdiff"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Configuration drift: one config version per device can be marked as the
+// approved baseline. The scheduler periodically exports the live config
+// (stored as a version like any other) and compares it with the baseline,
+// which catches changes made outside MikroMon (WinBox, console...). A new
+// drift is audited and published as a config_drift event naming the changed
+// sections.
+
+// ConfigTriggerDrift marks versions captured by the drift check
+const ConfigTriggerDrift = "drift"
+
+// Audit user of what the drift check finds
+const driftAuditUser = "system"
+
+// DriftStatus is the outcome of the last drift check of a device
+type DriftStatus struct {
+	DeviceID        string    `json:"device_id" bson:"_id"`
+	DeviceName      string    `json:"device_name" bson:"device_name"`
+	BaselineVersion int       `json:"baseline_version" bson:"baseline_version"`
+	LiveVersion     int       `json:"live_version" bson:"live_version"`
+	Drifted         bool      `json:"drifted" bson:"drifted"`
+	Sections        []string  `json:"sections" bson:"sections"` // Where the live config differs
+	Added           int       `json:"added" bson:"added"`       // Lines
+	Removed         int       `json:"removed" bson:"removed"`
+	Error           string    `json:"error,omitempty" bson:"error,omitempty"` // Export failed
+	DriftedSince    time.Time `json:"drifted_since,omitempty" bson:"drifted_since,omitempty"`
+	CheckedAt       time.Time `json:"checked_at" bson:"checked_at"`
+}
+
+var (
+	driftMu       sync.Mutex // One check at a time, held across the export
+	driftStatusMu sync.Mutex // Guards mockDrift and driftResets, never held across a check
+	mockDrift     []DriftStatus
+	mockDriftOnce sync.Once
+	// Times the status of a device was reset by a baseline change, so a
+	// check that started before does not store its stale result
+	driftResets = map[string]int{}
+)
+
+// setConfigBaseline makes version the baseline of a device; 0 clears it
+func setConfigBaseline(deviceID string, version int) error {
+	configMu.Lock()
+	defer configMu.Unlock()
+
+	collection := db.GetCollection("config_versions")
+	if collection == nil {
+		loadMockConfigs()
+		for i := range mockConfigs {
+			if mockConfigs[i].DeviceID == deviceID {
+				mockConfigs[i].Baseline = mockConfigs[i].Version == version
+			}
+		}
+		return persistence.GetStore().Save(persistence.ConfigVersionsFile, mockConfigs)
+	}
+
+	ctx := context.TODO()
+	_, err := collection.UpdateMany(ctx, bson.M{"device_id": deviceID, "baseline": true}, bson.M{"$set": bson.M{"baseline": false}})
+	if err == nil && version > 0 {
+		_, err = collection.UpdateOne(ctx, bson.M{"device_id": deviceID, "version": version}, bson.M{"$set": bson.M{"baseline": true}})
+	}
+	return err
+}
+
+// findBaseline returns the baseline in list, if any
+func findBaseline(list []ConfigVersion) (ConfigVersion, bool) {
+	for _, v := range list {
+		if v.Baseline {
+			return v, true
+		}
+	}
+	return ConfigVersion{}, false
+}
+
+// CheckDrift compares every device that has a baseline with its live
+// configuration. Called periodically by the scheduler worker.
+func CheckDrift() {
+	if !driftMu.TryLock() {
+		log.Println("Config: previous drift check still running, skipping")
+		return
+	}
+	defer driftMu.Unlock()
+
+	for _, device := range allDevices() {
+		list, err := ConfigVersions(device.ID.Hex())
+		if err != nil {
+			log.Printf("Config: error loading versions of %s: %v", device.Name, err)
+			continue
+		}
+		baseline, ok := findBaseline(list)
+		if !ok {
+			continue
+		}
+		checkDeviceDrift(device, baseline)
+	}
+}
+
+// checkDeviceDrift exports the live configuration of device, compares it
+// with baseline and records the result. Caller holds driftMu.
+func checkDeviceDrift(device Device, baseline ConfigVersion) DriftStatus {
+	driftStatusMu.Lock()
+	resets := driftResets[device.ID.Hex()]
+	driftStatusMu.Unlock()
+	prev, _ := findDriftStatus(device.ID.Hex())
+	status := DriftStatus{
+		DeviceID:        device.ID.Hex(),
+		DeviceName:      device.Name,
+		BaselineVersion: baseline.Version,
+		Sections:        []string{},
+		CheckedAt:       time.Now(),
+	}
+
+	live, err := captureLiveConfig(device)
+	if err != nil {
+		// Keep the last known drift, an unreachable device has not changed
+		log.Printf("Config: drift check of %s failed: %v", device.Name, err)
+		prev.DeviceID, prev.DeviceName, prev.BaselineVersion = status.DeviceID, status.DeviceName, status.BaselineVersion
+		prev.Error = err.Error()
+		prev.CheckedAt = status.CheckedAt
+		if prev.Sections == nil {
+			prev.Sections = []string{}
+		}
+		storeDriftStatus(prev, resets)
+		return prev
+	}
+
+	status.LiveVersion = live.Version
+	if live.SHA256 != baseline.SHA256 {
+		baseText, err := readConfigVersion(baseline)
+		liveText, lerr := readConfigVersion(live)
+		if err == nil {
+			err = lerr
+		}
+		if err != nil {
+			log.Printf("Config: drift check of %s: %v", device.Name, err)
+			status.Error = err.Error()
+		} else {
+			status.Sections, status.Added, status.Removed = changedSections(normalizeConfig(baseText), normalizeConfig(liveText))
+			status.Drifted = len(status.Sections) > 0
+		}
+	}
+	if status.Drifted {
+		status.DriftedSince = prev.DriftedSince
+		if !prev.Drifted || prev.BaselineVersion != status.BaselineVersion {
+			status.DriftedSince = status.CheckedAt
+		}
+	}
+
+	// Report changes of state, not every check of the same drift
+	target := device.Name + " (" + device.IP + ")"
+	switch {
+	case status.Drifted && (!prev.Drifted || prev.LiveVersion != status.LiveVersion || prev.BaselineVersion != status.BaselineVersion):
+		details := fmt.Sprintf("baseline v%d, live v%d: %s", status.BaselineVersion, status.LiveVersion, strings.Join(status.Sections, ", "))
+		audit.LogAction(driftAuditUser, "config_drift", target, details)
+		publishDrift(device, status)
+	case !status.Drifted && status.Error == "" && prev.Drifted:
+		audit.LogAction(driftAuditUser, "config_drift_resolved", target, fmt.Sprintf("baseline v%d", status.BaselineVersion))
+		publishDrift(device, status)
+	}
+
+	storeDriftStatus(status, resets)
+	return status
+}
+
+// captureLiveConfig exports the running configuration and stores it as a
+// version. It exports like the backups do, so a baseline captured before
+// CONFIG_EXPORT_SENSITIVE changed shows the secrets as drift until a new one
+// is approved.
+func captureLiveConfig(device Device) (ConfigVersion, error) {
+	var text string
+	if db.GetCollection("devices") == nil {
+		text = mockExport(device)
+	} else {
+		drv, sess, err := openDriver(device)
+		if err != nil {
+			return ConfigVersion{}, err
+		}
+		text, err = drv.ExportConfig(sess, ExportSensitive())
+		sess.Close()
+		if err != nil {
+			return ConfigVersion{}, err
+		}
+	}
+	v, _, err := saveConfigVersion(device, text, ExportSensitive(), ConfigTriggerDrift, "")
+	return v, err
+}
+
+func publishDrift(device Device, s DriftStatus) {
+	e := notify.Event{
+		Type:       notify.EventConfigDrift,
+		Severity:   notify.SeverityWarning,
+		Title:      fmt.Sprintf("Configuration drift on %s", device.Name),
+		Message:    "Changed sections: " + strings.Join(s.Sections, ", "),
+		DeviceID:   s.DeviceID,
+		DeviceName: device.Name,
+		Owner:      device.Owner,
+		Fields: map[string]string{
+			"baseline": "v" + strconv.Itoa(s.BaselineVersion),
+			"live":     "v" + strconv.Itoa(s.LiveVersion),
+			"added":    strconv.Itoa(s.Added),
+			"removed":  strconv.Itoa(s.Removed),
+		},
+	}
+	if !s.Drifted {
+		e.Severity = notify.SeverityInfo
+		e.Title = fmt.Sprintf("Configuration of %s is back to baseline", device.Name)
+		e.Message = ""
+		e.Fields = map[string]string{"baseline": "v" + strconv.Itoa(s.BaselineVersion)}
+	}
+	notify.Publish(e)
+}
+
+// changedSections returns the sections touched by the difference between
+// two normalized configs, in order of appearance, and the lines added and
+// removed
+func changedSections(base, live []string) ([]string, int, int) {
+	ros := isRouterOSExport(base) || isRouterOSExport(live)
+	sections := []string{}
+	seen := map[string]bool{}
+	added, removed := 0, 0
+	ai, bi := 0, 0
+	for _, e := range textdiff.Diff(base, live) {
+		var section string
+		switch e.Op {
+		case textdiff.Equal:
+			ai++
+			bi++
+			continue
+		case textdiff.Delete:
+			section = configSection(base, ai, ros)
+			removed++
+			ai++
+		case textdiff.Insert:
+			section = configSection(live, bi, ros)
+			added++
+			bi++
+		}
+		if !seen[section] {
+			seen[section] = true
+			sections = append(sections, section)
+		}
+	}
+	return sections, added, removed
+}
+
+func isRouterOSExport(lines []string) bool {
+	for _, l := range lines {
+		if strings.HasPrefix(l, "/") {
+			return true
+		}
+	}
+	return false
+}
+
+// configSection names the part of a config line i belongs to: the
+// "/ip firewall filter" menu on RouterOS; on OLTs the block it is indented
+// under ("interface gpon 0/1"), else the enclosing "[section]" or its
+// first word
+func configSection(lines []string, i int, ros bool) string {
+	line := lines[i]
+	indented := strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")
+	for j := i; j >= 0; j-- {
+		l := lines[j]
+		if ros {
+			if strings.HasPrefix(l, "/") {
+				return rosMenu(l)
+			}
+			continue
+		}
+		if strings.HasPrefix(l, "[") && strings.HasSuffix(l, "]") {
+			return l
+		}
+		if indented && j < i && l != "" && !strings.ContainsAny(l[:1], " \t#!") {
+			return l
+		}
+	}
+	if f := strings.Fields(line); len(f) > 0 && !ros {
+		return f[0]
+	}
+	return "(top)"
+}
+
+// rosMenu drops the command from a compact export line ("/ip address add ...")
+func rosMenu(l string) string {
+	menu := []string{}
+	for _, f := range strings.Fields(l) {
+		if strings.Contains(f, "=") || f == "add" || f == "set" || f == "remove" || f == "\\" {
+			break
+		}
+		menu = append(menu, f)
+	}
+	return strings.Join(menu, " ")
+}
+
+// loadMockDrift reads the JSON file once. Caller holds driftStatusMu.
+func loadMockDrift() {
+	mockDriftOnce.Do(func() {
+		if err := persistence.GetStore().Load(persistence.ConfigDriftFile, &mockDrift); err != nil {
+			mockDrift = []DriftStatus{}
+		}
+	})
+}
+
+// findDriftStatus returns the last check of a device
+func findDriftStatus(deviceID string) (DriftStatus, bool) {
+	collection := db.GetCollection("config_drift")
+	if collection == nil {
+		driftStatusMu.Lock()
+		defer driftStatusMu.Unlock()
+		loadMockDrift()
+		for _, s := range mockDrift {
+			if s.DeviceID == deviceID {
+				return s, true
+			}
+		}
+		return DriftStatus{}, false
+	}
+	var s DriftStatus
+	err := collection.FindOne(context.TODO(), bson.M{"_id": deviceID}).Decode(&s)
+	return s, err == nil
+}
+
+// storeDriftStatus replaces the last check of a device, unless its status
+// was reset since the check read resets
+func storeDriftStatus(s DriftStatus, resets int) {
+	driftStatusMu.Lock()
+	defer driftStatusMu.Unlock()
+	if driftResets[s.DeviceID] != resets {
+		log.Printf("Config: baseline of %s changed during the drift check, result dropped", s.DeviceName)
+		return
+	}
+	collection := db.GetCollection("config_drift")
+	if collection == nil {
+		loadMockDrift()
+		replaced := false
+		for i := range mockDrift {
+			if mockDrift[i].DeviceID == s.DeviceID {
+				mockDrift[i] = s
+				replaced = true
+			}
+		}
+		if !replaced {
+			mockDrift = append(mockDrift, s)
+		}
+		if err := persistence.GetStore().Save(persistence.ConfigDriftFile, mockDrift); err != nil {
+			log.Printf("Config: error saving drift status: %v", err)
+		}
+		return
+	}
+	_, err := collection.ReplaceOne(context.TODO(), bson.M{"_id": s.DeviceID}, s, options.Replace().SetUpsert(true))
+	if err != nil {
+		log.Printf("Config: error saving drift status of %s: %v", s.DeviceName, err)
+	}
+}
+
+// removeDriftStatus forgets the last check of a device, including one
+// still running
+func removeDriftStatus(deviceID string) {
+	driftStatusMu.Lock()
+	defer driftStatusMu.Unlock()
+	driftResets[deviceID]++
+	collection := db.GetCollection("config_drift")
+	if collection == nil {
+		loadMockDrift()
+		kept := mockDrift[:0]
+		for _, s := range mockDrift {
+			if s.DeviceID != deviceID {
+				kept = append(kept, s)
+			}
+		}
+		mockDrift = kept
+		persistence.GetStore().Save(persistence.ConfigDriftFile, mockDrift)
+		return
+	}
+	collection.DeleteOne(context.TODO(), bson.M{"_id": deviceID})
+}
+
+// SetConfigBaselineHandler approves a version as the baseline of its device
+// (PUT /devices/{id}/configs/{version}/baseline)
+func SetConfigBaselineHandler(w http.ResponseWriter, r *http.Request) {
+	device, ok := requestDevice(w, r)
+	if !ok {
+		return
+	}
+	list, err := ConfigVersions(device.ID.Hex())
+	if err != nil {
+		http.Error(w, "Error fetching configuration versions", http.StatusInternalServerError)
+		return
+	}
+	v, msg := pickVersion(list, mux.Vars(r)["version"], "")
+	if msg != "" {
+		http.Error(w, msg, http.StatusNotFound)
+		return
+	}
+	if err := setConfigBaseline(v.DeviceID, v.Version); err != nil {
+		http.Error(w, "Error saving baseline", http.StatusInternalServerError)
+		return
+	}
+	// The next check compares against the new baseline from scratch
+	removeDriftStatus(v.DeviceID)
+
+	username, _ := r.Context().Value("username").(string)
+	audit.LogAction(username, "config_baseline_set", device.Name+" ("+device.IP+")", fmt.Sprintf("v%d", v.Version))
+
+	v.Baseline = true
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+// ClearConfigBaselineHandler stops drift checks for a device
+// (DELETE /devices/{id}/configs/baseline)
+func ClearConfigBaselineHandler(w http.ResponseWriter, r *http.Request) {
+	device, ok := requestDevice(w, r)
+	if !ok {
+		return
+	}
+	if err := setConfigBaseline(device.ID.Hex(), 0); err != nil {
+		http.Error(w, "Error clearing baseline", http.StatusInternalServerError)
+		return
+	}
+	removeDriftStatus(device.ID.Hex())
+
+	username, _ := r.Context().Value("username").(string)
+	audit.LogAction(username, "config_baseline_clear", device.Name+" ("+device.IP+")", "")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// CheckDeviceDriftHandler runs the drift check of one device now
+// (POST /devices/{id}/configs/drift)
+func CheckDeviceDriftHandler(w http.ResponseWriter, r *http.Request) {
+	device, ok := requestDevice(w, r)
+	if !ok {
+		return
+	}
+	list, err := ConfigVersions(device.ID.Hex())
+	if err != nil {
+		http.Error(w, "Error fetching configuration versions", http.StatusInternalServerError)
+		return
+	}
+	baseline, ok := findBaseline(list)
+	if !ok {
+		http.Error(w, "Device has no baseline", http.StatusConflict)
+		return
+	}
+	if !driftMu.TryLock() {
+		http.Error(w, "A drift check is already running", http.StatusConflict)
+		return
+	}
+	status := checkDeviceDrift(device, baseline)
+	driftMu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
+
+// GetConfigDriftHandler lists the last drift check of the visible devices,
+// drifted first (GET /config-drift?drifted=true)
+func GetConfigDriftHandler(w http.ResponseWriter, r *http.Request) {
+	onlyDrifted := r.URL.Query().Get("drifted") == "true"
+	visible := map[string]bool{}
+	for _, id := range visibleDeviceIDs(r, "") {
+		visible[id] = true
+	}
+
+	var all []DriftStatus
+	collection := db.GetCollection("config_drift")
+	if collection == nil {
+		driftStatusMu.Lock()
+		loadMockDrift()
+		all = append(all, mockDrift...)
+		driftStatusMu.Unlock()
+	} else {
+		cursor, err := collection.Find(context.TODO(), bson.M{})
+		if err != nil {
+			http.Error(w, "Error fetching drift status", http.StatusInternalServerError)
+			return
+		}
+		defer cursor.Close(context.TODO())
+		if err := cursor.All(context.TODO(), &all); err != nil {
+			http.Error(w, "Error decoding drift status", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	list := []DriftStatus{}
+	for _, s := range all {
+		if visible[s.DeviceID] && (!onlyDrifted || s.Drifted) {
+			list = append(list, s)
+		}
+	}
+	sort.SliceStable(list, func(i, j int) bool {
+		if list[i].Drifted != list[j].Drifted {
+			return list[i].Drifted
+		}
+		return list[i].DeviceName < list[j].DeviceName
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
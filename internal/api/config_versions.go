@@ -0,0 +1,455 @@
+package api
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"mikromon/internal/audit"
+	"mikromon/internal/db"
+	"mikromon/internal/persistence"
+	"mikromon/internal/textdiff"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Text configuration history. Every backup also captures the configuration
+// as text (/export on RouterOS, the running config on OLTs); a new version
+// is kept only when it differs from the previous one once noise such as the
+// export timestamp is ignored. The text lives in data/configs/<device ID>/,
+// the metadata in "config_versions" (or persistence.ConfigVersionsFile).
+
+const configDir = "data/configs"
+
+// ConfigVersion is one distinct configuration of a device
+type ConfigVersion struct {
+	ID          primitive.ObjectID `json:"id" bson:"_id"`
+	DeviceID    string             `json:"device_id" bson:"device_id"`
+	DeviceName  string             `json:"device_name" bson:"device_name"`
+	DeviceModel string             `json:"device_model,omitempty" bson:"device_model,omitempty"` // Restores only go to the same model
+	Version     int                `json:"version" bson:"version"`                               // 1, 2... per device
+	Filename    string             `json:"filename" bson:"filename"`
+	SHA256      string             `json:"sha256" bson:"sha256"` // Of the text without noise
+	Lines       int                `json:"lines" bson:"lines"`
+	Sensitive   bool               `json:"sensitive" bson:"sensitive"` // Exported with show-sensitive
+	BackupID    string             `json:"backup_id,omitempty" bson:"backup_id,omitempty"`
+	Trigger     string             `json:"trigger" bson:"trigger"`
+	Baseline    bool               `json:"baseline" bson:"baseline"` // Approved configuration, see config_drift.go
+	CreatedAt   time.Time          `json:"created_at" bson:"created_at"`
+	CheckedAt   time.Time          `json:"checked_at" bson:"checked_at"` // Last capture with this same content
+}
+
+// Lines that change on every export without a configuration change
+var configNoise = []*regexp.Regexp{
+	regexp.MustCompile(`^# \S+ \S+ by RouterOS`), // # 2026-01-30 12:00:00 by RouterOS 7.15
+	regexp.MustCompile(`(?i)^\s*building configuration`),
+	regexp.MustCompile(`(?i)^\s*!?\s*(last configuration change|time|current time|saved at)\b.*\d{2}:\d{2}:\d{2}`),
+	regexp.MustCompile(`(?i)^\s*current configuration\s*:\s*\d+ bytes`),
+}
+
+// normalizeConfig returns the lines that matter for comparison
+func normalizeConfig(text string) []string {
+	lines := []string{}
+	for _, l := range textdiff.Lines(text) {
+		l = strings.TrimRight(l, " \t")
+		noise := false
+		for _, re := range configNoise {
+			if re.MatchString(l) {
+				noise = true
+				break
+			}
+		}
+		if !noise {
+			lines = append(lines, l)
+		}
+	}
+	// Blank lines at either end come and go with the CLI
+	for len(lines) > 0 && lines[0] == "" {
+		lines = lines[1:]
+	}
+	for len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// ExportSensitive tells whether backups capture secrets in the text export
+// (CONFIG_EXPORT_SENSITIVE=true). They are then readable by anyone allowed to
+// see the configuration history.
+func ExportSensitive() bool {
+	return os.Getenv("CONFIG_EXPORT_SENSITIVE") == "true"
+}
+
+// mockExport stands in for /export without a database
+func mockExport(device Device) string {
+	return fmt.Sprintf("# %s by RouterOS 7.15\n#\n/system identity\nset name=%s\n", time.Now().Format("2006-01-02 15:04:05"), device.Name)
+}
+
+var (
+	configMu       sync.Mutex // Serializes version numbering
+	mockConfigs    []ConfigVersion
+	mockConfigOnce sync.Once
+)
+
+// loadMockConfigs reads the JSON file once. Caller holds configMu.
+func loadMockConfigs() {
+	mockConfigOnce.Do(func() {
+		if err := persistence.GetStore().Load(persistence.ConfigVersionsFile, &mockConfigs); err != nil {
+			mockConfigs = []ConfigVersion{}
+		}
+	})
+}
+
+// saveConfigVersion records text as the newest configuration of device.
+// created is false when it matches the latest version, which only gets its
+// CheckedAt updated.
+func saveConfigVersion(device Device, text string, sensitive bool, trigger, backupID string) (ConfigVersion, bool, error) {
+	lines := normalizeConfig(text)
+	sum := sha256.Sum256([]byte(strings.Join(lines, "\n")))
+	hash := hex.EncodeToString(sum[:])
+	now := time.Now()
+
+	configMu.Lock()
+	defer configMu.Unlock()
+
+	latest, found, err := latestConfigVersion(device.ID.Hex())
+	if err != nil {
+		return ConfigVersion{}, false, err
+	}
+	if found && latest.SHA256 == hash && latest.Sensitive == sensitive {
+		latest.CheckedAt = now
+		return latest, false, storeConfigVersion(latest)
+	}
+
+	v := ConfigVersion{
+		ID:          primitive.NewObjectID(),
+		DeviceID:    device.ID.Hex(),
+		DeviceName:  device.Name,
+		DeviceModel: device.Model,
+		Version:     latest.Version + 1,
+		SHA256:      hash,
+		Lines:       len(lines),
+		Sensitive:   sensitive,
+		BackupID:    backupID,
+		Trigger:     trigger,
+		CreatedAt:   now,
+		CheckedAt:   now,
+	}
+	ext := ".rsc"
+	if isOLT(device) {
+		ext = ".cfg"
+	}
+	v.Filename = fmt.Sprintf("v%04d_%s%s", v.Version, now.Format("20060102_150405"), ext)
+
+	dir := filepath.Join(configDir, v.DeviceID)
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return ConfigVersion{}, false, err
+	}
+	if err := os.WriteFile(filepath.Join(dir, v.Filename), []byte(text), 0640); err != nil {
+		return ConfigVersion{}, false, err
+	}
+	return v, true, storeConfigVersion(v)
+}
+
+// storeConfigVersion inserts or replaces the metadata. Caller holds configMu.
+func storeConfigVersion(v ConfigVersion) error {
+	collection := db.GetCollection("config_versions")
+	if collection == nil {
+		loadMockConfigs()
+		replaced := false
+		for i := range mockConfigs {
+			if mockConfigs[i].ID == v.ID {
+				mockConfigs[i] = v
+				replaced = true
+			}
+		}
+		if !replaced {
+			mockConfigs = append(mockConfigs, v)
+		}
+		return persistence.GetStore().Save(persistence.ConfigVersionsFile, mockConfigs)
+	}
+
+	_, err := collection.ReplaceOne(context.TODO(), bson.M{"_id": v.ID}, v, options.Replace().SetUpsert(true))
+	return err
+}
+
+// latestConfigVersion returns the newest version of a device. Caller holds configMu.
+func latestConfigVersion(deviceID string) (ConfigVersion, bool, error) {
+	list, err := listConfigVersions(deviceID, 1)
+	if err != nil || len(list) == 0 {
+		return ConfigVersion{}, false, err
+	}
+	return list[0], true, nil
+}
+
+// ConfigVersions returns the versions of a device, newest first
+func ConfigVersions(deviceID string) ([]ConfigVersion, error) {
+	configMu.Lock()
+	defer configMu.Unlock()
+	return listConfigVersions(deviceID, 0)
+}
+
+// listConfigVersions returns up to limit versions, newest first; 0 is no
+// limit. Caller holds configMu.
+func listConfigVersions(deviceID string, limit int) ([]ConfigVersion, error) {
+	list := []ConfigVersion{}
+	collection := db.GetCollection("config_versions")
+	if collection == nil {
+		loadMockConfigs()
+		for _, v := range mockConfigs {
+			if v.DeviceID == deviceID {
+				list = append(list, v)
+			}
+		}
+		sort.Slice(list, func(i, j int) bool { return list[i].Version > list[j].Version })
+		if limit > 0 && len(list) > limit {
+			list = list[:limit]
+		}
+		return list, nil
+	}
+
+	opts := options.Find().SetSort(bson.M{"version": -1})
+	if limit > 0 {
+		opts.SetLimit(int64(limit))
+	}
+	cursor, err := collection.Find(context.TODO(), bson.M{"device_id": deviceID}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(context.TODO())
+	err = cursor.All(context.TODO(), &list)
+	return list, err
+}
+
+// readConfigVersion returns the stored text of a version
+func readConfigVersion(v ConfigVersion) (string, error) {
+	data, err := os.ReadFile(filepath.Join(configDir, v.DeviceID, filepath.Base(v.Filename)))
+	return string(data), err
+}
+
+// pickVersion finds a version in list (newest first) by number, or the
+// latest one created at or before a time
+func pickVersion(list []ConfigVersion, number, at string) (ConfigVersion, string) {
+	if number != "" {
+		n, err := strconv.Atoi(number)
+		if err != nil {
+			return ConfigVersion{}, "invalid version " + number
+		}
+		for _, v := range list {
+			if v.Version == n {
+				return v, ""
+			}
+		}
+		return ConfigVersion{}, "version " + number + " not found"
+	}
+	t, ok := parseTimeParam(at, time.Now())
+	if !ok {
+		return ConfigVersion{}, "invalid time " + at
+	}
+	for _, v := range list {
+		if !v.CreatedAt.After(t) {
+			return v, ""
+		}
+	}
+	return ConfigVersion{}, "no version at or before " + t.Format(time.RFC3339)
+}
+
+// GetConfigVersionsHandler lists the configuration versions of a device,
+// newest first (GET /devices/{id}/configs)
+func GetConfigVersionsHandler(w http.ResponseWriter, r *http.Request) {
+	device, ok := requestDevice(w, r)
+	if !ok {
+		return
+	}
+	list, err := ConfigVersions(device.ID.Hex())
+	if err != nil {
+		http.Error(w, "Error fetching configuration versions", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
+
+// GetConfigVersionHandler returns the text of one version
+// (GET /devices/{id}/configs/{version})
+func GetConfigVersionHandler(w http.ResponseWriter, r *http.Request) {
+	device, ok := requestDevice(w, r)
+	if !ok {
+		return
+	}
+	list, err := ConfigVersions(device.ID.Hex())
+	if err != nil {
+		http.Error(w, "Error fetching configuration versions", http.StatusInternalServerError)
+		return
+	}
+	v, msg := pickVersion(list, mux.Vars(r)["version"], "")
+	if msg != "" {
+		http.Error(w, msg, http.StatusNotFound)
+		return
+	}
+	text, err := readConfigVersion(v)
+	if err != nil {
+		log.Printf("Config: error reading %s of %s: %v", v.Filename, device.Name, err)
+		http.Error(w, "Configuration file not available", http.StatusNotFound)
+		return
+	}
+
+	if v.Sensitive {
+		username, _ := r.Context().Value("username").(string)
+		audit.LogAction(username, "config_version_view", device.Name+" ("+device.IP+")", fmt.Sprintf("v%d show-sensitive", v.Version))
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("inline; filename=%q", v.Filename))
+	w.Write([]byte(text))
+}
+
+// RestoreConfigVersionHandler uploads a RouterOS export from the history to
+// its device and runs /import on it. The device must still be the model the
+// version was captured from and "confirm" must repeat the device name.
+// OLT configurations cannot be restored this way.
+// POST /devices/{id}/configs/{version}/restore {"confirm": "<device name>"}
+func RestoreConfigVersionHandler(w http.ResponseWriter, r *http.Request) {
+	device, ok := requestDevice(w, r)
+	if !ok {
+		return
+	}
+	var input struct {
+		Confirm string `json:"confirm"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+	list, err := ConfigVersions(device.ID.Hex())
+	if err != nil {
+		http.Error(w, "Error fetching configuration versions", http.StatusInternalServerError)
+		return
+	}
+	v, msg := pickVersion(list, mux.Vars(r)["version"], "")
+	if msg != "" {
+		http.Error(w, msg, http.StatusNotFound)
+		return
+	}
+	if !strings.EqualFold(filepath.Ext(v.Filename), ".rsc") {
+		http.Error(w, "Only RouterOS exports (.rsc) can be restored", http.StatusBadRequest)
+		return
+	}
+	if input.Confirm != device.Name {
+		http.Error(w, fmt.Sprintf("Restoring overwrites the configuration of %s: set confirm to the device name to proceed", device.Name), http.StatusPreconditionRequired)
+		return
+	}
+	// A device replaced by another model keeps its ID
+	if v.DeviceModel != "" && device.Model != "" && !strings.EqualFold(v.DeviceModel, device.Model) {
+		http.Error(w, fmt.Sprintf("Version is from model %q, %s is %q: restoring across models is refused", v.DeviceModel, device.Name, device.Model), http.StatusConflict)
+		return
+	}
+	local := filepath.Join(configDir, v.DeviceID, filepath.Base(v.Filename))
+	if _, err := os.Stat(local); err != nil {
+		log.Printf("Config: error reading %s of %s: %v", v.Filename, device.Name, err)
+		http.Error(w, "Configuration file not available", http.StatusNotFound)
+		return
+	}
+
+	username, _ := r.Context().Value("username").(string)
+	target := device.Name + " (" + device.IP + ")"
+	details := fmt.Sprintf("v%d", v.Version)
+	if db.GetCollection("devices") != nil {
+		if err := restoreFile(device, local, filepath.Base(v.Filename)); err != nil {
+			audit.LogAction(username, "config_restore_failed", target, details+": "+err.Error())
+			http.Error(w, "Error restoring configuration: "+err.Error(), driverErrorStatus(err))
+			return
+		}
+	}
+	audit.LogAction(username, "config_restore", target, details)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "message": "Configuration imported"})
+}
+
+// DiffConfigVersionsHandler returns a unified diff between two versions,
+// ignoring noise such as the export timestamp. Each side is a version number
+// (from, to) or a time (since, until) meaning the version in effect then;
+// the newer side defaults to the latest version. Empty when nothing changed.
+// GET /devices/{id}/configs/diff?from=&to=&since=&until=&context=
+func DiffConfigVersionsHandler(w http.ResponseWriter, r *http.Request) {
+	device, ok := requestDevice(w, r)
+	if !ok {
+		return
+	}
+	q := r.URL.Query()
+	if q.Get("from") == "" && q.Get("since") == "" {
+		http.Error(w, "from or since required", http.StatusBadRequest)
+		return
+	}
+	context := 3
+	if c := q.Get("context"); c != "" {
+		n, err := strconv.Atoi(c)
+		if err != nil || n < 0 || n > 1000 {
+			http.Error(w, "Invalid context", http.StatusBadRequest)
+			return
+		}
+		context = n
+	}
+
+	list, err := ConfigVersions(device.ID.Hex())
+	if err != nil {
+		http.Error(w, "Error fetching configuration versions", http.StatusInternalServerError)
+		return
+	}
+	if len(list) == 0 {
+		http.Error(w, "No configuration versions for this device", http.StatusNotFound)
+		return
+	}
+	from, msg := pickVersion(list, q.Get("from"), q.Get("since"))
+	if msg != "" {
+		http.Error(w, msg, http.StatusNotFound)
+		return
+	}
+	to := list[0]
+	if q.Get("to") != "" || q.Get("until") != "" {
+		if to, msg = pickVersion(list, q.Get("to"), q.Get("until")); msg != "" {
+			http.Error(w, msg, http.StatusNotFound)
+			return
+		}
+	}
+
+	fromText, err := readConfigVersion(from)
+	if err != nil {
+		log.Printf("Config: error reading %s of %s: %v", from.Filename, device.Name, err)
+		http.Error(w, "Configuration file not available", http.StatusNotFound)
+		return
+	}
+	toText, err := readConfigVersion(to)
+	if err != nil {
+		log.Printf("Config: error reading %s of %s: %v", to.Filename, device.Name, err)
+		http.Error(w, "Configuration file not available", http.StatusNotFound)
+		return
+	}
+
+	label := func(v ConfigVersion) string {
+		return fmt.Sprintf("%s v%d\t%s", device.Name, v.Version, v.CreatedAt.Format("2006-01-02 15:04:05"))
+	}
+	diff := textdiff.Unified(label(from), label(to), normalizeConfig(fromText), normalizeConfig(toText), context)
+
+	if from.Sensitive || to.Sensitive {
+		username, _ := r.Context().Value("username").(string)
+		audit.LogAction(username, "config_version_view", device.Name+" ("+device.IP+")", fmt.Sprintf("diff v%d..v%d show-sensitive", from.Version, to.Version))
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write([]byte(diff))
+}
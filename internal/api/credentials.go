@@ -0,0 +1,142 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"mikromon/internal/audit"
+	"mikromon/internal/db"
+	"mikromon/internal/notify"
+	"mikromon/internal/persistence"
+	"mikromon/internal/secrets"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// maskedSecret replaces stored credentials in API responses
+const maskedSecret = "********"
+
+func maskSecret(s string) string {
+	if s == "" {
+		return ""
+	}
+	return maskedSecret
+}
+
+// rewrapDeviceCredentials re-encrypts every stored device password under the
+// current primary master key (sealing legacy plaintext ones on the way).
+func rewrapDeviceCredentials() (updated int, failed int) {
+	collection := db.GetCollection("devices")
+
+	if collection == nil {
+		mockDevicesMu.Lock()
+		defer mockDevicesMu.Unlock()
+		for i, d := range MockDevices {
+			next, err := secrets.Rewrap(d.Password)
+			if err != nil {
+				log.Printf("Secrets: failed to re-encrypt %s: %v", d.Name, err)
+				failed++
+				continue
+			}
+			if next != d.Password {
+				MockDevices[i].Password = next
+				updated++
+			}
+		}
+		if updated > 0 {
+			if err := persistence.GetStore().Save(persistence.DevicesFile, MockDevices); err != nil {
+				log.Printf("Secrets: failed to save devices file: %v", err)
+				return 0, len(MockDevices)
+			}
+		}
+		return updated, failed
+	}
+
+	var devices []Device
+	cursor, err := collection.Find(context.TODO(), bson.M{})
+	if err != nil {
+		log.Printf("Secrets: failed to list devices: %v", err)
+		return 0, 1
+	}
+	if err := cursor.All(context.TODO(), &devices); err != nil {
+		log.Printf("Secrets: failed to decode devices: %v", err)
+		return 0, 1
+	}
+
+	for _, d := range devices {
+		next, err := secrets.Rewrap(d.Password)
+		if err != nil {
+			log.Printf("Secrets: failed to re-encrypt %s: %v", d.Name, err)
+			failed++
+			continue
+		}
+		if next == d.Password {
+			continue
+		}
+		// Compare-and-set on the old value so a concurrent edit is not overwritten
+		res, err := collection.UpdateOne(context.TODO(),
+			bson.M{"_id": d.ID, "password": d.Password},
+			bson.M{"$set": bson.M{"password": next}})
+		if err != nil || res.MatchedCount == 0 {
+			failed++
+			continue
+		}
+		updated++
+	}
+	return updated, failed
+}
+
+// SealStoredCredentials encrypts any plaintext device password left from
+// older versions. Called once at startup.
+func SealStoredCredentials() {
+	updated, failed := rewrapDeviceCredentials()
+	if updated > 0 || failed > 0 {
+		log.Printf("Secrets: sealed %d stored device credentials (%d failed)", updated, failed)
+	}
+}
+
+// RotateMasterKeyHandler installs a new master key and re-wraps every stored
+// device credential and notification channel secret. Old keys stay loaded until all records are migrated, so
+// SSH sessions keep working during the rotation.
+func RotateMasterKeyHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		NewKey string `json:"new_key"` // Optional base64 32-byte key, random when empty
+	}
+	if r.ContentLength > 0 {
+		if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+			http.Error(w, "Invalid input", http.StatusBadRequest)
+			return
+		}
+	}
+
+	keyID, err := secrets.BeginRotation(input.NewKey)
+	if err != nil {
+		http.Error(w, "Error installing new master key: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	updated, failed := rewrapDeviceCredentials()
+	chUpdated, chFailed := notify.RewrapSecrets()
+	updated, failed = updated+chUpdated, failed+chFailed
+
+	complete := failed == 0
+	if complete {
+		if err := secrets.FinishRotation(); err != nil {
+			http.Error(w, "Credentials re-encrypted but old keys could not be retired: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	username, _ := r.Context().Value("username").(string)
+	audit.LogAction(username, "rotate_master_key", keyID, "")
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"key_id":   keyID,
+		"updated":  updated,
+		"failed":   failed,
+		"complete": complete, // false: old keys kept, run the rotation again
+	})
+}
@@ -3,30 +3,41 @@ package api
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"log"
 	"net/http"
+	"sync"
 	"time"
 
 	"mikromon/internal/audit"
 	"mikromon/internal/db"
+	"mikromon/internal/driver"
 	"mikromon/internal/persistence"
+	"mikromon/internal/recording"
+	"mikromon/internal/secrets"
 	"mikromon/internal/ssher"
 	"os"
 
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
 )
 
 type Device struct {
 	ID        primitive.ObjectID `json:"id" bson:"_id,omitempty"`
 	Name      string             `json:"name" bson:"name"`
 	IP        string             `json:"ip" bson:"ip"`
-	Type      string             `json:"type" bson:"type"`         // OLT, ROUTER
-	Username  string             `json:"username" bson:"username"` // Encrypt in prod
-	Password  string             `json:"password" bson:"password"` // Encrypt in prod
+	Type      string             `json:"type" bson:"type"`     // OLT, ROUTER
+	Vendor    string             `json:"vendor" bson:"vendor"` // mikrotik, huawei, zte (picks the driver)
+	Model     string             `json:"model" bson:"model"`   // e.g. CCR2004, MA5800-X7, C320
+	Username  string             `json:"username" bson:"username"`
+	Password  string             `json:"password" bson:"password"` // Sealed (secrets.Seal), masked in API responses
 	Port      int                `json:"port" bson:"port"`
 	Owner     string             `json:"owner" bson:"owner"` // Username of the owner
 	UseSSHKey bool               `json:"use_ssh_key" bson:"use_ssh_key"`
+	Transport string             `json:"transport,omitempty" bson:"transport,omitempty"` // ssh (default) or telnet
+	Group     string             `json:"group,omitempty" bson:"group,omitempty"`         // Free label (site, POP...) used by alert rules
 }
 
 type CommandRequest struct {
@@ -34,7 +45,17 @@ type CommandRequest struct {
 	Command  string `json:"command"`
 }
 
-var MockDevices []Device // Changed to slice, not pre-populated
+var (
+	MockDevices   []Device   // Changed to slice, not pre-populated
+	mockDevicesMu sync.Mutex // Guards MockDevices: handlers read it while credentials get re-wrapped
+)
+
+// MockDeviceList returns a copy of MockDevices that is safe to range over
+func MockDeviceList() []Device {
+	mockDevicesMu.Lock()
+	defer mockDevicesMu.Unlock()
+	return append([]Device{}, MockDevices...)
+}
 
 func init() {
 	// Attempt to load from disk on startup
@@ -51,6 +72,45 @@ func init() {
 	}
 }
 
+// findDevice looks up a device by hex ID in the DB or in MockDevices
+func findDevice(id string) (Device, bool) {
+	collection := db.GetCollection("devices")
+	if collection == nil {
+		for _, d := range MockDeviceList() {
+			if d.ID.Hex() == id {
+				return d, true
+			}
+		}
+		return Device{}, false
+	}
+
+	var device Device
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return Device{}, false
+	}
+	if err := collection.FindOne(context.TODO(), bson.M{"_id": oid}).Decode(&device); err != nil {
+		return Device{}, false
+	}
+	return device, true
+}
+
+// allDevices returns every registered device regardless of owner
+func allDevices() []Device {
+	collection := db.GetCollection("devices")
+	if collection == nil {
+		return MockDeviceList()
+	}
+
+	var devices []Device
+	cursor, err := collection.Find(context.TODO(), bson.M{})
+	if err != nil {
+		return nil
+	}
+	cursor.All(context.TODO(), &devices)
+	return devices
+}
+
 func GetDevicesHandler(w http.ResponseWriter, r *http.Request) {
 	collection := db.GetCollection("devices")
 	var devices []Device
@@ -66,7 +126,7 @@ func GetDevicesHandler(w http.ResponseWriter, r *http.Request) {
 		// Reload in case another process changed it? No, in-mem is single source of truth for this instance.
 
 		// Filter by owner
-		for _, d := range MockDevices {
+		for _, d := range MockDeviceList() {
 			if d.Owner == username {
 				devices = append(devices, d)
 			}
@@ -90,6 +150,11 @@ func GetDevicesHandler(w http.ResponseWriter, r *http.Request) {
 		devices = []Device{}
 	}
 
+	// Never send credentials to the browser
+	for i := range devices {
+		devices[i].Password = maskSecret(devices[i].Password)
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(devices)
 }
@@ -108,15 +173,28 @@ func AddDeviceHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	device.Owner = username
 
+	// Encrypt credentials before they touch disk or DB
+	if device.Password == maskedSecret {
+		device.Password = ""
+	}
+	sealed, err := secrets.Seal(device.Password)
+	if err != nil {
+		http.Error(w, "Error encrypting credentials", http.StatusInternalServerError)
+		return
+	}
+	device.Password = sealed
+
 	collection := db.GetCollection("devices")
 
 	if collection == nil {
 		// Mock Add & Persist
 		device.ID = primitive.NewObjectID()
+		mockDevicesMu.Lock()
 		MockDevices = append(MockDevices, device)
 
 		// Save to Disk
 		persistence.GetStore().Save(persistence.DevicesFile, MockDevices)
+		mockDevicesMu.Unlock()
 
 		fmt.Printf("MOCK: Added device %s (%s) for %s to JSON\n", device.Name, device.Type, device.Owner)
 	} else {
@@ -126,6 +204,7 @@ func AddDeviceHandler(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 	}
+	invalidateDeviceCache()
 
 	w.WriteHeader(http.StatusCreated)
 }
@@ -143,20 +222,24 @@ func DeleteDeviceHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	collection := db.GetCollection("devices")
+	var deleted Device
 
 	if collection == nil {
 		// Mock Delete
 		newMock := []Device{}
 		found := false
+		mockDevicesMu.Lock()
 		for _, d := range MockDevices {
 			if d.ID.Hex() == idStr && d.Owner == username {
 				found = true
+				deleted = d
 				continue // Skip (Delete)
 			}
 			newMock = append(newMock, d)
 		}
 		MockDevices = newMock
 		persistence.GetStore().Save(persistence.DevicesFile, MockDevices)
+		mockDevicesMu.Unlock()
 
 		if !found {
 			http.Error(w, "Device not found or permission denied", http.StatusForbidden)
@@ -165,16 +248,18 @@ func DeleteDeviceHandler(w http.ResponseWriter, r *http.Request) {
 	} else {
 		objID, _ := primitive.ObjectIDFromHex(idStr)
 		// Ensure we delete ONLY if it belongs to the user
-		res, err := collection.DeleteOne(context.TODO(), bson.M{"_id": objID, "owner": username})
-		if err != nil {
-			http.Error(w, "Error deleting device", http.StatusInternalServerError)
+		err := collection.FindOneAndDelete(context.TODO(), bson.M{"_id": objID, "owner": username}).Decode(&deleted)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			http.Error(w, "Device not found or permission denied", http.StatusForbidden)
 			return
 		}
-		if res.DeletedCount == 0 {
-			http.Error(w, "Device not found or permission denied", http.StatusForbidden)
+		if err != nil {
+			http.Error(w, "Error deleting device", http.StatusInternalServerError)
 			return
 		}
 	}
+	invalidateDeviceCache()
+	forgetUnusedHostKey(ssher.HostAddr(deleted.IP, deleted.Port))
 
 	w.WriteHeader(http.StatusOK)
 }
@@ -203,7 +288,7 @@ func RunCommandHandler(w http.ResponseWriter, r *http.Request) {
 			Port:     22,
 		}
 		// In full mock, search MockDevices
-		for _, d := range MockDevices {
+		for _, d := range MockDeviceList() {
 			if d.ID.Hex() == req.DeviceID {
 				device = d
 				break
@@ -218,6 +303,24 @@ func RunCommandHandler(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	username, _ := r.Context().Value("username").(string)
+	target := device.Name + " (" + device.IP + ")"
+
+	// Record the exchange (asciicast) so the audit log can show what ran and what came back
+	rec, recErr := recording.Start(username, req.DeviceID, target, "command", req.Command, 120, 40)
+	if recErr != nil {
+		log.Printf("Command recording failed: %v", recErr)
+	}
+	defer rec.Close()
+	rec.Input([]byte(req.Command + "\r\n"))
+
+	// 2a. Streaming response requested (SSE or chunked)
+	if mode := streamMode(r); mode != "" {
+		audit.LogActionWithRecording(username, "run_command", target, req.Command, rec.ID())
+		streamCommandOutput(w, r, mode, device, req.Command, collection == nil, rec)
+		return
+	}
+
 	var output string
 	var err error
 
@@ -228,18 +331,23 @@ func RunCommandHandler(w http.ResponseWriter, r *http.Request) {
 		output = fmt.Sprintf("MOCK OUTPUT from %s\n> %s\nResult: Success (Signal: -22dBm)", device.Name, req.Command)
 	} else {
 		if device.Port == 0 {
-			device.Port = 22
+			device.Port = driver.DefaultPort(device.Transport)
+		}
+		// The driver decides between an exec channel and an interactive CLI (OLTs)
+		var sess driver.Session
+		if _, sess, err = openDriver(device); err == nil {
+			output, err = sess.Run(req.Command)
+			sess.Close()
 		}
-		pool := ssher.GetPool()
-		output, err = pool.RunCommand(device.Username, device.Password, device.IP, device.Port, device.UseSSHKey, req.Command)
 	}
 
+	rec.Output([]byte(output))
+
 	// Log It
-	// Using "admin" as placeholder until we extract from JWT middleware context
-	audit.LogAction("admin", "run_command", device.Name+" ("+device.IP+")", req.Command)
+	audit.LogActionWithRecording(username, "run_command", target, req.Command, rec.ID())
 
 	if err != nil {
-		http.Error(w, "Command failed: "+err.Error(), http.StatusInternalServerError)
+		http.Error(w, "Command failed: "+err.Error(), sshErrorStatus(err))
 		return
 	}
 
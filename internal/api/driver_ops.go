@@ -0,0 +1,188 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"mikromon/internal/audit"
+	"mikromon/internal/auth"
+	"mikromon/internal/db"
+	"mikromon/internal/driver"
+
+	"github.com/gorilla/mux"
+)
+
+// openDriver picks the vendor driver of a device and opens a session on it
+func openDriver(device Device) (driver.Driver, driver.Session, error) {
+	drv, err := driver.For(device.Type, device.Vendor, device.Model)
+	if err != nil {
+		return nil, nil, err
+	}
+	sess, err := driver.Connect(driver.Target{
+		Host: device.IP, Port: device.Port, Username: device.Username,
+		Password: device.Password, UseSSHKey: device.UseSSHKey, Transport: device.Transport,
+	}, drv)
+	if err != nil {
+		return nil, nil, err
+	}
+	return drv, sess, nil
+}
+
+// driverErrorStatus is sshErrorStatus plus 501 for operations the vendor lacks
+func driverErrorStatus(err error) int {
+	if errors.Is(err, driver.ErrUnsupported) {
+		return http.StatusNotImplemented
+	}
+	return sshErrorStatus(err)
+}
+
+// canUseDevice reports whether the caller owns the device (admins see all)
+func canUseDevice(r *http.Request, device Device) bool {
+	username, _ := r.Context().Value("username").(string)
+	role, _ := r.Context().Value("role").(string)
+	return role == auth.RoleAdmin || device.Owner == username
+}
+
+// requestDevice loads the {id} device of the route, writing the error response when it fails
+func requestDevice(w http.ResponseWriter, r *http.Request) (Device, bool) {
+	device, ok := findDevice(mux.Vars(r)["id"])
+	if !ok || !canUseDevice(r, device) {
+		http.Error(w, "Device not found", http.StatusNotFound)
+		return Device{}, false
+	}
+	return device, true
+}
+
+// GetDeviceInterfacesHandler lists the ports of a device (GET /devices/{id}/interfaces)
+func GetDeviceInterfacesHandler(w http.ResponseWriter, r *http.Request) {
+	device, ok := requestDevice(w, r)
+	if !ok {
+		return
+	}
+
+	var list []driver.Interface
+	if db.GetCollection("devices") == nil {
+		list = []driver.Interface{
+			{Name: "ether1", Type: "ether", Status: "up", Comment: "WAN"},
+			{Name: "ether2", Type: "ether", Status: "up"},
+			{Name: "sfp-sfpplus1", Type: "ether", Status: "down"},
+		}
+	} else {
+		drv, sess, err := openDriver(device)
+		if err != nil {
+			http.Error(w, "Error connecting to device: "+err.Error(), driverErrorStatus(err))
+			return
+		}
+		list, err = drv.ListInterfaces(sess)
+		sess.Close()
+		if err != nil {
+			http.Error(w, "Error listing interfaces: "+err.Error(), driverErrorStatus(err))
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
+
+// GetOpticalPowerHandler reads ONU optical levels from an OLT (GET /devices/{id}/optical-power)
+func GetOpticalPowerHandler(w http.ResponseWriter, r *http.Request) {
+	device, ok := requestDevice(w, r)
+	if !ok {
+		return
+	}
+
+	var list []driver.OpticalReading
+	if db.GetCollection("devices") == nil {
+		list = []driver.OpticalReading{
+			{Port: "0/1/0", OnuID: 0, Serial: "48575443A1B2C3D4", RxPower: -19.42, TxPower: 2.1, OltRxPower: -22.3},
+			{Port: "0/1/0", OnuID: 1, Serial: "48575443A1B2C3D5", RxPower: -26.81, TxPower: 2.3, OltRxPower: -29.7},
+		}
+	} else {
+		drv, sess, err := openDriver(device)
+		if err != nil {
+			http.Error(w, "Error connecting to device: "+err.Error(), driverErrorStatus(err))
+			return
+		}
+		list, err = drv.OpticalPower(sess)
+		sess.Close()
+		if err != nil {
+			http.Error(w, "Error reading optical power: "+err.Error(), driverErrorStatus(err))
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
+
+// ExportDeviceConfigHandler returns the running configuration as text (GET /devices/{id}/export)
+func ExportDeviceConfigHandler(w http.ResponseWriter, r *http.Request) {
+	device, ok := requestDevice(w, r)
+	if !ok {
+		return
+	}
+	showSensitive := r.URL.Query().Get("sensitive") == "true"
+
+	var config string
+	if db.GetCollection("devices") == nil {
+		config = "# mock export of " + device.Name + "\n/system identity\nset name=" + device.Name + "\n"
+	} else {
+		drv, sess, err := openDriver(device)
+		if err != nil {
+			http.Error(w, "Error connecting to device: "+err.Error(), driverErrorStatus(err))
+			return
+		}
+		config, err = drv.ExportConfig(sess, showSensitive)
+		sess.Close()
+		if err != nil {
+			http.Error(w, "Error exporting configuration: "+err.Error(), driverErrorStatus(err))
+			return
+		}
+	}
+
+	username, _ := r.Context().Value("username").(string)
+	details := ""
+	if showSensitive {
+		details = "show-sensitive"
+	}
+	audit.LogAction(username, "config_export", device.Name+" ("+device.IP+")", details)
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write([]byte(config))
+}
+
+// RebootDeviceHandler reboots a device (POST /devices/{id}/reboot)
+func RebootDeviceHandler(w http.ResponseWriter, r *http.Request) {
+	device, ok := requestDevice(w, r)
+	if !ok {
+		return
+	}
+
+	if db.GetCollection("devices") != nil {
+		drv, sess, err := openDriver(device)
+		if err != nil {
+			http.Error(w, "Error connecting to device: "+err.Error(), driverErrorStatus(err))
+			return
+		}
+		err = drv.Reboot(sess)
+		sess.Close()
+		if err != nil {
+			http.Error(w, "Error rebooting device: "+err.Error(), driverErrorStatus(err))
+			return
+		}
+	}
+
+	username, _ := r.Context().Value("username").(string)
+	audit.LogAction(username, "device_reboot", device.Name+" ("+device.IP+")", "")
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]bool{"success": true})
+}
+
+// isOLT tells OLTs from routers and switches
+func isOLT(device Device) bool {
+	return strings.EqualFold(device.Type, "OLT")
+}
@@ -0,0 +1,109 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"mikromon/internal/audit"
+	"mikromon/internal/ssher"
+)
+
+// HostKeyInfo is a pinned SSH host key joined with the device using it
+type HostKeyInfo struct {
+	DeviceID   string `json:"device_id,omitempty"`
+	DeviceName string `json:"device_name,omitempty"`
+	ssher.KnownHost
+}
+
+// sshErrorStatus picks the HTTP status for an SSH failure.
+// A host key mismatch is a conflict the admin must resolve, not a server error.
+func sshErrorStatus(err error) int {
+	var mismatch *ssher.HostKeyMismatchError
+	if errors.As(err, &mismatch) {
+		return http.StatusConflict
+	}
+	return http.StatusInternalServerError
+}
+
+// GetHostKeysHandler lists pinned fingerprints (GET /devices/hostkeys)
+func GetHostKeysHandler(w http.ResponseWriter, r *http.Request) {
+	hosts, err := ssher.ListKnownHosts()
+	if err != nil {
+		http.Error(w, "Error fetching host keys", http.StatusInternalServerError)
+		return
+	}
+
+	byAddr := map[string]Device{}
+	for _, d := range allDevices() {
+		byAddr[ssher.HostAddr(d.IP, d.Port)] = d
+	}
+
+	list := []HostKeyInfo{}
+	for _, h := range hosts {
+		info := HostKeyInfo{KnownHost: h}
+		if d, ok := byAddr[h.Host]; ok {
+			info.DeviceID, info.DeviceName = d.ID.Hex(), d.Name
+		}
+		list = append(list, info)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
+
+// AcceptHostKeyHandler trusts the pending fingerprint of a device (POST /devices/hostkeys/accept?id=&fingerprint=).
+// The fingerprint must be the pending one the admin reviewed.
+func AcceptHostKeyHandler(w http.ResponseWriter, r *http.Request) {
+	device, ok := findDevice(r.URL.Query().Get("id"))
+	if !ok {
+		http.Error(w, "Device not found", http.StatusNotFound)
+		return
+	}
+	fingerprint := r.URL.Query().Get("fingerprint")
+	if fingerprint == "" {
+		http.Error(w, "Missing fingerprint", http.StatusBadRequest)
+		return
+	}
+
+	known, err := ssher.AcceptHostKey(ssher.HostAddr(device.IP, device.Port), fingerprint)
+	if err != nil {
+		http.Error(w, "Error accepting host key: "+err.Error(), http.StatusConflict)
+		return
+	}
+
+	username, _ := r.Context().Value("username").(string)
+	audit.LogAction(username, "hostkey_accept", device.Name+" ("+device.IP+")", known.Fingerprint)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(HostKeyInfo{DeviceID: device.ID.Hex(), DeviceName: device.Name, KnownHost: known})
+}
+
+// ResetHostKeyHandler forgets the pinned key of a device (DELETE /devices/hostkeys?id=)
+func ResetHostKeyHandler(w http.ResponseWriter, r *http.Request) {
+	device, ok := findDevice(r.URL.Query().Get("id"))
+	if !ok {
+		http.Error(w, "Device not found", http.StatusNotFound)
+		return
+	}
+
+	if err := ssher.ResetHostKey(ssher.HostAddr(device.IP, device.Port)); err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	username, _ := r.Context().Value("username").(string)
+	audit.LogAction(username, "hostkey_reset", device.Name+" ("+device.IP+")", "")
+	w.WriteHeader(http.StatusOK)
+}
+
+// forgetUnusedHostKey drops the pinned key of addr once no device uses it, so
+// a device later added at the same address does not inherit it
+func forgetUnusedHostKey(addr string) {
+	for _, d := range allDevices() {
+		if ssher.HostAddr(d.IP, d.Port) == addr {
+			return
+		}
+	}
+	ssher.ForgetHostKey(addr)
+}
@@ -0,0 +1,366 @@
+package api
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"mikromon/internal/db"
+	"mikromon/internal/syslog"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Syslog entries are queued by processLog and written in batches by
+// StartLogWriter: to the "syslog" collection (TTL index on received_at, see
+// scripts/seed_db.go), or without MongoDB to one JSON-lines file per day in
+// data/syslog, pruned after the retention window.
+
+const (
+	syslogDir            = "data/syslog"
+	defaultLogRetention  = 14 * 24 * time.Hour
+	logBatchSize         = 500
+	logFlushInterval     = 2 * time.Second
+	logQueueSize         = 20000
+	logPruneInterval     = time.Hour
+	maxLogScanLineLength = 1 << 20
+	maxLogPage           = 1000 // Largest page GetLogsHandler returns
+)
+
+var (
+	logQueue   = make(chan LogEntry, logQueueSize)
+	logDropped atomic.Int64
+
+	logRetainOnce sync.Once
+	logRetain     time.Duration
+
+	// Serializes file writes with file scans and pruning
+	logFileMu sync.RWMutex
+)
+
+// LogRetention is how long entries are kept (SYSLOG_RETENTION_DAYS, default 14)
+func LogRetention() time.Duration {
+	logRetainOnce.Do(func() {
+		logRetain = defaultLogRetention
+		if v := os.Getenv("SYSLOG_RETENTION_DAYS"); v != "" {
+			if days, err := strconv.Atoi(v); err == nil && days > 0 {
+				logRetain = time.Duration(days) * 24 * time.Hour
+			}
+		}
+	})
+	return logRetain
+}
+
+// queueLog hands an entry to the writer. When storage falls behind, entries
+// are dropped rather than blocking the listener.
+func queueLog(e LogEntry) {
+	select {
+	case logQueue <- e:
+	default:
+		logDropped.Add(1)
+	}
+}
+
+// StartLogWriter flushes queued entries every few seconds or once a batch is full
+func StartLogWriter() {
+	ticker := time.NewTicker(logFlushInterval)
+	defer ticker.Stop()
+	lastPrune := time.Time{}
+
+	batch := make([]LogEntry, 0, logBatchSize)
+	flush := func() {
+		if len(batch) > 0 {
+			if err := storeLogs(batch); err != nil {
+				log.Printf("Syslog: error storing %d entries: %v", len(batch), err)
+			}
+			batch = batch[:0]
+		}
+		if n := logDropped.Swap(0); n > 0 {
+			log.Printf("Syslog: queue full, dropped %d entries", n)
+		}
+		if time.Since(lastPrune) > logPruneInterval {
+			pruneLogFiles(time.Now().Add(-LogRetention()))
+			lastPrune = time.Now()
+		}
+	}
+
+	for {
+		select {
+		case e := <-logQueue:
+			batch = append(batch, e)
+			if len(batch) >= logBatchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
+
+func storeLogs(entries []LogEntry) error {
+	collection := db.GetCollection("syslog")
+	if collection != nil {
+		docs := make([]interface{}, len(entries))
+		for i, e := range entries {
+			docs[i] = e
+		}
+		_, err := collection.InsertMany(context.TODO(), docs, options.InsertMany().SetOrdered(false))
+		return err
+	}
+
+	logFileMu.Lock()
+	defer logFileMu.Unlock()
+	if err := os.MkdirAll(syslogDir, 0755); err != nil {
+		return err
+	}
+
+	// A batch can straddle midnight
+	var f *os.File
+	var w *bufio.Writer
+	day := ""
+	defer func() {
+		if f != nil {
+			w.Flush()
+			f.Close()
+		}
+	}()
+	for _, e := range entries {
+		if d := e.ReceivedAt.Local().Format("2006-01-02"); d != day {
+			if f != nil {
+				w.Flush()
+				f.Close()
+			}
+			var err error
+			f, err = os.OpenFile(logFile(d), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+			if err != nil {
+				f = nil
+				return err
+			}
+			w = bufio.NewWriter(f)
+			day = d
+		}
+		line, err := json.Marshal(e)
+		if err != nil {
+			continue
+		}
+		w.Write(append(line, '\n'))
+	}
+	return nil
+}
+
+func logFile(day string) string {
+	return filepath.Join(syslogDir, day+".jsonl")
+}
+
+// logDays lists the days with a log file, newest first
+func logDays() []string {
+	files, _ := filepath.Glob(filepath.Join(syslogDir, "*.jsonl"))
+	days := make([]string, 0, len(files))
+	for _, f := range files {
+		days = append(days, strings.TrimSuffix(filepath.Base(f), ".jsonl"))
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(days)))
+	return days
+}
+
+// pruneLogFiles removes the day files entirely before cutoff
+func pruneLogFiles(cutoff time.Time) {
+	if db.GetCollection("syslog") != nil {
+		return // TTL index
+	}
+	logFileMu.Lock()
+	defer logFileMu.Unlock()
+	for _, day := range logDays() {
+		t, err := time.ParseInLocation("2006-01-02", day, time.Local)
+		if err != nil || !t.AddDate(0, 0, 1).Before(cutoff) {
+			continue
+		}
+		if err := os.Remove(logFile(day)); err != nil {
+			log.Printf("Syslog: error pruning %s: %v", day, err)
+		}
+	}
+}
+
+// logQuery is a logFilter plus what only storage can do
+type logQuery struct {
+	logFilter
+	From, To time.Time // On received_at
+	Text     string    // Every word must appear in message, hostname or app
+	Skip     int
+	Limit    int
+}
+
+func (q logQuery) matchText(e LogEntry) bool {
+	if q.Text == "" {
+		return true
+	}
+	hay := strings.ToLower(e.Message + " " + e.Hostname + " " + e.AppName)
+	for _, word := range strings.Fields(strings.ToLower(q.Text)) {
+		if !strings.Contains(hay, word) {
+			return false
+		}
+	}
+	return true
+}
+
+func (q logQuery) match(e LogEntry) bool {
+	return !e.ReceivedAt.Before(q.From) && !e.ReceivedAt.After(q.To) && q.logFilter.match(e) && q.matchText(e)
+}
+
+// queryLogs returns one page of matching entries, newest first, and the total
+func queryLogs(q logQuery) ([]LogEntry, int64, error) {
+	collection := db.GetCollection("syslog")
+	if collection == nil {
+		return queryLogFiles(q)
+	}
+
+	filter := q.bson()
+	total, err := collection.CountDocuments(context.TODO(), filter)
+	if err != nil {
+		return nil, 0, err
+	}
+	opts := options.Find().
+		SetSort(bson.D{{Key: "received_at", Value: -1}, {Key: "_id", Value: -1}}).
+		SetSkip(int64(q.Skip)).
+		SetLimit(int64(q.Limit))
+	cursor, err := collection.Find(context.TODO(), filter, opts)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer cursor.Close(context.TODO())
+
+	list := []LogEntry{}
+	err = cursor.All(context.TODO(), &list)
+	return list, total, err
+}
+
+func (q logQuery) bson() bson.M {
+	ids := make([]string, 0, len(q.DeviceIDs))
+	for id := range q.DeviceIDs {
+		ids = append(ids, id)
+	}
+	and := []bson.M{
+		{"received_at": bson.M{"$gte": q.From, "$lte": q.To}},
+		{"severity": bson.M{"$lte": q.MaxSeverity}},
+	}
+	if q.Unmatched {
+		and = append(and, bson.M{"$or": []bson.M{
+			{"device_id": bson.M{"$in": ids}},
+			{"device_id": bson.M{"$exists": false}},
+		}})
+	} else {
+		and = append(and, bson.M{"device_id": bson.M{"$in": ids}})
+	}
+	if q.DeviceIP != "" {
+		and = append(and, bson.M{"device_ip": q.DeviceIP})
+	}
+	if q.Hostname != "" {
+		and = append(and, bson.M{"hostname": q.Hostname})
+	}
+	if q.AppName != "" {
+		and = append(and, bson.M{"app_name": q.AppName})
+	}
+	if q.MsgID != "" {
+		and = append(and, bson.M{"msg_id": q.MsgID})
+	}
+	if q.Facility >= 0 {
+		and = append(and, bson.M{"facility": syslog.FacilityName(q.Facility)})
+	}
+	if q.SDID != "" && q.SDParam == "" {
+		and = append(and, bson.M{"structured_data." + q.SDID: bson.M{"$exists": true}})
+	}
+	if q.SDParam != "" {
+		name, value, _ := strings.Cut(q.SDParam, "=")
+		if q.SDID != "" {
+			and = append(and, bson.M{"structured_data." + q.SDID + "." + name: value})
+		} else {
+			// Any SD element with that param
+			and = append(and, bson.M{"$expr": bson.M{"$anyElementTrue": bson.A{bson.M{"$map": bson.M{
+				"input": bson.M{"$objectToArray": bson.M{"$ifNull": bson.A{"$structured_data", bson.M{}}}},
+				"as":    "sd",
+				"in":    bson.M{"$eq": bson.A{bson.M{"$getField": bson.M{"field": name, "input": "$$sd.v"}}, value}},
+			}}}}})
+		}
+	}
+	if q.Text != "" {
+		// Text index on message, hostname and app_name
+		and = append(and, bson.M{"$text": bson.M{"$search": q.Text}})
+	}
+	return bson.M{"$and": and}
+}
+
+// queryLogFiles scans the day files of the range, newest first. Each file is
+// read forward, so matches are counted first to know which of them fall in
+// the page.
+func queryLogFiles(q logQuery) ([]LogEntry, int64, error) {
+	logFileMu.RLock()
+	defer logFileMu.RUnlock()
+
+	list := []LogEntry{}
+	var total int64
+	fromDay, toDay := q.From.Local().Format("2006-01-02"), q.To.Local().Format("2006-01-02")
+	for _, day := range logDays() {
+		if day < fromDay || day > toDay {
+			continue
+		}
+		n, err := scanLogFile(day, q, nil)
+		if err != nil {
+			return nil, 0, err
+		}
+		// Matches of this file take newest-first positions total..total+n-1
+		start, end := int64(q.Skip), int64(q.Skip+q.Limit)
+		if n > 0 && total < end && total+n > start {
+			var page []LogEntry
+			_, err := scanLogFile(day, q, func(i int64, e LogEntry) {
+				if pos := total + n - 1 - i; pos >= start && pos < end {
+					page = append(page, e)
+				}
+			})
+			if err != nil {
+				return nil, 0, err
+			}
+			for i := len(page) - 1; i >= 0; i-- {
+				list = append(list, page[i])
+			}
+		}
+		total += n
+	}
+	return list, total, nil
+}
+
+// scanLogFile counts the matches of a day file, calling fn on each in file order
+func scanLogFile(day string, q logQuery, fn func(i int64, e LogEntry)) (int64, error) {
+	f, err := os.Open(logFile(day))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil // Pruned meanwhile
+		}
+		return 0, err
+	}
+	defer f.Close()
+
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 64*1024), maxLogScanLineLength)
+	var n int64
+	for sc.Scan() {
+		var e LogEntry
+		if json.Unmarshal(sc.Bytes(), &e) != nil || !q.match(e) {
+			continue
+		}
+		if fn != nil {
+			fn(n, e)
+		}
+		n++
+	}
+	return n, sc.Err()
+}
@@ -0,0 +1,191 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Live syslog tail. Every subscriber has a buffered channel; processLog never
+// waits on it, so when a client cannot keep up its entries are dropped and it
+// is told how many it missed.
+
+const (
+	logTailBuffer    = 256
+	maxTailRegexLen  = 512
+	logTailDropEvery = time.Second // How often a dropped count is reported
+)
+
+type logSubscriber struct {
+	mu      sync.RWMutex
+	filter  logFilter
+	regex   *regexp.Regexp // On the message, nil = any
+	ch      chan LogEntry
+	dropped atomic.Int64
+}
+
+func (s *logSubscriber) match(e LogEntry) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.filter.match(e) && (s.regex == nil || s.regex.MatchString(e.Message))
+}
+
+func (s *logSubscriber) setFilter(f logFilter, re *regexp.Regexp) {
+	s.mu.Lock()
+	s.filter, s.regex = f, re
+	s.mu.Unlock()
+}
+
+var (
+	logSubsMu sync.RWMutex
+	logSubs   = map[*logSubscriber]struct{}{}
+)
+
+// publishLog hands an entry to every matching subscriber without blocking
+func publishLog(e LogEntry) {
+	logSubsMu.RLock()
+	defer logSubsMu.RUnlock()
+	for s := range logSubs {
+		if !s.match(e) {
+			continue
+		}
+		select {
+		case s.ch <- e:
+		default:
+			s.dropped.Add(1)
+		}
+	}
+}
+
+// LogTailMessage is what the server pushes
+type LogTailMessage struct {
+	Type    string    `json:"type"` // log, dropped, error, filter
+	Entry   *LogEntry `json:"entry,omitempty"`
+	Dropped int64     `json:"dropped,omitempty"`
+	Error   string    `json:"error,omitempty"`
+}
+
+// tailFilter builds a subscriber filter from the same params as GET /logs plus regex
+func tailFilter(r *http.Request, q url.Values) (logFilter, *regexp.Regexp, error) {
+	f, err := logFilterFrom(r, q)
+	if err != nil {
+		return f, nil, err
+	}
+	expr := q.Get("regex")
+	if expr == "" {
+		return f, nil, nil
+	}
+	if len(expr) > maxTailRegexLen {
+		return f, nil, fmt.Errorf("regex longer than %d characters", maxTailRegexLen)
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return f, nil, fmt.Errorf("invalid regex: %v", err)
+	}
+	return f, re, nil
+}
+
+// LogTailWebSocketHandler streams new syslog entries (GET /logs/tail, WebSocket).
+// The initial filter comes from the query string (same params as /logs plus
+// regex=); the client can replace it later by sending a JSON object of the
+// same params, e.g. {"severity": "err", "regex": "link (up|down)"}.
+func LogTailWebSocketHandler(w http.ResponseWriter, r *http.Request) {
+	f, re, err := tailFilter(r, r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	raw, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Println("WS Upgrade Error:", err)
+		return
+	}
+	conn := &wsConn{Conn: raw}
+	defer conn.Close()
+
+	sub := &logSubscriber{filter: f, regex: re, ch: make(chan LogEntry, logTailBuffer)}
+	logSubsMu.Lock()
+	logSubs[sub] = struct{}{}
+	logSubsMu.Unlock()
+	defer func() {
+		logSubsMu.Lock()
+		delete(logSubs, sub)
+		logSubsMu.Unlock()
+	}()
+
+	send := func(m LogTailMessage) error {
+		data, err := json.Marshal(m)
+		if err != nil {
+			return err
+		}
+		return conn.write(websocket.TextMessage, data)
+	}
+
+	// Client -> server: filter changes; also notices the browser going away
+	done := make(chan struct{})
+	conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	conn.SetPongHandler(func(string) error {
+		conn.SetReadDeadline(time.Now().Add(wsPongWait))
+		return nil
+	})
+	go func() {
+		defer close(done)
+		for {
+			_, data, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			conn.SetReadDeadline(time.Now().Add(wsPongWait))
+
+			var params map[string]string
+			if err := json.Unmarshal(data, &params); err != nil {
+				send(LogTailMessage{Type: "error", Error: "filter must be a JSON object of strings"})
+				continue
+			}
+			q := url.Values{}
+			for k, v := range params {
+				q.Set(k, v)
+			}
+			f, re, err := tailFilter(r, q)
+			if err != nil {
+				send(LogTailMessage{Type: "error", Error: err.Error()})
+				continue
+			}
+			sub.setFilter(f, re)
+			send(LogTailMessage{Type: "filter"})
+		}
+	}()
+
+	ping := time.NewTicker(wsPingInterval)
+	defer ping.Stop()
+	drops := time.NewTicker(logTailDropEvery)
+	defer drops.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case e := <-sub.ch:
+			if err := send(LogTailMessage{Type: "log", Entry: &e}); err != nil {
+				return
+			}
+		case <-drops.C:
+			if n := sub.dropped.Swap(0); n > 0 {
+				if err := send(LogTailMessage{Type: "dropped", Dropped: n}); err != nil {
+					return
+				}
+			}
+		case <-ping.C:
+			if err := conn.write(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		}
+	}
+}
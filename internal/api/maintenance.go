@@ -4,43 +4,76 @@ import (
 	"context"
 	"encoding/json"
 	"net/http"
-
-	// "sort"
+	"time"
 
 	"mikromon/internal/db"
 
 	"go.mongodb.org/mongo-driver/bson"
-	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo"
 )
 
 type SignalMetric struct {
-	DeviceName string  `json:"device_name" bson:"device_name"`
-	OnuSerial  string  `json:"onu_serial" bson:"onu_serial"`
-	RxPower    float64 `json:"rx_power" bson:"rx_power"` // dBm
-	Port       string  `json:"port" bson:"port"`
-	Timestamp  int64   `json:"timestamp" bson:"timestamp"`
+	DeviceID    string    `json:"device_id" bson:"device_id"`
+	DeviceName  string    `json:"device_name" bson:"device_name"`
+	OnuSerial   string    `json:"onu_serial" bson:"onu_serial"`
+	OnuID       int       `json:"onu_id" bson:"onu_id"`
+	RxPower     float64   `json:"rx_power" bson:"rx_power"` // dBm
+	TxPower     float64   `json:"tx_power,omitempty" bson:"tx_power,omitempty"`
+	OltRxPower  float64   `json:"olt_rx_power,omitempty" bson:"olt_rx_power,omitempty"`
+	Temperature float64   `json:"temperature,omitempty" bson:"temperature,omitempty"` // C
+	Voltage     float64   `json:"voltage,omitempty" bson:"voltage,omitempty"`         // V
+	Port        string    `json:"port" bson:"port"`
+	Timestamp   int64     `json:"timestamp" bson:"timestamp"`       // Unix seconds
+	CollectedAt time.Time `json:"collected_at" bson:"collected_at"` // Same instant as a Date, for the TTL index
 }
 
-// GetTopCriticalSignalsHandler returns the APIs with worst RX power
+// Below this RX power an ONU is listed as critical (dBm)
+const criticalRxPower = -27.0
+
+// GetTopCriticalSignalsHandler returns the ONUs with worst RX power (latest reading of each)
 func GetTopCriticalSignalsHandler(w http.ResponseWriter, r *http.Request) {
 	collection := db.GetCollection("historico_sinal")
-	var critical []SignalMetric
+	critical := []SignalMetric{}
+
+	visible := map[string]bool{}
+	for _, d := range allDevices() {
+		if canUseDevice(r, d) {
+			visible[d.ID.Hex()] = true
+		}
+	}
 
 	if collection == nil {
-		// Mock Fallback
-		critical = []SignalMetric{
-			{DeviceName: "OLT-Main", OnuSerial: "HWTC123456", RxPower: -28.5, Port: "PON 0/1"},
-			{DeviceName: "OLT-Main", OnuSerial: "HWTC999999", RxPower: -31.2, Port: "PON 0/2"},
-			{DeviceName: "OLT-Backup", OnuSerial: "ZTE11111", RxPower: -26.0, Port: "PON 1/1"},
+		critical = latestMemorySignals(func(m SignalMetric) bool {
+			return visible[m.DeviceID] && m.RxPower < criticalRxPower
+		})
+		if len(critical) > 10 {
+			critical = critical[:10]
 		}
 	} else {
-		// Find signals where RxPower < -27 (warning threshold)
-		// Sort by RxPower ascending (lower is worse, e.g. -30 < -20)
-		// Limit 10
-		opts := options.Find().SetSort(bson.D{{Key: "rx_power", Value: 1}}).SetLimit(10)
-		filter := bson.M{"rx_power": bson.M{"$lt": -27.0}}
+		ids := make([]string, 0, len(visible))
+		for id := range visible {
+			ids = append(ids, id)
+		}
+
+		// Latest reading of every ONU seen in the last day, then the 10 worst
+		// below the warning threshold (lower is worse, e.g. -30 < -20)
+		pipeline := mongo.Pipeline{
+			{{Key: "$match", Value: bson.M{
+				"device_id":    bson.M{"$in": ids},
+				"collected_at": bson.M{"$gte": time.Now().Add(-24 * time.Hour)},
+			}}},
+			{{Key: "$sort", Value: bson.D{{Key: "collected_at", Value: -1}}}},
+			{{Key: "$group", Value: bson.M{
+				"_id":    bson.M{"device_id": "$device_id", "onu_serial": "$onu_serial", "port": "$port", "onu_id": "$onu_id"},
+				"latest": bson.M{"$first": "$$ROOT"},
+			}}},
+			{{Key: "$replaceRoot", Value: bson.M{"newRoot": "$latest"}}},
+			{{Key: "$match", Value: bson.M{"rx_power": bson.M{"$lt": criticalRxPower}}}},
+			{{Key: "$sort", Value: bson.D{{Key: "rx_power", Value: 1}}}},
+			{{Key: "$limit", Value: 10}},
+		}
 
-		cursor, err := collection.Find(context.TODO(), filter, opts)
+		cursor, err := collection.Aggregate(context.TODO(), pipeline)
 		if err != nil {
 			http.Error(w, "Error fetching signals", http.StatusInternalServerError)
 			return
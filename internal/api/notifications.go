@@ -0,0 +1,383 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"mikromon/internal/audit"
+	"mikromon/internal/auth"
+	"mikromon/internal/notify"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Notification channels are managed by admins; every user manages their own
+// subscriptions and sees their own deliveries.
+
+// channelView masks the secrets of a channel. Users without
+// notifications:manage only see what they need to subscribe.
+func channelView(r *http.Request, ch notify.Channel) notify.Channel {
+	role, _ := r.Context().Value("role").(string)
+	if !auth.HasPermission(role, auth.PermManageNotifications) {
+		return notify.Channel{ID: ch.ID, Name: ch.Name, Type: ch.Type, Enabled: ch.Enabled}
+	}
+	ch.Secret = maskSecret(ch.Secret)
+	ch.SMTPPassword = maskSecret(ch.SMTPPassword)
+	ch.BotToken = maskSecret(ch.BotToken)
+	return ch
+}
+
+func pathObjectID(r *http.Request) (primitive.ObjectID, bool) {
+	id, err := primitive.ObjectIDFromHex(mux.Vars(r)["id"])
+	return id, err == nil
+}
+
+// GetNotificationChannelsHandler lists the channels (GET /notifications/channels)
+func GetNotificationChannelsHandler(w http.ResponseWriter, r *http.Request) {
+	channels, err := notify.ListChannels()
+	if err != nil {
+		http.Error(w, "Error fetching channels", http.StatusInternalServerError)
+		return
+	}
+	for i := range channels {
+		channels[i] = channelView(r, channels[i])
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(channels)
+}
+
+// CreateNotificationChannelHandler adds a channel (POST /notifications/channels)
+func CreateNotificationChannelHandler(w http.ResponseWriter, r *http.Request) {
+	var ch notify.Channel
+	if err := json.NewDecoder(r.Body).Decode(&ch); err != nil {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+	if err := ch.Validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	username, _ := r.Context().Value("username").(string)
+	ch.ID = primitive.NewObjectID()
+	ch.CreatedBy = username
+	ch.CreatedAt = time.Now()
+	ch.UpdatedAt = ch.CreatedAt
+
+	if err := notify.SaveChannel(ch); err != nil {
+		log.Printf("Error saving notification channel: %v", err)
+		http.Error(w, "Error saving channel", http.StatusInternalServerError)
+		return
+	}
+	audit.LogAction(username, "notification_channel_create", ch.Name, ch.Type)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(channelView(r, ch))
+}
+
+// UpdateNotificationChannelHandler replaces a channel (PUT /notifications/channels/{id}).
+// Masked secrets in the body keep their stored value.
+func UpdateNotificationChannelHandler(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathObjectID(r)
+	if !ok {
+		http.Error(w, "Channel not found", http.StatusNotFound)
+		return
+	}
+	old, err := notify.FindChannel(id)
+	if err != nil {
+		http.Error(w, "Channel not found", http.StatusNotFound)
+		return
+	}
+	var ch notify.Channel
+	if err := json.NewDecoder(r.Body).Decode(&ch); err != nil {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+	if ch.Secret == maskedSecret {
+		ch.Secret = old.Secret
+	}
+	if ch.SMTPPassword == maskedSecret {
+		ch.SMTPPassword = old.SMTPPassword
+	}
+	if ch.BotToken == maskedSecret {
+		ch.BotToken = old.BotToken
+	}
+	if err := ch.Validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	ch.ID, ch.CreatedBy, ch.CreatedAt = old.ID, old.CreatedBy, old.CreatedAt
+	ch.UpdatedAt = time.Now()
+
+	if err := notify.SaveChannel(ch); err != nil {
+		log.Printf("Error saving notification channel: %v", err)
+		http.Error(w, "Error saving channel", http.StatusInternalServerError)
+		return
+	}
+	username, _ := r.Context().Value("username").(string)
+	audit.LogAction(username, "notification_channel_update", ch.Name, ch.Type)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(channelView(r, ch))
+}
+
+// DeleteNotificationChannelHandler removes a channel and its subscriptions
+// (DELETE /notifications/channels/{id})
+func DeleteNotificationChannelHandler(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathObjectID(r)
+	if !ok {
+		http.Error(w, "Channel not found", http.StatusNotFound)
+		return
+	}
+	ch, _ := notify.FindChannel(id)
+	if err := notify.DeleteChannel(id); err != nil {
+		if errors.Is(err, notify.ErrNotFound) {
+			http.Error(w, "Channel not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "Error deleting channel", http.StatusInternalServerError)
+		return
+	}
+	username, _ := r.Context().Value("username").(string)
+	audit.LogAction(username, "notification_channel_delete", ch.Name, ch.Type)
+	w.WriteHeader(http.StatusOK)
+}
+
+// TestNotificationChannelHandler sends a test message right away
+// (POST /notifications/channels/{id}/test, body {"target": "..."} for email and bot).
+// Responds 502 with the delivery when the channel failed.
+func TestNotificationChannelHandler(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathObjectID(r)
+	if !ok {
+		http.Error(w, "Channel not found", http.StatusNotFound)
+		return
+	}
+	ch, err := notify.FindChannel(id)
+	if err != nil {
+		http.Error(w, "Channel not found", http.StatusNotFound)
+		return
+	}
+	var input struct {
+		Target string `json:"target"`
+	}
+	if r.ContentLength > 0 {
+		if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+			http.Error(w, "Invalid input", http.StatusBadRequest)
+			return
+		}
+	}
+	if err := ch.CheckTarget(input.Target); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	username, _ := r.Context().Value("username").(string)
+	d, err := notify.Test(ch, input.Target, username)
+	audit.LogAction(username, "notification_channel_test", ch.Name, d.Status)
+
+	w.Header().Set("Content-Type", "application/json")
+	if err != nil {
+		w.WriteHeader(http.StatusBadGateway)
+	}
+	json.NewEncoder(w).Encode(d)
+}
+
+// GetNotificationSubscriptionsHandler lists the caller's subscriptions; admins
+// see everyone's (GET /notifications/subscriptions)
+func GetNotificationSubscriptionsHandler(w http.ResponseWriter, r *http.Request) {
+	username, _ := r.Context().Value("username").(string)
+	role, _ := r.Context().Value("role").(string)
+	owner := username
+	if role == auth.RoleAdmin {
+		owner = r.URL.Query().Get("username")
+	}
+	subs, err := notify.ListSubscriptions(owner)
+	if err != nil {
+		http.Error(w, "Error fetching subscriptions", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(subs)
+}
+
+// decodeSubscription reads and validates a subscription from the body
+func decodeSubscription(w http.ResponseWriter, r *http.Request) (notify.Subscription, bool) {
+	var s notify.Subscription
+	if err := json.NewDecoder(r.Body).Decode(&s); err != nil {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return s, false
+	}
+	if err := s.Validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return s, false
+	}
+	ch, err := notify.FindChannel(s.ChannelID)
+	if err != nil {
+		http.Error(w, "Channel not found", http.StatusBadRequest)
+		return s, false
+	}
+	if err := ch.CheckTarget(s.Target); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return s, false
+	}
+	return s, true
+}
+
+// CreateNotificationSubscriptionHandler subscribes the caller to a channel
+// (POST /notifications/subscriptions)
+func CreateNotificationSubscriptionHandler(w http.ResponseWriter, r *http.Request) {
+	s, ok := decodeSubscription(w, r)
+	if !ok {
+		return
+	}
+	username, _ := r.Context().Value("username").(string)
+	s.ID = primitive.NewObjectID()
+	s.Username = username
+	s.CreatedAt = time.Now()
+
+	if err := notify.SaveSubscription(s); err != nil {
+		log.Printf("Error saving subscription: %v", err)
+		http.Error(w, "Error saving subscription", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(s)
+}
+
+// ownSubscription loads the subscription of the path; only its user or an
+// admin may touch it
+func ownSubscription(w http.ResponseWriter, r *http.Request) (notify.Subscription, bool) {
+	id, ok := pathObjectID(r)
+	if !ok {
+		http.Error(w, "Subscription not found", http.StatusNotFound)
+		return notify.Subscription{}, false
+	}
+	s, err := notify.FindSubscription(id)
+	username, _ := r.Context().Value("username").(string)
+	role, _ := r.Context().Value("role").(string)
+	if err != nil || (s.Username != username && role != auth.RoleAdmin) {
+		http.Error(w, "Subscription not found", http.StatusNotFound)
+		return notify.Subscription{}, false
+	}
+	return s, true
+}
+
+// UpdateNotificationSubscriptionHandler replaces a subscription
+// (PUT /notifications/subscriptions/{id})
+func UpdateNotificationSubscriptionHandler(w http.ResponseWriter, r *http.Request) {
+	old, ok := ownSubscription(w, r)
+	if !ok {
+		return
+	}
+	s, ok := decodeSubscription(w, r)
+	if !ok {
+		return
+	}
+	s.ID, s.Username, s.CreatedAt = old.ID, old.Username, old.CreatedAt
+
+	if err := notify.SaveSubscription(s); err != nil {
+		log.Printf("Error saving subscription: %v", err)
+		http.Error(w, "Error saving subscription", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s)
+}
+
+// DeleteNotificationSubscriptionHandler removes a subscription
+// (DELETE /notifications/subscriptions/{id})
+func DeleteNotificationSubscriptionHandler(w http.ResponseWriter, r *http.Request) {
+	s, ok := ownSubscription(w, r)
+	if !ok {
+		return
+	}
+	if err := notify.DeleteSubscription(s.ID); err != nil {
+		http.Error(w, "Error deleting subscription", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// GetNotificationTemplatesHandler lists the template of every event type
+// (GET /notifications/templates)
+func GetNotificationTemplatesHandler(w http.ResponseWriter, r *http.Request) {
+	list, err := notify.Templates()
+	if err != nil {
+		http.Error(w, "Error fetching templates", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
+
+// UpdateNotificationTemplateHandler customizes the template of an event type
+// (PUT /notifications/templates/{event})
+func UpdateNotificationTemplateHandler(w http.ResponseWriter, r *http.Request) {
+	var t notify.Template
+	if err := json.NewDecoder(r.Body).Decode(&t); err != nil {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+	t.Event = mux.Vars(r)["event"]
+	if err := t.Validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	username, _ := r.Context().Value("username").(string)
+	t.UpdatedBy = username
+	t.UpdatedAt = time.Now()
+
+	if err := notify.SaveTemplate(t); err != nil {
+		log.Printf("Error saving notification template: %v", err)
+		http.Error(w, "Error saving template", http.StatusInternalServerError)
+		return
+	}
+	audit.LogAction(username, "notification_template_update", t.Event, "")
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(t)
+}
+
+// ResetNotificationTemplateHandler restores the default template
+// (DELETE /notifications/templates/{event})
+func ResetNotificationTemplateHandler(w http.ResponseWriter, r *http.Request) {
+	event := mux.Vars(r)["event"]
+	if err := notify.ResetTemplate(event); err != nil {
+		http.Error(w, "Error resetting template", http.StatusInternalServerError)
+		return
+	}
+	username, _ := r.Context().Value("username").(string)
+	audit.LogAction(username, "notification_template_reset", event, "")
+	w.WriteHeader(http.StatusOK)
+}
+
+// GetNotificationDeliveriesHandler returns the delivery log, newest first.
+// Admins see every user's deliveries.
+// GET /notifications/deliveries?status=sent|failed|retrying|pending&limit=
+func GetNotificationDeliveriesHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	username, _ := r.Context().Value("username").(string)
+	role, _ := r.Context().Value("role").(string)
+	owner := username
+	if role == auth.RoleAdmin {
+		owner = q.Get("username")
+	}
+	limit := 200
+	if v, err := strconv.Atoi(q.Get("limit")); err == nil && v > 0 && v < limit {
+		limit = v
+	}
+
+	list, err := notify.ListDeliveries(owner, q.Get("status"), limit)
+	if err != nil {
+		http.Error(w, "Error fetching deliveries", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
@@ -0,0 +1,143 @@
+package api
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"sync"
+	"time"
+
+	"mikromon/internal/db"
+	"mikromon/internal/driver"
+)
+
+// OLT statistics are collected in the background (worker.StartOltCollector)
+// and kept in memory; handlers only read the cache so a slow OLT never blocks
+// the dashboard.
+
+var (
+	oltStatsMu    sync.RWMutex
+	oltStatsCache = map[string]OltStats{} // device ID -> last collection
+)
+
+// Max OLTs polled at the same time
+const oltCollectWorkers = 4
+
+func getOltStats(deviceID string) (OltStats, bool) {
+	oltStatsMu.RLock()
+	defer oltStatsMu.RUnlock()
+	s, ok := oltStatsCache[deviceID]
+	return s, ok
+}
+
+func setOltStats(s OltStats) {
+	oltStatsMu.Lock()
+	oltStatsCache[s.DeviceID] = s
+	oltStatsMu.Unlock()
+}
+
+// RefreshOltStats polls every OLT and updates the cache
+func RefreshOltStats() {
+	var olts []Device
+	for _, d := range allDevices() {
+		if isOLT(d) {
+			olts = append(olts, d)
+		}
+	}
+
+	mock := db.GetCollection("devices") == nil
+	sem := make(chan struct{}, oltCollectWorkers)
+	var wg sync.WaitGroup
+	for _, d := range olts {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(d Device) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			var ports []driver.PonPort
+			var err error
+			if mock {
+				ports = mockPonPorts(d)
+			} else {
+				ports, err = collectPonPorts(d)
+			}
+			if err != nil {
+				log.Printf("OLT stats: %s (%s): %v", d.Name, d.IP, err)
+				// Keep the last good numbers, flag them as stale
+				prev, ok := getOltStats(d.ID.Hex())
+				if !ok {
+					prev = OltStats{DeviceID: d.ID.Hex(), OltName: d.Name, PortDensity: []PonStatus{}}
+				}
+				prev.Error = err.Error()
+				setOltStats(prev)
+				return
+			}
+			setOltStats(buildOltStats(d, ports, time.Now()))
+		}(d)
+	}
+	wg.Wait()
+}
+
+func collectPonPorts(d Device) ([]driver.PonPort, error) {
+	drv, sess, err := openDriver(d)
+	if err != nil {
+		return nil, err
+	}
+	defer sess.Close()
+	return drv.PonPorts(sess)
+}
+
+func buildOltStats(d Device, ports []driver.PonPort, at time.Time) OltStats {
+	stats := OltStats{
+		DeviceID:    d.ID.Hex(),
+		OltName:     d.Name,
+		Vendor:      d.Vendor,
+		Model:       d.Model,
+		TotalPorts:  len(ports),
+		PortDensity: []PonStatus{},
+		CollectedAt: at,
+	}
+
+	var signalSum float64
+	var signalPorts int
+	for _, p := range ports {
+		if p.Onus > 0 {
+			stats.UsedPorts++
+		}
+		stats.TotalClients += p.Onus
+		stats.OnlineClients += p.Online
+		if p.AvgRxPower != 0 {
+			signalSum += p.AvgRxPower
+			signalPorts++
+		}
+		stats.PortDensity = append(stats.PortDensity, PonStatus{
+			Port:       p.Port,
+			Connected:  p.Onus,
+			Online:     p.Online,
+			TotalSlots: p.Capacity,
+			AvgSignal:  p.AvgRxPower,
+		})
+	}
+	if signalPorts > 0 {
+		stats.AvgSignal = math.Round(signalSum/float64(signalPorts)*100) / 100
+	}
+	return stats
+}
+
+// mockPonPorts gives the demo OLTs stable numbers when running without a DB
+func mockPonPorts(d Device) []driver.PonPort {
+	seed := int(d.ID.Timestamp().Unix() % 7)
+	ports := []driver.PonPort{}
+	for i := 0; i < 8; i++ {
+		onus := (seed*13 + i*29) % 110
+		ports = append(ports, driver.PonPort{
+			Port:       fmt.Sprintf("0/1/%d", i),
+			Onus:       onus,
+			Online:     onus - onus/15,
+			Capacity:   128,
+			AvgRxPower: -18.5 - float64((seed+i)%6),
+		})
+	}
+	return ports
+}
@@ -0,0 +1,551 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"mikromon/internal/audit"
+	"mikromon/internal/db"
+	"mikromon/internal/driver"
+	"mikromon/internal/persistence"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// OnuAssignment links an authorized ONU to a customer
+type OnuAssignment struct {
+	ID             primitive.ObjectID `json:"id" bson:"_id"`
+	DeviceID       string             `json:"device_id" bson:"device_id"`
+	DeviceName     string             `json:"device_name" bson:"device_name"`
+	PonPort        string             `json:"pon_port" bson:"pon_port"`
+	OnuID          int                `json:"onu_id" bson:"onu_id"`
+	SerialNumber   string             `json:"serial_number" bson:"serial_number"`
+	UserRef        string             `json:"user_ref" bson:"user_ref"` // Customer ID
+	OnuType        string             `json:"onu_type,omitempty" bson:"onu_type,omitempty"`
+	LineProfile    string             `json:"line_profile" bson:"line_profile"`
+	ServiceProfile string             `json:"service_profile" bson:"service_profile"`
+	VLAN           int                `json:"vlan" bson:"vlan"`
+	UserVLAN       int                `json:"user_vlan" bson:"user_vlan"`
+	GemPort        int                `json:"gemport" bson:"gemport"`
+	ServicePort    string             `json:"service_port" bson:"service_port"`
+	CreatedBy      string             `json:"created_by" bson:"created_by"`
+	CreatedAt      time.Time          `json:"created_at" bson:"created_at"`
+	UpdatedAt      time.Time          `json:"updated_at" bson:"updated_at"`
+}
+
+var (
+	assignMu       sync.Mutex
+	mockAssigns    []OnuAssignment
+	mockAssignOnce sync.Once
+)
+
+// loadMockAssignments reads the JSON file once. Caller holds assignMu.
+func loadMockAssignments() {
+	mockAssignOnce.Do(func() {
+		if err := persistence.GetStore().Load(persistence.OnuAssignmentsFile, &mockAssigns); err != nil {
+			mockAssigns = []OnuAssignment{}
+		}
+	})
+}
+
+// saveMockAssignments writes the JSON file. Caller holds assignMu.
+func saveMockAssignments() {
+	if err := persistence.GetStore().Save(persistence.OnuAssignmentsFile, mockAssigns); err != nil {
+		log.Printf("Error saving ONU assignments: %v", err)
+	}
+}
+
+func listAssignments(deviceIDs []string, userRef, serial string) ([]OnuAssignment, error) {
+	list := []OnuAssignment{}
+	collection := db.GetCollection("onu_assignments")
+	if collection == nil {
+		assignMu.Lock()
+		defer assignMu.Unlock()
+		loadMockAssignments()
+		allowed := map[string]bool{}
+		for _, id := range deviceIDs {
+			allowed[id] = true
+		}
+		for _, a := range mockAssigns {
+			if !allowed[a.DeviceID] || (userRef != "" && a.UserRef != userRef) ||
+				(serial != "" && !strings.EqualFold(a.SerialNumber, serial)) {
+				continue
+			}
+			list = append(list, a)
+		}
+		return list, nil
+	}
+
+	filter := bson.M{"device_id": bson.M{"$in": deviceIDs}}
+	if userRef != "" {
+		filter["user_ref"] = userRef
+	}
+	if serial != "" {
+		filter["serial_number"] = strings.ToUpper(serial)
+	}
+	cursor, err := collection.Find(context.TODO(), filter, options.Find().SetSort(bson.M{"created_at": -1}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(context.TODO())
+	err = cursor.All(context.TODO(), &list)
+	return list, err
+}
+
+func findAssignment(id string) (OnuAssignment, bool) {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return OnuAssignment{}, false
+	}
+
+	collection := db.GetCollection("onu_assignments")
+	if collection == nil {
+		assignMu.Lock()
+		defer assignMu.Unlock()
+		loadMockAssignments()
+		for _, a := range mockAssigns {
+			if a.ID == oid {
+				return a, true
+			}
+		}
+		return OnuAssignment{}, false
+	}
+
+	var a OnuAssignment
+	if err := collection.FindOne(context.TODO(), bson.M{"_id": oid}).Decode(&a); err != nil {
+		return OnuAssignment{}, false
+	}
+	return a, true
+}
+
+// saveAssignment inserts or replaces by ID
+func saveAssignment(a OnuAssignment) error {
+	a.UpdatedAt = time.Now()
+	collection := db.GetCollection("onu_assignments")
+	if collection == nil {
+		assignMu.Lock()
+		defer assignMu.Unlock()
+		loadMockAssignments()
+		for i := range mockAssigns {
+			if mockAssigns[i].ID == a.ID {
+				mockAssigns[i] = a
+				saveMockAssignments()
+				return nil
+			}
+		}
+		mockAssigns = append(mockAssigns, a)
+		saveMockAssignments()
+		return nil
+	}
+
+	_, err := collection.ReplaceOne(context.TODO(), bson.M{"_id": a.ID}, a, options.Replace().SetUpsert(true))
+	return err
+}
+
+func deleteAssignment(id primitive.ObjectID) error {
+	collection := db.GetCollection("onu_assignments")
+	if collection == nil {
+		assignMu.Lock()
+		defer assignMu.Unlock()
+		loadMockAssignments()
+		for i := range mockAssigns {
+			if mockAssigns[i].ID == id {
+				mockAssigns = append(mockAssigns[:i], mockAssigns[i+1:]...)
+				saveMockAssignments()
+				return nil
+			}
+		}
+		return nil
+	}
+
+	_, err := collection.DeleteOne(context.TODO(), bson.M{"_id": id})
+	return err
+}
+
+// provisionStatus maps a provisioning error to an HTTP status
+func provisionStatus(err error) int {
+	var stepErr *driver.StepError
+	switch {
+	case errors.Is(err, driver.ErrInvalidAuthorization):
+		return http.StatusBadRequest
+	case errors.Is(err, driver.ErrUnsupported):
+		return http.StatusNotImplemented
+	case errors.As(err, &stepErr):
+		return http.StatusBadGateway
+	}
+	return driverErrorStatus(err)
+}
+
+// writeProvisionError answers with the failed step and what was rolled back
+func writeProvisionError(w http.ResponseWriter, err error) {
+	resp := map[string]interface{}{"success": false, "error": err.Error()}
+	var stepErr *driver.StepError
+	if errors.As(err, &stepErr) {
+		resp["failed_step"] = stepErr.Step
+		resp["rolled_back"] = stepErr.RolledBack
+		if len(stepErr.UndoFailure) > 0 {
+			resp["rollback_errors"] = stepErr.UndoFailure
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(provisionStatus(err))
+	json.NewEncoder(w).Encode(resp)
+}
+
+// provisioner opens a session on an OLT whose driver can provision ONUs
+func provisioner(device Device) (driver.Provisioner, driver.Session, error) {
+	drv, sess, err := openDriver(device)
+	if err != nil {
+		return nil, nil, err
+	}
+	p, ok := drv.(driver.Provisioner)
+	if !ok {
+		sess.Close()
+		return nil, nil, driver.ErrUnsupported
+	}
+	return p, sess, nil
+}
+
+// runProvision plans steps with the OLT driver and applies them in one session
+func runProvision(device Device, plan func(driver.Provisioner) ([]driver.Step, error)) ([]string, error) {
+	prov, sess, err := provisioner(device)
+	if err != nil {
+		return nil, err
+	}
+	defer sess.Close()
+	steps, err := plan(prov)
+	if err != nil {
+		return nil, err
+	}
+	return driver.RunSteps(sess, steps)
+}
+
+// InstallOnuHandler authorizes a discovered ONU and stores it against the
+// customer reference (POST /onus/install). Steps applied before a failure
+// are rolled back on the OLT.
+func InstallOnuHandler(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		DeviceID string `json:"device_id"`
+		UserRef  string `json:"user_ref"` // Customer ID
+		driver.OnuAuthorization
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid Body", http.StatusBadRequest)
+		return
+	}
+	if req.UserRef == "" {
+		http.Error(w, "user_ref is required", http.StatusBadRequest)
+		return
+	}
+	device, ok := findDevice(req.DeviceID)
+	if !ok || !canUseDevice(r, device) || !isOLT(device) {
+		http.Error(w, "OLT not found", http.StatusNotFound)
+		return
+	}
+
+	onu := req.OnuAuthorization
+	onu.ServicePort = ""
+	onu.Serial = strings.ToUpper(strings.TrimSpace(onu.Serial))
+	if onu.Description == "" {
+		onu.Description = req.UserRef
+	}
+
+	// One ONU serial belongs to one customer
+	if existing, _ := listAssignments([]string{device.ID.Hex()}, "", onu.Serial); len(existing) > 0 {
+		http.Error(w, "ONU already provisioned for "+existing[0].UserRef, http.StatusConflict)
+		return
+	}
+
+	username, _ := r.Context().Value("username").(string)
+	target := device.Name + " (" + device.IP + ")"
+	applied := []string{}
+
+	if db.GetCollection("devices") == nil {
+		// Mock mode: nothing to talk to, pretend the OLT picked ID 1
+		if err := onu.Validate(); err != nil {
+			writeProvisionError(w, err)
+			return
+		}
+		if onu.OnuID == 0 {
+			onu.OnuID = 1
+		}
+		if onu.ServicePort == "" {
+			onu.ServicePort = "1"
+		}
+	} else {
+		var err error
+		applied, err = runProvision(device, func(p driver.Provisioner) ([]driver.Step, error) {
+			return p.AuthorizeSteps(&onu)
+		})
+		if err != nil {
+			audit.LogAction(username, "onu_install_failed", target, fmt.Sprintf("sn=%s port=%s ref=%s: %v", onu.Serial, onu.Port, req.UserRef, err))
+			writeProvisionError(w, err)
+			return
+		}
+	}
+
+	now := time.Now()
+	assignment := OnuAssignment{
+		ID:             primitive.NewObjectID(),
+		DeviceID:       device.ID.Hex(),
+		DeviceName:     device.Name,
+		PonPort:        onu.Port,
+		OnuID:          onu.OnuID,
+		SerialNumber:   onu.Serial,
+		UserRef:        req.UserRef,
+		OnuType:        onu.OnuType,
+		LineProfile:    onu.LineProfile,
+		ServiceProfile: onu.ServiceProfile,
+		VLAN:           onu.VLAN,
+		UserVLAN:       onu.UserVLAN,
+		GemPort:        onu.GemPort,
+		ServicePort:    onu.ServicePort,
+		CreatedBy:      username,
+		CreatedAt:      now,
+	}
+	if err := saveAssignment(assignment); err != nil {
+		// The ONU is live on the OLT; report it so it can be recorded by hand
+		log.Printf("ONU %s provisioned on %s but assignment not saved: %v", onu.Serial, device.Name, err)
+		http.Error(w, "ONU provisioned but assignment could not be saved: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	audit.LogAction(username, "onu_install", target,
+		fmt.Sprintf("sn=%s port=%s onu=%d vlan=%d ref=%s", onu.Serial, onu.Port, onu.OnuID, onu.VLAN, req.UserRef))
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":    true,
+		"assignment": assignment,
+		"steps":      applied,
+	})
+}
+
+// GetOnuAssignmentsHandler lists provisioned ONUs (GET /onus/assignments?device_id=&user_ref=&serial=)
+func GetOnuAssignmentsHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	list, err := listAssignments(visibleDeviceIDs(r, q.Get("device_id")), q.Get("user_ref"), q.Get("serial"))
+	if err != nil {
+		http.Error(w, "Error fetching assignments", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
+
+// authorization rebuilds what was sent to the OLT at install time
+func (a OnuAssignment) authorization() driver.OnuAuthorization {
+	return driver.OnuAuthorization{
+		Port:           a.PonPort,
+		Serial:         a.SerialNumber,
+		OnuID:          a.OnuID,
+		OnuType:        a.OnuType,
+		Description:    a.UserRef,
+		LineProfile:    a.LineProfile,
+		ServiceProfile: a.ServiceProfile,
+		VLAN:           a.VLAN,
+		UserVLAN:       a.UserVLAN,
+		GemPort:        a.GemPort,
+		ServicePort:    a.ServicePort,
+	}
+}
+
+// requestAssignment loads {id} and its OLT, checking the caller may use it
+func requestAssignment(w http.ResponseWriter, r *http.Request) (OnuAssignment, Device, bool) {
+	a, ok := findAssignment(mux.Vars(r)["id"])
+	if !ok {
+		http.Error(w, "Assignment not found", http.StatusNotFound)
+		return OnuAssignment{}, Device{}, false
+	}
+	device, ok := findDevice(a.DeviceID)
+	if !ok || !canUseDevice(r, device) {
+		http.Error(w, "Assignment not found", http.StatusNotFound)
+		return OnuAssignment{}, Device{}, false
+	}
+	return a, device, true
+}
+
+// DeprovisionOnuHandler removes the ONU from the OLT and deletes its
+// assignment (DELETE /onus/assignments/{id})
+func DeprovisionOnuHandler(w http.ResponseWriter, r *http.Request) {
+	a, device, ok := requestAssignment(w, r)
+	if !ok {
+		return
+	}
+	username, _ := r.Context().Value("username").(string)
+	target := device.Name + " (" + device.IP + ")"
+	onu := a.authorization()
+
+	applied := []string{}
+	if db.GetCollection("devices") != nil {
+		var err error
+		applied, err = runProvision(device, func(p driver.Provisioner) ([]driver.Step, error) {
+			return p.DeprovisionSteps(&onu)
+		})
+		if err != nil {
+			audit.LogAction(username, "onu_deprovision_failed", target, fmt.Sprintf("sn=%s port=%s onu=%d: %v", a.SerialNumber, a.PonPort, a.OnuID, err))
+			writeProvisionError(w, err)
+			return
+		}
+	}
+
+	if err := deleteAssignment(a.ID); err != nil {
+		log.Printf("ONU %s removed from %s but assignment not deleted: %v", a.SerialNumber, device.Name, err)
+		http.Error(w, "ONU removed but assignment could not be deleted: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	audit.LogAction(username, "onu_deprovision", target,
+		fmt.Sprintf("sn=%s port=%s onu=%d ref=%s", a.SerialNumber, a.PonPort, a.OnuID, a.UserRef))
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "steps": applied})
+}
+
+// RebootOnuHandler restarts the ONU (POST /onus/assignments/{id}/reboot)
+func RebootOnuHandler(w http.ResponseWriter, r *http.Request) {
+	a, device, ok := requestAssignment(w, r)
+	if !ok {
+		return
+	}
+
+	if db.GetCollection("devices") != nil {
+		prov, sess, err := provisioner(device)
+		if err != nil {
+			writeProvisionError(w, err)
+			return
+		}
+		err = prov.RebootOnu(sess, a.PonPort, a.OnuID)
+		sess.Close()
+		if err != nil {
+			http.Error(w, "Error rebooting ONU: "+err.Error(), driverErrorStatus(err))
+			return
+		}
+	}
+
+	username, _ := r.Context().Value("username").(string)
+	audit.LogAction(username, "onu_reboot", device.Name+" ("+device.IP+")",
+		fmt.Sprintf("sn=%s port=%s onu=%d", a.SerialNumber, a.PonPort, a.OnuID))
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]bool{"success": true})
+}
+
+// MoveOnuHandler moves the ONU to another PON port of the same OLT keeping
+// its service (POST /onus/assignments/{id}/move {"pon_port", "onu_id"})
+func MoveOnuHandler(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Port  string `json:"pon_port"`
+		OnuID int    `json:"onu_id"` // 0 = let the OLT pick
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Port == "" {
+		http.Error(w, "pon_port is required", http.StatusBadRequest)
+		return
+	}
+	a, device, ok := requestAssignment(w, r)
+	if !ok {
+		return
+	}
+	if req.Port == a.PonPort {
+		http.Error(w, "ONU is already on "+req.Port, http.StatusBadRequest)
+		return
+	}
+	username, _ := r.Context().Value("username").(string)
+	target := device.Name + " (" + device.IP + ")"
+
+	from := a.authorization()
+	to := a.authorization()
+	to.Port, to.OnuID, to.ServicePort = req.Port, req.OnuID, ""
+
+	applied := []string{}
+	if db.GetCollection("devices") == nil {
+		if to.OnuID == 0 {
+			to.OnuID = 1
+		}
+		to.ServicePort = "1"
+	} else {
+		var err error
+		applied, err = runProvision(device, func(p driver.Provisioner) ([]driver.Step, error) {
+			return driver.MoveSteps(p, &from, &to)
+		})
+		if err != nil {
+			audit.LogAction(username, "onu_move_failed", target, fmt.Sprintf("sn=%s %s -> %s: %v", a.SerialNumber, a.PonPort, req.Port, err))
+			writeProvisionError(w, err)
+			return
+		}
+	}
+
+	detail := fmt.Sprintf("sn=%s %s onu=%d -> %s onu=%d", a.SerialNumber, a.PonPort, a.OnuID, to.Port, to.OnuID)
+	a.PonPort, a.OnuID, a.ServicePort = to.Port, to.OnuID, to.ServicePort
+	if err := saveAssignment(a); err != nil {
+		log.Printf("ONU %s moved on %s but assignment not saved: %v", a.SerialNumber, device.Name, err)
+		http.Error(w, "ONU moved but assignment could not be saved: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	audit.LogAction(username, "onu_move", target, detail)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "assignment": a, "steps": applied})
+}
+
+// ReplaceOnuSerialHandler swaps a defective unit for a new one keeping the
+// ONU ID and service (POST /onus/assignments/{id}/replace {"serial_number"})
+func ReplaceOnuSerialHandler(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Serial string `json:"serial_number"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid Body", http.StatusBadRequest)
+		return
+	}
+	serial := strings.ToUpper(strings.TrimSpace(req.Serial))
+	if serial == "" {
+		http.Error(w, "serial_number is required", http.StatusBadRequest)
+		return
+	}
+	a, device, ok := requestAssignment(w, r)
+	if !ok {
+		return
+	}
+	if existing, _ := listAssignments([]string{device.ID.Hex()}, "", serial); len(existing) > 0 {
+		http.Error(w, "ONU already provisioned for "+existing[0].UserRef, http.StatusConflict)
+		return
+	}
+	username, _ := r.Context().Value("username").(string)
+	target := device.Name + " (" + device.IP + ")"
+	onu := a.authorization()
+
+	applied := []string{}
+	if db.GetCollection("devices") != nil {
+		var err error
+		applied, err = runProvision(device, func(p driver.Provisioner) ([]driver.Step, error) {
+			return p.ReplaceSerialSteps(&onu, serial)
+		})
+		if err != nil {
+			audit.LogAction(username, "onu_replace_failed", target, fmt.Sprintf("sn=%s -> %s: %v", a.SerialNumber, serial, err))
+			writeProvisionError(w, err)
+			return
+		}
+	}
+
+	detail := fmt.Sprintf("port=%s onu=%d sn=%s -> %s", a.PonPort, a.OnuID, a.SerialNumber, serial)
+	a.SerialNumber = serial
+	if err := saveAssignment(a); err != nil {
+		log.Printf("ONU serial replaced on %s but assignment not saved: %v", device.Name, err)
+		http.Error(w, "Serial replaced but assignment could not be saved: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	audit.LogAction(username, "onu_replace", target, detail)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "assignment": a, "steps": applied})
+}
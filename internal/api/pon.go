@@ -2,9 +2,11 @@ package api
 
 import (
 	"encoding/json"
-	"fmt"
-	"math/rand"
+	"log"
+	"mikromon/internal/db"
 	"net/http"
+	"strings"
+	"time"
 
 	"github.com/gorilla/mux"
 )
@@ -12,6 +14,7 @@ import (
 type PonStatus struct {
 	Port       string  `json:"port"` // 0/1/0
 	Connected  int     `json:"connected_clients"`
+	Online     int     `json:"online_clients"`
 	TotalSlots int     `json:"total_slots"`
 	AvgSignal  float64 `json:"avg_signal"`
 }
@@ -20,92 +23,115 @@ type UnregisteredOnu struct {
 	SerialNumber string  `json:"serial_number"`
 	PonPort      string  `json:"pon_port"`
 	Signal       float64 `json:"signal_dbm"`
+	Model        string  `json:"model,omitempty"`
+	DeviceID     string  `json:"device_id,omitempty"` // OLT where it was discovered
+	DeviceName   string  `json:"device_name,omitempty"`
 }
 
-// OltStats represents density and usage of all PON ports
+// OltStats represents density and usage of all PON ports of one OLT
 type OltStats struct {
-	OltName      string      `json:"olt_name"`
-	TotalPorts   int         `json:"total_ports"`
-	UsedPorts    int         `json:"used_ports"`
-	TotalClients int         `json:"total_clients"`
-	PortDensity  []PonStatus `json:"port_density"`
+	DeviceID      string      `json:"device_id"`
+	OltName       string      `json:"olt_name"`
+	Vendor        string      `json:"vendor,omitempty"`
+	Model         string      `json:"model,omitempty"`
+	TotalPorts    int         `json:"total_ports"` // PON ports reported by the OLT
+	UsedPorts     int         `json:"used_ports"`  // Ports with at least one ONU
+	TotalClients  int         `json:"total_clients"`
+	OnlineClients int         `json:"online_clients"`
+	AvgSignal     float64     `json:"avg_signal"`
+	PortDensity   []PonStatus `json:"port_density"`
+	CollectedAt   time.Time   `json:"collected_at"`    // Zero until the first collection
+	Error         string      `json:"error,omitempty"` // Last collection failure, numbers are from CollectedAt
 }
 
-func GetOltStatsHandler(w http.ResponseWriter, r *http.Request) {
-	// In a real scenario, this would aggregate data from all OLTs in DB
-	// For now, return mock data request by user
-
-	ports := []PonStatus{}
-	totalClients := 0
-
-	// Simulate 8 PON ports
-	for i := 0; i < 8; i++ {
-		connected := rand.Intn(100)
-		totalClients += connected
-		ports = append(ports, PonStatus{
-			Port:       fmt.Sprintf("0/1/%d", i),
-			Connected:  connected,
-			TotalSlots: 128,
-			AvgSignal:  -18.5 - rand.Float64()*5,
-		})
+// userOltStats returns the cached stats of every OLT the caller can see
+func userOltStats(r *http.Request) []OltStats {
+	list := []OltStats{}
+	for _, d := range allDevices() {
+		if !isOLT(d) || !canUseDevice(r, d) {
+			continue
+		}
+		stats, ok := getOltStats(d.ID.Hex())
+		if !ok {
+			stats = OltStats{DeviceID: d.ID.Hex(), OltName: d.Name, Vendor: d.Vendor, Model: d.Model, PortDensity: []PonStatus{}}
+		}
+		list = append(list, stats)
 	}
+	return list
+}
 
-	stats := OltStats{
-		OltName:      "Huawei-OLT-Core",
-		TotalPorts:   16, // Physical ports
-		UsedPorts:    8,  // Configured
-		TotalClients: totalClients,
-		PortDensity:  ports,
+// GetOltStatsHandler returns per-OLT PON occupation (GET /olt/stats[?device_id=])
+func GetOltStatsHandler(w http.ResponseWriter, r *http.Request) {
+	deviceID := r.URL.Query().Get("device_id")
+
+	list := []OltStats{}
+	for _, s := range userOltStats(r) {
+		if deviceID == "" || s.DeviceID == deviceID {
+			list = append(list, s)
+		}
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(stats)
+	json.NewEncoder(w).Encode(list)
 }
 
+// GetPonStatusHandler returns one PON port (GET /pon/{id}/status[?device_id=]).
+// The port goes in the path with dashes, 0-1-0 for 0/1/0.
 func GetPonStatusHandler(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	port := vars["id"] // Just echoing for now
-
-	// Mock Data Logic
-	// rand.Seed is deprecated since Go 1.20 but okay for legacy/simple
-	// We can use NewSource if strict, but let's keep simple
-	connected := rand.Intn(64)
-
-	status := PonStatus{
-		Port:       port,
-		Connected:  connected,
-		TotalSlots: 128,
-		AvgSignal:  -15.0 - rand.Float64()*10, // -15 to -25
+	port := strings.ReplaceAll(mux.Vars(r)["id"], "-", "/")
+	deviceID := r.URL.Query().Get("device_id")
+
+	for _, s := range userOltStats(r) {
+		if deviceID != "" && s.DeviceID != deviceID {
+			continue
+		}
+		for _, p := range s.PortDensity {
+			if p.Port == port {
+				w.Header().Set("Content-Type", "application/json")
+				json.NewEncoder(w).Encode(p)
+				return
+			}
+		}
 	}
-
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(status)
+	http.Error(w, "PON port not found", http.StatusNotFound)
 }
 
 func GetUnregisteredOnusHandler(w http.ResponseWriter, r *http.Request) {
-	// Mock Discovery
-	onus := []UnregisteredOnu{
-		{SerialNumber: "HWTC1A2B3C4D", PonPort: "0/1/2", Signal: -22.4},
-		{SerialNumber: "ZTEG98765432", PonPort: "0/1/4", Signal: -19.8},
+	onus := []UnregisteredOnu{}
+	only := r.URL.Query().Get("device_id")
+
+	if db.GetCollection("devices") == nil {
+		// Mock Discovery
+		onus = []UnregisteredOnu{
+			{SerialNumber: "HWTC1A2B3C4D", PonPort: "0/1/2", Signal: -22.4},
+			{SerialNumber: "ZTEG98765432", PonPort: "0/1/4", Signal: -19.8},
+		}
+	} else {
+		// Ask every OLT of the user through its vendor driver
+		for _, device := range allDevices() {
+			if !isOLT(device) || !canUseDevice(r, device) || (only != "" && device.ID.Hex() != only) {
+				continue
+			}
+			drv, sess, err := openDriver(device)
+			if err != nil {
+				log.Printf("ONU discovery: %s (%s): %v", device.Name, device.IP, err)
+				continue
+			}
+			found, err := drv.UnregisteredOnus(sess)
+			sess.Close()
+			if err != nil {
+				log.Printf("ONU discovery: %s (%s): %v", device.Name, device.IP, err)
+				continue
+			}
+			for _, o := range found {
+				onus = append(onus, UnregisteredOnu{
+					SerialNumber: o.SerialNumber, PonPort: o.PonPort, Signal: o.Signal, Model: o.Model,
+					DeviceID: device.ID.Hex(), DeviceName: device.Name,
+				})
+			}
+		}
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(onus)
 }
-
-func InstallOnuHandler(w http.ResponseWriter, r *http.Request) {
-	var req struct {
-		SerialNumber string `json:"serial_number"`
-		UserRef      string `json:"user_ref"` // Customer ID
-	}
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid Body", http.StatusBadRequest)
-		return
-	}
-
-	// Mock Installation Logic
-	// fmt.Printf("Installing ONU %s for User %s\n", req.SerialNumber, req.UserRef)
-
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]bool{"success": true})
-}
@@ -0,0 +1,71 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"mikromon/internal/recording"
+
+	"github.com/gorilla/mux"
+)
+
+// GetRecordingsHandler lists session recordings (GET /recordings?user=&device_id=)
+func GetRecordingsHandler(w http.ResponseWriter, r *http.Request) {
+	list, err := recording.List(r.URL.Query().Get("user"), r.URL.Query().Get("device_id"))
+	if err != nil {
+		http.Error(w, "Error fetching recordings", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
+
+// DownloadRecordingHandler returns the raw asciicast file (GET /recordings/{id}/download)
+func DownloadRecordingHandler(w http.ResponseWriter, r *http.Request) {
+	rec, err := recording.Get(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Recording not found", http.StatusNotFound)
+		return
+	}
+
+	f, err := os.Open(rec.Path)
+	if err != nil {
+		http.Error(w, "Recording file missing", http.StatusNotFound)
+		return
+	}
+	defer f.Close()
+	st, err := f.Stat()
+	if err != nil {
+		http.Error(w, "Recording file missing", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-asciicast")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+filepath.Base(rec.Path)+`"`)
+	http.ServeContent(w, r, filepath.Base(rec.Path), st.ModTime(), f)
+}
+
+// ReplayRecordingHandler returns the parsed events for the web player (GET /recordings/{id}/replay)
+func ReplayRecordingHandler(w http.ResponseWriter, r *http.Request) {
+	rec, err := recording.Get(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Recording not found", http.StatusNotFound)
+		return
+	}
+
+	header, events, err := recording.Load(rec)
+	if err != nil {
+		http.Error(w, "Error reading recording: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"recording": rec,
+		"header":    header,
+		"events":    events,
+	})
+}
@@ -0,0 +1,356 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"mikromon/internal/db"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	defaultHistoryRange  = 7 * 24 * time.Hour
+	maxHistoryRange      = 90 * 24 * time.Hour
+	defaultHistoryPoints = 200
+	maxHistoryPoints     = 2000
+
+	// A trend needs this much data before it means anything
+	minTrendSamples = 3
+	minTrendSpan    = 24 * time.Hour
+)
+
+// SignalPoint is one downsampled bucket of RX readings
+type SignalPoint struct {
+	Time    time.Time `json:"t"` // Bucket start
+	RxAvg   float64   `json:"rx_avg"`
+	RxMin   float64   `json:"rx_min"`
+	RxMax   float64   `json:"rx_max"`
+	Samples int       `json:"samples"`
+}
+
+// SignalTrend is a least squares fit of RX power over time.
+// A negative slope means the ONU is losing signal.
+type SignalTrend struct {
+	SlopePerWeek float64 `json:"slope_db_per_week"`
+	R2           float64 `json:"r2"` // How well the line fits (0..1)
+	Samples      int     `json:"samples"`
+}
+
+// OnuSignalSeries is the history of one ONU
+type OnuSignalSeries struct {
+	DeviceID   string        `json:"device_id"`
+	DeviceName string        `json:"device_name"`
+	Port       string        `json:"port"`
+	OnuID      int           `json:"onu_id"`
+	OnuSerial  string        `json:"onu_serial"`
+	CurrentRx  float64       `json:"current_rx"`
+	Trend      *SignalTrend  `json:"trend"` // nil when there is too little data
+	Points     []SignalPoint `json:"points,omitempty"`
+}
+
+// signalQuery selects raw readings
+type signalQuery struct {
+	DeviceIDs []string // Devices the caller may see
+	Serial    string
+	Port      string
+	From, To  time.Time
+}
+
+func (q signalQuery) match(m SignalMetric) bool {
+	if q.Serial != "" && !strings.EqualFold(m.OnuSerial, q.Serial) {
+		return false
+	}
+	if q.Port != "" && m.Port != q.Port {
+		return false
+	}
+	if m.CollectedAt.Before(q.From) || m.CollectedAt.After(q.To) {
+		return false
+	}
+	for _, id := range q.DeviceIDs {
+		if id == m.DeviceID {
+			return true
+		}
+	}
+	return false
+}
+
+// querySignals returns matching readings ordered by time
+func querySignals(q signalQuery) ([]SignalMetric, error) {
+	collection := db.GetCollection("historico_sinal")
+	if collection == nil {
+		signalMu.RLock()
+		defer signalMu.RUnlock()
+		list := []SignalMetric{}
+		for _, m := range memorySignals {
+			if q.match(m) {
+				list = append(list, m)
+			}
+		}
+		return list, nil
+	}
+
+	filter := bson.M{
+		"device_id":    bson.M{"$in": q.DeviceIDs},
+		"collected_at": bson.M{"$gte": q.From, "$lte": q.To},
+	}
+	if q.Serial != "" {
+		filter["onu_serial"] = strings.ToUpper(q.Serial)
+	}
+	if q.Port != "" {
+		filter["port"] = q.Port
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "collected_at", Value: 1}})
+
+	cursor, err := collection.Find(context.TODO(), filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(context.TODO())
+
+	list := []SignalMetric{}
+	if err := cursor.All(context.TODO(), &list); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+// groupByOnu splits time ordered readings per ONU, keeping first-seen order
+func groupByOnu(points []SignalMetric) [][]SignalMetric {
+	index := map[string]int{}
+	groups := [][]SignalMetric{}
+	for _, p := range points {
+		key := p.DeviceID + "|" + p.Port + "|" + strconv.Itoa(p.OnuID)
+		i, ok := index[key]
+		if !ok {
+			i = len(groups)
+			index[key] = i
+			groups = append(groups, nil)
+		}
+		groups[i] = append(groups[i], p)
+	}
+	return groups
+}
+
+// downsample averages readings into at most maxPoints buckets over [from, to]
+func downsample(points []SignalMetric, from, to time.Time, maxPoints int) []SignalPoint {
+	if len(points) == 0 {
+		return []SignalPoint{}
+	}
+	bucket := to.Sub(from) / time.Duration(maxPoints)
+	if bucket < time.Minute {
+		bucket = time.Minute
+	}
+
+	list := []SignalPoint{}
+	var cur *SignalPoint
+	var sum float64
+	for _, p := range points {
+		start := from.Add(p.CollectedAt.Sub(from) / bucket * bucket)
+		if cur == nil || !cur.Time.Equal(start) {
+			if cur != nil {
+				cur.RxAvg = round2(sum / float64(cur.Samples))
+			}
+			list = append(list, SignalPoint{Time: start, RxMin: p.RxPower, RxMax: p.RxPower})
+			cur = &list[len(list)-1]
+			sum = 0
+		}
+		sum += p.RxPower
+		cur.Samples++
+		cur.RxMin = round2(math.Min(cur.RxMin, p.RxPower))
+		cur.RxMax = round2(math.Max(cur.RxMax, p.RxPower))
+	}
+	cur.RxAvg = round2(sum / float64(cur.Samples))
+	return list
+}
+
+func round2(v float64) float64 {
+	return math.Round(v*100) / 100
+}
+
+// linearTrend fits rx = a + b*t over the raw readings, b in dB per week
+func linearTrend(points []SignalMetric) *SignalTrend {
+	n := len(points)
+	if n < minTrendSamples || points[n-1].CollectedAt.Sub(points[0].CollectedAt) < minTrendSpan {
+		return nil
+	}
+
+	week := float64(7 * 24 * time.Hour)
+	t0 := points[0].CollectedAt
+	var sx, sy, sxx, sxy, syy float64
+	for _, p := range points {
+		x := float64(p.CollectedAt.Sub(t0)) / week
+		y := p.RxPower
+		sx += x
+		sy += y
+		sxx += x * x
+		sxy += x * y
+		syy += y * y
+	}
+	fn := float64(n)
+	den := fn*sxx - sx*sx
+	if den == 0 {
+		return nil
+	}
+	slope := (fn*sxy - sx*sy) / den
+
+	r2 := 0.0
+	if vy := fn*syy - sy*sy; vy > 0 {
+		r := (fn*sxy - sx*sy) / math.Sqrt(den*vy)
+		r2 = r * r
+	}
+	return &SignalTrend{
+		SlopePerWeek: math.Round(slope*1000) / 1000,
+		R2:           math.Round(r2*1000) / 1000,
+		Samples:      n,
+	}
+}
+
+// visibleDeviceIDs lists the devices of the caller, optionally just one
+func visibleDeviceIDs(r *http.Request, only string) []string {
+	ids := []string{}
+	for _, d := range allDevices() {
+		if canUseDevice(r, d) && (only == "" || d.ID.Hex() == only) {
+			ids = append(ids, d.ID.Hex())
+		}
+	}
+	return ids
+}
+
+// parseTimeParam accepts RFC 3339 or Unix seconds
+func parseTimeParam(v string, def time.Time) (time.Time, bool) {
+	if v == "" {
+		return def, true
+	}
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t, true
+	}
+	if sec, err := strconv.ParseInt(v, 10, 64); err == nil {
+		return time.Unix(sec, 0), true
+	}
+	return time.Time{}, false
+}
+
+// GetSignalHistoryHandler returns the downsampled RX history of one ONU
+// (?serial=) or of every ONU of a PON port (?device_id=&port=), with a trend per ONU.
+// GET /signals/history?serial=&device_id=&port=&from=&to=&points=
+func GetSignalHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	serial, port, deviceID := q.Get("serial"), q.Get("port"), q.Get("device_id")
+	if serial == "" && (port == "" || deviceID == "") {
+		http.Error(w, "serial or device_id+port required", http.StatusBadRequest)
+		return
+	}
+
+	now := time.Now()
+	to, ok := parseTimeParam(q.Get("to"), now)
+	if !ok {
+		http.Error(w, "Invalid 'to'", http.StatusBadRequest)
+		return
+	}
+	from, ok := parseTimeParam(q.Get("from"), to.Add(-defaultHistoryRange))
+	if !ok || !from.Before(to) {
+		http.Error(w, "Invalid 'from'", http.StatusBadRequest)
+		return
+	}
+	if to.Sub(from) > maxHistoryRange {
+		from = to.Add(-maxHistoryRange)
+	}
+	maxPoints := defaultHistoryPoints
+	if v, err := strconv.Atoi(q.Get("points")); err == nil && v > 0 {
+		maxPoints = min(v, maxHistoryPoints)
+	}
+
+	readings, err := querySignals(signalQuery{
+		DeviceIDs: visibleDeviceIDs(r, deviceID),
+		Serial:    serial,
+		Port:      port,
+		From:      from,
+		To:        to,
+	})
+	if err != nil {
+		http.Error(w, "Error fetching signal history", http.StatusInternalServerError)
+		return
+	}
+
+	series := []OnuSignalSeries{}
+	for _, g := range groupByOnu(readings) {
+		last := g[len(g)-1]
+		series = append(series, OnuSignalSeries{
+			DeviceID:   last.DeviceID,
+			DeviceName: last.DeviceName,
+			Port:       last.Port,
+			OnuID:      last.OnuID,
+			OnuSerial:  last.OnuSerial,
+			CurrentRx:  last.RxPower,
+			Trend:      linearTrend(g),
+			Points:     downsample(g, from, to, maxPoints),
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"from":   from,
+		"to":     to,
+		"series": series,
+	})
+}
+
+// GetSignalDegradationHandler lists ONUs losing signal faster than min_loss
+// dB/week over the last days (default 14 days, 0.5 dB/week), worst first.
+// GET /signals/degradation?device_id=&port=&days=&min_loss=
+func GetSignalDegradationHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	days := 14
+	if v, err := strconv.Atoi(q.Get("days")); err == nil && v > 0 {
+		days = min(v, int(maxHistoryRange/(24*time.Hour)))
+	}
+	minLoss := 0.5
+	if v, err := strconv.ParseFloat(q.Get("min_loss"), 64); err == nil && v >= 0 {
+		minLoss = v
+	}
+
+	to := time.Now()
+	from := to.Add(-time.Duration(days) * 24 * time.Hour)
+	readings, err := querySignals(signalQuery{
+		DeviceIDs: visibleDeviceIDs(r, q.Get("device_id")),
+		Port:      q.Get("port"),
+		From:      from,
+		To:        to,
+	})
+	if err != nil {
+		http.Error(w, "Error fetching signal history", http.StatusInternalServerError)
+		return
+	}
+
+	degrading := []OnuSignalSeries{}
+	for _, g := range groupByOnu(readings) {
+		trend := linearTrend(g)
+		if trend == nil || trend.SlopePerWeek > -minLoss {
+			continue
+		}
+		last := g[len(g)-1]
+		degrading = append(degrading, OnuSignalSeries{
+			DeviceID:   last.DeviceID,
+			DeviceName: last.DeviceName,
+			Port:       last.Port,
+			OnuID:      last.OnuID,
+			OnuSerial:  last.OnuSerial,
+			CurrentRx:  last.RxPower,
+			Trend:      trend,
+		})
+	}
+	sort.Slice(degrading, func(i, j int) bool {
+		return degrading[i].Trend.SlopePerWeek < degrading[j].Trend.SlopePerWeek
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(degrading)
+}
@@ -0,0 +1,231 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"mikromon/internal/db"
+	"mikromon/internal/driver"
+	"mikromon/internal/notify"
+)
+
+// ONU optical readings are written to historico_sinal by the signal collector
+// (worker.StartSignalCollector). Without MongoDB they are kept in memory for
+// the retention window so the dashboards still work.
+
+const (
+	defaultSignalRetention = 30 * 24 * time.Hour
+	maxMemorySignals       = 200000
+)
+
+var (
+	signalMu         sync.RWMutex
+	memorySignals    []SignalMetric // Ordered by CollectedAt
+	signalRetainOnce sync.Once
+	signalRetain     time.Duration
+)
+
+// SignalRetention is how long readings are kept (SIGNAL_RETENTION_DAYS, default 30).
+// In MongoDB the TTL index on collected_at enforces it.
+func SignalRetention() time.Duration {
+	signalRetainOnce.Do(func() {
+		signalRetain = defaultSignalRetention
+		if v := os.Getenv("SIGNAL_RETENTION_DAYS"); v != "" {
+			if days, err := strconv.Atoi(v); err == nil && days > 0 {
+				signalRetain = time.Duration(days) * 24 * time.Hour
+			}
+		}
+	})
+	return signalRetain
+}
+
+// StoreSignalMetrics saves one collection round
+func StoreSignalMetrics(points []SignalMetric) error {
+	if len(points) == 0 {
+		return nil
+	}
+
+	collection := db.GetCollection("historico_sinal")
+	if collection != nil {
+		docs := make([]interface{}, len(points))
+		for i, p := range points {
+			docs[i] = p
+		}
+		_, err := collection.InsertMany(context.TODO(), docs)
+		return err
+	}
+
+	signalMu.Lock()
+	defer signalMu.Unlock()
+	memorySignals = append(memorySignals, points...)
+
+	// Drop what is past retention, and the oldest points beyond the cap
+	cutoff := time.Now().Add(-SignalRetention())
+	drop := sort.Search(len(memorySignals), func(i int) bool {
+		return !memorySignals[i].CollectedAt.Before(cutoff)
+	})
+	if over := len(memorySignals) - drop - maxMemorySignals; over > 0 {
+		drop += over
+	}
+	if drop > 0 {
+		memorySignals = append([]SignalMetric(nil), memorySignals[drop:]...)
+	}
+	return nil
+}
+
+// latestMemorySignals returns the newest in-memory reading of every ONU that
+// matches keep, worst RX power first
+func latestMemorySignals(keep func(SignalMetric) bool) []SignalMetric {
+	signalMu.RLock()
+	defer signalMu.RUnlock()
+
+	seen := map[string]bool{}
+	list := []SignalMetric{}
+	for i := len(memorySignals) - 1; i >= 0; i-- {
+		m := memorySignals[i]
+		key := m.DeviceID + "|" + m.Port + "|" + strconv.Itoa(m.OnuID) + "|" + m.OnuSerial
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		if keep(m) {
+			list = append(list, m)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].RxPower < list[j].RxPower })
+	return list
+}
+
+// CollectSignals reads the optical levels of every ONU on every OLT
+func CollectSignals() {
+	mock := db.GetCollection("devices") == nil
+	now := time.Now().UTC()
+
+	sem := make(chan struct{}, oltCollectWorkers)
+	var wg sync.WaitGroup
+	for _, d := range allDevices() {
+		if !isOLT(d) {
+			continue
+		}
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(d Device) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			var readings []driver.OpticalReading
+			var err error
+			if mock {
+				readings = mockOpticalReadings(d, now)
+			} else {
+				readings, err = collectOptical(d)
+			}
+			if err != nil {
+				log.Printf("Signal collector: %s (%s): %v", d.Name, d.IP, err)
+				return
+			}
+
+			points := make([]SignalMetric, 0, len(readings))
+			for _, rd := range readings {
+				points = append(points, SignalMetric{
+					DeviceID:    d.ID.Hex(),
+					DeviceName:  d.Name,
+					OnuSerial:   rd.Serial,
+					OnuID:       rd.OnuID,
+					RxPower:     rd.RxPower,
+					TxPower:     rd.TxPower,
+					OltRxPower:  rd.OltRxPower,
+					Temperature: rd.Temperature,
+					Voltage:     rd.Voltage,
+					Port:        rd.Port,
+					Timestamp:   now.Unix(),
+					CollectedAt: now,
+				})
+			}
+			if err := StoreSignalMetrics(points); err != nil {
+				log.Printf("Signal collector: saving %d readings of %s: %v", len(points), d.Name, err)
+			}
+			notifyCriticalSignals(d, points)
+		}(d)
+	}
+	wg.Wait()
+}
+
+// An ONU notified as critical must come back this far above criticalRxPower
+// before it can be notified again, so a level hovering at the limit is not
+// reported on every poll
+const criticalRecoverMargin = 1.0
+
+var (
+	criticalMu   sync.Mutex
+	criticalOnus = map[string]bool{} // device ID|serial of ONUs already notified
+)
+
+// notifyCriticalSignals publishes one event per OLT with the ONUs that just
+// dropped below criticalRxPower
+func notifyCriticalSignals(d Device, points []SignalMetric) {
+	var fresh []string
+	criticalMu.Lock()
+	for _, p := range points {
+		key := d.ID.Hex() + "|" + p.OnuSerial
+		switch {
+		case p.RxPower < criticalRxPower && !criticalOnus[key]:
+			criticalOnus[key] = true
+			fresh = append(fresh, fmt.Sprintf("%s (%s ONU %d): %.2f dBm", p.OnuSerial, p.Port, p.OnuID, p.RxPower))
+		case p.RxPower >= criticalRxPower+criticalRecoverMargin:
+			delete(criticalOnus, key)
+		}
+	}
+	criticalMu.Unlock()
+	if len(fresh) == 0 {
+		return
+	}
+
+	notify.Publish(notify.Event{
+		Type:       notify.EventCriticalSignal,
+		Severity:   notify.SeverityCritical,
+		Title:      fmt.Sprintf("%d ONU(s) below %.0f dBm on %s", len(fresh), criticalRxPower, d.Name),
+		Message:    strings.Join(fresh, "\n"),
+		DeviceID:   d.ID.Hex(),
+		DeviceName: d.Name,
+		Owner:      d.Owner,
+		Fields:     map[string]string{"onus": strconv.Itoa(len(fresh))},
+	})
+}
+
+func collectOptical(d Device) ([]driver.OpticalReading, error) {
+	drv, sess, err := openDriver(d)
+	if err != nil {
+		return nil, err
+	}
+	defer sess.Close()
+	return drv.OpticalPower(sess)
+}
+
+// mockOpticalReadings gives the demo OLTs a few ONUs with slowly drifting levels
+func mockOpticalReadings(d Device, at time.Time) []driver.OpticalReading {
+	seed := int(d.ID.Timestamp().Unix() % 7)
+	hours := float64(at.Unix()) / 3600
+	list := []driver.OpticalReading{}
+	for i := 0; i < 6; i++ {
+		base := -17.0 - float64((seed*3+i*5)%13)
+		list = append(list, driver.OpticalReading{
+			Port:        "0/1/" + strconv.Itoa(i%4),
+			OnuID:       i,
+			Serial:      "HWTC" + strings.ToUpper(strconv.FormatInt(int64(0xA0000000+seed*0x1000+i), 16)),
+			RxPower:     math.Round((base+0.4*math.Sin(hours+float64(i)))*100) / 100,
+			TxPower:     2.1,
+			Temperature: 41,
+			Voltage:     3.3,
+		})
+	}
+	return list
+}
@@ -0,0 +1,127 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"mikromon/internal/driver"
+	"mikromon/internal/recording"
+	"mikromon/internal/ssher"
+)
+
+// Long-running commands (e.g. "/system resource monitor") are streamed
+// instead of buffered. Two response styles are supported:
+//   - Server-Sent Events when the client sends "Accept: text/event-stream"
+//     (or ?stream=sse): events "stdout", "stderr", "error" and "done"
+//   - Plain chunked text with ?stream=chunked (stdout and stderr interleaved)
+// The remote command is stopped when the client disconnects or after maxStreamDuration.
+// Only devices whose driver runs commands on SSH exec channels can stream;
+// interactive CLIs (OLTs, telnet) get a 400 and must use the buffered call.
+
+const maxStreamDuration = 30 * time.Minute
+
+const (
+	streamSSE     = "sse"
+	streamChunked = "chunked"
+)
+
+// streamMode returns the requested streaming style, or "" for a buffered response
+func streamMode(r *http.Request) string {
+	switch r.URL.Query().Get("stream") {
+	case streamSSE:
+		return streamSSE
+	case streamChunked, "1", "true":
+		return streamChunked
+	}
+	if strings.Contains(r.Header.Get("Accept"), "text/event-stream") {
+		return streamSSE
+	}
+	return ""
+}
+
+// streamCommandOutput runs cmd on device and writes output as it arrives.
+// mock mode emits a single simulated chunk. Output is also written to rec.
+func streamCommandOutput(w http.ResponseWriter, r *http.Request, mode string, device Device, cmd string, mock bool, rec *recording.Recorder) error {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming not supported", http.StatusInternalServerError)
+		return fmt.Errorf("streaming not supported")
+	}
+
+	var streamer driver.Streamer
+	if !mock {
+		if device.Port == 0 {
+			device.Port = driver.DefaultPort(device.Transport)
+		}
+		noStream := fmt.Errorf("%s does not support streamed output, run the command without stream", device.Name)
+		// Checked before connecting so no interactive CLI is opened for nothing
+		drv, err := driver.For(device.Type, device.Vendor, device.Model)
+		if err == nil && (drv.CLI() != nil || strings.EqualFold(device.Transport, driver.TransportTelnet)) {
+			http.Error(w, noStream.Error(), http.StatusBadRequest)
+			return noStream
+		}
+		_, sess, err := openDriver(device)
+		if err != nil {
+			http.Error(w, "Command failed: "+err.Error(), driverErrorStatus(err))
+			return err
+		}
+		defer sess.Close()
+		if streamer, ok = sess.(driver.Streamer); !ok {
+			http.Error(w, noStream.Error(), http.StatusBadRequest)
+			return noStream
+		}
+	}
+
+	// The server WriteTimeout would cut long streams; lift it for this response
+	http.NewResponseController(w).SetWriteDeadline(time.Time{})
+
+	if mode == streamSSE {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("X-Accel-Buffering", "no") // Nginx proxy: do not buffer
+	} else {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Header().Set("X-Accel-Buffering", "no")
+	}
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	emit := func(c ssher.Chunk) {
+		rec.Output(c.Data)
+		if mode == streamSSE {
+			data, _ := json.Marshal(string(c.Data))
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", c.Stream, data)
+		} else {
+			w.Write(c.Data)
+		}
+		flusher.Flush()
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), maxStreamDuration)
+	defer cancel()
+
+	var err error
+	if mock {
+		time.Sleep(1 * time.Second) // Simulate network lag
+		emit(ssher.Chunk{Stream: ssher.StreamStdout, Data: []byte(fmt.Sprintf("MOCK OUTPUT from %s\n> %s\nResult: Success (Signal: -22dBm)\n", device.Name, cmd))})
+	} else {
+		err = streamer.Stream(ctx, cmd, emit)
+	}
+
+	if mode == streamSSE {
+		if err != nil && ctx.Err() == nil {
+			data, _ := json.Marshal(err.Error())
+			fmt.Fprintf(w, "event: error\ndata: %s\n\n", data)
+		}
+		fmt.Fprintf(w, "event: done\ndata: {}\n\n")
+		flusher.Flush()
+	} else if err != nil && ctx.Err() == nil {
+		fmt.Fprintf(w, "\n[error] %v\n", err)
+		flusher.Flush()
+	}
+	return err
+}
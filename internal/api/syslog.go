@@ -1,82 +1,317 @@
 package api
 
 import (
+	"crypto/tls"
 	"encoding/json"
 	"fmt"
-	"net"
+	"log"
 	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
+
+	"mikromon/internal/auth"
+	"mikromon/internal/syslog"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 type LogEntry struct {
-	ID        int       `json:"id"`
-	DeviceIP  string    `json:"device_ip"`
-	Timestamp time.Time `json:"timestamp"`
-	Message   string    `json:"message"`
-	Level     string    `json:"level"`
+	ID             primitive.ObjectID           `json:"id" bson:"_id"`
+	DeviceIP       string                       `json:"device_ip" bson:"device_ip"`
+	DeviceID       string                       `json:"device_id,omitempty" bson:"device_id,omitempty"` // Registered device with that IP
+	DeviceName     string                       `json:"device_name,omitempty" bson:"device_name,omitempty"`
+	Timestamp      time.Time                    `json:"timestamp" bson:"timestamp"` // As sent by the device, else when received
+	ReceivedAt     time.Time                    `json:"received_at" bson:"received_at"`
+	Message        string                       `json:"message" bson:"message"`
+	Level          string                       `json:"level" bson:"level"`       // Severity keyword (err, warning, info...)
+	Severity       int                          `json:"severity" bson:"severity"` // 0 (emerg) .. 7 (debug)
+	Facility       string                       `json:"facility" bson:"facility"`
+	Hostname       string                       `json:"hostname,omitempty" bson:"hostname,omitempty"`
+	AppName        string                       `json:"app_name,omitempty" bson:"app_name,omitempty"`
+	ProcID         string                       `json:"proc_id,omitempty" bson:"proc_id,omitempty"`
+	MsgID          string                       `json:"msg_id,omitempty" bson:"msg_id,omitempty"`
+	StructuredData map[string]map[string]string `json:"structured_data,omitempty" bson:"structured_data,omitempty"`
+	Format         string                       `json:"format" bson:"format"` // rfc5424, rfc3164 or raw
 }
 
+// Devices by IP, reloaded at most once a minute so a syslog burst does not
+// query the device list per message
+const deviceCacheTTL = time.Minute
+
 var (
-	Logs       []LogEntry
-	LogMutex   sync.Mutex
-	logCounter int
+	deviceIPMu     sync.Mutex
+	deviceIPs      map[string]Device
+	deviceIPLoaded time.Time
 )
 
+// deviceByIP finds the device a message came from
+func deviceByIP(ip string) (Device, bool) {
+	deviceIPMu.Lock()
+	defer deviceIPMu.Unlock()
+	if deviceIPs == nil || time.Since(deviceIPLoaded) > deviceCacheTTL {
+		deviceIPs = map[string]Device{}
+		for _, d := range allDevices() {
+			deviceIPs[d.IP] = d
+		}
+		deviceIPLoaded = time.Now()
+	}
+	d, ok := deviceIPs[ip]
+	return d, ok
+}
+
+// invalidateDeviceCache makes the next lookup see added or removed devices
+func invalidateDeviceCache() {
+	deviceIPMu.Lock()
+	deviceIPs = nil
+	deviceIPMu.Unlock()
+}
+
+// StartSyslogServer starts the configured listeners:
+//
+//	SYSLOG_UDP_ADDR  default ":1514"
+//	SYSLOG_TCP_ADDR  e.g. ":1514" (octet-counting or newline framing)
+//	SYSLOG_TLS_ADDR  e.g. ":6514", with SYSLOG_TLS_CERT and SYSLOG_TLS_KEY
+//	SYSLOG_MAX_MESSAGE  bytes per message, default 64 KiB
+//
+// An address set to "off" disables that listener.
 func StartSyslogServer() {
-	addr := net.UDPAddr{
-		Port: 1514,
-		IP:   net.ParseIP("0.0.0.0"),
+	maxSize := syslog.DefaultMaxMessage
+	if v, err := strconv.Atoi(os.Getenv("SYSLOG_MAX_MESSAGE")); err == nil && v >= 480 {
+		maxSize = v // RFC 5426 asks for at least 480
 	}
-	conn, err := net.ListenUDP("udp", &addr)
-	if err != nil {
-		fmt.Printf("ERROR starting Syslog Server: %v\n", err)
-		return
+	addr := func(env, def string) string {
+		v := strings.TrimSpace(os.Getenv(env))
+		if v == "" {
+			v = def
+		}
+		if strings.EqualFold(v, "off") {
+			return ""
+		}
+		return v
+	}
+	handle := func(ip string, data []byte) {
+		processLog(ip, string(data))
 	}
-	defer conn.Close()
 
-	fmt.Println("Syslog Server listening on UDP 514")
+	var wg sync.WaitGroup
+	run := func(name string, serve func() error) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := serve(); err != nil {
+				log.Printf("ERROR starting Syslog Server (%s): %v", name, err)
+			}
+		}()
+	}
 
-	buf := make([]byte, 2048)
-	for {
-		n, remoteAddr, err := conn.ReadFromUDP(buf)
+	if a := addr("SYSLOG_UDP_ADDR", ":1514"); a != "" {
+		run("UDP "+a, func() error { return syslog.ServeUDP(a, maxSize, handle) })
+	}
+	if a := addr("SYSLOG_TCP_ADDR", "off"); a != "" {
+		run("TCP "+a, func() error { return syslog.ServeTCP(a, nil, maxSize, handle) })
+	}
+	if a := addr("SYSLOG_TLS_ADDR", "off"); a != "" {
+		cert, err := tls.LoadX509KeyPair(os.Getenv("SYSLOG_TLS_CERT"), os.Getenv("SYSLOG_TLS_KEY"))
 		if err != nil {
-			continue
+			log.Printf("ERROR starting Syslog Server (TLS %s): loading SYSLOG_TLS_CERT/SYSLOG_TLS_KEY: %v", a, err)
+		} else {
+			cfg := &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}
+			run("TLS "+a, func() error { return syslog.ServeTCP(a, cfg, maxSize, handle) })
 		}
-
-		message := string(buf[:n])
-		processLog(remoteAddr.IP.String(), message)
 	}
+	wg.Wait()
 }
 
 func processLog(ip string, message string) {
-	LogMutex.Lock()
-	defer LogMutex.Unlock()
+	defer ingestAlarm(ip, message)
 
-	logCounter++
+	now := time.Now()
+	msg := syslog.Parse([]byte(message), now)
 	entry := LogEntry{
-		ID:        logCounter,
-		DeviceIP:  ip,
-		Timestamp: time.Now(),
-		Message:   message,
-		Level:     "info", // Default, could be parsed from syslog header
+		ID:             primitive.NewObjectID(),
+		DeviceIP:       ip,
+		Timestamp:      msg.Timestamp,
+		ReceivedAt:     now,
+		Message:        msg.Message,
+		Level:          syslog.SeverityName(msg.Severity),
+		Severity:       msg.Severity,
+		Facility:       syslog.FacilityName(msg.Facility),
+		Hostname:       msg.Hostname,
+		AppName:        msg.AppName,
+		ProcID:         msg.ProcID,
+		MsgID:          msg.MsgID,
+		StructuredData: msg.StructuredData,
+		Format:         msg.Format,
+	}
+	if entry.Timestamp.IsZero() {
+		entry.Timestamp = now
+	}
+	var device *Device
+	if d, ok := deviceByIP(ip); ok {
+		entry.DeviceID = d.ID.Hex()
+		entry.DeviceName = d.Name
+		device = &d
+	}
+
+	queueLog(entry)
+	publishLog(entry)
+	evaluateLog(entry, device)
+}
+
+// logFilter selects log entries. Zero values match everything.
+type logFilter struct {
+	DeviceIDs   map[string]bool // Devices the caller may see
+	Unmatched   bool            // Also entries from unknown senders
+	DeviceIP    string
+	Hostname    string
+	AppName     string
+	MsgID       string
+	Facility    int // -1 = any
+	MaxSeverity int // This severity or worse
+	SDID        string
+	SDParam     string // name=value inside any SD element (or SDID's)
+}
+
+// parseLogFilter reads the query; the error is meant for the client
+func parseLogFilter(r *http.Request) (logFilter, error) {
+	return logFilterFrom(r, r.URL.Query())
+}
+
+// logFilterFrom reads filter params from q; r only gives the caller's devices
+func logFilterFrom(r *http.Request, q url.Values) (logFilter, error) {
+	f := logFilter{
+		DeviceIDs:   map[string]bool{},
+		DeviceIP:    q.Get("device_ip"),
+		Hostname:    q.Get("hostname"),
+		AppName:     q.Get("app"),
+		MsgID:       q.Get("msg_id"),
+		Facility:    -1,
+		MaxSeverity: 7,
+		SDID:        q.Get("sd_id"),
+		SDParam:     q.Get("sd"),
 	}
+	for _, id := range visibleDeviceIDs(r, q.Get("device_id")) {
+		f.DeviceIDs[id] = true
+	}
+	// Messages from unregistered senders belong to no one; admins see them
+	role, _ := r.Context().Value("role").(string)
+	f.Unmatched = role == auth.RoleAdmin && q.Get("device_id") == ""
+
+	if v := q.Get("facility"); v != "" {
+		fac, ok := syslog.ParseFacility(v)
+		if !ok {
+			return f, fmt.Errorf("unknown facility %q", v)
+		}
+		f.Facility = fac
+	}
+	if v := q.Get("severity"); v != "" {
+		sev, ok := syslog.ParseSeverity(v)
+		if !ok {
+			return f, fmt.Errorf("unknown severity %q", v)
+		}
+		f.MaxSeverity = sev
+	}
+	if f.SDParam != "" && !strings.Contains(f.SDParam, "=") {
+		return f, fmt.Errorf("sd must be name=value")
+	}
+	return f, nil
+}
 
-	// Keep last 1000 logs in memory for now
-	Logs = append([]LogEntry{entry}, Logs...)
-	if len(Logs) > 1000 {
-		Logs = Logs[:1000]
+func (f logFilter) match(e LogEntry) bool {
+	if e.DeviceID == "" {
+		if !f.Unmatched {
+			return false
+		}
+	} else if !f.DeviceIDs[e.DeviceID] {
+		return false
+	}
+	if (f.DeviceIP != "" && e.DeviceIP != f.DeviceIP) ||
+		(f.Hostname != "" && !strings.EqualFold(e.Hostname, f.Hostname)) ||
+		(f.AppName != "" && !strings.EqualFold(e.AppName, f.AppName)) ||
+		(f.MsgID != "" && e.MsgID != f.MsgID) ||
+		(f.Facility >= 0 && e.Facility != syslog.FacilityName(f.Facility)) ||
+		e.Severity > f.MaxSeverity {
+		return false
+	}
+	if f.SDID != "" {
+		if _, ok := e.StructuredData[f.SDID]; !ok {
+			return false
+		}
+	}
+	if f.SDParam != "" {
+		name, value, _ := strings.Cut(f.SDParam, "=")
+		found := false
+		for id, params := range e.StructuredData {
+			if (f.SDID == "" || id == f.SDID) && params[name] == value {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
 	}
+	return true
 }
 
+// GetLogsHandler searches stored syslog entries, newest first
+// GET /logs?device_id=&device_ip=&hostname=&app=&msg_id=&facility=&severity=&sd_id=&sd=name=value
+//
+//	&from=&to=&q=&page=&limit=
+//
+// severity keeps that level and worse (severity=warning also returns err, crit...).
+// from/to are RFC 3339 or Unix seconds on the receive time (default: last 24h),
+// q is a full-text search on message, hostname and app.
 func GetLogsHandler(w http.ResponseWriter, r *http.Request) {
-	LogMutex.Lock()
-	logs := Logs
-	if logs == nil {
-		logs = []LogEntry{}
+	f, err := parseLogFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	params := r.URL.Query()
+
+	to, ok := parseTimeParam(params.Get("to"), time.Now())
+	if !ok {
+		http.Error(w, "Invalid 'to'", http.StatusBadRequest)
+		return
+	}
+	from, ok := parseTimeParam(params.Get("from"), to.Add(-24*time.Hour))
+	if !ok || from.After(to) {
+		http.Error(w, "Invalid 'from'", http.StatusBadRequest)
+		return
+	}
+	limit := 100
+	if v, err := strconv.Atoi(params.Get("limit")); err == nil && v > 0 {
+		limit = min(v, maxLogPage)
+	}
+	page := 1
+	if v, err := strconv.Atoi(params.Get("page")); err == nil && v > 0 {
+		page = v
+	}
+
+	logs, total, err := queryLogs(logQuery{
+		logFilter: f,
+		From:      from,
+		To:        to,
+		Text:      strings.TrimSpace(params.Get("q")),
+		Skip:      (page - 1) * limit,
+		Limit:     limit,
+	})
+	if err != nil {
+		log.Printf("Syslog: query error: %v", err)
+		http.Error(w, "Error fetching logs", http.StatusInternalServerError)
+		return
 	}
-	LogMutex.Unlock()
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(logs)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"entries": logs,
+		"total":   total,
+		"page":    page,
+		"limit":   limit,
+	})
 }
@@ -1,41 +1,181 @@
 package api
 
 import (
-    "encoding/json"
-    "net/http"
-    "fmt"
-    // "mikromon/internal/db"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"mikromon/internal/audit"
+	"mikromon/internal/auth"
 )
 
+// User is the public view of auth.User (never carries the password hash)
 type User struct {
-    ID       string `json:"id"`
-    Username string `json:"username"`
-    Role     string `json:"role"` // admin, tech, monitor
-    FullName string `json:"full_name"`
+	ID        string    `json:"id"`
+	Username  string    `json:"username"`
+	Role      string    `json:"role"` // admin, tech, monitor
+	FullName  string    `json:"full_name"`
+	Disabled  bool      `json:"disabled"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func toAPIUser(u auth.User) User {
+	return User{
+		ID:        u.ID.Hex(),
+		Username:  u.Username,
+		Role:      u.Role,
+		FullName:  u.FullName,
+		Disabled:  u.Disabled,
+		CreatedAt: u.CreatedAt,
+	}
 }
 
-var mockUsers = []User{
-    {ID: "1", Username: "admin", Role: "admin", FullName: "Administrador"},
-    {ID: "2", Username: "tech1", Role: "tech", FullName: "Técnico de Campo"},
+// userErrorStatus maps auth store errors to HTTP status codes
+func userErrorStatus(err error) int {
+	switch err {
+	case auth.ErrUserNotFound:
+		return http.StatusNotFound
+	case auth.ErrUserExists, auth.ErrLastAdmin:
+		return http.StatusConflict
+	case auth.ErrWeakPassword, auth.ErrInvalidRole:
+		return http.StatusBadRequest
+	default:
+		return http.StatusInternalServerError
+	}
 }
 
 func GetUsersHandler(w http.ResponseWriter, r *http.Request) {
-    // In prod: Fetch from DB
-    w.Header().Set("Content-Type", "application/json")
-    json.NewEncoder(w).Encode(mockUsers)
+	stored, err := auth.ListUsers()
+	if err != nil {
+		http.Error(w, "Error fetching users", http.StatusInternalServerError)
+		return
+	}
+
+	users := []User{}
+	for _, u := range stored {
+		users = append(users, toAPIUser(u))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(users)
 }
 
 func CreateUserHandler(w http.ResponseWriter, r *http.Request) {
-    var u User
-    if err := json.NewDecoder(r.Body).Decode(&u); err != nil {
-        http.Error(w, "Invalid input", http.StatusBadRequest)
-        return
-    }
-    
-    // Mock Save
-    u.ID = fmt.Sprintf("%d", len(mockUsers)+1)
-    mockUsers = append(mockUsers, u)
-    
-    w.Header().Set("Content-Type", "application/json")
-    json.NewEncoder(w).Encode(u)
+	var input struct {
+		Username string `json:"username"`
+		Password string `json:"password"`
+		Role     string `json:"role"`
+		FullName string `json:"full_name"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+
+	u, err := auth.CreateUser(auth.User{
+		Username: input.Username,
+		Role:     input.Role,
+		FullName: input.FullName,
+	}, input.Password)
+	if err != nil {
+		http.Error(w, "Error creating user: "+err.Error(), userErrorStatus(err))
+		return
+	}
+
+	actor, _ := r.Context().Value("username").(string)
+	audit.LogAction(actor, "user_create", u.Username, "role="+u.Role)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(toAPIUser(u))
+}
+
+// UpdateUserHandler changes full name, role and/or disabled flag (PUT /users?id=)
+func UpdateUserHandler(w http.ResponseWriter, r *http.Request) {
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		http.Error(w, "Missing ID", http.StatusBadRequest)
+		return
+	}
+
+	var upd auth.UserUpdate
+	if err := json.NewDecoder(r.Body).Decode(&upd); err != nil {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+
+	u, err := auth.UpdateUser(id, upd)
+	if err != nil {
+		http.Error(w, "Error updating user: "+err.Error(), userErrorStatus(err))
+		return
+	}
+
+	actor, _ := r.Context().Value("username").(string)
+	details := "role=" + u.Role
+	if u.Disabled {
+		details += " disabled"
+	}
+	audit.LogAction(actor, "user_update", u.Username, details)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(toAPIUser(*u))
+}
+
+func DeleteUserHandler(w http.ResponseWriter, r *http.Request) {
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		http.Error(w, "Missing ID", http.StatusBadRequest)
+		return
+	}
+
+	u, err := auth.FindUserByID(id)
+	if err != nil {
+		http.Error(w, "User not found", userErrorStatus(err))
+		return
+	}
+
+	actor, _ := r.Context().Value("username").(string)
+	if u.Username == actor {
+		http.Error(w, "Cannot delete your own user", http.StatusConflict)
+		return
+	}
+
+	if err := auth.DeleteUser(id); err != nil {
+		http.Error(w, "Error deleting user: "+err.Error(), userErrorStatus(err))
+		return
+	}
+
+	audit.LogAction(actor, "user_delete", u.Username, "")
+	w.WriteHeader(http.StatusOK)
+}
+
+// ResetUserPasswordHandler lets an admin force a new password (POST /users/reset-password?id=)
+func ResetUserPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		http.Error(w, "Missing ID", http.StatusBadRequest)
+		return
+	}
+
+	var input struct {
+		NewPassword string `json:"new_password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+
+	u, err := auth.FindUserByID(id)
+	if err != nil {
+		http.Error(w, "User not found", userErrorStatus(err))
+		return
+	}
+	if err := auth.SetPassword(id, input.NewPassword); err != nil {
+		http.Error(w, "Error resetting password: "+err.Error(), userErrorStatus(err))
+		return
+	}
+
+	actor, _ := r.Context().Value("username").(string)
+	audit.LogAction(actor, "user_reset_password", u.Username, "")
+	w.WriteHeader(http.StatusOK)
 }
@@ -1,9 +1,15 @@
 package api
 
 import (
+	"encoding/json"
+	"io"
 	"log"
 	"net/http"
+	"sync"
+	"time"
 
+	"mikromon/internal/audit"
+	"mikromon/internal/recording"
 	"mikromon/internal/ssher"
 
 	"github.com/gorilla/websocket"
@@ -20,55 +26,212 @@ var upgrader = websocket.Upgrader{
 	},
 }
 
-// WSCommandRequest represents the command structure coming from WS
+const (
+	wsIdleTimeout  = 15 * time.Minute // No keystroke and no output: close the session
+	wsPingInterval = 30 * time.Second
+	wsPongWait     = 70 * time.Second // Browser gone if no pong within this window
+	wsWriteWait    = 10 * time.Second
+)
+
+// WSCommandRequest is the first message sent by the terminal client
 type WSCommandRequest struct {
+	DeviceID  string `json:"device_id"` // Preferred: use stored (encrypted) credentials
 	Host      string `json:"host"`
 	Port      int    `json:"port"`
 	User      string `json:"user"`
 	Password  string `json:"password"`
-	Command   string `json:"command"`
+	Command   string `json:"command"` // Optional, typed into the shell once it opens
 	UseSSHKey bool   `json:"use_ssh_key"`
+	Cols      int    `json:"cols"`
+	Rows      int    `json:"rows"`
+}
+
+// WSTerminalMessage is every later client message: keystrokes or resize.
+// Binary frames are also accepted and treated as raw input.
+type WSTerminalMessage struct {
+	Type string `json:"type"` // input, resize
+	Data string `json:"data,omitempty"`
+	Cols int    `json:"cols,omitempty"`
+	Rows int    `json:"rows,omitempty"`
+}
+
+// wsConn serializes writes (output pump and pinger share the socket)
+type wsConn struct {
+	*websocket.Conn
+	mu sync.Mutex
+}
+
+func (c *wsConn) write(messageType int, data []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.SetWriteDeadline(time.Now().Add(wsWriteWait))
+	return c.WriteMessage(messageType, data)
 }
 
-// SSHWebSocketHandler handles the websocket connection for terminal output
+// SSHWebSocketHandler bridges an interactive PTY shell to the browser terminal
 func SSHWebSocketHandler(w http.ResponseWriter, r *http.Request) {
-	conn, err := upgrader.Upgrade(w, r, nil)
+	raw, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		log.Println("WS Upgrade Error:", err)
 		return
 	}
+	conn := &wsConn{Conn: raw}
 	defer conn.Close()
 
 	var req WSCommandRequest
 	if err := conn.ReadJSON(&req); err != nil {
 		log.Println("WS Read Error:", err)
-		conn.WriteMessage(websocket.TextMessage, []byte("Error reading request"))
+		conn.write(websocket.TextMessage, []byte("Error reading request"))
 		return
 	}
 
-	// Use the SSH Pool
-	pool := ssher.GetPool()
-
-	// Execute command (Stream output not fully implemented in ssher yet,
-	// but we can send the final result or implement a streaming reader)
-	// For now, let's send "Executing..." and then the result.
-	conn.WriteMessage(websocket.TextMessage, []byte("Executing command: "+req.Command+"\r\n"))
+	// Resolve stored credentials so the password never travels to the browser
+	target := req.Host
+	if req.DeviceID != "" {
+		device, ok := findDevice(req.DeviceID)
+		if !ok {
+			conn.write(websocket.TextMessage, []byte("Error: device not found\r\n"))
+			return
+		}
+		req.Host, req.Port, req.User, req.Password, req.UseSSHKey = device.IP, device.Port, device.Username, device.Password, device.UseSSHKey
+		target = device.Name + " (" + device.IP + ")"
+	}
 
 	// Validate inputs
 	if req.Port == 0 {
 		req.Port = 22
 	}
 
-	output, err := pool.RunCommand(req.User, req.Password, req.Host, req.Port, req.UseSSHKey, req.Command)
+	shell, err := ssher.GetPool().OpenShell(req.User, req.Password, req.Host, req.Port, req.UseSSHKey, "xterm-256color", req.Cols, req.Rows)
 	if err != nil {
-		log.Printf("Command execution failed: %v", err)
-		conn.WriteMessage(websocket.TextMessage, []byte("Error: "+err.Error()+"\r\n"))
-		// We might still want to show output (stderr is logged in RunCommand, but strictly we return stdout string)
-		// If ssher returns partial output, we send it.
+		log.Printf("WS shell failed: %v", err)
+		conn.write(websocket.TextMessage, []byte("Error: "+err.Error()+"\r\n"))
+		return
 	}
+	defer shell.Close()
 
-	// Send output
-	// Xterm.js expects \r\n for newlines often.
-	conn.WriteMessage(websocket.TextMessage, []byte(output))
-	conn.WriteMessage(websocket.TextMessage, []byte("\r\nCommand Completed.\r\n"))
+	// Record the whole session (asciicast) and link it from the audit log
+	username, _ := r.Context().Value("username").(string)
+	rec, err := recording.Start(username, req.DeviceID, target, "terminal", username+"@"+target, req.Cols, req.Rows)
+	if err != nil {
+		log.Printf("WS recording failed: %v", err)
+		conn.write(websocket.TextMessage, []byte("Error: session recording unavailable\r\n"))
+		return
+	}
+	defer rec.Close()
+	audit.LogActionWithRecording(username, "terminal_open", target, req.Command, rec.ID())
+
+	activity := make(chan struct{}, 1)
+	touch := func() {
+		select {
+		case activity <- struct{}{}:
+		default:
+		}
+	}
+	done := make(chan struct{})
+	var doneOnce sync.Once
+	finish := func() { doneOnce.Do(func() { close(done) }) }
+
+	// Remote -> browser (stdout and stderr share the PTY stream)
+	pump := func(src io.Reader) {
+		buf := make([]byte, 32*1024)
+		for {
+			n, err := src.Read(buf)
+			if n > 0 {
+				touch()
+				rec.Output(buf[:n])
+				if werr := conn.write(websocket.BinaryMessage, buf[:n]); werr != nil {
+					finish()
+					return
+				}
+			}
+			if err != nil {
+				finish()
+				return
+			}
+		}
+	}
+	go pump(shell.Stdout)
+	go pump(shell.Stderr)
+
+	// Shell exited on its own (e.g. "/quit")
+	go func() {
+		shell.Wait()
+		finish()
+	}()
+
+	// Browser -> remote
+	conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	conn.SetPongHandler(func(string) error {
+		conn.SetReadDeadline(time.Now().Add(wsPongWait))
+		return nil
+	})
+	go func() {
+		defer finish()
+		for {
+			msgType, data, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			conn.SetReadDeadline(time.Now().Add(wsPongWait))
+
+			if msgType == websocket.BinaryMessage {
+				touch()
+				rec.Input(data)
+				if _, err := shell.Stdin.Write(data); err != nil {
+					return
+				}
+				continue
+			}
+
+			var msg WSTerminalMessage
+			if err := json.Unmarshal(data, &msg); err != nil {
+				continue
+			}
+			switch msg.Type {
+			case "input":
+				touch()
+				rec.Input([]byte(msg.Data))
+				if _, err := shell.Stdin.Write([]byte(msg.Data)); err != nil {
+					return
+				}
+			case "resize":
+				shell.Resize(msg.Cols, msg.Rows)
+				rec.Resize(msg.Cols, msg.Rows)
+			}
+		}
+	}()
+
+	if req.Command != "" {
+		rec.Input([]byte(req.Command + "\r"))
+		shell.Stdin.Write([]byte(req.Command + "\r"))
+	}
+
+	ping := time.NewTicker(wsPingInterval)
+	defer ping.Stop()
+	idle := time.NewTimer(wsIdleTimeout)
+	defer idle.Stop()
+
+	for {
+		select {
+		case <-done:
+			conn.write(websocket.TextMessage, []byte("\r\nSession closed.\r\n"))
+			return
+		case <-activity:
+			if !idle.Stop() {
+				<-idle.C
+			}
+			idle.Reset(wsIdleTimeout)
+		case <-idle.C:
+			conn.write(websocket.TextMessage, []byte("\r\nSession closed after inactivity.\r\n"))
+			return
+		case <-ping.C:
+			conn.mu.Lock()
+			err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(wsWriteWait))
+			conn.mu.Unlock()
+			if err != nil {
+				return
+			}
+		}
+	}
 }
@@ -8,27 +8,35 @@ import (
 )
 
 type AuditLog struct {
-    Timestamp time.Time `bson:"timestamp"`
-    User      string    `bson:"user"`
-    Action    string    `bson:"action"`
-    Target    string    `bson:"target"`
-    Details   string    `bson:"details"`
+	Timestamp time.Time `bson:"timestamp"`
+	User      string    `bson:"user"`
+	Action    string    `bson:"action"`
+	Target    string    `bson:"target"`
+	Details   string    `bson:"details"`
+	// RecordingID links terminal/command sessions to their asciicast recording
+	RecordingID string `bson:"recording_id,omitempty"`
 }
 
 func LogAction(user, action, target, details string) {
-    // Run in background to not block request
-    go func() {
-        collection := db.GetCollection("audit_logs")
-        if collection == nil {
-            return // Skip logging in mock mode
-        }
-        entry := AuditLog{
-            Timestamp: time.Now(),
-            User:      user,
-            Action:    action,
-            Target:    target,
-            Details:   details,
-        }
-        collection.InsertOne(context.Background(), entry)
-    }()
+	LogActionWithRecording(user, action, target, details, "")
+}
+
+// LogActionWithRecording is LogAction for sessions that were recorded
+func LogActionWithRecording(user, action, target, details, recordingID string) {
+	// Run in background to not block request
+	go func() {
+		collection := db.GetCollection("audit_logs")
+		if collection == nil {
+			return // Skip logging in mock mode
+		}
+		entry := AuditLog{
+			Timestamp:   time.Now(),
+			User:        user,
+			Action:      action,
+			Target:      target,
+			Details:     details,
+			RecordingID: recordingID,
+		}
+		collection.InsertOne(context.Background(), entry)
+	}()
 }
@@ -3,24 +3,26 @@ package auth
 import (
 	"context"
 	"encoding/json"
-	"fmt"
 	"net/http"
 	"strings"
 	"time"
 
-	"mikromon/internal/db"
+	"mikromon/internal/audit"
 
 	"github.com/golang-jwt/jwt/v5"
-	"go.mongodb.org/mongo-driver/bson"
-	"golang.org/x/crypto/bcrypt"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 var SecretKey = []byte("SUPER_SECRET_KEY_CHANGE_ME")
 
 type User struct {
-	Username string `json:"username" bson:"username"`
-	Password string `json:"password" bson:"password_hash"`
-	Role     string `json:"role" bson:"role"` // admin, tech, monitor
+	ID        primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	Username  string             `json:"username" bson:"username"`
+	Password  string             `json:"password" bson:"password_hash"`
+	Role      string             `json:"role" bson:"role"` // admin, tech, monitor
+	FullName  string             `json:"full_name" bson:"full_name"`
+	Disabled  bool               `json:"disabled" bson:"disabled"`
+	CreatedAt time.Time          `json:"created_at" bson:"created_at"`
 }
 
 type Credentials struct {
@@ -41,38 +43,26 @@ func LoginHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Find user in DB
-	// For prototype, we will create a mock admin if not exists (TODO: Remove in prod)
-	if (creds.Username == "admin" && creds.Password == "admin") ||
-		(creds.Username == "sairo" && creds.Password == "sairo") ||
-		(creds.Username == "samuel" && creds.Password == "admin123") {
-		// Allow default users
-	} else {
-		// Check DB
-		collection := db.GetCollection("users")
-		if collection == nil {
-			http.Error(w, "Invalid credentials (Mock Mode)", http.StatusUnauthorized)
-			return
-		}
-
-		var result User
-		err := collection.FindOne(context.TODO(), bson.M{"username": creds.Username}).Decode(&result)
-		if err != nil {
-			http.Error(w, "Invalid credentials", http.StatusUnauthorized)
-			return
-		}
-		// Check Hash
-		if err := bcrypt.CompareHashAndPassword([]byte(result.Password), []byte(creds.Password)); err != nil {
-			http.Error(w, "Invalid credentials", http.StatusUnauthorized)
-			return
-		}
+	// Find user in DB (or JSON store in mock mode)
+	user, err := FindUser(creds.Username)
+	if err != nil || !user.CheckPassword(creds.Password) {
+		http.Error(w, "Invalid credentials", http.StatusUnauthorized)
+		return
+	}
+	if user.Disabled {
+		http.Error(w, "User disabled", http.StatusForbidden)
+		return
+	}
+	if !ValidRole(user.Role) {
+		http.Error(w, "User has no valid role assigned", http.StatusForbidden)
+		return
 	}
 
 	// Generate JWT
 	expirationTime := time.Now().Add(12 * time.Hour)
 	claims := &Claims{
-		Username: creds.Username,
-		Role:     "admin", // Defaulting to admin for the prototype login
+		Username: user.Username,
+		Role:     user.Role,
 		RegisteredClaims: jwt.RegisteredClaims{
 			ExpiresAt: jwt.NewNumericDate(expirationTime),
 		},
@@ -91,15 +81,30 @@ func LoginHandler(w http.ResponseWriter, r *http.Request) {
 
 func ChangePasswordHandler(w http.ResponseWriter, r *http.Request) {
 	var input struct {
-		NewPassword string `json:"new_password"`
+		CurrentPassword string `json:"current_password"`
+		NewPassword     string `json:"new_password"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
 		http.Error(w, "Invalid input", http.StatusBadRequest)
 		return
 	}
 
-	username := r.Context().Value("username").(string)
-	fmt.Printf("DEBUG: User %s changed password to [HIDDEN] (Mocked)\n", username)
+	username, _ := r.Context().Value("username").(string)
+	err := ChangePassword(username, input.CurrentPassword, input.NewPassword)
+	switch err {
+	case nil:
+	case ErrWrongPassword:
+		http.Error(w, "Senha atual incorreta", http.StatusForbidden)
+		return
+	case ErrWeakPassword:
+		http.Error(w, "Nova senha muito curta", http.StatusBadRequest)
+		return
+	default:
+		http.Error(w, "Error changing password", http.StatusInternalServerError)
+		return
+	}
+
+	audit.LogAction(username, "change_password", username, "")
 
 	w.WriteHeader(http.StatusOK)
 	w.Write([]byte(`{"message": "Senha alterada com sucesso"}`))
@@ -135,9 +140,16 @@ func JwtMiddleware(next http.Handler) http.Handler {
 			return
 		}
 
+		// Re-read the stored user so role changes and disabling apply immediately
+		user, err := FindUser(claims.Username)
+		if err != nil || user.Disabled || !ValidRole(user.Role) {
+			http.Error(w, "User not found or disabled", http.StatusUnauthorized)
+			return
+		}
+
 		// Pass claims to context
-		ctx := context.WithValue(r.Context(), "username", claims.Username)
-		ctx = context.WithValue(ctx, "role", claims.Role)
+		ctx := context.WithValue(r.Context(), "username", user.Username)
+		ctx = context.WithValue(ctx, "role", user.Role)
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
@@ -149,15 +161,9 @@ func MeHandler(w http.ResponseWriter, r *http.Request) {
 	username, _ := usernameVal.(string)
 	role, _ := roleVal.(string)
 
-	fullName := "Usuário"
-	if username == "admin" {
-		fullName = "Administrador"
-	}
-	if username == "sairo" {
-		fullName = "Sairo J."
-	}
-	if username == "samuel" {
-		fullName = "Samuel R."
+	fullName := username
+	if user, err := FindUser(username); err == nil && user.FullName != "" {
+		fullName = user.FullName
 	}
 
 	w.Header().Set("Content-Type", "application/json")
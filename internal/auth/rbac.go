@@ -0,0 +1,66 @@
+package auth
+
+import (
+	"net/http"
+)
+
+// Roles stored on users and carried in the JWT
+const (
+	RoleAdmin   = "admin"
+	RoleTech    = "tech"
+	RoleMonitor = "monitor"
+)
+
+// Permission is a coarse capability attached to a route
+type Permission string
+
+const (
+	PermRead          Permission = "read"           // Dashboards, lists, logs
+	PermExecute       Permission = "execute"        // Run commands, terminal, schedules, ONU install, manual backups
+	PermManageDevices Permission = "devices:manage" // Add/Delete devices, provisioning
+	PermManageBackups Permission = "backups:manage" // Backup config and deletion
+	PermManageUsers   Permission = "users:manage"   // User administration
+	PermManageSecrets Permission = "secrets:manage" // Master key rotation
+	PermAudit         Permission = "audit"          // Session recordings
+	PermManageAlerts  Permission = "alerts:manage"  // Alert rules
+
+	PermManageNotifications Permission = "notifications:manage" // Notification channels and templates
+)
+
+// rolePermissions maps each role to what it is allowed to do.
+// Admin is handled separately (everything allowed).
+var rolePermissions = map[string][]Permission{
+	RoleTech:    {PermRead, PermExecute},
+	RoleMonitor: {PermRead},
+}
+
+// ValidRole reports whether role is one of the known roles
+func ValidRole(role string) bool {
+	return role == RoleAdmin || role == RoleTech || role == RoleMonitor
+}
+
+// HasPermission checks if the given role grants perm
+func HasPermission(role string, perm Permission) bool {
+	if role == RoleAdmin {
+		return true
+	}
+	for _, p := range rolePermissions[role] {
+		if p == perm {
+			return true
+		}
+	}
+	return false
+}
+
+// Require wraps a handler so it only runs when the role in the request
+// context (set by JwtMiddleware) grants perm. Responds 403 otherwise.
+func Require(perm Permission, next http.HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		role, _ := r.Context().Value("role").(string)
+		if !HasPermission(role, perm) {
+			http.Error(w, "Forbidden: insufficient permissions", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,339 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"mikromon/internal/db"
+	"mikromon/internal/persistence"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// User storage: "users" collection, or persistence.UsersFile when Mongo is down.
+
+const minPasswordLength = 6
+
+var (
+	ErrUserNotFound  = errors.New("user not found")
+	ErrUserExists    = errors.New("username already exists")
+	ErrWeakPassword  = errors.New("password too short")
+	ErrInvalidRole   = errors.New("invalid role")
+	ErrLastAdmin     = errors.New("cannot remove or disable the last active admin")
+	ErrWrongPassword = errors.New("current password does not match")
+)
+
+var (
+	mockUsers   []User
+	mockUsersMu sync.Mutex
+)
+
+func init() {
+	// Load JSON users on startup (used only when Mongo is unavailable)
+	store := persistence.GetStore()
+	err := store.Load(persistence.UsersFile, &mockUsers)
+	if err != nil && os.IsNotExist(err) {
+		mockUsers = nil
+	}
+}
+
+func usersCollection() *mongo.Collection {
+	return db.GetCollection("users")
+}
+
+func saveMockUsers() error {
+	return persistence.GetStore().Save(persistence.UsersFile, mockUsers)
+}
+
+// HashPassword returns the bcrypt hash of a plain password
+func HashPassword(plain string) (string, error) {
+	if len(plain) < minPasswordLength {
+		return "", ErrWeakPassword
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(plain), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+// CheckPassword compares a plain password against the stored hash
+func (u *User) CheckPassword(plain string) bool {
+	return bcrypt.CompareHashAndPassword([]byte(u.Password), []byte(plain)) == nil
+}
+
+// EnsureDefaultAdmin creates an "admin" user with a random password when
+// no user exists at all, so a fresh install (DB or JSON) is never locked out.
+// The password is only ever shown in the log.
+func EnsureDefaultAdmin() {
+	users, err := ListUsers()
+	if err != nil || len(users) > 0 {
+		return
+	}
+	buf := make([]byte, 12)
+	if _, err := rand.Read(buf); err != nil {
+		log.Printf("Auth: could not create default admin: %v", err)
+		return
+	}
+	password := base64.RawURLEncoding.EncodeToString(buf) // 16 characters
+	if _, err := CreateUser(User{Username: "admin", Role: RoleAdmin, FullName: "Administrador"}, password); err != nil {
+		log.Printf("Auth: could not create default admin: %v", err)
+		return
+	}
+	log.Printf("Auth: created default user 'admin' with password %q. Change it after first login; it is not shown again.", password)
+}
+
+// FindUser looks up a user by username
+func FindUser(username string) (*User, error) {
+	coll := usersCollection()
+	if coll == nil {
+		mockUsersMu.Lock()
+		defer mockUsersMu.Unlock()
+		for _, u := range mockUsers {
+			if u.Username == username {
+				found := u
+				return &found, nil
+			}
+		}
+		return nil, ErrUserNotFound
+	}
+
+	var u User
+	err := coll.FindOne(context.TODO(), bson.M{"username": username}).Decode(&u)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrUserNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+
+// FindUserByID looks up a user by its hex ID
+func FindUserByID(id string) (*User, error) {
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, ErrUserNotFound
+	}
+
+	coll := usersCollection()
+	if coll == nil {
+		mockUsersMu.Lock()
+		defer mockUsersMu.Unlock()
+		for _, u := range mockUsers {
+			if u.ID == objID {
+				found := u
+				return &found, nil
+			}
+		}
+		return nil, ErrUserNotFound
+	}
+
+	var u User
+	err = coll.FindOne(context.TODO(), bson.M{"_id": objID}).Decode(&u)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrUserNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+
+// ListUsers returns every stored user (hashes included, callers must strip them)
+func ListUsers() ([]User, error) {
+	coll := usersCollection()
+	if coll == nil {
+		mockUsersMu.Lock()
+		defer mockUsersMu.Unlock()
+		return append([]User{}, mockUsers...), nil
+	}
+
+	var users []User
+	cursor, err := coll.Find(context.TODO(), bson.M{})
+	if err != nil {
+		return nil, err
+	}
+	if err := cursor.All(context.TODO(), &users); err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+// CreateUser hashes the password and stores a new user
+func CreateUser(u User, password string) (User, error) {
+	if u.Username == "" {
+		return User{}, errors.New("username required")
+	}
+	if !ValidRole(u.Role) {
+		return User{}, ErrInvalidRole
+	}
+	hash, err := HashPassword(password)
+	if err != nil {
+		return User{}, err
+	}
+	if _, err := FindUser(u.Username); err == nil {
+		return User{}, ErrUserExists
+	}
+
+	u.ID = primitive.NewObjectID()
+	u.Password = hash
+	u.CreatedAt = time.Now()
+
+	coll := usersCollection()
+	if coll == nil {
+		mockUsersMu.Lock()
+		defer mockUsersMu.Unlock()
+		mockUsers = append(mockUsers, u)
+		return u, saveMockUsers()
+	}
+
+	if _, err := coll.InsertOne(context.TODO(), u); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return User{}, ErrUserExists
+		}
+		return User{}, err
+	}
+	return u, nil
+}
+
+// saveUser replaces a stored user (matched by ID)
+func saveUser(u User) error {
+	coll := usersCollection()
+	if coll == nil {
+		mockUsersMu.Lock()
+		defer mockUsersMu.Unlock()
+		for i := range mockUsers {
+			if mockUsers[i].ID == u.ID {
+				mockUsers[i] = u
+				return saveMockUsers()
+			}
+		}
+		return ErrUserNotFound
+	}
+
+	res, err := coll.ReplaceOne(context.TODO(), bson.M{"_id": u.ID}, u)
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+// UserUpdate carries the optional fields an admin may change
+type UserUpdate struct {
+	FullName *string `json:"full_name"`
+	Role     *string `json:"role"`
+	Disabled *bool   `json:"disabled"`
+}
+
+// UpdateUser applies profile, role and disabled changes
+func UpdateUser(id string, upd UserUpdate) (*User, error) {
+	u, err := FindUserByID(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if upd.FullName != nil {
+		u.FullName = *upd.FullName
+	}
+	if upd.Role != nil {
+		if !ValidRole(*upd.Role) {
+			return nil, ErrInvalidRole
+		}
+		u.Role = *upd.Role
+	}
+	if upd.Disabled != nil {
+		u.Disabled = *upd.Disabled
+	}
+
+	// Demoting or disabling must not leave the system without an admin
+	if u.Role != RoleAdmin || u.Disabled {
+		if err := ensureOtherAdmin(u.ID); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := saveUser(*u); err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+// DeleteUser removes a user by ID
+func DeleteUser(id string) error {
+	u, err := FindUserByID(id)
+	if err != nil {
+		return err
+	}
+	if err := ensureOtherAdmin(u.ID); err != nil {
+		return err
+	}
+
+	coll := usersCollection()
+	if coll == nil {
+		mockUsersMu.Lock()
+		defer mockUsersMu.Unlock()
+		for i := range mockUsers {
+			if mockUsers[i].ID == u.ID {
+				mockUsers = append(mockUsers[:i], mockUsers[i+1:]...)
+				return saveMockUsers()
+			}
+		}
+		return ErrUserNotFound
+	}
+
+	_, err = coll.DeleteOne(context.TODO(), bson.M{"_id": u.ID})
+	return err
+}
+
+// SetPassword stores a new bcrypt hash for the user (admin reset)
+func SetPassword(id string, plain string) error {
+	u, err := FindUserByID(id)
+	if err != nil {
+		return err
+	}
+	hash, err := HashPassword(plain)
+	if err != nil {
+		return err
+	}
+	u.Password = hash
+	return saveUser(*u)
+}
+
+// ChangePassword verifies the current password before storing the new one
+func ChangePassword(username, current, next string) error {
+	u, err := FindUser(username)
+	if err != nil {
+		return err
+	}
+	if !u.CheckPassword(current) {
+		return ErrWrongPassword
+	}
+	return SetPassword(u.ID.Hex(), next)
+}
+
+// ensureOtherAdmin fails if no active admin would remain besides the given user
+func ensureOtherAdmin(except primitive.ObjectID) error {
+	users, err := ListUsers()
+	if err != nil {
+		return err
+	}
+	for _, u := range users {
+		if u.ID != except && u.Role == RoleAdmin && !u.Disabled {
+			return nil
+		}
+	}
+	return ErrLastAdmin
+}
@@ -0,0 +1,119 @@
+package driver
+
+import (
+	"encoding/hex"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// OLTs report ONU faults as text alarms (syslog, or the trap varbinds). The
+// wording differs per vendor and firmware, so matching is done on keywords
+// and the location is pulled out separately.
+
+const (
+	AlarmLOS       = "los"
+	AlarmDyingGasp = "dying_gasp"
+	AlarmLowPower  = "low_power"
+)
+
+// Alarm is one raise or clear of an ONU fault
+type Alarm struct {
+	Type    string // AlarmLOS, AlarmDyingGasp, AlarmLowPower
+	Cleared bool
+	Port    string // F/S/P
+	OnuID   int    // -1 when not reported
+	Serial  string // Empty when not reported
+}
+
+var (
+	alarmDyingGasp = regexp.MustCompile(`(?i)dying[- _]?gasp|\bDGi\b`)
+	alarmLOS       = regexp.MustCompile(`(?i)\bLOS[i]?\b|\bLOFi\b|loss of signal|loses its (?:GPON )?optical signal`)
+	alarmLowPower  = regexp.MustCompile(`(?i)(?:rx|receiv\w*|optical)\s+(?:optical\s+)?power\b.*\b(?:low|abnormal|exceeds?|below)|low (?:rx |optical )?power`)
+	alarmCleared   = regexp.MustCompile(`(?i)\b(?:recover(?:y|ed|s)?|clear(?:ed)?|restored?|disappear(?:ed|s)?)\b`)
+
+	// Huawei: "FrameID: 0, SlotID: 1, PortID: 3, ONT ID: 12"
+	huaweiAlarmLoc = regexp.MustCompile(`(?i)Frame\s*ID\s*[:=]\s*(\d+),?\s*Slot\s*ID\s*[:=]\s*(\d+),?\s*Port\s*ID\s*[:=]\s*(\d+)(?:,?\s*ONT\s*ID\s*[:=]\s*(\d+))?`)
+	// Huawei prints the SN in hex ("4857544312345678") and sometimes as "HWTC-12345678"
+	huaweiAlarmSN = regexp.MustCompile(`(?i)\bSN\s*[:=]\s*([0-9A-F]{16})\b|\b([A-Z]{4})-?([0-9A-F]{8})\b`)
+	// ZTE: "gpon-onu_1/2/3:4", "gpon_onu-1/2/3:4", "SN: ZTEGC1234567"
+	zteAlarmSN = regexp.MustCompile(`(?i)\b(?:SN|serial(?:[- ]number)?)\s*[:=]?\s*([A-Z]{4}[0-9A-F]{8})\b`)
+)
+
+// ParseAlarm recognizes an ONU alarm in an OLT message. vendor may be empty
+// when the sender is unknown; both formats are tried then.
+func ParseAlarm(vendor, msg string) (Alarm, bool) {
+	a := Alarm{OnuID: -1}
+	switch {
+	case alarmDyingGasp.MatchString(msg):
+		a.Type = AlarmDyingGasp
+	case alarmLOS.MatchString(msg):
+		a.Type = AlarmLOS
+	case alarmLowPower.MatchString(msg):
+		a.Type = AlarmLowPower
+	default:
+		return Alarm{}, false
+	}
+	a.Cleared = alarmCleared.MatchString(msg)
+
+	var located bool
+	switch strings.ToLower(vendor) {
+	case VendorHuawei:
+		located = a.locateHuawei(msg)
+	case VendorZTE:
+		located = a.locateZTE(msg)
+	default:
+		located = a.locateHuawei(msg) || a.locateZTE(msg)
+	}
+	if !located && a.Serial == "" {
+		// No way to tell which ONU it is about
+		return Alarm{}, false
+	}
+	return a, true
+}
+
+func (a *Alarm) locateHuawei(msg string) bool {
+	if m := huaweiAlarmSN.FindStringSubmatch(msg); m != nil {
+		if m[1] != "" {
+			a.Serial = hexSerial(m[1])
+		} else {
+			a.Serial = strings.ToUpper(m[2] + m[3])
+		}
+	}
+	m := huaweiAlarmLoc.FindStringSubmatch(msg)
+	if m == nil {
+		return false
+	}
+	a.Port = m[1] + "/" + m[2] + "/" + m[3]
+	if m[4] != "" {
+		a.OnuID, _ = strconv.Atoi(m[4])
+	}
+	return true
+}
+
+func (a *Alarm) locateZTE(msg string) bool {
+	if m := zteAlarmSN.FindStringSubmatch(msg); m != nil {
+		a.Serial = strings.ToUpper(m[1])
+	}
+	m := zteOnuRef.FindStringSubmatch(msg)
+	if m == nil {
+		return false
+	}
+	a.Port = m[1]
+	a.OnuID, _ = strconv.Atoi(m[2])
+	return true
+}
+
+// hexSerial turns "4857544312345678" into "HWTC12345678"
+func hexSerial(s string) string {
+	vendor, err := hex.DecodeString(s[:8])
+	if err != nil {
+		return strings.ToUpper(s)
+	}
+	for _, c := range vendor {
+		if c < 'A' || c > 'Z' {
+			return strings.ToUpper(s)
+		}
+	}
+	return string(vendor) + strings.ToUpper(s[8:])
+}
@@ -0,0 +1,278 @@
+package driver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"mikromon/internal/ssher"
+)
+
+// Vendor drivers hide the CLI differences between device families.
+// Every operation receives a Runner (an open session on the device) so the
+// same driver works over one-shot SSH exec channels or an interactive CLI.
+
+var ErrUnsupported = errors.New("operation not supported by this device driver")
+
+// Runner executes CLI commands on an open device session
+type Runner interface {
+	Run(cmd string) (string, error)
+	// RunConfirm is Run for commands that ask "are you sure? (y/n)"
+	RunConfirm(cmd string) (string, error)
+}
+
+// Session is a Runner bound to a connection that must be closed
+type Session interface {
+	Runner
+	Close() error
+}
+
+// Streamer is a Session that hands over output while the command runs.
+// Only exec channels stream; interactive CLIs answer once the prompt is back.
+type Streamer interface {
+	Stream(ctx context.Context, cmd string, onChunk func(ssher.Chunk)) error
+}
+
+// Target is how to reach a device
+type Target struct {
+	Host      string
+	Port      int
+	Username  string
+	Password  string // Sealed, decrypted inside ssher
+	UseSSHKey bool
+	Transport string // ssh (default) or telnet
+}
+
+// Interface is a network port as reported by the device
+type Interface struct {
+	Name    string `json:"name"`
+	Type    string `json:"type,omitempty"`
+	Status  string `json:"status"` // up, down, disabled
+	Comment string `json:"comment,omitempty"`
+}
+
+// OpticalReading is the optical state of one ONU as seen by the OLT
+type OpticalReading struct {
+	Port        string  `json:"port"` // F/S/P (Huawei 0/1/0, ZTE 1/2/1)
+	OnuID       int     `json:"onu_id"`
+	Serial      string  `json:"serial,omitempty"`
+	RxPower     float64 `json:"rx_power"`               // dBm, at the ONU
+	TxPower     float64 `json:"tx_power,omitempty"`     // dBm
+	OltRxPower  float64 `json:"olt_rx_power,omitempty"` // dBm, upstream at the OLT
+	Temperature float64 `json:"temperature,omitempty"`  // C
+	Voltage     float64 `json:"voltage,omitempty"`      // V
+}
+
+// PonPort is the occupation of one PON port
+type PonPort struct {
+	Port       string  `json:"port"`
+	Onus       int     `json:"onus"`   // Authorized ONUs
+	Online     int     `json:"online"` // ONUs currently up
+	Capacity   int     `json:"capacity"`
+	AvgRxPower float64 `json:"avg_rx_power"` // dBm over ONUs with a reading, 0 when none
+}
+
+// UnregisteredOnu is an ONU seen on a PON port but not yet authorized
+type UnregisteredOnu struct {
+	SerialNumber string  `json:"serial_number"`
+	PonPort      string  `json:"pon_port"`
+	Model        string  `json:"model,omitempty"`
+	Signal       float64 `json:"signal_dbm,omitempty"`
+}
+
+// BackupResult is either a file left on the device (to be fetched over SFTP)
+// or the backup content itself for devices that cannot store files for us.
+type BackupResult struct {
+	RemoteFile string
+	Content    []byte
+	Extension  string // .backup, .cfg
+}
+
+// Driver is implemented once per device family
+type Driver interface {
+	Name() string
+	// CLI returns the interactive session options, or nil when every command
+	// can run on its own SSH exec channel.
+	CLI() *ssher.CLIOptions
+
+	Backup(r Runner, name string) (BackupResult, error)
+	ExportConfig(r Runner, showSensitive bool) (string, error)
+	// Restore loads a backup or script already uploaded to the device
+	Restore(r Runner, file string) error
+	ListInterfaces(r Runner) ([]Interface, error)
+	OpticalPower(r Runner) ([]OpticalReading, error)
+	PonPorts(r Runner) ([]PonPort, error)
+	UnregisteredOnus(r Runner) ([]UnregisteredOnu, error)
+	Reboot(r Runner) error
+}
+
+// Vendors accepted in Device.Vendor
+const (
+	VendorMikrotik = "mikrotik"
+	VendorHuawei   = "huawei"
+	VendorZTE      = "zte"
+)
+
+// Transports accepted in Device.Transport
+const (
+	TransportSSH    = "ssh"
+	TransportTelnet = "telnet"
+)
+
+// DefaultPort is the port used when a device has none set
+func DefaultPort(transport string) int {
+	if strings.EqualFold(transport, TransportTelnet) {
+		return 23
+	}
+	return 22
+}
+
+// For picks the driver for a device. Vendor is preferred; when it is empty
+// routers and switches default to RouterOS and the model prefix is used for OLTs.
+func For(deviceType, vendor, model string) (Driver, error) {
+	vendor = strings.ToLower(strings.TrimSpace(vendor))
+	model = strings.ToUpper(strings.TrimSpace(model))
+
+	if vendor == "" {
+		switch {
+		case strings.HasPrefix(model, "MA56"), strings.HasPrefix(model, "MA58"), strings.HasPrefix(model, "EA5"):
+			vendor = VendorHuawei
+		case strings.HasPrefix(model, "C3"), strings.HasPrefix(model, "C6"):
+			vendor = VendorZTE
+		case strings.ToUpper(deviceType) != "OLT":
+			vendor = VendorMikrotik
+		}
+	}
+
+	switch vendor {
+	case VendorMikrotik, "routeros":
+		return RouterOS{}, nil
+	case VendorHuawei:
+		return Huawei{}, nil
+	case VendorZTE:
+		return ZTE{Model: model}, nil
+	case "":
+		return nil, fmt.Errorf("device vendor/model required to pick a driver for %s", deviceType)
+	}
+	return nil, fmt.Errorf("no driver for vendor %q", vendor)
+}
+
+// Connect opens a session suited to the driver on the pooled SSH connection
+func Connect(t Target, d Driver) (Session, error) {
+	if strings.EqualFold(t.Transport, TransportTelnet) {
+		opts := d.CLI()
+		if opts == nil {
+			return nil, fmt.Errorf("%s driver does not support telnet", d.Name())
+		}
+		return ssher.OpenTelnetCLI(t.Username, t.Password, t.Host, t.Port, *opts)
+	}
+
+	if t.Port == 0 {
+		t.Port = DefaultPort(t.Transport)
+	}
+	pool := ssher.GetPool()
+	if opts := d.CLI(); opts != nil {
+		return pool.OpenCLI(t.Username, t.Password, t.Host, t.Port, t.UseSSHKey, *opts)
+	}
+	return &execSession{pool: pool, t: t}, nil
+}
+
+// execSession runs each command on its own exec channel
+type execSession struct {
+	pool *ssher.Pool
+	t    Target
+}
+
+func (s *execSession) Run(cmd string) (string, error) {
+	return s.pool.RunCommand(s.t.Username, s.t.Password, s.t.Host, s.t.Port, s.t.UseSSHKey, cmd)
+}
+
+func (s *execSession) RunConfirm(cmd string) (string, error) {
+	return s.Run(cmd)
+}
+
+func (s *execSession) Stream(ctx context.Context, cmd string, onChunk func(ssher.Chunk)) error {
+	return s.pool.StreamCommand(ctx, s.t.Username, s.t.Password, s.t.Host, s.t.Port, s.t.UseSSHKey, cmd, onChunk)
+}
+
+func (s *execSession) Close() error { return nil }
+
+// GPON ports of both OLT families address up to 128 ONUs
+const gponCapacity = 128
+
+// ponOnu is the minimum an OLT driver knows about an authorized ONU
+type ponOnu struct {
+	Port   string
+	Online bool
+}
+
+// buildPonPorts lists every PON port of the OLT (ports) with its ONU count
+// and average RX reading. Ports that only show up in onus are added, so a
+// board the listing missed is still counted.
+func buildPonPorts(ports []string, onus []ponOnu, readings []OpticalReading) []PonPort {
+	byPort := map[string]*PonPort{}
+	var order []string
+	get := func(port string) *PonPort {
+		p, ok := byPort[port]
+		if !ok {
+			p = &PonPort{Port: port, Capacity: gponCapacity}
+			byPort[port] = p
+			order = append(order, port)
+		}
+		return p
+	}
+	for _, port := range ports {
+		get(port)
+	}
+	for _, o := range onus {
+		p := get(o.Port)
+		p.Onus++
+		if o.Online {
+			p.Online++
+		}
+	}
+
+	sums := map[string]float64{}
+	counts := map[string]int{}
+	for _, rd := range readings {
+		if rd.RxPower == 0 {
+			continue
+		}
+		sums[rd.Port] += rd.RxPower
+		counts[rd.Port]++
+	}
+
+	list := make([]PonPort, 0, len(order))
+	for _, port := range order {
+		p := byPort[port]
+		if n := counts[port]; n > 0 {
+			p.AvgRxPower = math.Round(sums[port]/float64(n)*100) / 100
+		}
+		list = append(list, *p)
+	}
+	sort.Slice(list, func(i, j int) bool { return portLess(list[i].Port, list[j].Port) })
+	return list
+}
+
+// portLess orders "0/1/10" after "0/1/2"
+func portLess(a, b string) bool {
+	pa, pb := strings.Split(a, "/"), strings.Split(b, "/")
+	for i := 0; i < len(pa) && i < len(pb); i++ {
+		na, errA := strconv.Atoi(pa[i])
+		nb, errB := strconv.Atoi(pb[i])
+		if errA != nil || errB != nil {
+			if pa[i] != pb[i] {
+				return pa[i] < pb[i]
+			}
+			continue
+		}
+		if na != nb {
+			return na < nb
+		}
+	}
+	return len(pa) < len(pb)
+}
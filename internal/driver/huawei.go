@@ -0,0 +1,559 @@
+package driver
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"mikromon/internal/ssher"
+)
+
+// Huawei drives MA56xx/MA58xx (and EA5800) GPON OLTs through the interactive CLI.
+// Ports are written F/S/P (frame/slot/port), e.g. 0/1/0.
+type Huawei struct{}
+
+func (Huawei) Name() string { return "huawei-ma5800" }
+
+func (Huawei) CLI() *ssher.CLIOptions {
+	return &ssher.CLIOptions{
+		// MA5800-X15>  MA5800-X15#  MA5800-X15(config)#  MA5800-X15(config-if-gpon-0/1)#
+		Prompt: regexp.MustCompile(`^[\w\-.]+(\([^)]*\))?[>#]\s*$`),
+		Pagers: map[string]string{
+			`-+\s*More\s*\(\s*Press 'Q' to break\s*\)\s*-+\s*$`: " ",
+			`\{\s*<cr>.*\}:\s*$`: "\n",
+		},
+		Confirm: regexp.MustCompile(`\(y/n\)\[n\]:\s*$`),
+		Init:    []string{"enable", "undo smart", "scroll 512", "config"},
+		Timeout: 60 * time.Second,
+	}
+}
+
+var huaweiFailure = regexp.MustCompile(`(?m)^\s*(Failure:.*|% (Unknown command|Parameter error|Incomplete command).*)$`)
+
+func huaweiCheck(out string, err error) (string, error) {
+	if err != nil {
+		return out, err
+	}
+	if m := huaweiFailure.FindString(out); m != "" {
+		return out, fmt.Errorf("huawei: %s", strings.TrimSpace(m))
+	}
+	return out, nil
+}
+
+// SplitPort turns "0/1/3" into frame/slot "0/1" and port "3"
+func SplitPort(fsp string) (string, string, error) {
+	parts := strings.Split(strings.ReplaceAll(fsp, " ", ""), "/")
+	if len(parts) != 3 {
+		return "", "", fmt.Errorf("invalid port %q, expected F/S/P", fsp)
+	}
+	return parts[0] + "/" + parts[1], parts[2], nil
+}
+
+func (Huawei) Backup(r Runner, name string) (BackupResult, error) {
+	out, err := huaweiCheck(r.Run("display current-configuration"))
+	if err != nil {
+		return BackupResult{}, err
+	}
+	return BackupResult{Content: []byte(out), Extension: ".cfg"}, nil
+}
+
+func (Huawei) ExportConfig(r Runner, showSensitive bool) (string, error) {
+	return huaweiCheck(r.Run("display current-configuration"))
+}
+
+func (Huawei) ListInterfaces(r Runner) ([]Interface, error) {
+	out, err := huaweiCheck(r.Run("display board 0"))
+	if err != nil {
+		return nil, err
+	}
+	// SlotID  BoardName  Status  SubType0 SubType1  Online/Offline
+	//   1     H901GPHF   Normal
+	row := regexp.MustCompile(`(?m)^\s*(\d+)\s+(\S+)\s+(\S+)`)
+	list := []Interface{}
+	for _, m := range row.FindAllStringSubmatch(out, -1) {
+		status := "down"
+		if strings.EqualFold(m[3], "Normal") || strings.HasPrefix(strings.ToLower(m[3]), "active") {
+			status = "up"
+		}
+		list = append(list, Interface{Name: "0/" + m[1], Type: m[2], Status: status})
+	}
+	return list, nil
+}
+
+// huaweiOnt is one row of "display ont info 0 all"
+type huaweiOnt struct {
+	Port     string
+	OntID    int
+	Serial   string
+	RunState string
+}
+
+var huaweiOntRow = regexp.MustCompile(`(?m)^\s*(\d+)/\s*(\d+)/\s*(\d+)\s+(\d+)\s+([0-9A-Fa-f]{16})\s+\S+\s+(\S+)`)
+
+func parseHuaweiOntInfo(out string) []huaweiOnt {
+	list := []huaweiOnt{}
+	for _, m := range huaweiOntRow.FindAllStringSubmatch(out, -1) {
+		id, _ := strconv.Atoi(m[4])
+		list = append(list, huaweiOnt{
+			Port:     m[1] + "/" + m[2] + "/" + m[3],
+			OntID:    id,
+			Serial:   strings.ToUpper(m[5]),
+			RunState: m[6],
+		})
+	}
+	return list
+}
+
+func (Huawei) onts(r Runner) ([]huaweiOnt, error) {
+	out, err := huaweiCheck(r.Run("display ont info 0 all"))
+	if err != nil {
+		return nil, err
+	}
+	return parseHuaweiOntInfo(out), nil
+}
+
+var huaweiOpticalRow = regexp.MustCompile(`(?m)^\s*(\d+)\s+(-?[\d.]+)\s+(-?[\d.]+)\s+(-?[\d.]+)\s+(-?[\d.]+)\s+(-?[\d.]+)`)
+
+// parseHuaweiOptical reads "display ont optical-info <port> all" inside interface gpon
+func parseHuaweiOptical(port, out string) []OpticalReading {
+	list := []OpticalReading{}
+	for _, m := range huaweiOpticalRow.FindAllStringSubmatch(out, -1) {
+		id, _ := strconv.Atoi(m[1])
+		f := make([]float64, 5)
+		for i := range f {
+			f[i], _ = strconv.ParseFloat(m[i+2], 64)
+		}
+		list = append(list, OpticalReading{
+			Port: port, OnuID: id,
+			RxPower: f[0], TxPower: f[1], OltRxPower: f[2], Temperature: f[3], Voltage: f[4],
+		})
+	}
+	return list
+}
+
+func (h Huawei) OpticalPower(r Runner) ([]OpticalReading, error) {
+	onts, err := h.onts(r)
+	if err != nil {
+		return nil, err
+	}
+	return h.optical(r, onts)
+}
+
+// optical reads the levels of every port that has ONTs
+func (Huawei) optical(r Runner, onts []huaweiOnt) ([]OpticalReading, error) {
+	serials := map[string]string{}
+	ports := map[string]bool{}
+	for _, o := range onts {
+		serials[fmt.Sprintf("%s:%d", o.Port, o.OntID)] = o.Serial
+		ports[o.Port] = true
+	}
+	portList := make([]string, 0, len(ports))
+	for p := range ports {
+		portList = append(portList, p)
+	}
+	sort.Strings(portList)
+
+	readings := []OpticalReading{}
+	for _, port := range portList {
+		fs, p, _ := SplitPort(port)
+		if _, err := huaweiCheck(r.Run("interface gpon " + fs)); err != nil {
+			return readings, err
+		}
+		out, err := huaweiCheck(r.Run("display ont optical-info " + p + " all"))
+		r.Run("quit")
+		if err != nil {
+			continue // Port without online ONTs answers with a failure
+		}
+		for _, rd := range parseHuaweiOptical(port, out) {
+			rd.Serial = serials[fmt.Sprintf("%s:%d", port, rd.OnuID)]
+			readings = append(readings, rd)
+		}
+	}
+	return readings, nil
+}
+
+// huaweiPonBoard matches the PON service boards in "display board 0":
+// H901GPHF, H805GPFD, H806GPBH (GPON), H901XGHD (XG-PON), H901XSHF (XGS-PON)
+var huaweiPonBoard = regexp.MustCompile(`(GP|XG|XS)[A-Z]{2}$`)
+
+// parseHuaweiPonSlots returns the slots holding a PON board
+func parseHuaweiPonSlots(out string) []string {
+	// Empty slots are a bare number, so rows must not run into the next line
+	row := regexp.MustCompile(`(?m)^[ \t]*(\d+)[ \t]+(\S+)`)
+	slots := []string{}
+	for _, m := range row.FindAllStringSubmatch(out, -1) {
+		if huaweiPonBoard.MatchString(strings.ToUpper(m[2])) {
+			slots = append(slots, m[1])
+		}
+	}
+	return slots
+}
+
+// parseHuaweiBoardPorts reads the port table of "display board 0/<slot>"
+//
+//	Port  Port   min-distance  max-distance  Optical-module
+//	      Type   (km)          (km)          status
+//	   0  GPON   0             20            In port
+func parseHuaweiBoardPorts(out string) []string {
+	row := regexp.MustCompile(`(?m)^\s*(\d+)\s+\S*PON\b`)
+	ports := []string{}
+	for _, m := range row.FindAllStringSubmatch(out, -1) {
+		ports = append(ports, m[1])
+	}
+	return ports
+}
+
+// ponPortList lists the PON ports of every PON board in frame 0
+func (Huawei) ponPortList(r Runner) ([]string, error) {
+	out, err := huaweiCheck(r.Run("display board 0"))
+	if err != nil {
+		return nil, err
+	}
+	ports := []string{}
+	for _, slot := range parseHuaweiPonSlots(out) {
+		out, err := huaweiCheck(r.Run("display board 0/" + slot))
+		if err != nil {
+			continue // Board not running; its ONTs still count below
+		}
+		for _, p := range parseHuaweiBoardPorts(out) {
+			ports = append(ports, "0/"+slot+"/"+p)
+		}
+	}
+	return ports, nil
+}
+
+func (h Huawei) PonPorts(r Runner) ([]PonPort, error) {
+	ports, err := h.ponPortList(r)
+	if err != nil {
+		return nil, err
+	}
+	onts, err := h.onts(r)
+	if err != nil {
+		return nil, err
+	}
+	readings, err := h.optical(r, onts)
+	if err != nil {
+		return nil, err
+	}
+	onus := make([]ponOnu, 0, len(onts))
+	for _, o := range onts {
+		onus = append(onus, ponOnu{Port: o.Port, Online: strings.EqualFold(o.RunState, "online")})
+	}
+	return buildPonPorts(ports, onus, readings), nil
+}
+
+// parseHuaweiAutofind reads the "display ont autofind all" key/value blocks
+func parseHuaweiAutofind(out string) []UnregisteredOnu {
+	list := []UnregisteredOnu{}
+	var cur *UnregisteredOnu
+	for _, line := range strings.Split(out, "\n") {
+		key, val, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		key, val = strings.TrimSpace(key), strings.TrimSpace(val)
+		switch key {
+		case "Number":
+			list = append(list, UnregisteredOnu{})
+			cur = &list[len(list)-1]
+		case "F/S/P":
+			if cur != nil {
+				cur.PonPort = strings.ReplaceAll(val, " ", "")
+			}
+		case "Ont SN":
+			if cur != nil {
+				// 485754431A2B3C4D (HWTC-1A2B3C4D)
+				cur.SerialNumber = strings.ToUpper(strings.Fields(val + " ")[0])
+			}
+		case "Ont EquipmentID":
+			if cur != nil {
+				cur.Model = val
+			}
+		}
+	}
+	return list
+}
+
+func (Huawei) UnregisteredOnus(r Runner) ([]UnregisteredOnu, error) {
+	out, err := r.Run("display ont autofind all")
+	if err != nil {
+		return nil, err
+	}
+	if strings.Contains(out, "does not exist") {
+		return []UnregisteredOnu{}, nil
+	}
+	return parseHuaweiAutofind(out), nil
+}
+
+func (Huawei) Restore(r Runner, file string) error {
+	return ErrUnsupported
+}
+
+func (Huawei) Reboot(r Runner) error {
+	_, err := huaweiCheck(r.RunConfirm("reboot system"))
+	return err
+}
+
+var (
+	huaweiOntAdded  = regexp.MustCompile(`ONTID\s*:\s*(\d+)`)
+	huaweiSvcPortID = regexp.MustCompile(`(?m)^\s*(\d+)\s+(\d+)\s+\S+\s+gpon\s`)
+)
+
+// inGpon runs cmds inside "interface gpon F/S" and always leaves it
+func (Huawei) inGpon(r Runner, fs string, cmds ...string) (string, error) {
+	if _, err := huaweiCheck(r.Run("interface gpon " + fs)); err != nil {
+		return "", err
+	}
+	defer r.Run("quit")
+	return runAll(r, huaweiCheck, cmds...)
+}
+
+// ontAddCommand adds the ONT of a on port p, under a.OnuID when it is set
+func ontAddCommand(a *OnuAuthorization, p string) string {
+	add := fmt.Sprintf("ont add %s sn-auth %s omci ont-lineprofile-name %s ont-srvprofile-name %s",
+		p, a.Serial, a.LineProfile, a.ServiceProfile)
+	if a.OnuID > 0 {
+		add = fmt.Sprintf("ont add %s %d sn-auth %s omci ont-lineprofile-name %s ont-srvprofile-name %s",
+			p, a.OnuID, a.Serial, a.LineProfile, a.ServiceProfile)
+	}
+	if a.Description != "" {
+		add += fmt.Sprintf(" desc %q", a.Description)
+	}
+	return add
+}
+
+func (h Huawei) AuthorizeSteps(a *OnuAuthorization) ([]Step, error) {
+	if err := a.Validate(); err != nil {
+		return nil, err
+	}
+	fs, p, err := SplitPort(a.Port)
+	if err != nil {
+		return nil, err
+	}
+
+	add := ontAddCommand(a, p)
+
+	return []Step{
+		{
+			Name: "ont add",
+			Do: func(r Runner) error {
+				out, err := h.inGpon(r, fs, add)
+				if err != nil {
+					return err
+				}
+				m := huaweiOntAdded.FindStringSubmatch(out)
+				if m == nil {
+					return fmt.Errorf("ONT ID not found in: %s", strings.TrimSpace(out))
+				}
+				a.OnuID, _ = strconv.Atoi(m[1])
+				return nil
+			},
+			Undo: func(r Runner) error {
+				_, err := h.inGpon(r, fs, fmt.Sprintf("ont delete %s %d", p, a.OnuID))
+				return err
+			},
+		},
+		{
+			Name: "native vlan",
+			Do: func(r Runner) error {
+				_, err := h.inGpon(r, fs, fmt.Sprintf("ont port native-vlan %s %d eth 1 vlan %d priority 0", p, a.OnuID, a.UserVLAN))
+				return err
+			},
+		},
+		{
+			Name: "service-port",
+			Do: func(r Runner) error {
+				_, err := runAll(r, huaweiCheck, fmt.Sprintf(
+					"service-port vlan %d gpon %s ont %d gemport %d multi-service user-vlan %d tag-transform translate",
+					a.VLAN, a.Port, a.OnuID, a.GemPort, a.UserVLAN))
+				if err != nil {
+					return err
+				}
+				out, err := huaweiCheck(r.Run(fmt.Sprintf("display service-port port %s ont %d", a.Port, a.OnuID)))
+				if err != nil {
+					return err
+				}
+				for _, m := range huaweiSvcPortID.FindAllStringSubmatch(out, -1) {
+					if m[2] == strconv.Itoa(a.VLAN) {
+						a.ServicePort = m[1]
+						return nil
+					}
+				}
+				return fmt.Errorf("service-port created but its index was not found")
+			},
+			Undo: func(r Runner) error {
+				if a.ServicePort == "" {
+					return nil
+				}
+				_, err := huaweiCheck(r.Run("undo service-port " + a.ServicePort))
+				return err
+			},
+		},
+	}, nil
+}
+
+// huaweiServicePort is one row of "display service-port port F/S/P ont N"
+//
+//	INDEX VLAN VLAN   PORT F/ S/ P VPI  VCI  FLOW  FLOW  RX  TX  STATE
+//	      ID   ATTR   TYPE                   TYPE  PARA
+//	   12  100 common gpon 0/1 /0  3    1    vlan  100   6   6   up
+type huaweiServicePort struct {
+	Index    string
+	VLAN     string
+	GemPort  string // VCI
+	FlowType string
+	FlowPara string // User VLAN when FlowType is vlan
+	RxTable  string // Traffic table indexes, "-" when none
+	TxTable  string
+}
+
+var huaweiSvcPortRow = regexp.MustCompile(`(?m)^\s*(\d+)\s+(\d+)\s+\S+\s+gpon\s+\d+\s*/\s*\d+\s*/\s*\d+\s+\d+\s+(\d+)\s+(\S+)\s+(\S+)\s+(\S+)\s+(\S+)`)
+
+func parseHuaweiServicePorts(out string) []huaweiServicePort {
+	list := []huaweiServicePort{}
+	for _, m := range huaweiSvcPortRow.FindAllStringSubmatch(out, -1) {
+		list = append(list, huaweiServicePort{
+			Index: m[1], VLAN: m[2], GemPort: m[3],
+			FlowType: m[4], FlowPara: m[5], RxTable: m[6], TxTable: m[7],
+		})
+	}
+	return list
+}
+
+// command recreates the service-port under its old index
+func (s huaweiServicePort) command(port string, onuID int) string {
+	cmd := fmt.Sprintf("service-port %s vlan %s gpon %s ont %d gemport %s", s.Index, s.VLAN, port, onuID, s.GemPort)
+	if strings.EqualFold(s.FlowType, "vlan") {
+		userVLAN := s.FlowPara
+		if strings.HasPrefix(strings.ToLower(userVLAN), "untag") {
+			userVLAN = "untagged"
+		}
+		cmd += " multi-service user-vlan " + userVLAN + " tag-transform translate"
+	}
+	if _, err := strconv.Atoi(s.RxTable); err == nil {
+		if _, err := strconv.Atoi(s.TxTable); err == nil {
+			cmd += " inbound traffic-table index " + s.RxTable + " outbound traffic-table index " + s.TxTable
+		}
+	}
+	return cmd
+}
+
+// servicePorts lists the service-ports bound to an ONT
+func (Huawei) servicePorts(r Runner, port string, onuID int) ([]huaweiServicePort, error) {
+	out, err := huaweiCheck(r.Run(fmt.Sprintf("display service-port port %s ont %d", port, onuID)))
+	if err != nil {
+		return nil, err
+	}
+	// Removing one that cannot be read back would make it unrecoverable
+	list := parseHuaweiServicePorts(out)
+	if n := len(huaweiSvcPortID.FindAllString(out, -1)); n != len(list) {
+		return nil, fmt.Errorf("could read only %d of %d service-ports of ONT %d on %s", len(list), n, onuID, port)
+	}
+	return list, nil
+}
+
+func (h Huawei) DeprovisionSteps(a *OnuAuthorization) ([]Step, error) {
+	if a.OnuID <= 0 {
+		return nil, fmt.Errorf("%w: onu_id is required", ErrInvalidAuthorization)
+	}
+	fs, p, err := SplitPort(a.Port)
+	if err != nil {
+		return nil, err
+	}
+
+	// The OLT refuses to delete an ONT that still has service-ports. Each one
+	// is read before removal so undo rebuilds them as they were, once the
+	// ONT is back under its old ID.
+	var removed []huaweiServicePort
+	return []Step{
+		{
+			Name: "undo service-port",
+			Do: func(r Runner) error {
+				list, err := h.servicePorts(r, a.Port, a.OnuID)
+				if err != nil {
+					return err
+				}
+				for _, sp := range list {
+					if _, err := huaweiCheck(r.Run("undo service-port " + sp.Index)); err != nil {
+						return err
+					}
+					removed = append(removed, sp)
+				}
+				return nil
+			},
+			Undo: func(r Runner) error {
+				cmds := make([]string, 0, len(removed))
+				for _, sp := range removed {
+					cmds = append(cmds, sp.command(a.Port, a.OnuID))
+				}
+				_, err := runAll(r, huaweiCheck, cmds...)
+				return err
+			},
+		},
+		{
+			Name: "ont delete",
+			Do: func(r Runner) error {
+				_, err := h.inGpon(r, fs, fmt.Sprintf("ont delete %s %d", p, a.OnuID))
+				return err
+			},
+			Undo: func(r Runner) error {
+				if a.Serial == "" || a.LineProfile == "" || a.ServiceProfile == "" {
+					return fmt.Errorf("cannot add ONT %d back on %s: serial and profiles unknown", a.OnuID, a.Port)
+				}
+				userVLAN := a.UserVLAN
+				if userVLAN == 0 {
+					userVLAN = a.VLAN
+				}
+				cmds := []string{ontAddCommand(a, p)}
+				if userVLAN > 0 {
+					cmds = append(cmds, fmt.Sprintf("ont port native-vlan %s %d eth 1 vlan %d priority 0", p, a.OnuID, userVLAN))
+				}
+				_, err := h.inGpon(r, fs, cmds...)
+				return err
+			},
+		},
+	}, nil
+}
+
+func (h Huawei) ReplaceSerialSteps(a *OnuAuthorization, serial string) ([]Step, error) {
+	if a.OnuID <= 0 || serial == "" {
+		return nil, fmt.Errorf("%w: onu_id and serial_number are required", ErrInvalidAuthorization)
+	}
+	fs, p, err := SplitPort(a.Port)
+	if err != nil {
+		return nil, err
+	}
+	old := a.Serial
+
+	return []Step{{
+		Name: "ont modify sn",
+		Do: func(r Runner) error {
+			if _, err := h.inGpon(r, fs, fmt.Sprintf("ont modify %s %d sn %s", p, a.OnuID, serial)); err != nil {
+				return err
+			}
+			a.Serial = serial
+			return nil
+		},
+		Undo: func(r Runner) error {
+			_, err := h.inGpon(r, fs, fmt.Sprintf("ont modify %s %d sn %s", p, a.OnuID, old))
+			a.Serial = old
+			return err
+		},
+	}}, nil
+}
+
+func (Huawei) RebootOnu(r Runner, port string, onuID int) error {
+	fs, p, err := SplitPort(port)
+	if err != nil {
+		return err
+	}
+	if _, err := huaweiCheck(r.Run("interface gpon " + fs)); err != nil {
+		return err
+	}
+	defer r.Run("quit")
+	_, err = huaweiCheck(r.RunConfirm(fmt.Sprintf("ont reset %s %d", p, onuID)))
+	return err
+}
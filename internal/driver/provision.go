@@ -0,0 +1,146 @@
+package driver
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ONU provisioning is a list of steps. Each step knows how to undo itself so
+// a failure halfway leaves the OLT as it was.
+
+// OnuAuthorization describes the ONU to authorize
+type OnuAuthorization struct {
+	Port           string `json:"pon_port"`        // F/S/P
+	Serial         string `json:"serial_number"`   // As reported by discovery
+	OnuID          int    `json:"onu_id"`          // 0 lets the OLT (Huawei) or the driver (ZTE) pick
+	OnuType        string `json:"onu_type"`        // ZTE only (e.g. ZTE-F660)
+	Description    string `json:"description"`     // Usually the customer reference
+	LineProfile    string `json:"line_profile"`    // Huawei ont-lineprofile / ZTE tcont profile
+	ServiceProfile string `json:"service_profile"` // Huawei ont-srvprofile / ZTE pon-onu-mng service
+	VLAN           int    `json:"vlan"`            // Service VLAN
+	UserVLAN       int    `json:"user_vlan"`       // 0 = same as VLAN
+	GemPort        int    `json:"gemport"`         // 0 = 1
+	ServicePort    string `json:"service_port"`    // Filled in: service-port index (Huawei) or number (ZTE)
+}
+
+// Step is one change on the device. Undo is nil when the step leaves nothing behind.
+type Step struct {
+	Name string
+	Do   func(r Runner) error
+	Undo func(r Runner) error
+}
+
+// StepError tells which step failed and how the rollback went
+type StepError struct {
+	Step        string
+	Err         error
+	RolledBack  []string
+	UndoFailure []string
+}
+
+func (e *StepError) Error() string {
+	return fmt.Sprintf("step %q failed: %v", e.Step, e.Err)
+}
+
+func (e *StepError) Unwrap() error { return e.Err }
+
+// Provisioner is implemented by OLT drivers
+type Provisioner interface {
+	// AuthorizeSteps plans the authorization; the steps fill OnuID and
+	// ServicePort in a as they run.
+	AuthorizeSteps(a *OnuAuthorization) ([]Step, error)
+	// DeprovisionSteps removes an authorized ONU and its service
+	DeprovisionSteps(a *OnuAuthorization) ([]Step, error)
+	// ReplaceSerialSteps binds an authorized ONU to a new serial, keeping its config
+	ReplaceSerialSteps(a *OnuAuthorization, serial string) ([]Step, error)
+	// RebootOnu restarts one ONU
+	RebootOnu(r Runner, port string, onuID int) error
+}
+
+// MoveSteps moves an ONU to another port: it is removed from the old one and
+// authorized again on the new one with the same config. If the new port
+// fails, the removal is undone step by step, which puts the ONU back where it
+// was with all its service-ports.
+func MoveSteps(p Provisioner, from, to *OnuAuthorization) ([]Step, error) {
+	// Undoing the removal needs the full config of the ONU
+	if err := from.Validate(); err != nil {
+		return nil, err
+	}
+	remove, err := p.DeprovisionSteps(from)
+	if err != nil {
+		return nil, err
+	}
+	add, err := p.AuthorizeSteps(to)
+	if err != nil {
+		return nil, err
+	}
+
+	steps := []Step{}
+	for _, s := range remove {
+		s.Name += " on " + from.Port
+		steps = append(steps, s)
+	}
+	for _, s := range add {
+		s.Name += " on " + to.Port
+		steps = append(steps, s)
+	}
+	return steps, nil
+}
+
+var ErrInvalidAuthorization = errors.New("invalid ONU authorization")
+
+func (a *OnuAuthorization) Validate() error {
+	if a.Port == "" || a.Serial == "" {
+		return fmt.Errorf("%w: pon_port and serial_number are required", ErrInvalidAuthorization)
+	}
+	if a.LineProfile == "" || a.ServiceProfile == "" {
+		return fmt.Errorf("%w: line_profile and service_profile are required", ErrInvalidAuthorization)
+	}
+	if a.VLAN < 1 || a.VLAN > 4094 {
+		return fmt.Errorf("%w: vlan must be 1-4094", ErrInvalidAuthorization)
+	}
+	if a.UserVLAN == 0 {
+		a.UserVLAN = a.VLAN
+	}
+	if a.GemPort == 0 {
+		a.GemPort = 1
+	}
+	return nil
+}
+
+// RunSteps applies steps in order. When one fails, the ones already applied
+// are undone in reverse order and a *StepError is returned.
+func RunSteps(r Runner, steps []Step) ([]string, error) {
+	applied := []string{}
+	for i, s := range steps {
+		if err := s.Do(r); err != nil {
+			stepErr := &StepError{Step: s.Name, Err: err}
+			for j := i - 1; j >= 0; j-- {
+				if steps[j].Undo == nil {
+					continue
+				}
+				if uerr := steps[j].Undo(r); uerr != nil {
+					stepErr.UndoFailure = append(stepErr.UndoFailure, fmt.Sprintf("%s: %v", steps[j].Name, uerr))
+				} else {
+					stepErr.RolledBack = append(stepErr.RolledBack, steps[j].Name)
+				}
+			}
+			return applied, stepErr
+		}
+		applied = append(applied, s.Name)
+	}
+	return applied, nil
+}
+
+// runAll runs commands in sequence with a vendor error check, stopping at the first failure
+func runAll(r Runner, check func(string, error) (string, error), cmds ...string) (string, error) {
+	var out string
+	for _, cmd := range cmds {
+		o, err := check(r.Run(cmd))
+		out += o
+		if err != nil {
+			return out, fmt.Errorf("%s: %w", cmd, err)
+		}
+	}
+	return out, nil
+}
@@ -0,0 +1,152 @@
+package driver
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"mikromon/internal/ssher"
+)
+
+// RouterOS drives MikroTik routers and switches (v6 and v7) over SSH exec.
+type RouterOS struct{}
+
+func (RouterOS) Name() string { return "routeros" }
+
+func (RouterOS) CLI() *ssher.CLIOptions { return nil }
+
+// RouterOS prints errors on stdout with exit status 0
+var rosError = regexp.MustCompile(`(?m)^(failure:|bad command name|syntax error|expected end of command|input does not match any value).*`)
+
+func rosCheck(out string, err error) (string, error) {
+	if err != nil {
+		return out, err
+	}
+	if m := rosError.FindString(out); m != "" {
+		return out, fmt.Errorf("routeros: %s", strings.TrimSpace(m))
+	}
+	return out, nil
+}
+
+func (RouterOS) Backup(r Runner, name string) (BackupResult, error) {
+	name = strings.TrimSuffix(name, ".backup")
+	_, err := rosCheck(r.Run(fmt.Sprintf("/system backup save name=%s dont-encrypt=yes", name)))
+	if err != nil {
+		return BackupResult{}, err
+	}
+	return BackupResult{RemoteFile: name + ".backup", Extension: ".backup"}, nil
+}
+
+func (RouterOS) ExportConfig(r Runner, showSensitive bool) (string, error) {
+	cmd := "/export"
+	if showSensitive {
+		cmd += " show-sensitive"
+	}
+	out, err := rosCheck(r.Run(cmd))
+	if err != nil && strings.Contains(err.Error(), "show-sensitive") {
+		// RouterOS v6 has no show-sensitive (it shows secrets by default)
+		out, err = rosCheck(r.Run("/export"))
+	}
+	return strings.ReplaceAll(out, "\r", ""), err
+}
+
+// ParseTerse splits a "print terse" line into its flags and key=value pairs
+func ParseTerse(line string) (string, map[string]string) {
+	fields := map[string]string{}
+	flags := ""
+	rest := strings.TrimSpace(line)
+
+	// Leading item number and flags: " 0 R  name=ether1 ..."
+	if i := strings.IndexByte(rest, '='); i > 0 {
+		head := rest[:strings.LastIndexByte(rest[:i], ' ')+1]
+		for _, tok := range strings.Fields(head) {
+			if tok[0] < '0' || tok[0] > '9' {
+				flags += tok
+			}
+		}
+		rest = rest[len(head):]
+	}
+
+	// key=value, values may be quoted and contain spaces
+	for len(rest) > 0 {
+		rest = strings.TrimLeft(rest, " ")
+		eq := strings.IndexByte(rest, '=')
+		if eq < 0 {
+			break
+		}
+		key := rest[:eq]
+		rest = rest[eq+1:]
+		var val string
+		if strings.HasPrefix(rest, `"`) {
+			end := strings.Index(rest[1:], `"`)
+			if end < 0 {
+				end = len(rest) - 1
+			}
+			val = rest[1 : end+1]
+			rest = rest[min(end+2, len(rest)):]
+		} else {
+			end := strings.IndexByte(rest, ' ')
+			if end < 0 {
+				end = len(rest)
+			}
+			val = rest[:end]
+			rest = rest[end:]
+		}
+		fields[key] = val
+	}
+	return flags, fields
+}
+
+func (RouterOS) ListInterfaces(r Runner) ([]Interface, error) {
+	out, err := rosCheck(r.Run("/interface print terse without-paging"))
+	if err != nil {
+		return nil, err
+	}
+
+	list := []Interface{}
+	for _, line := range strings.Split(strings.ReplaceAll(out, "\r", ""), "\n") {
+		flags, f := ParseTerse(line)
+		if f["name"] == "" {
+			continue
+		}
+		status := "down"
+		switch {
+		case strings.Contains(flags, "X"):
+			status = "disabled"
+		case strings.Contains(flags, "R"):
+			status = "up"
+		}
+		list = append(list, Interface{Name: f["name"], Type: f["type"], Status: status, Comment: f["comment"]})
+	}
+	return list, nil
+}
+
+func (RouterOS) OpticalPower(r Runner) ([]OpticalReading, error) {
+	return nil, ErrUnsupported
+}
+
+func (RouterOS) PonPorts(r Runner) ([]PonPort, error) {
+	return nil, ErrUnsupported
+}
+
+func (RouterOS) UnregisteredOnus(r Runner) ([]UnregisteredOnu, error) {
+	return nil, ErrUnsupported
+}
+
+// Restore runs /import for .rsc scripts and /system backup load otherwise.
+// A backup load reboots the router, so it goes through :execute like Reboot.
+func (RouterOS) Restore(r Runner, file string) error {
+	if strings.HasSuffix(file, ".rsc") {
+		_, err := rosCheck(r.Run(fmt.Sprintf("/import file-name=%q", file)))
+		return err
+	}
+	_, err := rosCheck(r.Run(fmt.Sprintf(":execute {/system backup load name=%q password=\"\"}", file)))
+	return err
+}
+
+func (RouterOS) Reboot(r Runner) error {
+	// :execute runs in background, so the confirmation prompt is skipped and
+	// the session returns before the router goes down
+	_, err := rosCheck(r.Run(":execute {/system reboot}"))
+	return err
+}
@@ -0,0 +1,409 @@
+package driver
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"mikromon/internal/ssher"
+)
+
+// ZTE drives C3xx (C300/C320) and C6xx (C600/C620/C650) GPON OLTs.
+// Ports are written rack/shelf/slot style 1/2/1; the interface names differ:
+// C3xx "gpon-olt_1/2/1" / "gpon-onu_1/2/1:5", C6xx "gpon_olt-1/2/1" / "gpon_onu-1/2/1:5".
+type ZTE struct {
+	Model string
+}
+
+func (z ZTE) Name() string {
+	if z.isC6() {
+		return "zte-c600"
+	}
+	return "zte-c300"
+}
+
+func (z ZTE) isC6() bool {
+	return strings.HasPrefix(strings.ToUpper(z.Model), "C6")
+}
+
+// OltIf returns the PON interface name for a port
+func (z ZTE) OltIf(port string) string {
+	if z.isC6() {
+		return "gpon_olt-" + port
+	}
+	return "gpon-olt_" + port
+}
+
+// OnuIf returns the ONU interface name
+func (z ZTE) OnuIf(port string, onuID int) string {
+	if z.isC6() {
+		return fmt.Sprintf("gpon_onu-%s:%d", port, onuID)
+	}
+	return fmt.Sprintf("gpon-onu_%s:%d", port, onuID)
+}
+
+func (ZTE) CLI() *ssher.CLIOptions {
+	return &ssher.CLIOptions{
+		// ZXAN#  ZXAN(config)#  ZXAN(config-if)#
+		Prompt:  regexp.MustCompile(`^[\w\-.]+(\([^)]*\))?#\s*$`),
+		Pagers:  map[string]string{`--More--\s*$`: " "},
+		Confirm: regexp.MustCompile(`(\[yes/no\]|\(y/n\)|\[y/n\])\s*:?\s*$`),
+		Init:    []string{"terminal length 0"},
+		Timeout: 60 * time.Second,
+	}
+}
+
+var zteFailure = regexp.MustCompile(`(?m)^\s*(%\s*(Invalid|Unknown|Incomplete|Ambiguous).*|%Code \d+:.*|Error\s*[:\d].*)$`)
+
+func zteCheck(out string, err error) (string, error) {
+	if err != nil {
+		return out, err
+	}
+	if m := zteFailure.FindString(out); m != "" {
+		return out, fmt.Errorf("zte: %s", strings.TrimSpace(m))
+	}
+	return out, nil
+}
+
+// zteOnuRef matches gpon-onu_1/2/1:5, gpon_onu-1/2/1:5 or a bare 1/2/1:5
+var zteOnuRef = regexp.MustCompile(`(?:gpon[-_]onu[-_])?(\d+/\d+/\d+):(\d+)`)
+
+func (ZTE) Backup(r Runner, name string) (BackupResult, error) {
+	out, err := zteCheck(r.Run("show running-config"))
+	if err != nil {
+		return BackupResult{}, err
+	}
+	return BackupResult{Content: []byte(out), Extension: ".cfg"}, nil
+}
+
+func (ZTE) ExportConfig(r Runner, showSensitive bool) (string, error) {
+	return zteCheck(r.Run("show running-config"))
+}
+
+func (ZTE) ListInterfaces(r Runner) ([]Interface, error) {
+	out, err := zteCheck(r.Run("show card"))
+	if err != nil {
+		return nil, err
+	}
+	// Rack Shelf Slot CfgType RealType Port HardVer SoftVer Status
+	//  1    1    3    GTGO    GTGO     8    ...              INSERVICE
+	list := []Interface{}
+	for _, m := range zteCardRow.FindAllStringSubmatch(out, -1) {
+		status := "down"
+		if m[7] == "INSERVICE" || m[7] == "STANDBY" {
+			status = "up"
+		}
+		list = append(list, Interface{Name: m[1] + "/" + m[2] + "/" + m[3], Type: m[4], Status: status})
+	}
+	return list, nil
+}
+
+// zteOnu is one authorized ONU from "show gpon onu baseinfo"
+type zteOnu struct {
+	Port   string
+	OnuID  int
+	Type   string
+	Serial string
+	State  string
+}
+
+func parseZTEBaseinfo(out string) []zteOnu {
+	// gpon-onu_1/2/1:1  ZTE-F660  sn  SN:ZTEGC0000001  ready
+	row := regexp.MustCompile(`(?m)^\s*\S*?(\d+/\d+/\d+):(\d+)\s+(\S+)\s+\S+\s+SN:(\S+)\s+(\S+)`)
+	list := []zteOnu{}
+	for _, m := range row.FindAllStringSubmatch(out, -1) {
+		id, _ := strconv.Atoi(m[2])
+		list = append(list, zteOnu{Port: m[1], OnuID: id, Type: m[3], Serial: strings.ToUpper(m[4]), State: m[5]})
+	}
+	return list
+}
+
+func (z ZTE) onus(r Runner) ([]zteOnu, error) {
+	out, err := zteCheck(r.Run("show gpon onu baseinfo"))
+	if err != nil {
+		return nil, err
+	}
+	return parseZTEBaseinfo(out), nil
+}
+
+var zteRxRow = regexp.MustCompile(`(?m)^\s*\S*?(\d+/\d+/\d+):(\d+)\s+(-?[\d.]+)\s*\(?dbm\)?`)
+
+func (z ZTE) OpticalPower(r Runner) ([]OpticalReading, error) {
+	onus, err := z.onus(r)
+	if err != nil {
+		return nil, err
+	}
+
+	serials := map[string]string{}
+	var ports []string
+	seen := map[string]bool{}
+	for _, o := range onus {
+		serials[fmt.Sprintf("%s:%d", o.Port, o.OnuID)] = o.Serial
+		if !seen[o.Port] {
+			seen[o.Port] = true
+			ports = append(ports, o.Port)
+		}
+	}
+
+	readings := []OpticalReading{}
+	for _, port := range ports {
+		// Onu                 Rx power
+		// gpon-onu_1/2/1:1    -21.325(dbm)
+		out, err := zteCheck(r.Run("show pon power onu-rx " + z.OltIf(port)))
+		if err != nil {
+			continue
+		}
+		for _, m := range zteRxRow.FindAllStringSubmatch(strings.ToLower(out), -1) {
+			id, _ := strconv.Atoi(m[2])
+			rx, _ := strconv.ParseFloat(m[3], 64)
+			readings = append(readings, OpticalReading{
+				Port: m[1], OnuID: id, RxPower: rx,
+				Serial: serials[fmt.Sprintf("%s:%d", m[1], id)],
+			})
+		}
+	}
+	return readings, nil
+}
+
+// parseZTEState reads "show gpon onu state"
+//
+//	OnuIndex   Admin State  OMCC State  Phase State  Channel
+//	1/2/1:1    enable       enable      working      1(GPON)
+func parseZTEState(out string) []ponOnu {
+	row := regexp.MustCompile(`(?m)^\s*\S*?(\d+/\d+/\d+):\d+\s+\S+\s+\S+\s+(\S+)`)
+	list := []ponOnu{}
+	for _, m := range row.FindAllStringSubmatch(out, -1) {
+		list = append(list, ponOnu{Port: m[1], Online: strings.EqualFold(m[2], "working")})
+	}
+	return list
+}
+
+// zteCardRow is a card of "show card" (the port count is empty on some cards)
+var zteCardRow = regexp.MustCompile(`(?m)^\s*(\d+)\s+(\d+)\s+(\d+)\s+(\S+)\s+(\S+)\s+(\d+)?.*?(INSERVICE|OFFLINE|STANDBY|HWONLINE|CONFIGING|TYPEMISMATCH)\s*$`)
+
+// zteGponCard matches GPON line cards: GTGO, GTGH, GTGQ (C3xx), GFGH, GFGL (C6xx)
+var zteGponCard = regexp.MustCompile(`^G[TF]G`)
+
+// parseZTEPonPorts lists shelf/slot/port for every port of the GPON cards
+//
+//	Rack Shelf Slot CfgType RealType Port HardVer SoftVer Status
+//	 1    1    2    GTGH    GTGH     16   ...              INSERVICE
+func parseZTEPonPorts(out string) []string {
+	ports := []string{}
+	for _, m := range zteCardRow.FindAllStringSubmatch(out, -1) {
+		if !zteGponCard.MatchString(strings.ToUpper(m[4])) {
+			continue
+		}
+		n, _ := strconv.Atoi(m[6])
+		for p := 1; p <= n; p++ {
+			ports = append(ports, fmt.Sprintf("%s/%s/%d", m[2], m[3], p))
+		}
+	}
+	return ports
+}
+
+func (z ZTE) PonPorts(r Runner) ([]PonPort, error) {
+	cards, err := zteCheck(r.Run("show card"))
+	if err != nil {
+		return nil, err
+	}
+	out, err := zteCheck(r.Run("show gpon onu state"))
+	if err != nil {
+		return nil, err
+	}
+	onus := parseZTEState(out)
+	readings, err := z.OpticalPower(r)
+	if err != nil {
+		return nil, err
+	}
+	return buildPonPorts(parseZTEPonPorts(cards), onus, readings), nil
+}
+
+func parseZTEUncfg(out string) []UnregisteredOnu {
+	// gpon-onu_1/2/1:1   ZTEGC8A3B2C1   unknown
+	// C6xx: 1/2/1  1  ZTEGC8A3B2C1 ...
+	row := regexp.MustCompile(`(?m)^\s*\S*?(\d+/\d+/\d+)(?::\d+)?\s+(?:\d+\s+)?(?:SN:)?([A-Za-z]{4}[0-9A-Fa-f]{8})\b`)
+	list := []UnregisteredOnu{}
+	for _, m := range row.FindAllStringSubmatch(out, -1) {
+		list = append(list, UnregisteredOnu{PonPort: m[1], SerialNumber: strings.ToUpper(m[2])})
+	}
+	return list
+}
+
+func (ZTE) UnregisteredOnus(r Runner) ([]UnregisteredOnu, error) {
+	out, err := r.Run("show gpon onu uncfg")
+	if err != nil {
+		return nil, err
+	}
+	if strings.Contains(out, "No related information") {
+		return []UnregisteredOnu{}, nil
+	}
+	return parseZTEUncfg(out), nil
+}
+
+func (ZTE) Restore(r Runner, file string) error {
+	return ErrUnsupported
+}
+
+func (ZTE) Reboot(r Runner) error {
+	_, err := zteCheck(r.RunConfirm("reboot"))
+	return err
+}
+
+// zteConfig runs cmds in configuration mode and always goes back to enable mode
+func zteConfig(r Runner, cmds ...string) error {
+	if _, err := zteCheck(r.Run("configure terminal")); err != nil {
+		return err
+	}
+	defer r.Run("end")
+	_, err := runAll(r, zteCheck, cmds...)
+	return err
+}
+
+// freeOnuID returns the lowest ONU ID not used on a port
+func (z ZTE) freeOnuID(r Runner, port string) (int, error) {
+	out, err := zteCheck(r.Run("show gpon onu state " + z.OltIf(port)))
+	if err != nil {
+		return 0, err
+	}
+	used := map[int]bool{}
+	for _, m := range zteOnuRef.FindAllStringSubmatch(out, -1) {
+		if m[1] == port {
+			id, _ := strconv.Atoi(m[2])
+			used[id] = true
+		}
+	}
+	for id := 1; id <= gponCapacity; id++ {
+		if !used[id] {
+			return id, nil
+		}
+	}
+	return 0, fmt.Errorf("no free ONU ID on %s", port)
+}
+
+func (z ZTE) AuthorizeSteps(a *OnuAuthorization) ([]Step, error) {
+	if err := a.Validate(); err != nil {
+		return nil, err
+	}
+	if a.OnuType == "" {
+		return nil, fmt.Errorf("%w: onu_type is required on ZTE", ErrInvalidAuthorization)
+	}
+	name := a.Description
+	if name == "" {
+		name = a.Serial
+	}
+
+	return []Step{
+		{
+			Name: "onu register",
+			Do: func(r Runner) error {
+				if a.OnuID == 0 {
+					id, err := z.freeOnuID(r, a.Port)
+					if err != nil {
+						return err
+					}
+					a.OnuID = id
+				}
+				return zteConfig(r, "interface "+z.OltIf(a.Port),
+					fmt.Sprintf("onu %d type %s sn %s", a.OnuID, a.OnuType, a.Serial))
+			},
+			Undo: func(r Runner) error {
+				return zteConfig(r, "interface "+z.OltIf(a.Port), fmt.Sprintf("no onu %d", a.OnuID))
+			},
+		},
+		{
+			Name: "tcont and service-port",
+			Do: func(r Runner) error {
+				onuIf := z.OnuIf(a.Port, a.OnuID)
+				tcont := []string{
+					"interface " + onuIf,
+					fmt.Sprintf("name %s", strings.ReplaceAll(name, " ", "_")),
+					"tcont 1 profile " + a.LineProfile,
+					fmt.Sprintf("gemport %d tcont 1", a.GemPort),
+				}
+				if z.isC6() {
+					// C6xx moved service-ports to the vport interface
+					tcont = append(tcont, "exit",
+						fmt.Sprintf("interface vport-%s.%d:%d", a.Port, a.OnuID, a.GemPort),
+						fmt.Sprintf("service-port 1 user-vlan %d vlan %d", a.UserVLAN, a.VLAN))
+				} else {
+					tcont = append(tcont,
+						fmt.Sprintf("service-port 1 vport %d user-vlan %d vlan %d", a.GemPort, a.UserVLAN, a.VLAN))
+				}
+				if err := zteConfig(r, tcont...); err != nil {
+					return err
+				}
+				a.ServicePort = "1"
+				return nil
+			},
+			// Removed with the ONU
+		},
+		{
+			Name: "onu service",
+			Do: func(r Runner) error {
+				return zteConfig(r, "pon-onu-mng "+z.OnuIf(a.Port, a.OnuID),
+					fmt.Sprintf("service %s gemport %d vlan %d", a.ServiceProfile, a.GemPort, a.VLAN))
+			},
+		},
+	}, nil
+}
+
+func (z ZTE) DeprovisionSteps(a *OnuAuthorization) ([]Step, error) {
+	if a.OnuID <= 0 || a.Port == "" {
+		return nil, fmt.Errorf("%w: pon_port and onu_id are required", ErrInvalidAuthorization)
+	}
+	// "no onu" takes the tcont, gemport and service-ports with it; undo
+	// authorizes the ONU again under the same ID
+	return []Step{{
+		Name: "no onu",
+		Do: func(r Runner) error {
+			return zteConfig(r, "interface "+z.OltIf(a.Port), fmt.Sprintf("no onu %d", a.OnuID))
+		},
+		Undo: func(r Runner) error {
+			again := *a
+			steps, err := z.AuthorizeSteps(&again)
+			if err != nil {
+				return fmt.Errorf("cannot register ONU %d back on %s: %w", a.OnuID, a.Port, err)
+			}
+			_, err = RunSteps(r, steps)
+			return err
+		},
+	}}, nil
+}
+
+func (z ZTE) ReplaceSerialSteps(a *OnuAuthorization, serial string) ([]Step, error) {
+	if a.OnuID <= 0 || a.Port == "" || serial == "" {
+		return nil, fmt.Errorf("%w: pon_port, onu_id and serial_number are required", ErrInvalidAuthorization)
+	}
+	old := a.Serial
+	onuIf := z.OnuIf(a.Port, a.OnuID)
+
+	return []Step{{
+		Name: "registration-method sn",
+		Do: func(r Runner) error {
+			if err := zteConfig(r, "interface "+onuIf, "registration-method sn "+serial); err != nil {
+				return err
+			}
+			a.Serial = serial
+			return nil
+		},
+		Undo: func(r Runner) error {
+			a.Serial = old
+			return zteConfig(r, "interface "+onuIf, "registration-method sn "+old)
+		},
+	}}, nil
+}
+
+func (z ZTE) RebootOnu(r Runner, port string, onuID int) error {
+	if _, err := zteCheck(r.Run("configure terminal")); err != nil {
+		return err
+	}
+	defer r.Run("end")
+	if _, err := zteCheck(r.Run("pon-onu-mng " + z.OnuIf(port, onuID))); err != nil {
+		return err
+	}
+	_, err := zteCheck(r.RunConfirm("reboot"))
+	return err
+}
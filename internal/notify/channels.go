@@ -0,0 +1,264 @@
+package notify
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/http"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	sendTimeout     = 30 * time.Second
+	maxResponseBody = 4096 // Kept from error responses
+	userAgent       = "MikroMon-Notify/1.0"
+)
+
+var httpClient = &http.Client{Timeout: sendTimeout}
+
+// WebhookPayload is the JSON body POSTed to webhooks. The request carries
+//
+//	X-MikroMon-Event:     event type
+//	X-MikroMon-Delivery:  event ID (the same on retries)
+//	X-MikroMon-Timestamp: Unix seconds
+//	X-MikroMon-Signature: sha256=<hex HMAC-SHA256 of "<timestamp>.<body>">
+//
+// The signature is sent only when the channel has a secret.
+type WebhookPayload struct {
+	Event   Event  `json:"event"`
+	Subject string `json:"subject"`
+	Text    string `json:"text"`
+}
+
+// SignWebhook returns the X-MikroMon-Signature value of a body
+func SignWebhook(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func sendWebhook(ch Channel, e Event, subject, text string) error {
+	body, err := json.Marshal(WebhookPayload{Event: e, Subject: subject, Text: text})
+	if err != nil {
+		return permanent(err)
+	}
+	req, err := http.NewRequest(http.MethodPost, ch.URL, bytes.NewReader(body))
+	if err != nil {
+		return permanent(err)
+	}
+	for k, v := range ch.Headers {
+		req.Header.Set(k, v)
+	}
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", userAgent)
+	req.Header.Set("X-MikroMon-Event", e.Type)
+	req.Header.Set("X-MikroMon-Delivery", e.ID)
+	req.Header.Set("X-MikroMon-Timestamp", ts)
+	if ch.Secret != "" {
+		req.Header.Set("X-MikroMon-Signature", SignWebhook(ch.Secret, ts, body))
+	}
+	_, err = doHTTP(req)
+	return err
+}
+
+// doHTTP sends req and classifies failures: network errors, 429 and 5xx are
+// worth a retry, other non-2xx statuses are not
+func doHTTP(req *http.Request) ([]byte, error) {
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		// Drop the URL, it may carry a token
+		var ue *url.Error
+		if errors.As(err, &ue) {
+			err = ue.Err
+		}
+		return nil, fmt.Errorf("request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, maxResponseBody))
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return data, nil
+	}
+	err = fmt.Errorf("HTTP %d: %s", resp.StatusCode, strings.TrimSpace(string(data)))
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500 {
+		return data, err
+	}
+	return data, permanent(err)
+}
+
+// botResponse is the envelope of Telegram Bot API replies
+type botResponse struct {
+	OK          bool   `json:"ok"`
+	Description string `json:"description"`
+}
+
+func sendBot(ch Channel, chatID, subject, text string) error {
+	body, err := json.Marshal(map[string]interface{}{
+		"chat_id":                  chatID,
+		"text":                     subject + "\n\n" + text,
+		"disable_web_page_preview": true,
+	})
+	if err != nil {
+		return permanent(err)
+	}
+	req, err := http.NewRequest(http.MethodPost, ch.BotAPIURL+"/bot"+ch.BotToken+"/sendMessage", bytes.NewReader(body))
+	if err != nil {
+		return permanent(errors.New("invalid bot API URL"))
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", userAgent)
+
+	data, err := doHTTP(req)
+	var reply botResponse
+	if json.Unmarshal(data, &reply) == nil && reply.Description != "" && err != nil {
+		// Prefer the API's own explanation ("chat not found"...)
+		if isTemporary(err) {
+			return fmt.Errorf("bot API: %s", reply.Description)
+		}
+		return permanent(fmt.Errorf("bot API: %s", reply.Description))
+	}
+	if err != nil {
+		return err
+	}
+	if !reply.OK {
+		return permanent(errors.New("bot API did not accept the message"))
+	}
+	return nil
+}
+
+func sendEmail(ch Channel, to, subject, text string) error {
+	from, err := mail.ParseAddress(ch.From)
+	if err != nil {
+		return permanent(fmt.Errorf("invalid from address"))
+	}
+	rcpt, err := mail.ParseAddress(to)
+	if err != nil {
+		return permanent(fmt.Errorf("invalid recipient %q", to))
+	}
+	msg, err := buildMessage(from, rcpt, subject, text)
+	if err != nil {
+		return permanent(err)
+	}
+	// PlainAuth refuses to send the password without TLS, except to
+	// localhost; no retry will change that
+	if ch.SMTPUsername != "" && ch.SMTPTLS == TLSNone && !isLocalhost(ch.SMTPHost) {
+		return permanent(errors.New("SMTP login needs TLS or STARTTLS unless the server is localhost"))
+	}
+
+	addr := net.JoinHostPort(ch.SMTPHost, strconv.Itoa(ch.SMTPPort))
+	tlsConfig := &tls.Config{ServerName: ch.SMTPHost, InsecureSkipVerify: ch.SMTPSkipVerify}
+	dialer := &net.Dialer{Timeout: sendTimeout}
+	var conn net.Conn
+	if ch.SMTPTLS == TLSImplicit {
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, tlsConfig)
+	} else {
+		conn, err = dialer.Dial("tcp", addr)
+	}
+	if err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Now().Add(sendTimeout))
+
+	c, err := smtp.NewClient(conn, ch.SMTPHost)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+
+	if ch.SMTPTLS == TLSStartTLS {
+		if ok, _ := c.Extension("STARTTLS"); !ok {
+			return permanent(errors.New("SMTP server does not offer STARTTLS"))
+		}
+		if err := c.StartTLS(tlsConfig); err != nil {
+			return permanent(fmt.Errorf("STARTTLS: %v", err))
+		}
+	}
+	if ch.SMTPUsername != "" {
+		if err := c.Auth(smtp.PlainAuth("", ch.SMTPUsername, ch.SMTPPassword, ch.SMTPHost)); err != nil {
+			return smtpError("AUTH", err)
+		}
+	}
+	if err := c.Mail(from.Address); err != nil {
+		return smtpError("MAIL FROM", err)
+	}
+	if err := c.Rcpt(rcpt.Address); err != nil {
+		return smtpError("RCPT TO", err)
+	}
+	w, err := c.Data()
+	if err != nil {
+		return smtpError("DATA", err)
+	}
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return smtpError("DATA", err)
+	}
+	return c.Quit()
+}
+
+// isLocalhost mirrors the hosts smtp.PlainAuth trusts without TLS
+func isLocalhost(host string) bool {
+	return host == "localhost" || host == "127.0.0.1" || host == "::1"
+}
+
+// smtpError makes 5xx replies permanent; 4xx (greylisting, rate limits) are retried
+func smtpError(stage string, err error) error {
+	var reply *textproto.Error
+	if errors.As(err, &reply) && reply.Code >= 500 {
+		return permanent(fmt.Errorf("%s: %v", stage, err))
+	}
+	return fmt.Errorf("%s: %v", stage, err)
+}
+
+func buildMessage(from, to *mail.Address, subject, text string) ([]byte, error) {
+	var buf bytes.Buffer
+	host := "mikromon"
+	if at := strings.LastIndex(from.Address, "@"); at >= 0 {
+		host = from.Address[at+1:]
+	}
+	headers := [][2]string{
+		{"From", from.String()},
+		{"To", to.String()},
+		{"Subject", mime.QEncoding.Encode("utf-8", subject)},
+		{"Date", time.Now().Format(time.RFC1123Z)},
+		{"Message-ID", "<" + primitive.NewObjectID().Hex() + "@" + host + ">"},
+		{"MIME-Version", "1.0"},
+		{"Content-Type", "text/plain; charset=utf-8"},
+		{"Content-Transfer-Encoding", "quoted-printable"},
+		{"Auto-Submitted", "auto-generated"},
+	}
+	for _, h := range headers {
+		fmt.Fprintf(&buf, "%s: %s\r\n", h[0], h[1])
+	}
+	buf.WriteString("\r\n")
+	qp := quotedprintable.NewWriter(&buf)
+	if _, err := qp.Write([]byte(strings.ReplaceAll(text, "\n", "\r\n"))); err != nil {
+		return nil, err
+	}
+	if err := qp.Close(); err != nil {
+		return nil, err
+	}
+	buf.WriteString("\r\n")
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,422 @@
+package notify
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func testEvent() Event {
+	return Event{
+		ID:       "65f0c0ffee0000000000cafe",
+		Type:     EventBackupFailed,
+		Severity: SeverityWarning,
+		Title:    "Backup of OLT-1 failed",
+		Message:  "timeout",
+		Time:     time.Unix(1767225600, 0),
+	}
+}
+
+func TestSendWebhookSignsBody(t *testing.T) {
+	var header http.Header
+	var body []byte
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header.Clone()
+		body, _ = io.ReadAll(r.Body)
+	}))
+	defer srv.Close()
+
+	ch := Channel{Type: ChannelWebhook, URL: srv.URL, Secret: "s3cret", Headers: map[string]string{"X-Team": "noc"}}
+	if err := sendWebhook(ch, testEvent(), "subject", "text"); err != nil {
+		t.Fatalf("sendWebhook: %v", err)
+	}
+
+	ts := header.Get("X-MikroMon-Timestamp")
+	if _, err := strconv.ParseInt(ts, 10, 64); err != nil {
+		t.Fatalf("timestamp %q is not Unix seconds", ts)
+	}
+	mac := hmac.New(sha256.New, []byte("s3cret"))
+	mac.Write([]byte(ts + "." + string(body)))
+	if want := "sha256=" + hex.EncodeToString(mac.Sum(nil)); header.Get("X-MikroMon-Signature") != want {
+		t.Errorf("signature = %q, want %q", header.Get("X-MikroMon-Signature"), want)
+	}
+	if header.Get("X-MikroMon-Event") != EventBackupFailed || header.Get("X-MikroMon-Delivery") != testEvent().ID {
+		t.Errorf("event headers = %q, %q", header.Get("X-MikroMon-Event"), header.Get("X-MikroMon-Delivery"))
+	}
+	if header.Get("X-Team") != "noc" {
+		t.Errorf("custom header not sent")
+	}
+
+	var payload WebhookPayload
+	if err := json.Unmarshal(body, &payload); err != nil {
+		t.Fatalf("body is not a WebhookPayload: %v", err)
+	}
+	if payload.Event.Title != testEvent().Title || payload.Subject != "subject" || payload.Text != "text" {
+		t.Errorf("payload = %+v", payload)
+	}
+}
+
+func TestSendWebhookWithoutSecretIsUnsigned(t *testing.T) {
+	signed := true
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, signed = r.Header["X-Mikromon-Signature"]
+	}))
+	defer srv.Close()
+
+	if err := sendWebhook(Channel{Type: ChannelWebhook, URL: srv.URL}, testEvent(), "s", "t"); err != nil {
+		t.Fatalf("sendWebhook: %v", err)
+	}
+	if signed {
+		t.Error("signature sent without a secret")
+	}
+}
+
+func TestSendWebhookStatusClassification(t *testing.T) {
+	tests := []struct {
+		status    int
+		ok        bool
+		temporary bool
+	}{
+		{http.StatusOK, true, false},
+		{http.StatusNoContent, true, false},
+		{http.StatusBadRequest, false, false},
+		{http.StatusUnauthorized, false, false},
+		{http.StatusNotFound, false, false},
+		{http.StatusTooManyRequests, false, true},
+		{http.StatusInternalServerError, false, true},
+		{http.StatusBadGateway, false, true},
+		{http.StatusServiceUnavailable, false, true},
+	}
+	for _, tt := range tests {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(tt.status)
+			io.WriteString(w, "reason")
+		}))
+		err := sendWebhook(Channel{Type: ChannelWebhook, URL: srv.URL}, testEvent(), "s", "t")
+		srv.Close()
+
+		if tt.ok {
+			if err != nil {
+				t.Errorf("HTTP %d: unexpected error %v", tt.status, err)
+			}
+			continue
+		}
+		if err == nil {
+			t.Errorf("HTTP %d: no error", tt.status)
+			continue
+		}
+		if isTemporary(err) != tt.temporary {
+			t.Errorf("HTTP %d: temporary = %v, want %v (%v)", tt.status, isTemporary(err), tt.temporary, err)
+		}
+		if !strings.Contains(err.Error(), strconv.Itoa(tt.status)) {
+			t.Errorf("HTTP %d: error %q does not name the status", tt.status, err)
+		}
+	}
+}
+
+func TestSendWebhookUnreachableIsTemporary(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	url := srv.URL
+	srv.Close()
+
+	err := sendWebhook(Channel{Type: ChannelWebhook, URL: url}, testEvent(), "s", "t")
+	if err == nil || !isTemporary(err) {
+		t.Fatalf("err = %v, want a temporary error", err)
+	}
+}
+
+func TestSendBot(t *testing.T) {
+	var path string
+	var msg map[string]interface{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		json.NewDecoder(r.Body).Decode(&msg)
+		io.WriteString(w, `{"ok":true,"result":{}}`)
+	}))
+	defer srv.Close()
+
+	ch := Channel{Type: ChannelBot, BotAPIURL: srv.URL, BotToken: "123:ABC"}
+	if err := sendBot(ch, "-1001", "Subject", "Body"); err != nil {
+		t.Fatalf("sendBot: %v", err)
+	}
+	if path != "/bot123:ABC/sendMessage" {
+		t.Errorf("path = %q", path)
+	}
+	if msg["chat_id"] != "-1001" || msg["text"] != "Subject\n\nBody" {
+		t.Errorf("message = %v", msg)
+	}
+}
+
+func TestSendBotErrors(t *testing.T) {
+	tests := []struct {
+		name      string
+		status    int
+		reply     string
+		temporary bool
+		contains  string
+	}{
+		{"chat not found", http.StatusBadRequest, `{"ok":false,"description":"Bad Request: chat not found"}`, false, "chat not found"},
+		{"bad token", http.StatusUnauthorized, `{"ok":false,"description":"Unauthorized"}`, false, "Unauthorized"},
+		{"rate limited", http.StatusTooManyRequests, `{"ok":false,"description":"Too Many Requests: retry after 5"}`, true, "Too Many Requests"},
+		{"server error", http.StatusBadGateway, `bad gateway`, true, "502"},
+		{"not accepted", http.StatusOK, `{"ok":false}`, false, "did not accept"},
+	}
+	for _, tt := range tests {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(tt.status)
+			io.WriteString(w, tt.reply)
+		}))
+		err := sendBot(Channel{Type: ChannelBot, BotAPIURL: srv.URL, BotToken: "t"}, "1", "s", "b")
+		srv.Close()
+
+		if err == nil {
+			t.Errorf("%s: no error", tt.name)
+			continue
+		}
+		if isTemporary(err) != tt.temporary {
+			t.Errorf("%s: temporary = %v, want %v (%v)", tt.name, isTemporary(err), tt.temporary, err)
+		}
+		if !strings.Contains(err.Error(), tt.contains) {
+			t.Errorf("%s: error %q does not contain %q", tt.name, err, tt.contains)
+		}
+	}
+}
+
+// smtpServer is just enough of an SMTP server to receive one message,
+// optionally over STARTTLS
+type smtpServer struct {
+	ln        net.Listener
+	tls       *tls.Config // nil: STARTTLS not offered
+	rcptReply string
+
+	mu     sync.Mutex
+	secure bool // STARTTLS done before MAIL FROM
+	auth   string
+	from   string
+	rcpt   string
+	data   string
+}
+
+func newSMTPServer(t *testing.T, tlsConfig *tls.Config) *smtpServer {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &smtpServer{ln: ln, tls: tlsConfig, rcptReply: "250 2.1.5 OK"}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *smtpServer) channel(mode string) Channel {
+	addr := s.ln.Addr().(*net.TCPAddr)
+	return Channel{
+		Type: ChannelEmail, SMTPHost: "127.0.0.1", SMTPPort: addr.Port, SMTPTLS: mode,
+		SMTPSkipVerify: true, SMTPUsername: "mailer", SMTPPassword: "pw", From: "MikroMon <noc@example.com>",
+	}
+}
+
+func (s *smtpServer) serve(conn net.Conn) {
+	defer func() { conn.Close() }()
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	tp := textproto.NewConn(conn)
+	secure := false
+	tp.PrintfLine("220 localhost ESMTP test")
+	for {
+		line, err := tp.ReadLine()
+		if err != nil {
+			return
+		}
+		verb, arg, _ := strings.Cut(line, " ")
+		switch strings.ToUpper(verb) {
+		case "EHLO", "HELO":
+			tp.PrintfLine("250-localhost")
+			if s.tls != nil && !secure {
+				tp.PrintfLine("250-STARTTLS")
+			}
+			tp.PrintfLine("250 AUTH PLAIN")
+		case "STARTTLS":
+			tp.PrintfLine("220 2.0.0 Ready to start TLS")
+			tconn := tls.Server(conn, s.tls)
+			if err := tconn.Handshake(); err != nil {
+				return
+			}
+			conn, tp, secure = tconn, textproto.NewConn(tconn), true
+		case "AUTH":
+			_, initial, _ := strings.Cut(arg, " ")
+			creds, _ := base64.StdEncoding.DecodeString(initial)
+			s.mu.Lock()
+			s.auth = string(creds)
+			s.mu.Unlock()
+			tp.PrintfLine("235 2.7.0 Authentication successful")
+		case "MAIL":
+			s.mu.Lock()
+			s.from, s.secure = arg, secure
+			s.mu.Unlock()
+			tp.PrintfLine("250 2.1.0 OK")
+		case "RCPT":
+			s.mu.Lock()
+			s.rcpt = arg
+			s.mu.Unlock()
+			tp.PrintfLine("%s", s.rcptReply)
+		case "DATA":
+			tp.PrintfLine("354 End data with <CR><LF>.<CR><LF>")
+			data, err := tp.ReadDotBytes()
+			if err != nil {
+				return
+			}
+			s.mu.Lock()
+			s.data = string(data)
+			s.mu.Unlock()
+			tp.PrintfLine("250 2.0.0 Queued")
+		case "RSET", "NOOP":
+			tp.PrintfLine("250 2.0.0 OK")
+		case "QUIT":
+			tp.PrintfLine("221 2.0.0 Bye")
+			return
+		default:
+			tp.PrintfLine("502 5.5.2 Command not recognized")
+		}
+	}
+}
+
+func selfSignedTLS(t *testing.T) *tls.Config {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}}
+}
+
+func TestSendEmail(t *testing.T) {
+	tests := []struct {
+		name   string
+		mode   string
+		tls    bool
+		secure bool
+	}{
+		{"starttls", TLSStartTLS, true, true},
+		{"plain", TLSNone, false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var tlsConfig *tls.Config
+			if tt.tls {
+				tlsConfig = selfSignedTLS(t)
+			}
+			s := newSMTPServer(t, tlsConfig)
+			if err := sendEmail(s.channel(tt.mode), "Ops <ops@example.com>", "Backup failed", "OLT-1\ntimeout"); err != nil {
+				t.Fatalf("sendEmail: %v", err)
+			}
+
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			if s.secure != tt.secure {
+				t.Errorf("message sent over TLS = %v, want %v", s.secure, tt.secure)
+			}
+			if s.auth != "\x00mailer\x00pw" {
+				t.Errorf("AUTH PLAIN credentials = %q", s.auth)
+			}
+			if s.from != "FROM:<noc@example.com>" || s.rcpt != "TO:<ops@example.com>" {
+				t.Errorf("envelope = %q / %q", s.from, s.rcpt)
+			}
+			for _, want := range []string{"Subject: Backup failed", "To: \"Ops\" <ops@example.com>", "OLT-1\ntimeout"} {
+				if !strings.Contains(s.data, want) {
+					t.Errorf("message does not contain %q:\n%s", want, s.data)
+				}
+			}
+		})
+	}
+}
+
+func TestSendEmailStartTLSNotOffered(t *testing.T) {
+	s := newSMTPServer(t, nil)
+	err := sendEmail(s.channel(TLSStartTLS), "ops@example.com", "s", "b")
+	if err == nil || isTemporary(err) || !strings.Contains(err.Error(), "STARTTLS") {
+		t.Fatalf("err = %v, want a permanent STARTTLS error", err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.from != "" {
+		t.Error("message sent without the required STARTTLS")
+	}
+}
+
+func TestSendEmailPlainLoginIsPermanent(t *testing.T) {
+	s := newSMTPServer(t, nil)
+	ch := s.channel(TLSNone)
+	ch.SMTPHost = "mail.example.com"
+	err := sendEmail(ch, "ops@example.com", "s", "b")
+	if err == nil || isTemporary(err) {
+		t.Fatalf("err = %v, want a permanent error for a login without TLS", err)
+	}
+}
+
+func TestSendEmailReplyClassification(t *testing.T) {
+	tests := []struct {
+		reply     string
+		temporary bool
+	}{
+		{"450 4.2.0 Greylisted, try again later", true},
+		{"452 4.5.3 Too many recipients", true},
+		{"550 5.1.1 No such user", false},
+		{"554 5.7.1 Relay access denied", false},
+	}
+	for _, tt := range tests {
+		s := newSMTPServer(t, nil)
+		s.rcptReply = tt.reply
+		err := sendEmail(s.channel(TLSNone), "ops@example.com", "s", "b")
+		if err == nil {
+			t.Errorf("%q: no error", tt.reply)
+			continue
+		}
+		if isTemporary(err) != tt.temporary {
+			t.Errorf("%q: temporary = %v, want %v (%v)", tt.reply, isTemporary(err), tt.temporary, err)
+		}
+		if !strings.Contains(err.Error(), "RCPT TO") {
+			t.Errorf("%q: error %q does not name the stage", tt.reply, err)
+		}
+	}
+}
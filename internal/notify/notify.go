@@ -0,0 +1,285 @@
+package notify
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"mikromon/internal/auth"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Notifications: events are published by the workers and the alert engine,
+// matched against the users' subscriptions and delivered through channels
+// (webhook, email, bot). Every delivery is recorded in the delivery log and
+// retried with backoff when the failure looks temporary.
+
+// Event types
+const (
+	EventBackupFailed   = "backup_failed"
+	EventTaskFailed     = "task_failed"
+	EventCriticalSignal = "critical_signal"
+	EventAlertFiring    = "alert_firing"
+	EventAlertResolved  = "alert_resolved"
+	EventConfigDrift    = "config_drift"
+	EventTest           = "test"
+)
+
+// EventTypes lists the events a subscription can select
+var EventTypes = []string{EventBackupFailed, EventTaskFailed, EventCriticalSignal, EventAlertFiring, EventAlertResolved, EventConfigDrift}
+
+// Severities, from least to most severe
+const (
+	SeverityInfo     = "info"
+	SeverityWarning  = "warning"
+	SeverityCritical = "critical"
+)
+
+func severityRank(s string) int {
+	switch s {
+	case SeverityCritical:
+		return 2
+	case SeverityWarning:
+		return 1
+	}
+	return 0
+}
+
+// ValidSeverity reports whether s is info, warning or critical
+func ValidSeverity(s string) bool {
+	return s == SeverityInfo || s == SeverityWarning || s == SeverityCritical
+}
+
+// Event is something that happened and may interest someone
+type Event struct {
+	ID         string            `json:"id"`
+	Type       string            `json:"type"`
+	Severity   string            `json:"severity"`
+	Title      string            `json:"title"`
+	Message    string            `json:"message"`
+	DeviceID   string            `json:"device_id,omitempty"`
+	DeviceName string            `json:"device_name,omitempty"`
+	Fields     map[string]string `json:"fields,omitempty"`
+	Time       time.Time         `json:"time"`
+
+	// Owner of the device. Besides admins, only the owner is notified;
+	// events without an owner go to admins only.
+	Owner string `json:"-"`
+}
+
+const (
+	eventQueueSize = 1000
+	maxSending     = 4 // Concurrent deliveries
+)
+
+var (
+	events  = make(chan Event, eventQueueSize)
+	sending = make(chan struct{}, maxSending)
+
+	// Wait before each retry; a delivery gets len(retryDelays)+1 attempts
+	retryDelays = []time.Duration{30 * time.Second, 2 * time.Minute, 10 * time.Minute}
+
+	startOnce sync.Once
+)
+
+// Publish queues an event without blocking the caller
+func Publish(e Event) {
+	if e.ID == "" {
+		e.ID = primitive.NewObjectID().Hex()
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	if e.Severity == "" {
+		e.Severity = SeverityInfo
+	}
+	select {
+	case events <- e:
+	default:
+		log.Printf("Notify: queue full, dropping %s event %q", e.Type, e.Title)
+	}
+}
+
+// Start resumes the deliveries a restart interrupted, then dispatches
+// published events until the process exits
+func Start() {
+	startOnce.Do(func() {
+		log.Println("Notify: dispatcher started")
+		resumeDeliveries()
+		for e := range events {
+			dispatch(e)
+		}
+	})
+}
+
+// resumeDeliveries picks up the deliveries left pending or retrying by the
+// previous run: each is sent when its retry was due (now if that passed).
+// Entries recorded before the event was kept cannot be resent and are
+// marked failed.
+func resumeDeliveries() {
+	var list []Delivery
+	for _, status := range []string{DeliveryPending, DeliveryRetrying} {
+		found, err := ListDeliveries("", status, 0)
+		if err != nil {
+			log.Printf("Notify: error loading %s deliveries: %v", status, err)
+			return
+		}
+		list = append(list, found...)
+	}
+
+	resumed := 0
+	for _, d := range list {
+		if d.Event == nil {
+			d.finish(errors.New("interrupted by a restart"), false)
+			saveDelivery(d)
+			continue
+		}
+		var delay time.Duration
+		if d.Attempts > 0 && d.Attempts <= len(retryDelays) {
+			delay = time.Until(d.UpdatedAt.Add(retryDelays[d.Attempts-1]))
+		}
+		retryAfter(max(delay, 0), d, *d.Event)
+		resumed++
+	}
+	if resumed > 0 {
+		log.Printf("Notify: resuming %d interrupted deliveries", resumed)
+	}
+}
+
+// dispatch creates one delivery per matching subscription
+func dispatch(e Event) {
+	subs, err := ListSubscriptions("")
+	if err != nil {
+		log.Printf("Notify: error loading subscriptions: %v", err)
+		return
+	}
+	channels, err := ListChannels()
+	if err != nil {
+		log.Printf("Notify: error loading channels: %v", err)
+		return
+	}
+	byID := map[primitive.ObjectID]Channel{}
+	for _, ch := range channels {
+		byID[ch.ID] = ch
+	}
+
+	roles := map[string]string{} // "" = unknown or disabled user
+	for _, s := range subs {
+		ch, ok := byID[s.ChannelID]
+		if !ok || !ch.Enabled || !s.match(e) {
+			continue
+		}
+		role, seen := roles[s.Username]
+		if !seen {
+			if u, err := auth.FindUser(s.Username); err == nil && !u.Disabled {
+				role = u.Role
+			}
+			roles[s.Username] = role
+		}
+		if role == "" || (role != auth.RoleAdmin && (e.Owner == "" || e.Owner != s.Username)) {
+			continue
+		}
+
+		d := newDelivery(e, ch, s.Username, s.Target)
+		if err := saveDelivery(d); err != nil {
+			log.Printf("Notify: error recording delivery: %v", err)
+		}
+		go attempt(d, ch, e)
+	}
+}
+
+// Test sends a test event through a channel right away, without retries
+func Test(ch Channel, target, username string) (Delivery, error) {
+	e := Event{
+		ID:       primitive.NewObjectID().Hex(),
+		Type:     EventTest,
+		Severity: SeverityInfo,
+		Title:    "MikroMon test notification",
+		Message:  fmt.Sprintf("Channel %q is working.", ch.Name),
+		Time:     time.Now(),
+	}
+	d := newDelivery(e, ch, username, target)
+	err := send(ch, target, e)
+	d.Attempts = 1
+	d.finish(err, false)
+	if serr := saveDelivery(d); serr != nil {
+		log.Printf("Notify: error recording delivery: %v", serr)
+	}
+	return d, err
+}
+
+// attempt sends one delivery and schedules its retry on temporary failures
+func attempt(d Delivery, ch Channel, e Event) {
+	sending <- struct{}{}
+	err := send(ch, d.Target, e)
+	<-sending
+
+	d.Attempts++
+	retry := err != nil && isTemporary(err) && d.Attempts <= len(retryDelays)
+	d.finish(err, retry)
+	if serr := saveDelivery(d); serr != nil {
+		log.Printf("Notify: error recording delivery: %v", serr)
+	}
+	if err == nil {
+		return
+	}
+	if !retry {
+		log.Printf("Notify: %s delivery of %q to %s failed after %d attempt(s): %v", ch.Type, e.Title, d.Username, d.Attempts, err)
+		return
+	}
+	retryAfter(retryDelays[d.Attempts-1], d, e)
+}
+
+// retryAfter attempts d again once delay has passed
+func retryAfter(delay time.Duration, d Delivery, e Event) {
+	time.AfterFunc(delay, func() {
+		// Pick up edits (new URL, password...) made in the meantime
+		current, err := FindChannel(d.ChannelID)
+		if err != nil || !current.Enabled {
+			d.finish(errors.New("channel deleted or disabled before retry"), false)
+			saveDelivery(d)
+			return
+		}
+		attempt(d, current, e)
+	})
+}
+
+// send renders the event for the channel and hands it over
+func send(ch Channel, target string, e Event) error {
+	ch, err := ch.opened()
+	if err != nil {
+		return permanent(fmt.Errorf("cannot decrypt channel secrets: %v", err))
+	}
+	subject, body, err := render(e)
+	if err != nil {
+		return permanent(err)
+	}
+	switch ch.Type {
+	case ChannelWebhook:
+		return sendWebhook(ch, e, subject, body)
+	case ChannelEmail:
+		return sendEmail(ch, target, subject, body)
+	case ChannelBot:
+		return sendBot(ch, target, subject, body)
+	}
+	return permanent(fmt.Errorf("unknown channel type %q", ch.Type))
+}
+
+// permanentError marks failures that a retry cannot fix (bad address,
+// rejected credentials, 4xx responses...)
+type permanentError struct{ err error }
+
+func (p permanentError) Error() string { return p.err.Error() }
+func (p permanentError) Unwrap() error { return p.err }
+
+func permanent(err error) error {
+	return permanentError{err}
+}
+
+func isTemporary(err error) bool {
+	var p permanentError
+	return !errors.As(err, &p)
+}
@@ -0,0 +1,111 @@
+package notify
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"mikromon/internal/persistence"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// The store falls back to JSON files under data/ without a database; keep
+// them out of the source tree
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "notify-test")
+	if err != nil {
+		panic(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		panic(err)
+	}
+	if err := os.Mkdir(persistence.DataDir, 0755); err != nil {
+		panic(err)
+	}
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+func waitDelivery(t *testing.T, id primitive.ObjectID, status string) Delivery {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		list, err := ListDeliveries("", "", 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, d := range list {
+			if d.ID == id && d.Status == status {
+				return d
+			}
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("delivery %s never became %s: %+v", id.Hex(), status, list)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestResumeDeliveries(t *testing.T) {
+	received := make(chan string, 4)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received <- r.Header.Get("X-MikroMon-Delivery")
+	}))
+	defer srv.Close()
+
+	ch := Channel{ID: primitive.NewObjectID(), Name: "hook", Type: ChannelWebhook, Enabled: true, URL: srv.URL}
+	if err := SaveChannel(ch); err != nil {
+		t.Fatal(err)
+	}
+	disabled := Channel{ID: primitive.NewObjectID(), Name: "off", Type: ChannelWebhook, URL: srv.URL}
+	if err := SaveChannel(disabled); err != nil {
+		t.Fatal(err)
+	}
+
+	// Retry overdue since the process stopped
+	overdue := newDelivery(testEvent(), ch, "admin", "")
+	overdue.Status, overdue.Attempts, overdue.UpdatedAt = DeliveryRetrying, 1, time.Now().Add(-time.Hour)
+	// Created, never attempted
+	pending := newDelivery(Event{ID: "pending-event", Type: EventTaskFailed, Title: "Task failed"}, ch, "admin", "")
+	// Recorded before deliveries kept their event
+	legacy := newDelivery(testEvent(), ch, "admin", "")
+	legacy.Status, legacy.Attempts, legacy.Event = DeliveryRetrying, 1, nil
+	// Channel disabled while the process was down
+	orphan := newDelivery(testEvent(), disabled, "admin", "")
+	orphan.Status, orphan.Attempts = DeliveryRetrying, 2
+	orphan.UpdatedAt = time.Now().Add(-time.Hour)
+	for _, d := range []Delivery{overdue, pending, legacy, orphan} {
+		if err := saveDelivery(d); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	resumeDeliveries()
+
+	got := waitDelivery(t, overdue.ID, DeliverySent)
+	if got.Attempts != 2 {
+		t.Errorf("overdue delivery attempts = %d, want 2", got.Attempts)
+	}
+	waitDelivery(t, pending.ID, DeliverySent)
+	if d := waitDelivery(t, legacy.ID, DeliveryFailed); d.LastError == "" {
+		t.Error("legacy delivery failed without a reason")
+	}
+	waitDelivery(t, orphan.ID, DeliveryFailed)
+
+	seen := map[string]bool{}
+	for len(seen) < 2 {
+		select {
+		case id := <-received:
+			seen[id] = true
+		case <-time.After(5 * time.Second):
+			t.Fatalf("webhook received %v", seen)
+		}
+	}
+	if !seen[testEvent().ID] || !seen["pending-event"] {
+		t.Errorf("webhook received %v", seen)
+	}
+}
@@ -0,0 +1,627 @@
+package notify
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/mail"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"mikromon/internal/db"
+	"mikromon/internal/persistence"
+	"mikromon/internal/secrets"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Storage: "notification_channels", "notification_subscriptions",
+// "notification_templates" and "notification_deliveries", or the matching
+// persistence files when Mongo is down.
+
+var ErrNotFound = errors.New("not found")
+
+// Channel types
+const (
+	ChannelWebhook = "webhook"
+	ChannelEmail   = "email"
+	ChannelBot     = "bot" // Telegram Bot API compatible
+)
+
+// SMTP TLS modes
+const (
+	TLSStartTLS = "starttls" // Plain connection upgraded with STARTTLS (required)
+	TLSImplicit = "tls"      // TLS from the first byte (port 465)
+	TLSNone     = "none"     // No encryption (local relays only)
+)
+
+const defaultBotAPIURL = "https://api.telegram.org"
+
+// Channel is a configured way out. Secret, SMTPPassword and BotToken are
+// sealed with the master key (secrets.Seal).
+type Channel struct {
+	ID      primitive.ObjectID `json:"id" bson:"_id"`
+	Name    string             `json:"name" bson:"name"`
+	Type    string             `json:"type" bson:"type"` // webhook, email, bot
+	Enabled bool               `json:"enabled" bson:"enabled"`
+
+	// Webhook: JSON POST signed with HMAC-SHA256 of Secret
+	URL     string            `json:"url,omitempty" bson:"url,omitempty"`
+	Secret  string            `json:"secret,omitempty" bson:"secret,omitempty"`
+	Headers map[string]string `json:"headers,omitempty" bson:"headers,omitempty"`
+
+	// Email
+	SMTPHost       string `json:"smtp_host,omitempty" bson:"smtp_host,omitempty"`
+	SMTPPort       int    `json:"smtp_port,omitempty" bson:"smtp_port,omitempty"`
+	SMTPUsername   string `json:"smtp_username,omitempty" bson:"smtp_username,omitempty"`
+	SMTPPassword   string `json:"smtp_password,omitempty" bson:"smtp_password,omitempty"`
+	SMTPTLS        string `json:"smtp_tls,omitempty" bson:"smtp_tls,omitempty"` // starttls (default), tls, none
+	SMTPSkipVerify bool   `json:"smtp_skip_verify,omitempty" bson:"smtp_skip_verify,omitempty"`
+	From           string `json:"from,omitempty" bson:"from,omitempty"`
+
+	// Bot: POST {BotAPIURL}/bot{BotToken}/sendMessage
+	BotAPIURL string `json:"bot_api_url,omitempty" bson:"bot_api_url,omitempty"`
+	BotToken  string `json:"bot_token,omitempty" bson:"bot_token,omitempty"`
+
+	CreatedBy string    `json:"created_by" bson:"created_by"`
+	CreatedAt time.Time `json:"created_at" bson:"created_at"`
+	UpdatedAt time.Time `json:"updated_at" bson:"updated_at"`
+}
+
+// Validate checks the fields the channel type needs and fills defaults.
+// The error is meant for the client.
+func (ch *Channel) Validate() error {
+	if strings.TrimSpace(ch.Name) == "" {
+		return fmt.Errorf("name is required")
+	}
+	switch ch.Type {
+	case ChannelWebhook:
+		if err := checkHTTPURL(ch.URL); err != nil {
+			return fmt.Errorf("url: %v", err)
+		}
+	case ChannelEmail:
+		if ch.SMTPHost == "" {
+			return fmt.Errorf("smtp_host is required")
+		}
+		if ch.SMTPTLS == "" {
+			ch.SMTPTLS = TLSStartTLS
+		}
+		if ch.SMTPTLS != TLSStartTLS && ch.SMTPTLS != TLSImplicit && ch.SMTPTLS != TLSNone {
+			return fmt.Errorf("smtp_tls must be starttls, tls or none")
+		}
+		if ch.SMTPPort == 0 {
+			ch.SMTPPort = 587
+			if ch.SMTPTLS == TLSImplicit {
+				ch.SMTPPort = 465
+			}
+		}
+		if ch.SMTPPort < 1 || ch.SMTPPort > 65535 {
+			return fmt.Errorf("invalid smtp_port")
+		}
+		if _, err := mail.ParseAddress(ch.From); err != nil {
+			return fmt.Errorf("from must be an email address")
+		}
+	case ChannelBot:
+		if ch.BotAPIURL == "" {
+			ch.BotAPIURL = defaultBotAPIURL
+		}
+		ch.BotAPIURL = strings.TrimRight(ch.BotAPIURL, "/")
+		if err := checkHTTPURL(ch.BotAPIURL); err != nil {
+			return fmt.Errorf("bot_api_url: %v", err)
+		}
+		if ch.BotToken == "" {
+			return fmt.Errorf("bot_token is required")
+		}
+	default:
+		return fmt.Errorf("type must be webhook, email or bot")
+	}
+	return nil
+}
+
+func checkHTTPURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("must be an http(s) URL")
+	}
+	return nil
+}
+
+// CheckTarget validates the recipient of a subscription to ch
+func (ch *Channel) CheckTarget(target string) error {
+	switch ch.Type {
+	case ChannelEmail:
+		if _, err := mail.ParseAddress(target); err != nil {
+			return fmt.Errorf("target must be an email address")
+		}
+	case ChannelBot:
+		if strings.TrimSpace(target) == "" {
+			return fmt.Errorf("target must be a chat ID")
+		}
+	}
+	return nil
+}
+
+// sealed returns the channel with its secrets encrypted
+func (ch Channel) sealed() (Channel, error) {
+	var err error
+	if ch.Secret, err = secrets.Seal(ch.Secret); err != nil {
+		return ch, err
+	}
+	if ch.SMTPPassword, err = secrets.Seal(ch.SMTPPassword); err != nil {
+		return ch, err
+	}
+	ch.BotToken, err = secrets.Seal(ch.BotToken)
+	return ch, err
+}
+
+// opened returns the channel with its secrets decrypted, for sending
+func (ch Channel) opened() (Channel, error) {
+	var err error
+	if ch.Secret, err = secrets.Open(ch.Secret); err != nil {
+		return ch, err
+	}
+	if ch.SMTPPassword, err = secrets.Open(ch.SMTPPassword); err != nil {
+		return ch, err
+	}
+	ch.BotToken, err = secrets.Open(ch.BotToken)
+	return ch, err
+}
+
+// Subscription routes events to a user through a channel
+type Subscription struct {
+	ID        primitive.ObjectID `json:"id" bson:"_id"`
+	Username  string             `json:"username" bson:"username"`
+	ChannelID primitive.ObjectID `json:"channel_id" bson:"channel_id"`
+	Target    string             `json:"target,omitempty" bson:"target,omitempty"` // Email address or chat ID; unused by webhooks
+	Enabled   bool               `json:"enabled" bson:"enabled"`
+
+	// Filters, empty matches everything
+	Events      []string `json:"events,omitempty" bson:"events,omitempty"`
+	MinSeverity string   `json:"min_severity,omitempty" bson:"min_severity,omitempty"`
+	DeviceIDs   []string `json:"device_ids,omitempty" bson:"device_ids,omitempty"`
+
+	CreatedAt time.Time `json:"created_at" bson:"created_at"`
+}
+
+// Validate checks the filters; the error is meant for the client
+func (s *Subscription) Validate() error {
+	for _, ev := range s.Events {
+		if !containsString(EventTypes, ev) {
+			return fmt.Errorf("unknown event %q", ev)
+		}
+	}
+	if s.MinSeverity != "" && !ValidSeverity(s.MinSeverity) {
+		return fmt.Errorf("min_severity must be info, warning or critical")
+	}
+	return nil
+}
+
+func (s *Subscription) match(e Event) bool {
+	if !s.Enabled {
+		return false
+	}
+	if len(s.Events) > 0 && !containsString(s.Events, e.Type) {
+		return false
+	}
+	if severityRank(e.Severity) < severityRank(s.MinSeverity) {
+		return false
+	}
+	return len(s.DeviceIDs) == 0 || containsString(s.DeviceIDs, e.DeviceID)
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// Delivery states
+const (
+	DeliveryPending  = "pending"
+	DeliveryRetrying = "retrying"
+	DeliverySent     = "sent"
+	DeliveryFailed   = "failed"
+)
+
+// Delivery is one event sent (or not) to one subscriber
+type Delivery struct {
+	ID          primitive.ObjectID `json:"id" bson:"_id"`
+	EventID     string             `json:"event_id" bson:"event_id"`
+	EventType   string             `json:"event_type" bson:"event_type"`
+	Title       string             `json:"title" bson:"title"`
+	DeviceID    string             `json:"device_id,omitempty" bson:"device_id,omitempty"`
+	ChannelID   primitive.ObjectID `json:"channel_id" bson:"channel_id"`
+	ChannelName string             `json:"channel_name" bson:"channel_name"`
+	ChannelType string             `json:"channel_type" bson:"channel_type"`
+	Username    string             `json:"username" bson:"username"`
+	Target      string             `json:"target,omitempty" bson:"target,omitempty"`
+	Status      string             `json:"status" bson:"status"`
+	Attempts    int                `json:"attempts" bson:"attempts"`
+	LastError   string             `json:"last_error,omitempty" bson:"last_error,omitempty"`
+	CreatedAt   time.Time          `json:"created_at" bson:"created_at"`
+	UpdatedAt   time.Time          `json:"updated_at" bson:"updated_at"`
+	SentAt      *time.Time         `json:"sent_at,omitempty" bson:"sent_at,omitempty"`
+
+	// What is sent, kept so a restart can resume the delivery
+	Event *Event `json:"event,omitempty" bson:"event,omitempty"`
+}
+
+func newDelivery(e Event, ch Channel, username, target string) Delivery {
+	now := time.Now()
+	return Delivery{
+		Event:       &e,
+		ID:          primitive.NewObjectID(),
+		EventID:     e.ID,
+		EventType:   e.Type,
+		Title:       e.Title,
+		DeviceID:    e.DeviceID,
+		ChannelID:   ch.ID,
+		ChannelName: ch.Name,
+		ChannelType: ch.Type,
+		Username:    username,
+		Target:      target,
+		Status:      DeliveryPending,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+}
+
+// finish records the outcome of an attempt
+func (d *Delivery) finish(err error, retrying bool) {
+	now := time.Now()
+	d.UpdatedAt = now
+	switch {
+	case err == nil:
+		d.Status, d.LastError, d.SentAt = DeliverySent, "", &now
+	case retrying:
+		d.Status, d.LastError = DeliveryRetrying, err.Error()
+	default:
+		d.Status, d.LastError = DeliveryFailed, err.Error()
+	}
+}
+
+const maxMockDeliveries = 1000
+
+var (
+	storeMu           sync.Mutex
+	mockChannels      []Channel
+	mockSubscriptions []Subscription
+	mockTemplates     []Template
+	mockDeliveries    []Delivery
+	mockOnce          sync.Once
+)
+
+// loadMock reads the JSON files once. Caller holds storeMu.
+func loadMock() {
+	mockOnce.Do(func() {
+		store := persistence.GetStore()
+		if store.Load(persistence.NotificationChannelsFile, &mockChannels) != nil {
+			mockChannels = []Channel{}
+		}
+		if store.Load(persistence.NotificationSubscriptionsFile, &mockSubscriptions) != nil {
+			mockSubscriptions = []Subscription{}
+		}
+		if store.Load(persistence.NotificationTemplatesFile, &mockTemplates) != nil {
+			mockTemplates = []Template{}
+		}
+		if store.Load(persistence.NotificationDeliveriesFile, &mockDeliveries) != nil {
+			mockDeliveries = []Delivery{}
+		}
+	})
+}
+
+// ListChannels returns every channel with its secrets still sealed
+func ListChannels() ([]Channel, error) {
+	collection := db.GetCollection("notification_channels")
+	if collection == nil {
+		storeMu.Lock()
+		defer storeMu.Unlock()
+		loadMock()
+		return append([]Channel{}, mockChannels...), nil
+	}
+
+	list := []Channel{}
+	cursor, err := collection.Find(context.TODO(), bson.M{}, options.Find().SetSort(bson.M{"name": 1}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(context.TODO())
+	err = cursor.All(context.TODO(), &list)
+	return list, err
+}
+
+// FindChannel looks up a channel by ID
+func FindChannel(id primitive.ObjectID) (Channel, error) {
+	collection := db.GetCollection("notification_channels")
+	if collection == nil {
+		storeMu.Lock()
+		defer storeMu.Unlock()
+		loadMock()
+		for _, ch := range mockChannels {
+			if ch.ID == id {
+				return ch, nil
+			}
+		}
+		return Channel{}, ErrNotFound
+	}
+
+	var ch Channel
+	err := collection.FindOne(context.TODO(), bson.M{"_id": id}).Decode(&ch)
+	if err == mongo.ErrNoDocuments {
+		return Channel{}, ErrNotFound
+	}
+	return ch, err
+}
+
+// SaveChannel inserts or replaces a channel by ID, sealing its secrets
+func SaveChannel(ch Channel) error {
+	ch, err := ch.sealed()
+	if err != nil {
+		return err
+	}
+	collection := db.GetCollection("notification_channels")
+	if collection == nil {
+		storeMu.Lock()
+		defer storeMu.Unlock()
+		loadMock()
+		replaced := false
+		for i := range mockChannels {
+			if mockChannels[i].ID == ch.ID {
+				mockChannels[i] = ch
+				replaced = true
+			}
+		}
+		if !replaced {
+			mockChannels = append(mockChannels, ch)
+		}
+		return persistence.GetStore().Save(persistence.NotificationChannelsFile, mockChannels)
+	}
+
+	_, err = collection.ReplaceOne(context.TODO(), bson.M{"_id": ch.ID}, ch, options.Replace().SetUpsert(true))
+	return err
+}
+
+// DeleteChannel removes a channel and the subscriptions to it
+func DeleteChannel(id primitive.ObjectID) error {
+	collection := db.GetCollection("notification_channels")
+	if collection == nil {
+		storeMu.Lock()
+		defer storeMu.Unlock()
+		loadMock()
+		found := false
+		for i := range mockChannels {
+			if mockChannels[i].ID == id {
+				mockChannels = append(mockChannels[:i], mockChannels[i+1:]...)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return ErrNotFound
+		}
+		subs := mockSubscriptions[:0]
+		for _, s := range mockSubscriptions {
+			if s.ChannelID != id {
+				subs = append(subs, s)
+			}
+		}
+		mockSubscriptions = subs
+		store := persistence.GetStore()
+		if err := store.Save(persistence.NotificationChannelsFile, mockChannels); err != nil {
+			return err
+		}
+		return store.Save(persistence.NotificationSubscriptionsFile, mockSubscriptions)
+	}
+
+	res, err := collection.DeleteOne(context.TODO(), bson.M{"_id": id})
+	if err != nil {
+		return err
+	}
+	if res.DeletedCount == 0 {
+		return ErrNotFound
+	}
+	_, err = db.GetCollection("notification_subscriptions").DeleteMany(context.TODO(), bson.M{"channel_id": id})
+	return err
+}
+
+// RewrapSecrets re-encrypts the channel secrets under the current primary
+// master key, for key rotation
+func RewrapSecrets() (updated int, failed int) {
+	channels, err := ListChannels()
+	if err != nil {
+		log.Printf("Secrets: failed to list notification channels: %v", err)
+		return 0, 1
+	}
+	for _, ch := range channels {
+		next := ch
+		var errs [3]error
+		next.Secret, errs[0] = secrets.Rewrap(ch.Secret)
+		next.SMTPPassword, errs[1] = secrets.Rewrap(ch.SMTPPassword)
+		next.BotToken, errs[2] = secrets.Rewrap(ch.BotToken)
+		if err := errors.Join(errs[:]...); err != nil {
+			log.Printf("Secrets: failed to re-encrypt channel %s: %v", ch.Name, err)
+			failed++
+			continue
+		}
+		if next.Secret == ch.Secret && next.SMTPPassword == ch.SMTPPassword && next.BotToken == ch.BotToken {
+			continue
+		}
+		if err := SaveChannel(next); err != nil {
+			log.Printf("Secrets: failed to save channel %s: %v", ch.Name, err)
+			failed++
+			continue
+		}
+		updated++
+	}
+	return updated, failed
+}
+
+// ListSubscriptions returns the subscriptions of username ("" = everyone)
+func ListSubscriptions(username string) ([]Subscription, error) {
+	collection := db.GetCollection("notification_subscriptions")
+	if collection == nil {
+		storeMu.Lock()
+		defer storeMu.Unlock()
+		loadMock()
+		list := []Subscription{}
+		for _, s := range mockSubscriptions {
+			if username == "" || s.Username == username {
+				list = append(list, s)
+			}
+		}
+		return list, nil
+	}
+
+	filter := bson.M{}
+	if username != "" {
+		filter["username"] = username
+	}
+	list := []Subscription{}
+	cursor, err := collection.Find(context.TODO(), filter)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(context.TODO())
+	err = cursor.All(context.TODO(), &list)
+	return list, err
+}
+
+// FindSubscription looks up a subscription by ID
+func FindSubscription(id primitive.ObjectID) (Subscription, error) {
+	subs, err := ListSubscriptions("")
+	if err != nil {
+		return Subscription{}, err
+	}
+	for _, s := range subs {
+		if s.ID == id {
+			return s, nil
+		}
+	}
+	return Subscription{}, ErrNotFound
+}
+
+// SaveSubscription inserts or replaces a subscription by ID
+func SaveSubscription(s Subscription) error {
+	collection := db.GetCollection("notification_subscriptions")
+	if collection == nil {
+		storeMu.Lock()
+		defer storeMu.Unlock()
+		loadMock()
+		replaced := false
+		for i := range mockSubscriptions {
+			if mockSubscriptions[i].ID == s.ID {
+				mockSubscriptions[i] = s
+				replaced = true
+			}
+		}
+		if !replaced {
+			mockSubscriptions = append(mockSubscriptions, s)
+		}
+		return persistence.GetStore().Save(persistence.NotificationSubscriptionsFile, mockSubscriptions)
+	}
+
+	_, err := collection.ReplaceOne(context.TODO(), bson.M{"_id": s.ID}, s, options.Replace().SetUpsert(true))
+	return err
+}
+
+// DeleteSubscription removes a subscription by ID
+func DeleteSubscription(id primitive.ObjectID) error {
+	collection := db.GetCollection("notification_subscriptions")
+	if collection == nil {
+		storeMu.Lock()
+		defer storeMu.Unlock()
+		loadMock()
+		for i := range mockSubscriptions {
+			if mockSubscriptions[i].ID == id {
+				mockSubscriptions = append(mockSubscriptions[:i], mockSubscriptions[i+1:]...)
+				return persistence.GetStore().Save(persistence.NotificationSubscriptionsFile, mockSubscriptions)
+			}
+		}
+		return ErrNotFound
+	}
+
+	res, err := collection.DeleteOne(context.TODO(), bson.M{"_id": id})
+	if err != nil {
+		return err
+	}
+	if res.DeletedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// saveDelivery inserts or replaces a delivery log entry. The file keeps
+// only the newest entries; MongoDB expires them with a TTL index.
+func saveDelivery(d Delivery) error {
+	collection := db.GetCollection("notification_deliveries")
+	if collection == nil {
+		storeMu.Lock()
+		defer storeMu.Unlock()
+		loadMock()
+		replaced := false
+		for i := range mockDeliveries {
+			if mockDeliveries[i].ID == d.ID {
+				mockDeliveries[i] = d
+				replaced = true
+			}
+		}
+		if !replaced {
+			mockDeliveries = append(mockDeliveries, d)
+			if len(mockDeliveries) > maxMockDeliveries {
+				mockDeliveries = mockDeliveries[len(mockDeliveries)-maxMockDeliveries:]
+			}
+		}
+		return persistence.GetStore().Save(persistence.NotificationDeliveriesFile, mockDeliveries)
+	}
+
+	_, err := collection.ReplaceOne(context.TODO(), bson.M{"_id": d.ID}, d, options.Replace().SetUpsert(true))
+	return err
+}
+
+// ListDeliveries returns the delivery log newest first. Empty username and
+// status match all; limit 0 is no limit.
+func ListDeliveries(username, status string, limit int) ([]Delivery, error) {
+	list := []Delivery{}
+	collection := db.GetCollection("notification_deliveries")
+	if collection == nil {
+		storeMu.Lock()
+		defer storeMu.Unlock()
+		loadMock()
+		for i := len(mockDeliveries) - 1; i >= 0 && (limit == 0 || len(list) < limit); i-- {
+			d := mockDeliveries[i]
+			if (username != "" && d.Username != username) || (status != "" && d.Status != status) {
+				continue
+			}
+			list = append(list, d)
+		}
+		return list, nil
+	}
+
+	filter := bson.M{}
+	if username != "" {
+		filter["username"] = username
+	}
+	if status != "" {
+		filter["status"] = status
+	}
+	opts := options.Find().SetSort(bson.M{"created_at": -1})
+	if limit > 0 {
+		opts.SetLimit(int64(limit))
+	}
+	cursor, err := collection.Find(context.TODO(), filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(context.TODO())
+	err = cursor.All(context.TODO(), &list)
+	return list, err
+}
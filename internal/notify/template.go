@@ -0,0 +1,182 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"text/template"
+	"time"
+
+	"mikromon/internal/db"
+	"mikromon/internal/persistence"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Template renders the subject and body of an event type with text/template.
+// The data is the Event: {{.Title}}, {{.Message}}, {{.DeviceName}},
+// {{.Severity}}, {{.Time}}, {{index .Fields "name"}}...
+type Template struct {
+	Event     string    `json:"event" bson:"_id"`
+	Subject   string    `json:"subject" bson:"subject"`
+	Body      string    `json:"body" bson:"body"`
+	Default   bool      `json:"default" bson:"-"` // Not customized
+	UpdatedBy string    `json:"updated_by,omitempty" bson:"updated_by,omitempty"`
+	UpdatedAt time.Time `json:"updated_at,omitempty" bson:"updated_at,omitempty"`
+}
+
+const (
+	defaultSubject = `[MikroMon] {{.Title}}`
+	defaultBody    = `{{.Title}}
+{{if .Message}}
+{{.Message}}
+{{end}}
+Severity: {{.Severity}}
+{{- if .DeviceName}}
+Device: {{.DeviceName}}{{end}}
+{{- range $k, $v := .Fields}}
+{{$k}}: {{$v}}{{end}}
+Time: {{.Time.Format "2006-01-02 15:04:05 MST"}}
+`
+	maxTemplateLength = 8192
+)
+
+// defaultTemplate is used for event types without a customized template
+func defaultTemplate(event string) Template {
+	return Template{Event: event, Subject: defaultSubject, Body: defaultBody, Default: true}
+}
+
+// Validate parses the template and renders it with a sample event
+func (t *Template) Validate() error {
+	if t.Event != EventTest && !containsString(EventTypes, t.Event) {
+		return fmt.Errorf("unknown event %q", t.Event)
+	}
+	if len(t.Subject) > maxTemplateLength || len(t.Body) > maxTemplateLength {
+		return fmt.Errorf("template longer than %d characters", maxTemplateLength)
+	}
+	if strings.TrimSpace(t.Body) == "" {
+		return fmt.Errorf("body is required")
+	}
+	sample := Event{
+		Type: t.Event, Severity: SeverityWarning, Title: "Sample", Message: "Sample message",
+		DeviceID: "0", DeviceName: "sample-device", Fields: map[string]string{"key": "value"}, Time: time.Now(),
+	}
+	_, _, err := t.render(sample)
+	return err
+}
+
+func (t *Template) render(e Event) (subject, body string, err error) {
+	var buf bytes.Buffer
+	st, err := template.New("subject").Option("missingkey=zero").Parse(t.Subject)
+	if err != nil {
+		return "", "", fmt.Errorf("subject: %v", err)
+	}
+	if err := st.Execute(&buf, e); err != nil {
+		return "", "", fmt.Errorf("subject: %v", err)
+	}
+	// Subjects end up in mail headers and chat titles: one line
+	subject = strings.Join(strings.Fields(buf.String()), " ")
+
+	buf.Reset()
+	bt, err := template.New("body").Option("missingkey=zero").Parse(t.Body)
+	if err != nil {
+		return "", "", fmt.Errorf("body: %v", err)
+	}
+	if err := bt.Execute(&buf, e); err != nil {
+		return "", "", fmt.Errorf("body: %v", err)
+	}
+	return subject, strings.TrimSpace(buf.String()), nil
+}
+
+// render uses the customized template of the event type, if any
+func render(e Event) (subject, body string, err error) {
+	t, err := GetTemplate(e.Type)
+	if err != nil {
+		t = defaultTemplate(e.Type)
+	}
+	return t.render(e)
+}
+
+// Templates returns the template of every event type, customized or not
+func Templates() ([]Template, error) {
+	list := []Template{}
+	for _, ev := range append(append([]string{}, EventTypes...), EventTest) {
+		t, err := GetTemplate(ev)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, t)
+	}
+	return list, nil
+}
+
+// GetTemplate returns the template of an event type, the default when not customized
+func GetTemplate(event string) (Template, error) {
+	collection := db.GetCollection("notification_templates")
+	if collection == nil {
+		storeMu.Lock()
+		defer storeMu.Unlock()
+		loadMock()
+		for _, t := range mockTemplates {
+			if t.Event == event {
+				return t, nil
+			}
+		}
+		return defaultTemplate(event), nil
+	}
+
+	var t Template
+	err := collection.FindOne(context.TODO(), bson.M{"_id": event}).Decode(&t)
+	if err == mongo.ErrNoDocuments {
+		return defaultTemplate(event), nil
+	}
+	return t, err
+}
+
+// SaveTemplate customizes the template of an event type
+func SaveTemplate(t Template) error {
+	t.Default = false
+	collection := db.GetCollection("notification_templates")
+	if collection == nil {
+		storeMu.Lock()
+		defer storeMu.Unlock()
+		loadMock()
+		replaced := false
+		for i := range mockTemplates {
+			if mockTemplates[i].Event == t.Event {
+				mockTemplates[i] = t
+				replaced = true
+			}
+		}
+		if !replaced {
+			mockTemplates = append(mockTemplates, t)
+		}
+		return persistence.GetStore().Save(persistence.NotificationTemplatesFile, mockTemplates)
+	}
+
+	_, err := collection.ReplaceOne(context.TODO(), bson.M{"_id": t.Event}, t, options.Replace().SetUpsert(true))
+	return err
+}
+
+// ResetTemplate goes back to the default template of an event type
+func ResetTemplate(event string) error {
+	collection := db.GetCollection("notification_templates")
+	if collection == nil {
+		storeMu.Lock()
+		defer storeMu.Unlock()
+		loadMock()
+		for i := range mockTemplates {
+			if mockTemplates[i].Event == event {
+				mockTemplates = append(mockTemplates[:i], mockTemplates[i+1:]...)
+				return persistence.GetStore().Save(persistence.NotificationTemplatesFile, mockTemplates)
+			}
+		}
+		return nil
+	}
+
+	_, err := collection.DeleteOne(context.TODO(), bson.M{"_id": event})
+	return err
+}
@@ -13,6 +13,20 @@ const (
 	DataDir     = "data"
 	DevicesFile = "data/devices.json"
 	UsersFile   = "data/users.json"
+
+	KnownHostsFile     = "data/known_hosts.json"
+	RecordingsFile     = "data/recordings.json"
+	OnuAssignmentsFile = "data/onu_assignments.json"
+	AlarmsFile         = "data/alarms.json"
+	AlertRulesFile     = "data/alert_rules.json"
+	AlertsFile         = "data/alerts.json"
+	ConfigVersionsFile = "data/config_versions.json"
+	ConfigDriftFile    = "data/config_drift.json"
+
+	NotificationChannelsFile      = "data/notification_channels.json"
+	NotificationSubscriptionsFile = "data/notification_subscriptions.json"
+	NotificationTemplatesFile     = "data/notification_templates.json"
+	NotificationDeliveriesFile    = "data/notification_deliveries.json"
 )
 
 type Store struct {
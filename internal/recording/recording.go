@@ -0,0 +1,393 @@
+package recording
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"mikromon/internal/db"
+	"mikromon/internal/persistence"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Terminal session recordings in asciicast v2 format
+// (https://docs.asciinema.org/manual/asciicast/v2/).
+// Files live in data/recordings/<user>/<device id>/<recording id>.cast and the
+// metadata in the "recordings" collection (or persistence.RecordingsFile).
+// The metadata is stored when the recording starts, so a session cut short by
+// a crash is still listed, and completed with its end and size on Close.
+
+const baseDir = "data/recordings"
+
+// flushEvery bounds how much of a quiet session sits in the write buffer
+const flushEvery = 5 * time.Second
+
+var ErrNotFound = errors.New("recording not found")
+
+type Recording struct {
+	ID         primitive.ObjectID `json:"id" bson:"_id"`
+	User       string             `json:"user" bson:"user"`
+	DeviceID   string             `json:"device_id" bson:"device_id"`
+	DeviceName string             `json:"device_name" bson:"device_name"`
+	Kind       string             `json:"kind" bson:"kind"` // terminal, command
+	Title      string             `json:"title" bson:"title"`
+	Width      int                `json:"width" bson:"width"`
+	Height     int                `json:"height" bson:"height"`
+	StartedAt  time.Time          `json:"started_at" bson:"started_at"`
+	EndedAt    time.Time          `json:"ended_at" bson:"ended_at"`
+	Duration   float64            `json:"duration" bson:"duration"` // seconds
+	Size       int64              `json:"size" bson:"size"`
+	Path       string             `json:"-" bson:"path"`
+}
+
+// Header is the first line of an asciicast v2 file
+type Header struct {
+	Version   int               `json:"version"`
+	Width     int               `json:"width"`
+	Height    int               `json:"height"`
+	Timestamp int64             `json:"timestamp"`
+	Title     string            `json:"title,omitempty"`
+	Env       map[string]string `json:"env,omitempty"`
+}
+
+// Recorder appends timed events to an open recording.
+// A nil *Recorder is valid and records nothing.
+type Recorder struct {
+	mu     sync.Mutex
+	f      *os.File
+	w      *bufio.Writer
+	start  time.Time
+	rec    Recording
+	carry  map[string][]byte // incomplete UTF-8 sequences per event type
+	closed bool
+	done   chan struct{} // Stops the periodic flush
+}
+
+var (
+	mockRecordings []Recording
+	mockMu         sync.Mutex
+	loadOnce       sync.Once
+)
+
+func loadMock() {
+	loadOnce.Do(func() {
+		persistence.GetStore().Load(persistence.RecordingsFile, &mockRecordings)
+	})
+}
+
+func safeName(s string) string {
+	s = strings.Map(func(r rune) rune {
+		if r == '/' || r == '\\' || r == '.' || r == ' ' {
+			return '_'
+		}
+		return r
+	}, s)
+	if s == "" {
+		return "unknown"
+	}
+	return s
+}
+
+// Start creates a new recording file and writes the asciicast header
+func Start(user, deviceID, deviceName, kind, title string, cols, rows int) (*Recorder, error) {
+	if cols <= 0 {
+		cols = 80
+	}
+	if rows <= 0 {
+		rows = 24
+	}
+
+	rec := Recording{
+		ID:         primitive.NewObjectID(),
+		User:       user,
+		DeviceID:   deviceID,
+		DeviceName: deviceName,
+		Kind:       kind,
+		Title:      title,
+		Width:      cols,
+		Height:     rows,
+		StartedAt:  time.Now(),
+	}
+	rec.Path = filepath.Join(baseDir, safeName(user), safeName(deviceID), rec.ID.Hex()+".cast")
+
+	if err := os.MkdirAll(filepath.Dir(rec.Path), 0750); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(rec.Path, os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0640)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &Recorder{f: f, w: bufio.NewWriter(f), start: rec.StartedAt, rec: rec, carry: map[string][]byte{}, done: make(chan struct{})}
+	header, _ := json.Marshal(Header{
+		Version:   2,
+		Width:     cols,
+		Height:    rows,
+		Timestamp: rec.StartedAt.Unix(),
+		Title:     title,
+		Env:       map[string]string{"TERM": "xterm-256color"},
+	})
+	r.w.Write(header)
+	r.w.WriteByte('\n')
+	if err := r.w.Flush(); err != nil {
+		f.Close()
+		os.Remove(rec.Path)
+		return nil, err
+	}
+	if err := insert(rec); err != nil {
+		f.Close()
+		os.Remove(rec.Path)
+		return nil, err
+	}
+	go r.flushLoop()
+	return r, nil
+}
+
+// flushLoop writes buffered events out until the recorder is closed
+func (r *Recorder) flushLoop() {
+	ticker := time.NewTicker(flushEvery)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.done:
+			return
+		case <-ticker.C:
+			r.mu.Lock()
+			if !r.closed {
+				r.w.Flush()
+			}
+			r.mu.Unlock()
+		}
+	}
+}
+
+// ID returns the hex ID used to link the recording from the audit log
+func (r *Recorder) ID() string {
+	if r == nil {
+		return ""
+	}
+	return r.rec.ID.Hex()
+}
+
+func (r *Recorder) event(kind string, data []byte) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return
+	}
+
+	// Keep split multi-byte characters for the next event
+	buf := append(r.carry[kind], data...)
+	cut := len(buf)
+	for i := 1; i <= utf8.UTFMax && i <= len(buf); i++ {
+		if utf8.RuneStart(buf[len(buf)-i]) {
+			if !utf8.FullRune(buf[len(buf)-i:]) {
+				cut = len(buf) - i
+			}
+			break
+		}
+	}
+	r.carry[kind] = append([]byte(nil), buf[cut:]...)
+	if cut == 0 {
+		return
+	}
+
+	line, _ := json.Marshal([]interface{}{time.Since(r.start).Seconds(), kind, string(buf[:cut])})
+	r.w.Write(line)
+	r.w.WriteByte('\n')
+	if r.w.Buffered() > 16*1024 {
+		r.w.Flush()
+	}
+}
+
+// Output records bytes sent by the device
+func (r *Recorder) Output(data []byte) { r.event("o", data) }
+
+// Input records bytes typed by the user
+func (r *Recorder) Input(data []byte) { r.event("i", data) }
+
+// Resize records a terminal size change
+func (r *Recorder) Resize(cols, rows int) {
+	if cols > 0 && rows > 0 {
+		r.event("r", []byte(fmt.Sprintf("%dx%d", cols, rows)))
+	}
+}
+
+// Close flushes the file and completes the recording metadata
+func (r *Recorder) Close() error {
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	if r.closed {
+		r.mu.Unlock()
+		return nil
+	}
+	r.closed = true
+	close(r.done)
+	r.w.Flush()
+	r.f.Close()
+	r.mu.Unlock()
+
+	r.rec.EndedAt = time.Now()
+	r.rec.Duration = r.rec.EndedAt.Sub(r.start).Seconds()
+	if st, err := os.Stat(r.rec.Path); err == nil {
+		r.rec.Size = st.Size()
+	}
+	return finish(r.rec)
+}
+
+func insert(rec Recording) error {
+	coll := db.GetCollection("recordings")
+	if coll == nil {
+		loadMock()
+		mockMu.Lock()
+		defer mockMu.Unlock()
+		mockRecordings = append(mockRecordings, rec)
+		return persistence.GetStore().Save(persistence.RecordingsFile, mockRecordings)
+	}
+	_, err := coll.InsertOne(context.TODO(), rec)
+	return err
+}
+
+// finish stores the end, duration and size of a recording inserted at Start
+func finish(rec Recording) error {
+	coll := db.GetCollection("recordings")
+	if coll == nil {
+		loadMock()
+		mockMu.Lock()
+		defer mockMu.Unlock()
+		for i := range mockRecordings {
+			if mockRecordings[i].ID == rec.ID {
+				mockRecordings[i] = rec
+				return persistence.GetStore().Save(persistence.RecordingsFile, mockRecordings)
+			}
+		}
+		return ErrNotFound
+	}
+	_, err := coll.UpdateByID(context.TODO(), rec.ID, bson.M{"$set": bson.M{
+		"ended_at": rec.EndedAt,
+		"duration": rec.Duration,
+		"size":     rec.Size,
+	}})
+	return err
+}
+
+// List returns recordings, newest first, optionally filtered by user and device
+func List(user, deviceID string) ([]Recording, error) {
+	coll := db.GetCollection("recordings")
+	if coll == nil {
+		loadMock()
+		mockMu.Lock()
+		defer mockMu.Unlock()
+		list := []Recording{}
+		for _, rec := range mockRecordings {
+			if (user == "" || rec.User == user) && (deviceID == "" || rec.DeviceID == deviceID) {
+				list = append(list, rec)
+			}
+		}
+		sort.Slice(list, func(i, j int) bool { return list[i].StartedAt.After(list[j].StartedAt) })
+		return list, nil
+	}
+
+	filter := bson.M{}
+	if user != "" {
+		filter["user"] = user
+	}
+	if deviceID != "" {
+		filter["device_id"] = deviceID
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "started_at", Value: -1}}).SetLimit(500)
+	cursor, err := coll.Find(context.TODO(), filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	list := []Recording{}
+	if err := cursor.All(context.TODO(), &list); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+// Get returns the metadata of one recording
+func Get(id string) (Recording, error) {
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return Recording{}, ErrNotFound
+	}
+
+	coll := db.GetCollection("recordings")
+	if coll == nil {
+		loadMock()
+		mockMu.Lock()
+		defer mockMu.Unlock()
+		for _, rec := range mockRecordings {
+			if rec.ID == objID {
+				return rec, nil
+			}
+		}
+		return Recording{}, ErrNotFound
+	}
+
+	var rec Recording
+	if err := coll.FindOne(context.TODO(), bson.M{"_id": objID}).Decode(&rec); err != nil {
+		return Recording{}, ErrNotFound
+	}
+	return rec, nil
+}
+
+// Event is one parsed asciicast line: [time, type, data]
+type Event struct {
+	Time float64 `json:"time"`
+	Type string  `json:"type"` // o, i, r
+	Data string  `json:"data"`
+}
+
+// Load parses a recording file for replay
+func Load(rec Recording) (Header, []Event, error) {
+	var header Header
+	events := []Event{}
+
+	f, err := os.Open(rec.Path)
+	if err != nil {
+		return header, nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+	first := true
+	for scanner.Scan() {
+		if first {
+			first = false
+			if err := json.Unmarshal(scanner.Bytes(), &header); err != nil {
+				return header, nil, fmt.Errorf("invalid asciicast header: %v", err)
+			}
+			continue
+		}
+		var raw []interface{}
+		if err := json.Unmarshal(scanner.Bytes(), &raw); err != nil || len(raw) != 3 {
+			continue
+		}
+		t, _ := raw[0].(float64)
+		kind, _ := raw[1].(string)
+		data, _ := raw[2].(string)
+		events = append(events, Event{Time: t, Type: kind, Data: data})
+	}
+	return header, events, scanner.Err()
+}
@@ -0,0 +1,382 @@
+package secrets
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Envelope encryption for device credentials.
+//
+// Every secret gets its own random data key (DEK). The secret is sealed with
+// the DEK and the DEK is wrapped with the master key. Stored format:
+//
+//	enc:v1:<master key id>:<base64 wrapped DEK>:<base64 sealed secret>
+//
+// Rotating the master key only re-wraps the DEKs; the sealed data is untouched.
+//
+// Master keys come from MIKROMON_MASTER_KEY (base64, 32 bytes) and/or the key
+// file (MIKROMON_MASTER_KEY_FILE, default data/master.key, one base64 key per
+// line). The last key loaded is the primary used for new secrets; the others
+// are kept only to decrypt older values. The environment key is never written
+// to the key file unless the file already held it.
+
+const (
+	prefix         = "enc:v1:"
+	keySize        = 32
+	defaultKeyFile = "data/master.key"
+)
+
+var (
+	ErrUnknownKey = errors.New("secret sealed with an unknown master key")
+	ErrMalformed  = errors.New("malformed sealed secret")
+)
+
+type keyring struct {
+	mu      sync.RWMutex
+	keys    map[string][]byte
+	order   []string // load order, last is primary
+	primary string
+	file    string
+	envKey  string // ID of MIKROMON_MASTER_KEY when the key file does not hold it
+}
+
+var (
+	ring     *keyring
+	ringOnce sync.Once
+	ringErr  error
+)
+
+func getRing() (*keyring, error) {
+	ringOnce.Do(func() {
+		ring, ringErr = loadKeyring()
+	})
+	return ring, ringErr
+}
+
+func keyID(key []byte) string {
+	sum := sha256.Sum256(key)
+	return hex.EncodeToString(sum[:4])
+}
+
+func decodeKey(s string) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(s))
+	if err != nil {
+		return nil, fmt.Errorf("master key is not valid base64: %v", err)
+	}
+	if len(key) != keySize {
+		return nil, fmt.Errorf("master key must be %d bytes, got %d", keySize, len(key))
+	}
+	return key, nil
+}
+
+func (k *keyring) add(key []byte) string {
+	id := keyID(key)
+	if _, ok := k.keys[id]; !ok {
+		k.order = append(k.order, id)
+	}
+	k.keys[id] = key
+	k.primary = id
+	return id
+}
+
+func loadKeyring() (*keyring, error) {
+	k := &keyring{keys: make(map[string][]byte)}
+
+	k.file = os.Getenv("MIKROMON_MASTER_KEY_FILE")
+	if k.file == "" {
+		k.file = defaultKeyFile
+	}
+
+	if env := os.Getenv("MIKROMON_MASTER_KEY"); env != "" {
+		key, err := decodeKey(env)
+		if err != nil {
+			return nil, err
+		}
+		k.envKey = k.add(key)
+	}
+
+	data, err := os.ReadFile(k.file)
+	if err == nil {
+		for _, line := range strings.Split(string(data), "\n") {
+			if strings.TrimSpace(line) == "" {
+				continue
+			}
+			key, err := decodeKey(line)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", k.file, err)
+			}
+			if k.add(key) == k.envKey {
+				k.envKey = ""
+			}
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read master key file: %v", err)
+	}
+
+	if len(k.keys) == 0 {
+		// First start: generate a key so credentials are never stored in clear
+		key := make([]byte, keySize)
+		if _, err := rand.Read(key); err != nil {
+			return nil, err
+		}
+		k.add(key)
+		if err := k.persist(); err != nil {
+			return nil, err
+		}
+		log.Printf("Secrets: generated new master key in %s. Back it up, device credentials cannot be recovered without it.", k.file)
+	}
+
+	return k, nil
+}
+
+// persist writes the keyring to the key file, primary last, leaving out the
+// environment key. Caller holds the lock (or owns k).
+func (k *keyring) persist() error {
+	if err := os.MkdirAll(filepath.Dir(k.file), 0700); err != nil {
+		return err
+	}
+	var sb strings.Builder
+	for _, id := range k.order {
+		if id == k.envKey {
+			continue
+		}
+		sb.WriteString(base64.StdEncoding.EncodeToString(k.keys[id]))
+		sb.WriteString("\n")
+	}
+	tmp := k.file + ".tmp"
+	if err := os.WriteFile(tmp, []byte(sb.String()), 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, k.file)
+}
+
+func seal(key, plain []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, plain, nil), nil
+}
+
+func open(key, sealed []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return nil, ErrMalformed
+	}
+	return gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], nil)
+}
+
+type envelope struct {
+	keyID      string
+	wrappedDEK []byte
+	data       []byte
+}
+
+func parse(s string) (*envelope, error) {
+	parts := strings.Split(strings.TrimPrefix(s, prefix), ":")
+	if len(parts) != 3 {
+		return nil, ErrMalformed
+	}
+	wrapped, err := base64.RawStdEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, ErrMalformed
+	}
+	data, err := base64.RawStdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrMalformed
+	}
+	return &envelope{keyID: parts[0], wrappedDEK: wrapped, data: data}, nil
+}
+
+func (e *envelope) String() string {
+	return prefix + e.keyID + ":" +
+		base64.RawStdEncoding.EncodeToString(e.wrappedDEK) + ":" +
+		base64.RawStdEncoding.EncodeToString(e.data)
+}
+
+// IsSealed reports whether s was produced by Seal
+func IsSealed(s string) bool {
+	return strings.HasPrefix(s, prefix)
+}
+
+// Seal encrypts a secret under the primary master key. Empty stays empty.
+func Seal(plain string) (string, error) {
+	if plain == "" || IsSealed(plain) {
+		return plain, nil
+	}
+	k, err := getRing()
+	if err != nil {
+		return "", err
+	}
+	k.mu.RLock()
+	id, master := k.primary, k.keys[k.primary]
+	k.mu.RUnlock()
+
+	dek := make([]byte, keySize)
+	if _, err := rand.Read(dek); err != nil {
+		return "", err
+	}
+	data, err := seal(dek, []byte(plain))
+	if err != nil {
+		return "", err
+	}
+	wrapped, err := seal(master, dek)
+	if err != nil {
+		return "", err
+	}
+	return (&envelope{keyID: id, wrappedDEK: wrapped, data: data}).String(), nil
+}
+
+// unwrap returns the DEK of an envelope
+func unwrap(k *keyring, e *envelope) ([]byte, error) {
+	k.mu.RLock()
+	master, ok := k.keys[e.keyID]
+	k.mu.RUnlock()
+	if !ok {
+		return nil, ErrUnknownKey
+	}
+	return open(master, e.wrappedDEK)
+}
+
+// Open decrypts a sealed secret. Values that were never sealed (legacy
+// plaintext, or passwords typed in the browser) are returned unchanged.
+func Open(s string) (string, error) {
+	if !IsSealed(s) {
+		return s, nil
+	}
+	k, err := getRing()
+	if err != nil {
+		return "", err
+	}
+	e, err := parse(s)
+	if err != nil {
+		return "", err
+	}
+	dek, err := unwrap(k, e)
+	if err != nil {
+		return "", fmt.Errorf("failed to unwrap data key: %v", err)
+	}
+	plain, err := open(dek, e.data)
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt secret: %v", err)
+	}
+	return string(plain), nil
+}
+
+// Rewrap re-wraps the data key of s under the current primary key.
+// Plaintext values are sealed.
+func Rewrap(s string) (string, error) {
+	if s == "" {
+		return s, nil
+	}
+	if !IsSealed(s) {
+		return Seal(s)
+	}
+	k, err := getRing()
+	if err != nil {
+		return "", err
+	}
+	e, err := parse(s)
+	if err != nil {
+		return "", err
+	}
+
+	k.mu.RLock()
+	primaryID, primary := k.primary, k.keys[k.primary]
+	k.mu.RUnlock()
+	if e.keyID == primaryID {
+		return s, nil
+	}
+
+	dek, err := unwrap(k, e)
+	if err != nil {
+		return "", err
+	}
+	wrapped, err := seal(primary, dek)
+	if err != nil {
+		return "", err
+	}
+	e.keyID, e.wrappedDEK = primaryID, wrapped
+	return e.String(), nil
+}
+
+// PrimaryKeyID returns the ID of the key used for new secrets
+func PrimaryKeyID() (string, error) {
+	k, err := getRing()
+	if err != nil {
+		return "", err
+	}
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return k.primary, nil
+}
+
+// BeginRotation installs a new primary master key (random when newKey is
+// empty) while keeping the old ones for decryption, and persists the keyring.
+func BeginRotation(newKey string) (string, error) {
+	k, err := getRing()
+	if err != nil {
+		return "", err
+	}
+
+	var key []byte
+	if newKey != "" {
+		if key, err = decodeKey(newKey); err != nil {
+			return "", err
+		}
+	} else {
+		key = make([]byte, keySize)
+		if _, err := rand.Read(key); err != nil {
+			return "", err
+		}
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	id := k.add(key)
+	return id, k.persist()
+}
+
+// FinishRotation drops every key except the primary once all stored
+// secrets have been re-wrapped.
+func FinishRotation() error {
+	k, err := getRing()
+	if err != nil {
+		return err
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	k.keys = map[string][]byte{k.primary: k.keys[k.primary]}
+	k.order = []string{k.primary}
+	if os.Getenv("MIKROMON_MASTER_KEY") != "" {
+		log.Printf("Secrets: rotation finished, update MIKROMON_MASTER_KEY or remove it (key file %s now holds the primary key)", k.file)
+	}
+	return k.persist()
+}
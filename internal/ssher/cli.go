@@ -0,0 +1,232 @@
+package ssher
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CLI drives an interactive PTY shell like a human would: it types a command,
+// answers pagers and waits for the prompt. Needed for OLTs (Huawei, ZTE),
+// whose CLIs do not work through one-shot exec channels.
+
+// CLIOptions describes a vendor CLI
+type CLIOptions struct {
+	Prompt  *regexp.Regexp    // Matches the prompt at the end of the output
+	Pagers  map[string]string // Regex at the end of output -> reply (e.g. "--More--" -> " ")
+	Confirm *regexp.Regexp    // Yes/no question answered by RunConfirm
+	Init    []string          // Commands sent after login (enable, disable paging...)
+	Timeout time.Duration     // Per command
+}
+
+var ErrCLITimeout = errors.New("timeout waiting for CLI prompt")
+
+var ansiEscape = regexp.MustCompile(`\x1b\[[0-9;?]*[A-Za-z]|\x1b[78]`)
+
+type CLI struct {
+	in     io.Writer
+	close  func() error
+	opts   CLIOptions
+	pagers []cliPager
+
+	mu     sync.Mutex
+	buf    bytes.Buffer
+	notify chan struct{}
+	err    error
+	runMu  sync.Mutex
+}
+
+type cliPager struct {
+	re    *regexp.Regexp
+	reply string
+}
+
+// OpenCLI opens an interactive session and runs the init commands
+func (p *Pool) OpenCLI(user, password, host string, port int, useSSHKey bool, opts CLIOptions) (*CLI, error) {
+	shell, err := p.OpenShell(user, password, host, port, useSSHKey, "vt100", 200, 500)
+	if err != nil {
+		return nil, err
+	}
+
+	c := newCLI(shell.Stdin, shell.Close, opts, shell.Stdout, shell.Stderr)
+
+	// Wait for the banner and first prompt
+	if _, err := c.waitPrompt(nil); err != nil {
+		shell.Close()
+		return nil, fmt.Errorf("no CLI prompt after login: %v", err)
+	}
+	if err := c.init(); err != nil {
+		shell.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+func newCLI(in io.Writer, close func() error, opts CLIOptions, outputs ...io.Reader) *CLI {
+	if opts.Timeout == 0 {
+		opts.Timeout = 30 * time.Second
+	}
+	c := &CLI{in: in, close: close, opts: opts, notify: make(chan struct{}, 1)}
+	for expr, reply := range opts.Pagers {
+		c.pagers = append(c.pagers, cliPager{re: regexp.MustCompile(expr), reply: reply})
+	}
+	for _, out := range outputs {
+		go c.read(out)
+	}
+	return c
+}
+
+func (c *CLI) init() error {
+	for _, cmd := range c.opts.Init {
+		if _, err := c.Run(cmd); err != nil {
+			return fmt.Errorf("init command %q failed: %v", cmd, err)
+		}
+	}
+	return nil
+}
+
+func (c *CLI) read(src io.Reader) {
+	buf := make([]byte, 8192)
+	for {
+		n, err := src.Read(buf)
+		c.mu.Lock()
+		if n > 0 {
+			c.buf.Write(buf[:n])
+		}
+		if err != nil {
+			c.err = err
+		}
+		c.mu.Unlock()
+		select {
+		case c.notify <- struct{}{}:
+		default:
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+func (c *CLI) drain() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s := c.buf.String()
+	c.buf.Reset()
+	return s
+}
+
+// waitPrompt collects output until the prompt shows up, answering pagers
+// (and the confirm question when answer is not nil).
+func (c *CLI) waitPrompt(answer *string) (string, error) {
+	var out strings.Builder
+	deadline := time.NewTimer(c.opts.Timeout)
+	defer deadline.Stop()
+
+	for {
+		c.mu.Lock()
+		pending := c.buf.String()
+		readErr := c.err
+		c.mu.Unlock()
+
+		clean := cleanTerminal(pending)
+		tail := clean
+		if i := strings.LastIndex(clean, "\n"); i >= 0 {
+			tail = clean[i+1:]
+		}
+
+		switch {
+		case c.opts.Prompt.MatchString(tail) && tail != "":
+			c.drain()
+			out.WriteString(clean)
+			return out.String(), nil
+		case answer != nil && c.opts.Confirm != nil && c.opts.Confirm.MatchString(tail):
+			c.drain()
+			out.WriteString(clean)
+			c.in.Write([]byte(*answer + "\n"))
+			answer = nil
+			continue
+		}
+
+		handled := false
+		for _, pg := range c.pagers {
+			if loc := pg.re.FindStringIndex(tail); loc != nil {
+				c.drain()
+				out.WriteString(strings.TrimSuffix(clean, tail[loc[0]:]))
+				c.in.Write([]byte(pg.reply))
+				handled = true
+				break
+			}
+		}
+		if handled {
+			continue
+		}
+
+		if readErr != nil {
+			return out.String() + clean, readErr
+		}
+
+		select {
+		case <-c.notify:
+		case <-deadline.C:
+			return out.String() + clean, ErrCLITimeout
+		}
+	}
+}
+
+// cleanTerminal removes escape sequences and applies carriage returns, which
+// pagers use to overwrite their "More" line
+func cleanTerminal(s string) string {
+	s = ansiEscape.ReplaceAllString(strings.ReplaceAll(s, "\r\n", "\n"), "")
+	lines := strings.Split(s, "\n")
+	for i, l := range lines {
+		if j := strings.LastIndexByte(l, '\r'); j >= 0 {
+			lines[i] = l[j+1:]
+			if strings.TrimSpace(lines[i]) == "" {
+				lines[i] = ""
+			}
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+func (c *CLI) exec(cmd string, answer *string) (string, error) {
+	c.runMu.Lock()
+	defer c.runMu.Unlock()
+
+	c.drain()
+	if _, err := c.in.Write([]byte(cmd + "\n")); err != nil {
+		return "", err
+	}
+	raw, err := c.waitPrompt(answer)
+
+	// Strip the echoed command and the trailing prompt
+	lines := strings.Split(raw, "\n")
+	if len(lines) > 0 && strings.Contains(lines[0], strings.TrimSpace(cmd)) {
+		lines = lines[1:]
+	}
+	if len(lines) > 0 && c.opts.Prompt.MatchString(lines[len(lines)-1]) {
+		lines = lines[:len(lines)-1]
+	}
+	return strings.Join(lines, "\n"), err
+}
+
+// Run types cmd and returns its output
+func (c *CLI) Run(cmd string) (string, error) {
+	return c.exec(cmd, nil)
+}
+
+// RunConfirm types cmd and answers "y" to the vendor's confirmation question
+func (c *CLI) RunConfirm(cmd string) (string, error) {
+	yes := "y"
+	return c.exec(cmd, &yes)
+}
+
+// Close ends the CLI session
+func (c *CLI) Close() error {
+	return c.close()
+}
@@ -0,0 +1,262 @@
+package ssher
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"mikromon/internal/db"
+	"mikromon/internal/persistence"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"golang.org/x/crypto/ssh"
+)
+
+// Trust-on-first-use host key pinning.
+// The first key a device presents is recorded; any later different key is
+// rejected (and kept as "pending" so an admin can review and accept it).
+// Records are keyed by the "host:port" address used to dial the device, so
+// devices sharing an address share its pin. When a device goes away its pin
+// is forgotten (see ForgetHostKey), and a new device reusing the address
+// pins its own key instead of inheriting the old one.
+
+type KnownHost struct {
+	Host               string    `json:"host" bson:"host"` // host:port
+	KeyType            string    `json:"key_type" bson:"key_type"`
+	Fingerprint        string    `json:"fingerprint" bson:"fingerprint"` // SHA256:...
+	FirstSeen          time.Time `json:"first_seen" bson:"first_seen"`
+	LastSeen           time.Time `json:"last_seen" bson:"last_seen"`
+	PendingKeyType     string    `json:"pending_key_type,omitempty" bson:"pending_key_type,omitempty"`
+	PendingFingerprint string    `json:"pending_fingerprint,omitempty" bson:"pending_fingerprint,omitempty"`
+	PendingSeen        time.Time `json:"pending_seen,omitempty" bson:"pending_seen,omitempty"`
+}
+
+// HostKeyMismatchError is returned (wrapped by ssh.Dial) when a device
+// presents a key different from the pinned one.
+type HostKeyMismatchError struct {
+	Host      string
+	Expected  string
+	Presented string
+}
+
+func (e *HostKeyMismatchError) Error() string {
+	return fmt.Sprintf("host key mismatch for %s: expected %s, got %s (possible spoofed device; an admin must accept the new key)", e.Host, e.Expected, e.Presented)
+}
+
+var (
+	ErrHostKeyNotFound   = errors.New("no known host key for device")
+	ErrNoPendingHostKey  = errors.New("no pending host key to accept")
+	ErrPendingKeyChanged = errors.New("pending host key differs from the one reviewed")
+)
+
+type knownHostStore struct {
+	mu    sync.Mutex
+	hosts map[string]KnownHost // JSON mode cache
+}
+
+var (
+	hostStore     *knownHostStore
+	hostStoreOnce sync.Once
+)
+
+func getHostStore() *knownHostStore {
+	hostStoreOnce.Do(func() {
+		hostStore = &knownHostStore{hosts: make(map[string]KnownHost)}
+		var list []KnownHost
+		if err := persistence.GetStore().Load(persistence.KnownHostsFile, &list); err == nil {
+			for _, h := range list {
+				hostStore.hosts[h.Host] = h
+			}
+		}
+	})
+	return hostStore
+}
+
+// saveFile persists the JSON cache. Caller holds s.mu.
+func (s *knownHostStore) saveFile() {
+	list := make([]KnownHost, 0, len(s.hosts))
+	for _, h := range s.hosts {
+		list = append(list, h)
+	}
+	if err := persistence.GetStore().Save(persistence.KnownHostsFile, list); err != nil {
+		log.Printf("SSH: failed to save known hosts: %v", err)
+	}
+}
+
+// get returns the record of host. found is false only when there is none;
+// a lookup that fails says nothing about the host and returns the error.
+func (s *knownHostStore) get(host string) (KnownHost, bool, error) {
+	coll := db.GetCollection("known_hosts")
+	if coll == nil {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		h, ok := s.hosts[host]
+		return h, ok, nil
+	}
+
+	var h KnownHost
+	err := coll.FindOne(context.TODO(), bson.M{"host": host}).Decode(&h)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return KnownHost{}, false, nil
+	}
+	if err != nil {
+		return KnownHost{}, false, err
+	}
+	return h, true, nil
+}
+
+func (s *knownHostStore) put(h KnownHost) {
+	coll := db.GetCollection("known_hosts")
+	if coll == nil {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.hosts[h.Host] = h
+		s.saveFile()
+		return
+	}
+
+	opts := options.Replace().SetUpsert(true)
+	if _, err := coll.ReplaceOne(context.TODO(), bson.M{"host": h.Host}, h, opts); err != nil {
+		log.Printf("SSH: failed to save known host %s: %v", h.Host, err)
+	}
+}
+
+func (s *knownHostStore) remove(host string) bool {
+	coll := db.GetCollection("known_hosts")
+	if coll == nil {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if _, ok := s.hosts[host]; !ok {
+			return false
+		}
+		delete(s.hosts, host)
+		s.saveFile()
+		return true
+	}
+
+	res, err := coll.DeleteOne(context.TODO(), bson.M{"host": host})
+	return err == nil && res.DeletedCount > 0
+}
+
+// hostKeyCallback implements TOFU verification for ssh.ClientConfig
+func hostKeyCallback(hostport string, _ net.Addr, key ssh.PublicKey) error {
+	s := getHostStore()
+	fp := ssh.FingerprintSHA256(key)
+	now := time.Now()
+
+	known, ok, err := s.get(hostport)
+	if err != nil {
+		// Pinning now could replace a key we merely failed to read
+		log.Printf("SSH: cannot verify host key of %s: %v", hostport, err)
+		return fmt.Errorf("cannot verify host key of %s: %v", hostport, err)
+	}
+	if !ok {
+		log.Printf("SSH: pinning new host key for %s (%s %s)", hostport, key.Type(), fp)
+		s.put(KnownHost{Host: hostport, KeyType: key.Type(), Fingerprint: fp, FirstSeen: now, LastSeen: now})
+		return nil
+	}
+
+	if known.Fingerprint == fp {
+		// Refresh at most hourly to avoid a write per connection
+		if now.Sub(known.LastSeen) > time.Hour {
+			known.LastSeen = now
+			s.put(known)
+		}
+		return nil
+	}
+
+	log.Printf("SSH: HOST KEY MISMATCH for %s: pinned %s, presented %s", hostport, known.Fingerprint, fp)
+	known.PendingKeyType, known.PendingFingerprint, known.PendingSeen = key.Type(), fp, now
+	s.put(known)
+	return &HostKeyMismatchError{Host: hostport, Expected: known.Fingerprint, Presented: fp}
+}
+
+// HostAddr builds the key used by the known hosts store (same form as the dial address)
+func HostAddr(host string, port int) string {
+	if port == 0 {
+		port = 22
+	}
+	return fmt.Sprintf("%s:%d", host, port)
+}
+
+// ListKnownHosts returns every pinned host key
+func ListKnownHosts() ([]KnownHost, error) {
+	coll := db.GetCollection("known_hosts")
+	if coll == nil {
+		s := getHostStore()
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		list := make([]KnownHost, 0, len(s.hosts))
+		for _, h := range s.hosts {
+			list = append(list, h)
+		}
+		return list, nil
+	}
+
+	var list []KnownHost
+	cursor, err := coll.Find(context.TODO(), bson.M{})
+	if err != nil {
+		return nil, err
+	}
+	if err := cursor.All(context.TODO(), &list); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+// GetKnownHost returns the record for host:port
+func GetKnownHost(hostport string) (KnownHost, bool) {
+	h, ok, err := getHostStore().get(hostport)
+	return h, ok && err == nil
+}
+
+// AcceptHostKey promotes the pending fingerprint to the pinned one.
+// fingerprint is the pending key the admin reviewed; if the device has since
+// presented another one, nothing is accepted.
+func AcceptHostKey(hostport, fingerprint string) (KnownHost, error) {
+	s := getHostStore()
+	known, ok, err := s.get(hostport)
+	if err != nil {
+		return KnownHost{}, err
+	}
+	if !ok {
+		return KnownHost{}, ErrHostKeyNotFound
+	}
+	if known.PendingFingerprint == "" {
+		return KnownHost{}, ErrNoPendingHostKey
+	}
+	if known.PendingFingerprint != fingerprint {
+		return KnownHost{}, ErrPendingKeyChanged
+	}
+
+	known.KeyType, known.Fingerprint = known.PendingKeyType, known.PendingFingerprint
+	known.FirstSeen, known.LastSeen = time.Now(), time.Now()
+	known.PendingKeyType, known.PendingFingerprint, known.PendingSeen = "", "", time.Time{}
+	s.put(known)
+
+	GetPool().invalidateHost(hostport)
+	return known, nil
+}
+
+// ResetHostKey forgets the pinned key; the next connection pins again
+func ResetHostKey(hostport string) error {
+	if !getHostStore().remove(hostport) {
+		return ErrHostKeyNotFound
+	}
+	GetPool().invalidateHost(hostport)
+	return nil
+}
+
+// ForgetHostKey drops the pin of an address no device uses any more
+func ForgetHostKey(hostport string) {
+	if getHostStore().remove(hostport) {
+		log.Printf("SSH: forgot host key for %s", hostport)
+		GetPool().invalidateHost(hostport)
+	}
+}
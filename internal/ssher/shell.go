@@ -0,0 +1,94 @@
+package ssher
+
+import (
+	"fmt"
+	"io"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// Shell is an interactive PTY session opened on a pooled connection.
+// The underlying ssh.Client stays in the pool; only the session is closed.
+type Shell struct {
+	session *ssh.Session
+	Stdin   io.WriteCloser
+	Stdout  io.Reader
+	Stderr  io.Reader
+
+	closeOnce sync.Once
+}
+
+// OpenShell allocates a PTY and starts the login shell on host
+func (p *Pool) OpenShell(user, password, host string, port int, useSSHKey bool, term string, cols, rows int) (*Shell, error) {
+	if term == "" {
+		term = "xterm-256color"
+	}
+	if cols <= 0 {
+		cols = 80
+	}
+	if rows <= 0 {
+		rows = 24
+	}
+
+	session, err := p.newSession(user, password, host, port, useSSHKey)
+	if err != nil {
+		return nil, err
+	}
+
+	modes := ssh.TerminalModes{
+		ssh.ECHO:          1,
+		ssh.TTY_OP_ISPEED: 14400,
+		ssh.TTY_OP_OSPEED: 14400,
+	}
+	if err := session.RequestPty(term, rows, cols, modes); err != nil {
+		session.Close()
+		return nil, fmt.Errorf("failed to allocate pty: %v", err)
+	}
+
+	stdin, err := session.StdinPipe()
+	if err != nil {
+		session.Close()
+		return nil, err
+	}
+	stdout, err := session.StdoutPipe()
+	if err != nil {
+		session.Close()
+		return nil, err
+	}
+	stderr, err := session.StderrPipe()
+	if err != nil {
+		session.Close()
+		return nil, err
+	}
+
+	if err := session.Shell(); err != nil {
+		session.Close()
+		return nil, fmt.Errorf("failed to start shell: %v", err)
+	}
+
+	return &Shell{session: session, Stdin: stdin, Stdout: stdout, Stderr: stderr}, nil
+}
+
+// Resize informs the remote PTY of the new terminal size
+func (s *Shell) Resize(cols, rows int) error {
+	if cols <= 0 || rows <= 0 {
+		return nil
+	}
+	return s.session.WindowChange(rows, cols)
+}
+
+// Wait blocks until the remote shell exits
+func (s *Shell) Wait() error {
+	return s.session.Wait()
+}
+
+// Close ends the session (safe to call more than once)
+func (s *Shell) Close() error {
+	var err error
+	s.closeOnce.Do(func() {
+		s.Stdin.Close()
+		err = s.session.Close()
+	})
+	return err
+}
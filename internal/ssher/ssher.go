@@ -6,9 +6,12 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+	"strings"
 	"sync"
 	"time"
 
+	"mikromon/internal/secrets"
+
 	"github.com/pkg/sftp"
 	"golang.org/x/crypto/ssh"
 )
@@ -71,13 +74,18 @@ func (p *Pool) GetClient(user, password, host string, port int, useSSHKey bool)
 		}
 		auth = append(auth, ssh.PublicKeys(signer))
 	} else {
-		auth = append(auth, ssh.Password(password))
+		// Device passwords are stored sealed; this is the only place they are decrypted
+		plain, err := secrets.Open(password)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt device credentials: %v", err)
+		}
+		auth = append(auth, ssh.Password(plain))
 	}
 
 	config := &ssh.ClientConfig{
 		User:            user,
 		Auth:            auth,
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		HostKeyCallback: hostKeyCallback,
 		Timeout:         5 * time.Second,
 		Config: ssh.Config{
 			KeyExchanges: []string{
@@ -95,7 +103,7 @@ func (p *Pool) GetClient(user, password, host string, port int, useSSHKey bool)
 		},
 	}
 
-	addr := fmt.Sprintf("%s:%d", host, port)
+	addr := HostAddr(host, port)
 	client, err := ssh.Dial("tcp", addr, config)
 	if err != nil {
 		return nil, err
@@ -105,11 +113,24 @@ func (p *Pool) GetClient(user, password, host string, port int, useSSHKey bool)
 	return client, nil
 }
 
-// RunCommand executes a command on the remote host using the pool
-func (p *Pool) RunCommand(user, password, host string, port int, useSSHKey bool, cmd string) (string, error) {
+// invalidateHost drops pooled connections to host:port (e.g. after a host key change)
+func (p *Pool) invalidateHost(hostport string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for key, client := range p.clients {
+		if strings.Contains(key, "@"+hostport+"[") {
+			client.Close()
+			delete(p.clients, key)
+		}
+	}
+}
+
+// newSession opens a session on the pooled client, redialing once if the
+// cached connection turned out to be dead.
+func (p *Pool) newSession(user, password, host string, port int, useSSHKey bool) (*ssh.Session, error) {
 	client, err := p.GetClient(user, password, host, port, useSSHKey)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
 	session, err := client.NewSession()
@@ -131,13 +152,22 @@ func (p *Pool) RunCommand(user, password, host string, port int, useSSHKey bool,
 		// Retry once
 		client, err = p.GetClient(user, password, host, port, useSSHKey)
 		if err != nil {
-			return "", fmt.Errorf("retry dial failed: %v", err)
+			return nil, fmt.Errorf("retry dial failed: %w", err)
 		}
 		session, err = client.NewSession()
 		if err != nil {
-			return "", fmt.Errorf("retry session failed: %v", err)
+			return nil, fmt.Errorf("retry session failed: %v", err)
 		}
 	}
+	return session, nil
+}
+
+// RunCommand executes a command on the remote host using the pool
+func (p *Pool) RunCommand(user, password, host string, port int, useSSHKey bool, cmd string) (string, error) {
+	session, err := p.newSession(user, password, host, port, useSSHKey)
+	if err != nil {
+		return "", err
+	}
 	defer session.Close()
 
 	var stdoutBuf, stderrBuf bytes.Buffer
@@ -195,3 +225,55 @@ func (p *Pool) DownloadFile(user, password, host string, port int, useSSHKey boo
 
 	return nil
 }
+
+// UploadFile copies a local file to the remote host over SFTP
+func (p *Pool) UploadFile(user, password, host string, port int, useSSHKey bool, localPath, remotePath string) error {
+	client, err := p.GetClient(user, password, host, port, useSSHKey)
+	if err != nil {
+		return err
+	}
+
+	to, err := sftp.NewClient(client)
+	if err != nil {
+		return fmt.Errorf("failed to create sftp client: %v", err)
+	}
+	defer to.Close()
+
+	srcFile, err := os.Open(localPath)
+	if err != nil {
+		return fmt.Errorf("failed to open local file %s: %v", localPath, err)
+	}
+	defer srcFile.Close()
+
+	dstFile, err := to.Create(remotePath)
+	if err != nil {
+		return fmt.Errorf("failed to create remote file %s: %v", remotePath, err)
+	}
+	if _, err := dstFile.ReadFrom(srcFile); err != nil {
+		dstFile.Close()
+		return fmt.Errorf("failed to copy file content: %v", err)
+	}
+	if err := dstFile.Close(); err != nil {
+		return fmt.Errorf("failed to close remote file %s: %v", remotePath, err)
+	}
+	return nil
+}
+
+// RemoveFile deletes a file from the remote host over SFTP
+func (p *Pool) RemoveFile(user, password, host string, port int, useSSHKey bool, remotePath string) error {
+	client, err := p.GetClient(user, password, host, port, useSSHKey)
+	if err != nil {
+		return err
+	}
+
+	sc, err := sftp.NewClient(client)
+	if err != nil {
+		return fmt.Errorf("failed to create sftp client: %v", err)
+	}
+	defer sc.Close()
+
+	if err := sc.Remove(remotePath); err != nil {
+		return fmt.Errorf("failed to remove remote file %s: %v", remotePath, err)
+	}
+	return nil
+}
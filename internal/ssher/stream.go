@@ -0,0 +1,86 @@
+package ssher
+
+import (
+	"context"
+	"io"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// Chunk is a piece of command output as it arrives from the device
+type Chunk struct {
+	Stream string `json:"stream"` // stdout, stderr
+	Data   []byte `json:"data"`
+}
+
+const (
+	StreamStdout = "stdout"
+	StreamStderr = "stderr"
+)
+
+// StreamCommand runs cmd and hands every output chunk to onChunk as soon as
+// it is read (calls are serialized). Cancelling ctx signals and closes the
+// remote command; the returned error is then ctx.Err().
+func (p *Pool) StreamCommand(ctx context.Context, user, password, host string, port int, useSSHKey bool, cmd string, onChunk func(Chunk)) error {
+	session, err := p.newSession(user, password, host, port, useSSHKey)
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+
+	stdout, err := session.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	stderr, err := session.StderrPipe()
+	if err != nil {
+		return err
+	}
+
+	if err := session.Start(cmd); err != nil {
+		return err
+	}
+
+	var emitMu sync.Mutex
+	var wg sync.WaitGroup
+	pump := func(name string, src io.Reader) {
+		defer wg.Done()
+		buf := make([]byte, 4096)
+		for {
+			n, err := src.Read(buf)
+			if n > 0 {
+				data := make([]byte, n)
+				copy(data, buf[:n])
+				emitMu.Lock()
+				onChunk(Chunk{Stream: name, Data: data})
+				emitMu.Unlock()
+			}
+			if err != nil {
+				return
+			}
+		}
+	}
+	wg.Add(2)
+	go pump(StreamStdout, stdout)
+	go pump(StreamStderr, stderr)
+
+	// Stop the remote side when the caller goes away
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+			session.Signal(ssh.SIGINT)
+			session.Close()
+		case <-stop:
+		}
+	}()
+
+	wg.Wait()
+	err = session.Wait()
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
+}
@@ -0,0 +1,170 @@
+package ssher
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"mikromon/internal/secrets"
+)
+
+// Telnet transport for OLTs that have SSH disabled (common on older ZTE and
+// Huawei boxes). Only what a CLI session needs is implemented: option
+// negotiation is refused except echo and suppress-go-ahead.
+
+const (
+	telnetIAC  = 255
+	telnetDONT = 254
+	telnetDO   = 253
+	telnetWONT = 252
+	telnetWILL = 251
+	telnetSB   = 250
+	telnetSE   = 240
+
+	telnetOptEcho = 1
+	telnetOptSGA  = 3
+)
+
+var telnetLogin = regexp.MustCompile(`(?i)(user\s*name|login|username)\s*:\s*$|(?i)password\s*:\s*$`)
+
+// telnetConn strips protocol commands from the byte stream and answers them
+type telnetConn struct {
+	conn net.Conn
+	r    *bufio.Reader
+	wmu  sync.Mutex
+}
+
+func (t *telnetConn) reply(cmd, opt byte) {
+	t.wmu.Lock()
+	t.conn.Write([]byte{telnetIAC, cmd, opt})
+	t.wmu.Unlock()
+}
+
+func (t *telnetConn) Read(p []byte) (int, error) {
+	n := 0
+	for n == 0 {
+		b, err := t.r.ReadByte()
+		if err != nil {
+			return n, err
+		}
+		if b != telnetIAC {
+			p[n] = b
+			n++
+			// Take whatever else is already buffered
+			for n < len(p) && t.r.Buffered() > 0 {
+				if next, _ := t.r.Peek(1); next[0] == telnetIAC {
+					break
+				}
+				p[n], _ = t.r.ReadByte()
+				n++
+			}
+			continue
+		}
+
+		cmd, err := t.r.ReadByte()
+		if err != nil {
+			return n, err
+		}
+		switch cmd {
+		case telnetIAC: // Escaped 0xFF
+			p[n] = telnetIAC
+			n++
+		case telnetDO, telnetDONT, telnetWILL, telnetWONT:
+			opt, err := t.r.ReadByte()
+			if err != nil {
+				return n, err
+			}
+			switch {
+			case cmd == telnetDO && opt == telnetOptSGA:
+				t.reply(telnetWILL, opt)
+			case cmd == telnetDO:
+				t.reply(telnetWONT, opt)
+			case cmd == telnetWILL && (opt == telnetOptEcho || opt == telnetOptSGA):
+				t.reply(telnetDO, opt)
+			case cmd == telnetWILL:
+				t.reply(telnetDONT, opt)
+			}
+		case telnetSB:
+			// Skip subnegotiation up to IAC SE
+			for {
+				b, err := t.r.ReadByte()
+				if err != nil {
+					return n, err
+				}
+				if b == telnetIAC {
+					if se, _ := t.r.ReadByte(); se == telnetSE {
+						break
+					}
+				}
+			}
+		}
+	}
+	return n, nil
+}
+
+// Write sends NVT line endings and escapes 0xFF
+func (t *telnetConn) Write(p []byte) (int, error) {
+	out := bytes.ReplaceAll(p, []byte{telnetIAC}, []byte{telnetIAC, telnetIAC})
+	out = bytes.ReplaceAll(out, []byte("\n"), []byte("\r\n"))
+	t.wmu.Lock()
+	defer t.wmu.Unlock()
+	if _, err := t.conn.Write(out); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// OpenTelnetCLI logs into a device over Telnet and runs the init commands.
+// password may be sealed (secrets.Seal).
+func OpenTelnetCLI(user, password, host string, port int, opts CLIOptions) (*CLI, error) {
+	if port == 0 {
+		port = 23
+	}
+	plain, err := secrets.Open(password)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt device password: %v", err)
+	}
+
+	conn, err := net.DialTimeout("tcp", HostAddr(host, port), 5*time.Second)
+	if err != nil {
+		return nil, fmt.Errorf("failed to dial: %v", err)
+	}
+	tc := &telnetConn{conn: conn, r: bufio.NewReader(conn)}
+	c := newCLI(tc, conn.Close, opts, tc)
+
+	// Answer the login questions until the CLI prompt shows up
+	prompt := c.opts.Prompt
+	c.opts.Prompt = regexp.MustCompile(telnetLogin.String() + "|" + prompt.String())
+	for i := 0; ; i++ {
+		out, err := c.waitPrompt(nil)
+		if err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("no CLI prompt after login: %v", err)
+		}
+		tail := out[strings.LastIndex(out, "\n")+1:]
+		if !telnetLogin.MatchString(tail) {
+			break
+		}
+		if i > 3 {
+			conn.Close()
+			return nil, fmt.Errorf("telnet login to %s failed", host)
+		}
+		if strings.Contains(strings.ToLower(tail), "password") {
+			tc.Write([]byte(plain + "\n"))
+		} else {
+			tc.Write([]byte(user + "\n"))
+		}
+	}
+	c.opts.Prompt = prompt
+
+	if err := c.init(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return c, nil
+}
@@ -0,0 +1,325 @@
+package syslog
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Parser for RFC 5424 and RFC 3164 (BSD) syslog messages. Network gear is
+// loose with both, so anything that does not fit is kept as a raw message
+// with the RFC 3164 defaults rather than rejected.
+
+const (
+	FormatRFC5424 = "rfc5424"
+	FormatRFC3164 = "rfc3164"
+	FormatRaw     = "raw"
+)
+
+// Defaults for a message without PRI (RFC 3164 section 4.3.3)
+const (
+	defaultFacility = 1 // user
+	defaultSeverity = 5 // notice
+)
+
+var severityNames = []string{"emerg", "alert", "crit", "err", "warning", "notice", "info", "debug"}
+
+var facilityNames = []string{
+	"kern", "user", "mail", "daemon", "auth", "syslog", "lpr", "news",
+	"uucp", "cron", "authpriv", "ftp", "ntp", "security", "console", "solaris-cron",
+	"local0", "local1", "local2", "local3", "local4", "local5", "local6", "local7",
+}
+
+// Message is a parsed syslog message
+type Message struct {
+	Format         string
+	Facility       int
+	Severity       int
+	Timestamp      time.Time // Zero when the sender did not set one
+	Hostname       string
+	AppName        string
+	ProcID         string
+	MsgID          string
+	StructuredData map[string]map[string]string // SD-ID -> param -> value
+	Message        string
+}
+
+// SeverityName returns the keyword of a severity code ("err", "warning"...)
+func SeverityName(code int) string {
+	if code < 0 || code >= len(severityNames) {
+		return ""
+	}
+	return severityNames[code]
+}
+
+// ParseSeverity accepts a keyword (and the usual aliases) or a code
+func ParseSeverity(s string) (int, bool) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	switch s {
+	case "emergency", "panic":
+		return 0, true
+	case "critical":
+		return 2, true
+	case "error":
+		return 3, true
+	case "warn":
+		return 4, true
+	case "informational":
+		return 6, true
+	}
+	for i, n := range severityNames {
+		if n == s {
+			return i, true
+		}
+	}
+	if n, err := strconv.Atoi(s); err == nil && n >= 0 && n < len(severityNames) {
+		return n, true
+	}
+	return 0, false
+}
+
+// FacilityName returns the keyword of a facility code ("local7"...)
+func FacilityName(code int) string {
+	if code < 0 || code >= len(facilityNames) {
+		return ""
+	}
+	return facilityNames[code]
+}
+
+// ParseFacility accepts a keyword or a code
+func ParseFacility(s string) (int, bool) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	for i, n := range facilityNames {
+		if n == s {
+			return i, true
+		}
+	}
+	if n, err := strconv.Atoi(s); err == nil && n >= 0 && n < len(facilityNames) {
+		return n, true
+	}
+	return 0, false
+}
+
+// Parse decodes one message. now is used to complete RFC 3164 timestamps,
+// which carry no year.
+func Parse(data []byte, now time.Time) Message {
+	s := strings.TrimRight(string(data), "\r\n\x00")
+	m := Message{Format: FormatRaw, Facility: defaultFacility, Severity: defaultSeverity}
+
+	pri, rest, ok := parsePRI(s)
+	if !ok {
+		m.Message = s
+		return m
+	}
+	m.Facility, m.Severity = pri/8, pri%8
+
+	// RFC 5424 has a version right after PRI: "<34>1 2003-10-11T22:14:15.003Z ..."
+	if len(rest) > 1 && rest[0] >= '1' && rest[0] <= '9' && rest[1] == ' ' {
+		if parse5424(&m, rest[2:]) {
+			return m
+		}
+	}
+	parse3164(&m, rest, now)
+	return m
+}
+
+func parsePRI(s string) (int, string, bool) {
+	if len(s) < 3 || s[0] != '<' {
+		return 0, s, false
+	}
+	end := strings.IndexByte(s, '>')
+	if end < 2 || end > 4 {
+		return 0, s, false
+	}
+	pri, err := strconv.Atoi(s[1:end])
+	if err != nil || pri < 0 || pri > 191 {
+		return 0, s, false
+	}
+	return pri, s[end+1:], true
+}
+
+func nilValue(s string) string {
+	if s == "-" {
+		return ""
+	}
+	return s
+}
+
+// parse5424 fills m from what follows "VERSION SP"
+func parse5424(m *Message, s string) bool {
+	fields := make([]string, 0, 5)
+	for len(fields) < 5 {
+		i := strings.IndexByte(s, ' ')
+		if i < 0 {
+			return false
+		}
+		fields = append(fields, s[:i])
+		s = s[i+1:]
+	}
+
+	if ts := fields[0]; ts != "-" {
+		t, err := time.Parse(time.RFC3339Nano, ts)
+		if err != nil {
+			return false
+		}
+		m.Timestamp = t
+	}
+	m.Hostname = nilValue(fields[1])
+	m.AppName = nilValue(fields[2])
+	m.ProcID = nilValue(fields[3])
+	m.MsgID = nilValue(fields[4])
+
+	if strings.HasPrefix(s, "-") {
+		s = s[1:]
+	} else if strings.HasPrefix(s, "[") {
+		sd, rest, ok := parseSD(s)
+		if !ok {
+			return false
+		}
+		m.StructuredData = sd
+		s = rest
+	} else {
+		return false
+	}
+
+	s = strings.TrimPrefix(s, " ")
+	s = strings.TrimPrefix(s, "\ufeff") // BOM before a UTF-8 MSG
+	m.Message = s
+	m.Format = FormatRFC5424
+	return true
+}
+
+// parseSD reads [id k="v" ...][id2 ...] and returns what follows it
+func parseSD(s string) (map[string]map[string]string, string, bool) {
+	sd := map[string]map[string]string{}
+	for strings.HasPrefix(s, "[") {
+		s = s[1:]
+		end := strings.IndexAny(s, " ]")
+		if end <= 0 {
+			return nil, "", false
+		}
+		params := map[string]string{}
+		sd[s[:end]] = params
+		s = s[end:]
+
+		for {
+			s = strings.TrimLeft(s, " ")
+			if s == "" {
+				return nil, "", false
+			}
+			if s[0] == ']' {
+				s = s[1:]
+				break
+			}
+			eq := strings.Index(s, "=\"")
+			if eq <= 0 {
+				return nil, "", false
+			}
+			name := s[:eq]
+			s = s[eq+2:]
+
+			var val strings.Builder
+			closed := false
+			for i := 0; i < len(s); i++ {
+				c := s[i]
+				if c == '\\' && i+1 < len(s) && (s[i+1] == '"' || s[i+1] == '\\' || s[i+1] == ']') {
+					val.WriteByte(s[i+1])
+					i++
+					continue
+				}
+				if c == '"' {
+					s = s[i+1:]
+					closed = true
+					break
+				}
+				val.WriteByte(c)
+			}
+			if !closed {
+				return nil, "", false
+			}
+			params[name] = val.String()
+		}
+	}
+	return sd, s, true
+}
+
+// BSD timestamps as sent by routers and OLTs: "Jan  2 15:04:05", sometimes
+// with a year or milliseconds, or already RFC 3339. Longest first.
+var bsdLayouts = []string{
+	"Jan _2 2006 15:04:05.000",
+	"Jan _2 2006 15:04:05",
+	"Jan _2 15:04:05.000",
+	"Jan _2 15:04:05",
+}
+
+// parse3164 fills m from what follows PRI. Hostname and tag are optional.
+func parse3164(m *Message, s string, now time.Time) {
+	m.Format = FormatRFC3164
+	s = strings.TrimLeft(s, " ")
+
+	// HOSTNAME follows the timestamp unless the next word is already the tag
+	// ("sshd[12]:"). Without a timestamp the first word is as likely the
+	// message as a hostname, so none is taken.
+	if t, rest, ok := parseBSDTime(s, now); ok {
+		m.Timestamp = t
+		s = rest
+		if word, rest, ok := nextWord(s); ok && !isTag(word) && rest != "" {
+			m.Hostname = word
+			s = rest
+		}
+	}
+	if word, rest, ok := nextWord(s); ok && isTag(word) {
+		tag := strings.TrimSuffix(word, ":")
+		if i := strings.IndexByte(tag, '['); i > 0 && strings.HasSuffix(tag, "]") {
+			m.ProcID = tag[i+1 : len(tag)-1]
+			tag = tag[:i]
+		}
+		m.AppName = tag
+		s = rest
+	}
+	m.Message = s
+}
+
+func parseBSDTime(s string, now time.Time) (time.Time, string, bool) {
+	// RFC 3339 as some senders use in BSD framing
+	if i := strings.IndexByte(s, ' '); i >= 19 && s[4] == '-' {
+		if t, err := time.Parse(time.RFC3339Nano, s[:i]); err == nil {
+			return t, s[i+1:], true
+		}
+	}
+	for _, layout := range bsdLayouts {
+		if len(s) < len(layout) {
+			continue
+		}
+		n := len(layout)
+		t, err := time.ParseInLocation(layout, s[:n], now.Location())
+		if err != nil {
+			continue
+		}
+		if t.Year() == 0 {
+			t = t.AddDate(now.Year(), 0, 0)
+			// A message from Dec 31 read on Jan 1
+			if t.After(now.Add(24 * time.Hour)) {
+				t = t.AddDate(-1, 0, 0)
+			}
+		}
+		return t, strings.TrimLeft(s[n:], " "), true
+	}
+	return time.Time{}, s, false
+}
+
+func nextWord(s string) (string, string, bool) {
+	if s == "" {
+		return "", s, false
+	}
+	i := strings.IndexByte(s, ' ')
+	if i < 0 {
+		return s, "", true
+	}
+	return s[:i], s[i+1:], true
+}
+
+// isTag tells a tag ("sshd[12]:", "%%01SHELL/5/CMD:") from a hostname
+func isTag(word string) bool {
+	return strings.HasSuffix(word, ":") || (strings.Contains(word, "[") && strings.HasSuffix(word, "]"))
+}
@@ -0,0 +1,197 @@
+package syslog
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Listeners for syslog over UDP (RFC 5426), TCP (RFC 6587) and TLS (RFC 5425).
+// Stream transports accept both octet-counting ("LEN SP MSG") and newline
+// framing; the first byte of each frame tells which one the sender uses.
+
+const (
+	DefaultMaxMessage = 64 * 1024
+	tcpIdleTimeout    = 10 * time.Minute
+	maxStreamConns    = 1024
+)
+
+// Handler receives every message with the sender's IP
+type Handler func(ip string, data []byte)
+
+// ServeUDP reads datagrams from addr until the socket fails
+func ServeUDP(addr string, maxSize int, h Handler) error {
+	conn, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	log.Printf("Syslog Server listening on UDP %s", conn.LocalAddr())
+
+	buf := make([]byte, maxSize)
+	for {
+		n, remote, err := conn.ReadFrom(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return err
+			}
+			continue
+		}
+		h(hostIP(remote), append([]byte(nil), buf[:n]...))
+	}
+}
+
+// ServeTCP accepts stream connections on addr; with a tls.Config they are TLS
+func ServeTCP(addr string, tlsConfig *tls.Config, maxSize int, h Handler) error {
+	var ln net.Listener
+	var err error
+	proto := "TCP"
+	if tlsConfig != nil {
+		ln, err = tls.Listen("tcp", addr, tlsConfig)
+		proto = "TLS"
+	} else {
+		ln, err = net.Listen("tcp", addr)
+	}
+	if err != nil {
+		return err
+	}
+	defer ln.Close()
+	log.Printf("Syslog Server listening on %s %s", proto, ln.Addr())
+
+	sem := make(chan struct{}, maxStreamConns)
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return err
+			}
+			time.Sleep(100 * time.Millisecond)
+			continue
+		}
+		select {
+		case sem <- struct{}{}:
+		default:
+			log.Printf("Syslog %s: too many connections, refusing %s", proto, conn.RemoteAddr())
+			conn.Close()
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			serveStream(conn, maxSize, h)
+		}()
+	}
+}
+
+func serveStream(conn net.Conn, maxSize int, h Handler) {
+	defer conn.Close()
+	ip := hostIP(conn.RemoteAddr())
+	r := bufio.NewReaderSize(conn, 16*1024)
+	for {
+		conn.SetReadDeadline(time.Now().Add(tcpIdleTimeout))
+		frame, err := ReadFrame(r, maxSize)
+		if len(frame) > 0 {
+			h(ip, frame)
+		}
+		if err != nil {
+			if !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) {
+				log.Printf("Syslog stream from %s: %v", ip, err)
+			}
+			return
+		}
+	}
+}
+
+// ErrFrame is returned for a malformed octet count; the stream cannot be
+// resynchronized after it.
+var ErrFrame = errors.New("invalid syslog frame")
+
+// ReadFrame reads one message from a stream. Messages longer than maxSize
+// are truncated and the rest of the frame is discarded.
+func ReadFrame(r *bufio.Reader, maxSize int) ([]byte, error) {
+	// Skip blank lines between frames
+	var first byte
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		if b != '\n' && b != '\r' && b != 0 && b != ' ' {
+			first = b
+			break
+		}
+	}
+
+	if first >= '1' && first <= '9' {
+		// Octet counting: MSG-LEN SP SYSLOG-MSG
+		digits := []byte{first}
+		for {
+			b, err := r.ReadByte()
+			if err != nil {
+				return nil, err
+			}
+			if b == ' ' {
+				break
+			}
+			if b < '0' || b > '9' || len(digits) >= 9 {
+				return nil, ErrFrame
+			}
+			digits = append(digits, b)
+		}
+		n, _ := strconv.Atoi(string(digits))
+		keep := min(n, maxSize)
+		msg := make([]byte, keep)
+		if _, err := io.ReadFull(r, msg); err != nil {
+			return nil, err
+		}
+		if n > keep {
+			if _, err := r.Discard(n - keep); err != nil {
+				return msg, err
+			}
+		}
+		return msg, nil
+	}
+
+	// Non-transparent framing: up to LF (or NUL, used by some senders)
+	msg := []byte{first}
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			// Last message of a stream closed without a trailer
+			return msg, err
+		}
+		if b == '\n' || b == 0 {
+			break
+		}
+		if len(msg) < maxSize {
+			msg = append(msg, b)
+		}
+	}
+	if len(msg) > 0 && msg[len(msg)-1] == '\r' {
+		msg = msg[:len(msg)-1]
+	}
+	return msg, nil
+}
+
+func hostIP(addr net.Addr) string {
+	switch a := addr.(type) {
+	case *net.UDPAddr:
+		return a.IP.String()
+	case *net.TCPAddr:
+		return a.IP.String()
+	}
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+	return host
+}
@@ -0,0 +1,299 @@
+package textdiff
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Line diffs with Myers' O(ND) algorithm in its linear-space form (the
+// middle snake splits the problem in two), rendered as unified diffs. Lines
+// found on one side only are set aside first: they cannot be part of the
+// common subsequence, so two unrelated files cost O(N) instead of O(N²).
+
+// Op is what happens to a line
+type Op int
+
+const (
+	Equal Op = iota
+	Delete
+	Insert
+)
+
+// Edit is one line of the edit script
+type Edit struct {
+	Op   Op
+	Line string
+}
+
+// Lines splits text into lines without their terminators
+func Lines(text string) []string {
+	text = strings.TrimSuffix(strings.ReplaceAll(text, "\r\n", "\n"), "\n")
+	if text == "" {
+		return nil
+	}
+	return strings.Split(text, "\n")
+}
+
+// Diff returns the shortest edit script turning a into b
+func Diff(a, b []string) []Edit {
+	// Lines are compared as numbers; those on one side only are left out of
+	// the search and put back as plain deletions and insertions
+	ids := map[string]int{}
+	id := func(l string) int {
+		n, ok := ids[l]
+		if !ok {
+			n = len(ids)
+			ids[l] = n
+		}
+		return n
+	}
+	inA, inB := map[int]bool{}, map[int]bool{}
+	aIDs, bIDs := make([]int, len(a)), make([]int, len(b))
+	for i, l := range a {
+		aIDs[i] = id(l)
+		inA[aIDs[i]] = true
+	}
+	for i, l := range b {
+		bIDs[i] = id(l)
+		inB[bIDs[i]] = true
+	}
+	var as, bs, aPos, bPos []int
+	for i, n := range aIDs {
+		if inB[n] {
+			as, aPos = append(as, n), append(aPos, i)
+		}
+	}
+	for i, n := range bIDs {
+		if inA[n] {
+			bs, bPos = append(bs, n), append(bPos, i)
+		}
+	}
+
+	var ops []Op
+	diffInts(as, bs, &ops)
+
+	edits := make([]Edit, 0, len(a)+len(b))
+	i, j, fa, fb := 0, 0, 0, 0
+	flush := func(toA, toB int) {
+		for ; i < toA; i++ {
+			edits = append(edits, Edit{Delete, a[i]})
+		}
+		for ; j < toB; j++ {
+			edits = append(edits, Edit{Insert, b[j]})
+		}
+	}
+	for _, op := range ops {
+		switch op {
+		case Equal:
+			flush(aPos[fa], bPos[fb])
+			edits = append(edits, Edit{Equal, a[i]})
+			i, j, fa, fb = i+1, j+1, fa+1, fb+1
+		case Delete:
+			flush(aPos[fa]+1, j)
+			fa++
+		case Insert:
+			flush(i, bPos[fb]+1)
+			fb++
+		}
+	}
+	flush(len(a), len(b))
+	return edits
+}
+
+// diffInts appends the shortest edit script turning a into b to ops
+func diffInts(a, b []int, ops *[]Op) {
+	pre := 0
+	for pre < len(a) && pre < len(b) && a[pre] == b[pre] {
+		pre++
+	}
+	suf := 0
+	for suf < len(a)-pre && suf < len(b)-pre && a[len(a)-1-suf] == b[len(b)-1-suf] {
+		suf++
+	}
+	for ; pre > 0; pre-- {
+		*ops = append(*ops, Equal)
+		a, b = a[1:], b[1:]
+	}
+	a, b = a[:len(a)-suf], b[:len(b)-suf]
+
+	switch {
+	case len(a) == 0:
+		for range b {
+			*ops = append(*ops, Insert)
+		}
+	case len(b) == 0:
+		for range a {
+			*ops = append(*ops, Delete)
+		}
+	default:
+		if x, y, ok := middleSnake(a, b); ok {
+			diffInts(a[:x], b[:y], ops)
+			diffInts(a[x:], b[y:], ops)
+		} else {
+			for range a {
+				*ops = append(*ops, Delete)
+			}
+			for range b {
+				*ops = append(*ops, Insert)
+			}
+		}
+	}
+	for ; suf > 0; suf-- {
+		*ops = append(*ops, Equal)
+	}
+}
+
+// middleSnake runs the search from both ends at once and returns where the
+// two paths meet, a point on a shortest edit path strictly inside it. a and
+// b are not empty and differ at both ends. ok is false when they share no line.
+func middleSnake(a, b []int) (int, int, bool) {
+	n, m := len(a), len(b)
+	maxD := (n + m + 1) / 2
+	off := maxD
+	// vf[off+k] is the furthest x on diagonal k from the start, vb the same
+	// counted from the end; -1 is not reached yet
+	vf := make([]int, 2*maxD+2)
+	vb := make([]int, 2*maxD+2)
+	for i := range vf {
+		vf[i], vb[i] = -1, -1
+	}
+	vf[off+1], vb[off+1] = 0, 0
+	delta := n - m
+	odd := delta%2 != 0
+	// Diagonals that ran off the grid are skipped from then on
+	kfStart, kfEnd, kbStart, kbEnd := 0, 0, 0, 0
+
+	for d := 0; d < maxD; d++ {
+		for k := -d + kfStart; k <= d-kfEnd; k += 2 {
+			var x int
+			if k == -d || (k != d && vf[off+k-1] < vf[off+k+1]) {
+				x = vf[off+k+1]
+			} else {
+				x = vf[off+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			vf[off+k] = x
+			switch {
+			case x > n:
+				kfEnd += 2
+			case y > m:
+				kfStart += 2
+			case odd:
+				if kb := off + delta - k; kb >= 0 && kb < len(vb) && vb[kb] != -1 && x >= n-vb[kb] {
+					return x, y, true
+				}
+			}
+		}
+		for k := -d + kbStart; k <= d-kbEnd; k += 2 {
+			var x int
+			if k == -d || (k != d && vb[off+k-1] < vb[off+k+1]) {
+				x = vb[off+k+1]
+			} else {
+				x = vb[off+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[n-x-1] == b[m-y-1] {
+				x++
+				y++
+			}
+			vb[off+k] = x
+			switch {
+			case x > n:
+				kbEnd += 2
+			case y > m:
+				kbStart += 2
+			case !odd:
+				if kf := off + delta - k; kf >= 0 && kf < len(vf) && vf[kf] != -1 {
+					fx := vf[kf]
+					if fx >= n-x {
+						return fx, off + fx - kf, true
+					}
+				}
+			}
+		}
+	}
+	return 0, 0, false
+}
+
+// Unified renders the difference between a and b as a unified diff with
+// context lines around each change. It is empty when they are equal.
+func Unified(fromName, toName string, a, b []string, context int) string {
+	edits := Diff(a, b)
+
+	// Line numbers in a and b before each edit
+	aPos := make([]int, len(edits)+1)
+	bPos := make([]int, len(edits)+1)
+	for i, e := range edits {
+		aPos[i+1], bPos[i+1] = aPos[i], bPos[i]
+		if e.Op != Insert {
+			aPos[i+1]++
+		}
+		if e.Op != Delete {
+			bPos[i+1]++
+		}
+	}
+
+	var out strings.Builder
+	i := 0
+	for {
+		for i < len(edits) && edits[i].Op == Equal {
+			i++
+		}
+		if i >= len(edits) {
+			break
+		}
+		if out.Len() == 0 {
+			fmt.Fprintf(&out, "--- %s\n+++ %s\n", fromName, toName)
+		}
+
+		// Changes closer than two contexts share a hunk
+		start, end := max(i-context, 0), i
+		for {
+			for end < len(edits) && edits[end].Op != Equal {
+				end++
+			}
+			next := end
+			for next < len(edits) && edits[next].Op == Equal {
+				next++
+			}
+			if next < len(edits) && next-end <= 2*context {
+				end = next
+				continue
+			}
+			end = min(end+context, len(edits))
+			break
+		}
+
+		fmt.Fprintf(&out, "@@ -%s +%s @@\n",
+			hunkRange(aPos[start], aPos[end]-aPos[start]), hunkRange(bPos[start], bPos[end]-bPos[start]))
+		for _, e := range edits[start:end] {
+			switch e.Op {
+			case Equal:
+				out.WriteString(" ")
+			case Delete:
+				out.WriteString("-")
+			case Insert:
+				out.WriteString("+")
+			}
+			out.WriteString(e.Line)
+			out.WriteString("\n")
+		}
+		i = end
+	}
+	return out.String()
+}
+
+// hunkRange formats "start,count" the way diff -u does
+func hunkRange(before, count int) string {
+	switch count {
+	case 0:
+		return fmt.Sprintf("%d,0", before)
+	case 1:
+		return fmt.Sprintf("%d", before+1)
+	}
+	return fmt.Sprintf("%d,%d", before+1, count)
+}
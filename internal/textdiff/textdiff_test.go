@@ -0,0 +1,163 @@
+package textdiff
+
+import (
+	"fmt"
+	"math/rand"
+	"runtime"
+	"slices"
+	"testing"
+	"time"
+)
+
+// lcs is the length of the longest common subsequence, by dynamic programming
+func lcs(a, b []string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for i := range a {
+		for j := range b {
+			if a[i] == b[j] {
+				cur[j+1] = prev[j] + 1
+			} else {
+				cur[j+1] = max(prev[j+1], cur[j])
+			}
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+// checkScript fails unless edits turn a into b with as few changes as possible
+func checkScript(t *testing.T, a, b []string, edits []Edit) {
+	t.Helper()
+	var gotA, gotB []string
+	changes := 0
+	for _, e := range edits {
+		switch e.Op {
+		case Equal:
+			gotA = append(gotA, e.Line)
+			gotB = append(gotB, e.Line)
+		case Delete:
+			gotA = append(gotA, e.Line)
+			changes++
+		case Insert:
+			gotB = append(gotB, e.Line)
+			changes++
+		}
+	}
+	if !slices.Equal(gotA, a) || !slices.Equal(gotB, b) {
+		t.Fatalf("script does not turn %q into %q: %v", a, b, edits)
+	}
+	if want := len(a) + len(b) - 2*lcs(a, b); changes != want {
+		t.Fatalf("diff %q -> %q: %d changes, shortest is %d", a, b, changes, want)
+	}
+}
+
+func TestDiffRandom(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	random := func() []string {
+		l := make([]string, rng.Intn(30))
+		for i := range l {
+			l[i] = string(rune('a' + rng.Intn(6)))
+		}
+		return l
+	}
+	for range 5000 {
+		a, b := random(), random()
+		checkScript(t, a, b, Diff(a, b))
+	}
+}
+
+func TestDiffEdgeCases(t *testing.T) {
+	cases := [][2][]string{
+		{nil, nil},
+		{nil, {"a"}},
+		{{"a"}, nil},
+		{{"a", "b", "c"}, {"a", "b", "c"}},
+		{{"a", "b", "c"}, {"x", "y", "z"}},
+		{{"a", "b", "c", "d"}, {"d", "c", "b", "a"}},
+		{{"a", "x", "b"}, {"a", "b", "y"}},
+	}
+	for _, c := range cases {
+		checkScript(t, c[0], c[1], Diff(c[0], c[1]))
+	}
+}
+
+func TestUnified(t *testing.T) {
+	a := Lines("one\ntwo\nthree\nfour\nfive\nsix\nseven\neight\n")
+	b := Lines("one\ntwo\nTHREE\nfour\nfive\nsix\nseven\neight\nnine\n")
+	want := `--- old
++++ new
+@@ -2,3 +2,3 @@
+ two
+-three
++THREE
+ four
+@@ -8 +8,2 @@
+ eight
++nine
+`
+	if got := Unified("old", "new", a, b, 1); got != want {
+		t.Fatalf("Unified:\n%s\nwant:\n%s", got, want)
+	}
+	if got := Unified("old", "new", a, a, 3); got != "" {
+		t.Fatalf("Unified of equal input = %q, want empty", got)
+	}
+}
+
+// Exports of unrelated or heavily reworked configs must not blow up
+func TestDiffLargeInputs(t *testing.T) {
+	const n = 10000
+	unrelatedA := make([]string, n)
+	unrelatedB := make([]string, n)
+	for i := range n {
+		unrelatedA[i] = fmt.Sprintf("/ip address add address=10.0.%d.1/24", i)
+		unrelatedB[i] = fmt.Sprintf("/ip route add gateway=10.1.%d.1", i)
+	}
+	// Shared lines in reverse order: the worst case of the search itself
+	forward := make([]string, n/2)
+	for i := range forward {
+		forward[i] = fmt.Sprintf("set %d", i)
+	}
+	reversed := slices.Clone(forward)
+	slices.Reverse(reversed)
+	// Every other line rewritten
+	edited := slices.Clone(unrelatedA)
+	for i := 0; i < n; i += 2 {
+		edited[i] += " comment=changed"
+	}
+
+	for _, c := range []struct {
+		name string
+		a, b []string
+	}{
+		{"unrelated", unrelatedA, unrelatedB},
+		{"reversed", forward, reversed},
+		{"interleaved", unrelatedA, edited},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			var before, after runtime.MemStats
+			runtime.GC()
+			runtime.ReadMemStats(&before)
+			start := time.Now()
+			edits := Diff(c.a, c.b)
+			took := time.Since(start)
+			runtime.ReadMemStats(&after)
+
+			if alloc := after.TotalAlloc - before.TotalAlloc; alloc > 64<<20 {
+				t.Errorf("allocated %d MiB", alloc>>20)
+			}
+			if took > 2*time.Second {
+				t.Errorf("took %v", took)
+			}
+			var changes int
+			for _, e := range edits {
+				if e.Op != Equal {
+					changes++
+				}
+			}
+			if want := len(c.a) + len(c.b) - 2*lcs(c.a, c.b); changes != want {
+				t.Errorf("%d changes, shortest is %d", changes, want)
+			}
+		})
+	}
+}
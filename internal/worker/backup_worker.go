@@ -6,7 +6,8 @@ import (
 	"log"
 	"mikromon/internal/api"
 	"mikromon/internal/db"
-	"mikromon/internal/ssher"
+	"mikromon/internal/notify"
+	"sync"
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
@@ -17,9 +18,9 @@ import (
 func StartBackupWorker() {
 	log.Println("Worker: Backup Automation started")
 
-	// Check every 5 minutes to see if any device needs a backup
-	// (either it never had one or the last one was > 1 hour ago)
-	ticker := time.NewTicker(5 * time.Minute)
+	// Check every 5 minutes which devices are due, going by the backups per
+	// day of the stored configuration
+	ticker := time.NewTicker(checkEvery)
 	defer ticker.Stop()
 
 	// Run once at start
@@ -30,52 +31,82 @@ func StartBackupWorker() {
 	}
 }
 
+const checkEvery = 5 * time.Minute
+
+// backupCheckMu keeps a slow check from overlapping the next one, which
+// would back up the same devices twice
+var backupCheckMu sync.Mutex
+
 func runBackupCheck() {
-	log.Println("Worker: Running backup check...")
-	devices := getAllDevices()
+	if !backupCheckMu.TryLock() {
+		log.Println("Worker: previous backup check still running, skipping")
+		return
+	}
+	defer backupCheckMu.Unlock()
 
-	for _, dev := range devices {
-		lastBackupTime := getLastBackupTime(dev.ID.Hex())
+	config := api.LoadBackupConfig()
 
-		log.Printf("[DEBUG] Worker checking device: %s (%s) with user: %s", dev.Name, dev.IP, dev.Username)
+	if !config.Enabled {
+		log.Println("Worker: Automatic backups disabled, skipping backup check")
+	} else {
+		log.Println("Worker: Running backup check...")
+		// A device is due slightly early rather than a whole check late
+		interval := config.Retention.BackupInterval() - checkEvery
+		for _, dev := range getAllDevices() {
+			lastBackupTime := getLastBackupTime(dev.ID.Hex())
+			if !lastBackupTime.IsZero() && time.Since(lastBackupTime) <= interval {
+				continue
+			}
 
-		// If never backed up OR last backup was more than 55 minutes ago
-		if lastBackupTime.IsZero() || time.Since(lastBackupTime) > 55*time.Minute {
 			log.Printf("Worker: Triggering backup for %s (Last run: %v)", dev.Name, lastBackupTime)
 			err := executeBackupForDevice(dev)
 			if err != nil {
 				log.Printf("Worker: Failed backup for %s: %v", dev.Name, err)
+				notify.Publish(notify.Event{
+					Type:       notify.EventBackupFailed,
+					Severity:   notify.SeverityCritical,
+					Title:      fmt.Sprintf("Backup failed on %s", dev.Name),
+					Message:    err.Error(),
+					DeviceID:   dev.ID.Hex(),
+					DeviceName: dev.Name,
+					Owner:      dev.Owner,
+					Fields:     map[string]string{"ip": dev.IP},
+				})
 			}
 		}
 	}
 
 	log.Println("Worker: Starting retention cleanup...")
-	applyRetentionPolicy()
+	deleted, err := api.ApplyRetention()
+	if err != nil {
+		log.Printf("Worker: Retention cleanup failed: %v", err)
+	} else if deleted > 0 {
+		log.Printf("Worker: Retention cleanup deleted %d backup(s)", deleted)
+	}
 }
 
 func getLastBackupTime(deviceID string) time.Time {
 	coll := db.GetCollection("backups")
-	var backup api.Backup
 
-	if coll != nil {
-		// Find latest backup for this device
-		opts := options.FindOne().SetSort(bson.M{"created_at": -1})
-		err := coll.FindOne(context.TODO(), bson.M{"device_id": deviceID}, opts).Decode(&backup)
-		if err != nil {
-			return time.Time{}
-		}
-	} else {
+	if coll == nil {
 		// Mock check
-		for _, b := range api.MockBackups {
-			if b.DeviceID == deviceID {
-				t, _ := time.Parse("2006-01-02 15:04", b.CreatedAt)
-				return t // Should ideally check all and find max, but for mock first is usually latest
+		var last time.Time
+		for _, b := range api.MockBackupList() {
+			if t, ok := api.BackupTime(b); ok && b.DeviceID == deviceID && !b.IsTest && t.After(last) {
+				last = t
 			}
 		}
-		return time.Time{}
+		return last
 	}
 
-	t, _ := time.Parse("2006-01-02 15:04", backup.CreatedAt)
+	// Find latest backup for this device ("2006-01-02 15:04" sorts by time)
+	var backup api.Backup
+	opts := options.FindOne().SetSort(bson.M{"created_at": -1})
+	err := coll.FindOne(context.TODO(), bson.M{"device_id": deviceID, "is_test": bson.M{"$ne": true}}, opts).Decode(&backup)
+	if err != nil {
+		return time.Time{}
+	}
+	t, _ := api.BackupTime(backup)
 	return t
 }
 
@@ -87,7 +118,11 @@ type BackupDevice struct {
 	Password  string             `bson:"password"`
 	Port      int                `bson:"port"`
 	Type      string             `bson:"type"`
+	Vendor    string             `bson:"vendor"`
+	Model     string             `bson:"model"`
 	UseSSHKey bool               `bson:"use_ssh_key"`
+	Transport string             `bson:"transport"`
+	Owner     string             `bson:"owner"`
 }
 
 func getAllDevices() []BackupDevice {
@@ -100,122 +135,22 @@ func getAllDevices() []BackupDevice {
 		}
 	} else {
 		// Mock devices if no DB
-		for _, d := range api.MockDevices {
+		for _, d := range api.MockDeviceList() {
 			devices = append(devices, BackupDevice{
 				ID: d.ID, Name: d.Name, IP: d.IP, Username: d.Username, Password: d.Password, Port: d.Port, Type: d.Type,
+				Vendor: d.Vendor, Model: d.Model, UseSSHKey: d.UseSSHKey, Transport: d.Transport, Owner: d.Owner,
 			})
 		}
 	}
 	return devices
 }
 
+// executeBackupForDevice runs the backup pipeline (download, checksum, local
+// storage); failures are recorded there as failed backups
 func executeBackupForDevice(dev BackupDevice) error {
-	if dev.Port == 0 {
-		dev.Port = 22
-	}
-
-	timestamp := time.Now().Format("20060102_1504")
-	filename := fmt.Sprintf("mikromon_auto_%s.backup", timestamp)
-
-	// Command for MikroTik. For OLT or Switch it might differ, but assuming MikroTik as per user request context.
-	cmd := fmt.Sprintf("/system backup save name=%s", filename)
-
-	var err error
-	if db.GetCollection("devices") != nil {
-		pool := ssher.GetPool()
-		_, err = pool.RunCommand(dev.Username, dev.Password, dev.IP, dev.Port, dev.UseSSHKey, cmd)
-	} else {
-		// Mock success
-		time.Sleep(1 * time.Second)
-	}
-
-	if err != nil {
-		return err
-	}
-
-	// Register backup in DB
-	backup := api.Backup{
-		ID:         primitive.NewObjectID().Hex(),
-		DeviceID:   dev.ID.Hex(),
-		DeviceName: dev.Name,
-		Filename:   filename,
-		Size:       "Calculando...",
-		CreatedAt:  time.Now().Format("2006-01-02 15:04"),
-	}
-
-	coll := db.GetCollection("backups")
-	if coll != nil {
-		coll.InsertOne(context.TODO(), backup)
-	} else {
-		api.MockBackups = append([]api.Backup{backup}, api.MockBackups...)
-	}
-
-	return nil
-}
-
-func applyRetentionPolicy() {
-	coll := db.GetCollection("backups")
-	var backups []api.Backup
-
-	if coll != nil {
-		cursor, err := coll.Find(context.TODO(), bson.M{})
-		if err == nil {
-			cursor.All(context.TODO(), &backups)
-		}
-	} else {
-		backups = api.MockBackups
-	}
-
-	now := time.Now()
-	for _, b := range backups {
-		created, err := time.Parse("2006-01-02 15:04", b.CreatedAt)
-		if err != nil {
-			continue
-		}
-
-		age := now.Sub(created)
-		keep := true
-
-		// 1 month = 30 days
-		// 3 months = 90 days
-		// 1 year = 365 days
-		// 3 years = 1095 days
-
-		days := age.Hours() / 24
-
-		if days <= 30 {
-			// keep everything
-			keep = true
-		} else if days <= 90 {
-			// keep 12/day (bi-hourly)
-			keep = (created.Hour()%2 == 0)
-		} else if days <= 365 {
-			// keep 6/day (every 4h)
-			keep = (created.Hour()%4 == 0)
-		} else if days <= 1095 {
-			// keep 1/day
-			keep = (created.Hour() == 0)
-		} else {
-			// keep 1/week (e.g. Sunday)
-			keep = (created.Hour() == 0 && created.Weekday() == time.Sunday)
-		}
-
-		if !keep {
-			deleteBackup(b.ID)
-		}
-	}
-}
-
-func deleteBackup(id string) {
-	coll := db.GetCollection("backups")
-	if coll != nil {
-		coll.DeleteOne(context.TODO(), bson.M{"id": id})
-	} else {
-		for i, b := range api.MockBackups {
-			if b.ID == id {
-				api.MockBackups = append(api.MockBackups[:i], api.MockBackups[i+1:]...)
-				break
-			}
-		}
-	}
+	_, err := api.RunBackup(api.Device{
+		ID: dev.ID, Name: dev.Name, IP: dev.IP, Username: dev.Username, Password: dev.Password, Port: dev.Port, Type: dev.Type,
+		Vendor: dev.Vendor, Model: dev.Model, UseSSHKey: dev.UseSSHKey, Transport: dev.Transport, Owner: dev.Owner,
+	}, api.BackupAuto)
+	return err
 }
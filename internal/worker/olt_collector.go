@@ -0,0 +1,35 @@
+package worker
+
+import (
+	"log"
+	"os"
+	"time"
+
+	"mikromon/internal/api"
+)
+
+const defaultOltPollInterval = 5 * time.Minute
+
+// StartOltCollector refreshes the PON statistics of every OLT.
+// The interval comes from OLT_POLL_INTERVAL (Go duration, e.g. "2m").
+func StartOltCollector() {
+	interval := defaultOltPollInterval
+	if v := os.Getenv("OLT_POLL_INTERVAL"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil && d >= 30*time.Second {
+			interval = d
+		} else {
+			log.Printf("Worker: invalid OLT_POLL_INTERVAL %q, using %v", v, interval)
+		}
+	}
+	log.Printf("Worker: OLT statistics collector started (every %v)", interval)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	// Run once at start
+	api.RefreshOltStats()
+
+	for range ticker.C {
+		api.RefreshOltStats()
+	}
+}
@@ -4,12 +4,14 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"os"
 	"strings"
 	"time"
 
 	"mikromon/internal/api"
 	"mikromon/internal/db"
-	"mikromon/internal/ssher"
+	"mikromon/internal/driver"
+	"mikromon/internal/notify"
 
 	// Para acessar structs se necessário, mas melhor redefinir ou mover structs para pacote models para evitar ciclo.
 	// Como api importa db, e worker importa api, ok. Mas api/schedules.go define Schedule.
@@ -37,23 +39,57 @@ type ScheduleTask struct {
 }
 
 type DeviceCredentials struct {
+	Name      string `bson:"name"`
+	Owner     string `bson:"owner"`
 	IP        string `bson:"ip"`
 	Username  string `bson:"username"`
 	Password  string `bson:"password"`
 	Port      int    `bson:"port"`
+	Type      string `bson:"type"`
+	Vendor    string `bson:"vendor"`
+	Model     string `bson:"model"`
 	UseSSHKey bool   `bson:"use_ssh_key"`
+	Transport string `bson:"transport"`
 }
 
+const defaultDriftInterval = time.Hour
+
 func StartScheduler() {
 	log.Println("Worker: Scheduler started")
 	ticker := time.NewTicker(30 * time.Second) // Check every 30s
 	defer ticker.Stop()
 
+	driftEvery := driftInterval()
+	lastDrift := time.Now()
+
 	for range ticker.C {
 		checkSchedules()
+
+		// Out-of-band changes (WinBox, console) never reach the audit log
+		if driftEvery > 0 && time.Since(lastDrift) >= driftEvery {
+			lastDrift = time.Now()
+			go api.CheckDrift()
+		}
 	}
 }
 
+// driftInterval reads CONFIG_DRIFT_INTERVAL (Go duration, "off" disables)
+func driftInterval() time.Duration {
+	interval := defaultDriftInterval
+	if v := os.Getenv("CONFIG_DRIFT_INTERVAL"); v == "off" {
+		log.Println("Worker: configuration drift check disabled")
+		return 0
+	} else if v != "" {
+		if d, err := time.ParseDuration(v); err == nil && d >= 5*time.Minute {
+			interval = d
+		} else {
+			log.Printf("Worker: invalid CONFIG_DRIFT_INTERVAL %q, using %v", v, interval)
+		}
+	}
+	log.Printf("Worker: configuration drift check every %v", interval)
+	return interval
+}
+
 func checkSchedules() {
 	coll := db.GetCollection("schedules")
 
@@ -124,14 +160,15 @@ func executeTask(task ScheduleTask) {
 		err := devColl.FindOne(context.TODO(), bson.M{"_id": objID}).Decode(&dev)
 		if err != nil {
 			updateTaskResult(task.ID, "Failed: Device not found", "error")
+			notifyTaskFailed(task, dev, "device not found")
 			return
 		}
 	} else {
 		// Mock Device
 		found := false
-		for _, d := range api.MockDevices {
+		for _, d := range api.MockDeviceList() {
 			if d.ID.Hex() == task.DeviceID || task.DeviceID == "d1" { // d1 is hardcoded in mock schedule
-				dev = DeviceCredentials{IP: d.IP, Username: d.Username, Password: d.Password, Port: d.Port}
+				dev = DeviceCredentials{Name: d.Name, Owner: d.Owner, IP: d.IP, Username: d.Username, Password: d.Password, Port: d.Port, Type: d.Type, Vendor: d.Vendor, Model: d.Model, Transport: d.Transport}
 				found = true
 				break
 			}
@@ -143,7 +180,7 @@ func executeTask(task ScheduleTask) {
 	}
 
 	if dev.Port == 0 {
-		dev.Port = 22
+		dev.Port = driver.DefaultPort(dev.Transport)
 	}
 
 	// 2. Execute SSH or Mock
@@ -157,14 +194,14 @@ func executeTask(task ScheduleTask) {
 		time.Sleep(2 * time.Second)
 		output = fmt.Sprintf("MOCK EXECUTION of '%s' on %s\n> Success.", task.Command, dev.IP)
 	} else {
-		pool := ssher.GetPool()
-		output, err = pool.RunCommand(dev.Username, dev.Password, dev.IP, dev.Port, dev.UseSSHKey, task.Command)
+		output, err = runOnDevice(dev, task.Command)
 	}
 
 	status := "completed"
 	if err != nil {
 		output = fmt.Sprintf("Error: %v\nPartial Output: %s", err, output)
 		// status = "error" // Let's keep completed to stop spinning generally
+		notifyTaskFailed(task, dev, err.Error())
 	}
 
 	// 3. Update Result & Reschedule if recurring
@@ -175,6 +212,27 @@ func executeTask(task ScheduleTask) {
 	}
 }
 
+// notifyTaskFailed tells the subscribers that a scheduled command failed
+func notifyTaskFailed(task ScheduleTask, dev DeviceCredentials, reason string) {
+	name := dev.Name
+	if name == "" {
+		name = dev.IP
+	}
+	if name == "" {
+		name = task.DeviceID
+	}
+	notify.Publish(notify.Event{
+		Type:       notify.EventTaskFailed,
+		Severity:   notify.SeverityWarning,
+		Title:      fmt.Sprintf("Scheduled task '%s' failed on %s", task.Title, name),
+		Message:    reason,
+		DeviceID:   task.DeviceID,
+		DeviceName: dev.Name,
+		Owner:      dev.Owner,
+		Fields:     map[string]string{"command": task.Command},
+	})
+}
+
 func updateTaskResult(id primitive.ObjectID, result string, status string) {
 	coll := db.GetCollection("schedules")
 	if coll != nil {
@@ -241,3 +299,20 @@ func rescheduleTask(task ScheduleTask, intervalStr string) {
 	}
 	log.Printf("Worker: Rescheduled task '%s' to %s", task.Title, nextRunStr)
 }
+
+// runOnDevice runs cmd through the device driver, so OLTs get an interactive
+// CLI session instead of an exec channel they do not support
+func runOnDevice(dev DeviceCredentials, cmd string) (string, error) {
+	drv, err := driver.For(dev.Type, dev.Vendor, dev.Model)
+	if err != nil {
+		return "", err
+	}
+	sess, err := driver.Connect(driver.Target{
+		Host: dev.IP, Port: dev.Port, Username: dev.Username, Password: dev.Password, UseSSHKey: dev.UseSSHKey, Transport: dev.Transport,
+	}, drv)
+	if err != nil {
+		return "", err
+	}
+	defer sess.Close()
+	return sess.Run(cmd)
+}
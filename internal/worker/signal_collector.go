@@ -0,0 +1,35 @@
+package worker
+
+import (
+	"log"
+	"os"
+	"time"
+
+	"mikromon/internal/api"
+)
+
+const defaultSignalPollInterval = 15 * time.Minute
+
+// StartSignalCollector stores the optical levels of every ONU in historico_sinal.
+// The interval comes from SIGNAL_POLL_INTERVAL (Go duration, e.g. "10m").
+func StartSignalCollector() {
+	interval := defaultSignalPollInterval
+	if v := os.Getenv("SIGNAL_POLL_INTERVAL"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil && d >= time.Minute {
+			interval = d
+		} else {
+			log.Printf("Worker: invalid SIGNAL_POLL_INTERVAL %q, using %v", v, interval)
+		}
+	}
+	log.Printf("Worker: ONU signal collector started (every %v)", interval)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	// Run once at start
+	api.CollectSignals()
+
+	for range ticker.C {
+		api.CollectSignals()
+	}
+}
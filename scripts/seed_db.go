@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"strconv"
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
@@ -43,6 +44,21 @@ func main() {
 	// 4. Signal History Indexes
 	createSignalIndexes(ctx, db)
 
+	// 5. ONU Alarm Indexes
+	createAlarmIndexes(ctx, db)
+
+	// 6. Syslog Indexes
+	createSyslogIndexes(ctx, db)
+
+	// 7. Log Alert Indexes
+	createAlertIndexes(ctx, db)
+
+	// 8. Notification Indexes
+	createNotificationIndexes(ctx, db)
+
+	// 9. Config Version Indexes
+	createConfigVersionIndexes(ctx, db)
+
 	fmt.Println("Seeding completed successfully.")
 }
 
@@ -60,7 +76,7 @@ func createUsers(ctx context.Context, db *mongo.Database) {
 
 	// Seed Admin
 	hash, _ := bcrypt.GenerateFromPassword([]byte("admin"), bcrypt.DefaultCost)
-	admin := bson.M{"username": "admin", "password_hash": string(hash), "role": "admin"}
+	admin := bson.M{"username": "admin", "password_hash": string(hash), "role": "admin", "full_name": "Administrador", "disabled": false}
 
 	opts := options.Update().SetUpsert(true)
 	_, err = coll.UpdateOne(ctx, bson.M{"username": "admin"}, bson.M{"$set": admin}, opts)
@@ -103,9 +119,128 @@ func createSignalIndexes(ctx context.Context, db *mongo.Database) {
 		},
 	})
 
-	// TTL Index (retain logs for 30 days)
-	// Assuming 'timestamp' is a Date object, if int64 we can't use TTL easily without a shadow date field.
-	// For now skipping TTL on int64.
+	// Index: device + port + collected_at (history queries)
+	coll.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{
+			{Key: "device_id", Value: 1},
+			{Key: "port", Value: 1},
+			{Key: "collected_at", Value: -1},
+		},
+	})
+
+	// TTL Index (retain readings for SIGNAL_RETENTION_DAYS, default 30 days)
+	// 'timestamp' is int64, so the TTL uses the collected_at Date written alongside it.
+	retentionDays := 30
+	if v, err := strconv.Atoi(os.Getenv("SIGNAL_RETENTION_DAYS")); err == nil && v > 0 {
+		retentionDays = v
+	}
+	coll.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "collected_at", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(int32(retentionDays * 24 * 3600)),
+	})
 
 	fmt.Println("Configured Signal History Indexes")
 }
+
+func createAlarmIndexes(ctx context.Context, db *mongo.Database) {
+	coll := db.Collection("onu_alarms")
+
+	// Index: lookup of the open alarm of an ONU
+	coll.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{
+			{Key: "device_id", Value: 1},
+			{Key: "port", Value: 1},
+			{Key: "onu_id", Value: 1},
+			{Key: "type", Value: 1},
+			{Key: "active", Value: 1},
+		},
+	})
+
+	// Index: listing by state, newest first
+	coll.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "active", Value: 1}, {Key: "raised_at", Value: -1}},
+	})
+
+	fmt.Println("Configured Alarm Indexes")
+}
+
+func createSyslogIndexes(ctx context.Context, db *mongo.Database) {
+	coll := db.Collection("syslog")
+
+	// Index: device + received_at (per device queries)
+	coll.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{
+			{Key: "device_id", Value: 1},
+			{Key: "received_at", Value: -1},
+		},
+	})
+
+	// Text index for the search box
+	coll.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{
+			{Key: "message", Value: "text"},
+			{Key: "hostname", Value: "text"},
+			{Key: "app_name", Value: "text"},
+		},
+	})
+
+	// TTL Index (retain entries for SYSLOG_RETENTION_DAYS, default 14 days)
+	retentionDays := 14
+	if v, err := strconv.Atoi(os.Getenv("SYSLOG_RETENTION_DAYS")); err == nil && v > 0 {
+		retentionDays = v
+	}
+	coll.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "received_at", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(int32(retentionDays * 24 * 3600)),
+	})
+
+	fmt.Println("Configured Syslog Indexes")
+}
+
+func createAlertIndexes(ctx context.Context, db *mongo.Database) {
+	coll := db.Collection("alerts")
+
+	// Index: listing by state, newest first
+	coll.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "state", Value: 1}, {Key: "starts_at", Value: -1}},
+	})
+
+	// Index: per device listing
+	coll.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "device_id", Value: 1}, {Key: "starts_at", Value: -1}},
+	})
+
+	fmt.Println("Configured Alert Indexes")
+}
+
+func createNotificationIndexes(ctx context.Context, db *mongo.Database) {
+	// Index: subscriptions of a user
+	db.Collection("notification_subscriptions").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "username", Value: 1}},
+	})
+
+	coll := db.Collection("notification_deliveries")
+
+	// Index: delivery log of a user, newest first
+	coll.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "username", Value: 1}, {Key: "created_at", Value: -1}},
+	})
+
+	// TTL Index (keep the delivery log for 30 days)
+	coll.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "created_at", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(30 * 24 * 3600),
+	})
+
+	fmt.Println("Configured Notification Indexes")
+}
+
+func createConfigVersionIndexes(ctx context.Context, db *mongo.Database) {
+	// Unique Index: one number per version of a device, also serving newest-first listing
+	db.Collection("config_versions").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "device_id", Value: 1}, {Key: "version", Value: -1}},
+		Options: options.Index().SetUnique(true),
+	})
+
+	fmt.Println("Configured Config Version Indexes")
+}